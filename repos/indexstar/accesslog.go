@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	accessLogStdout = "stdout"
+
+	defaultAccessLogMaxSize     = 100 << 20
+	defaultAccessLogMaxFiles    = 5
+	defaultAccessLogMaxBodySize = 64 << 10
+
+	redactedValue = "REDACTED"
+)
+
+// accessLogRedactable are the access log fields that can be redacted.
+var accessLogRedactable = map[string]struct{}{
+	"ClientIP":     {},
+	"Path":         {},
+	"Query":        {},
+	"RequestBody":  {},
+	"ResponseBody": {},
+}
+
+// accessLogEntry is one line of the access log.
+type accessLogEntry struct {
+	Time         time.Time
+	Method       string
+	Path         string
+	Query        string `json:",omitempty"`
+	Status       int
+	LatencyMs    float64
+	Bytes        int64
+	Backends     int32
+	Results      int32
+	ClientIP     string
+	RequestBody  string `json:",omitempty"`
+	ResponseBody string `json:",omitempty"`
+}
+
+// accessLogSettings are the access log settings that can be changed while the
+// server is running, without reopening the output.
+type accessLogSettings struct {
+	sampleRate  float64
+	debug       bool
+	maxBodySize int
+	redact      map[string]struct{}
+}
+
+// accessLogger writes structured access logs as JSON lines to stdout or to a
+// rotating file.
+type accessLogger struct {
+	// settings is nil when access logging is disabled.
+	settings atomic.Pointer[accessLogSettings]
+
+	mutex  sync.Mutex
+	out    io.Writer
+	closer io.Closer
+	// output, maxSize and maxFiles identify the current output, so that it
+	// is only reopened when they change.
+	output   string
+	maxSize  int64
+	maxFiles int
+}
+
+// configure applies the access log config. Access logging is disabled if alc
+// is nil or has no output.
+func (l *accessLogger) configure(alc *AccessLogConfig) error {
+	if alc == nil || alc.Output == "" {
+		l.settings.Store(nil)
+		return l.close()
+	}
+
+	settings := &accessLogSettings{
+		sampleRate:  alc.SampleRate,
+		debug:       alc.Debug,
+		maxBodySize: alc.MaxBodySize,
+		redact:      make(map[string]struct{}, len(alc.Redact)),
+	}
+	if settings.sampleRate == 0 {
+		settings.sampleRate = 1
+	}
+	if settings.sampleRate < 0 || settings.sampleRate > 1 {
+		return fmt.Errorf("access log sample rate must be between 0 and 1")
+	}
+	if settings.maxBodySize == 0 {
+		settings.maxBodySize = defaultAccessLogMaxBodySize
+	}
+	for _, field := range alc.Redact {
+		if _, ok := accessLogRedactable[field]; !ok {
+			return fmt.Errorf("access log field %q cannot be redacted", field)
+		}
+		settings.redact[field] = struct{}{}
+	}
+	maxSize := alc.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultAccessLogMaxSize
+	}
+	maxFiles := alc.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = defaultAccessLogMaxFiles
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.out == nil || alc.Output != l.output || maxSize != l.maxSize || maxFiles != l.maxFiles {
+		var out io.Writer
+		var closer io.Closer
+		if alc.Output == accessLogStdout {
+			out = os.Stdout
+		} else {
+			rf, err := openRotatingFile(alc.Output, maxSize, maxFiles)
+			if err != nil {
+				return fmt.Errorf("cannot open access log: %w", err)
+			}
+			out, closer = rf, rf
+		}
+		if l.closer != nil {
+			if err := l.closer.Close(); err != nil {
+				log.Warnw("Failed to close access log", "err", err)
+			}
+		}
+		l.out, l.closer = out, closer
+		l.output, l.maxSize, l.maxFiles = alc.Output, maxSize, maxFiles
+	}
+	l.settings.Store(settings)
+	return nil
+}
+
+// close closes the access log output.
+func (l *accessLogger) close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	var err error
+	if l.closer != nil {
+		err = l.closer.Close()
+	}
+	l.out, l.closer, l.output = nil, nil, ""
+	return err
+}
+
+func (l *accessLogger) write(entry *accessLogEntry, settings *accessLogSettings) {
+	for field := range settings.redact {
+		switch field {
+		case "ClientIP":
+			entry.ClientIP = redactedValue
+		case "Path":
+			entry.Path = redactedValue
+		case "Query":
+			if entry.Query != "" {
+				entry.Query = redactedValue
+			}
+		case "RequestBody":
+			if entry.RequestBody != "" {
+				entry.RequestBody = redactedValue
+			}
+		case "ResponseBody":
+			if entry.ResponseBody != "" {
+				entry.ResponseBody = redactedValue
+			}
+		}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Warnw("Failed to marshal access log entry", "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.out == nil {
+		return
+	}
+	if _, err = l.out.Write(line); err != nil {
+		log.Warnw("Failed to write access log", "err", err)
+	}
+}
+
+// accessInfo collects the access log fields that are known by the request
+// handlers.
+type accessInfo struct {
+	backends atomic.Int32
+	results  atomic.Int32
+}
+
+type accessInfoKey struct{}
+
+// accessInfoFrom returns the accessInfo of a request context, or nil if the
+// request is not logged.
+func accessInfoFrom(ctx context.Context) *accessInfo {
+	ai, _ := ctx.Value(accessInfoKey{}).(*accessInfo)
+	return ai
+}
+
+// setAccessResults sets the number of results of a logged request.
+func setAccessResults(ctx context.Context, n int) {
+	if ai := accessInfoFrom(ctx); ai != nil {
+		ai.results.Store(int32(n))
+	}
+}
+
+// logAccess writes an access log entry for a sample of the requests handled
+// by next.
+func (s *server) logAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := s.accessLog.settings.Load()
+		if settings == nil || (settings.sampleRate < 1 && rand.Float64() >= settings.sampleRate) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		ai := &accessInfo{}
+		r = r.WithContext(context.WithValue(r.Context(), accessInfoKey{}, ai))
+		lw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+		var reqBody *limitedBuffer
+		if settings.debug {
+			reqBody = &limitedBuffer{max: settings.maxBodySize}
+			lw.body = &limitedBuffer{max: settings.maxBodySize}
+			r.Body = &teeReadCloser{ReadCloser: r.Body, w: reqBody}
+		}
+		entry := &accessLogEntry{
+			Time:     start,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Query:    r.URL.RawQuery,
+			ClientIP: s.state.Load().limiter.clientIP(r),
+		}
+
+		next.ServeHTTP(lw, r)
+
+		entry.Status = lw.status
+		entry.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+		entry.Bytes = lw.bytes
+		entry.Backends = ai.backends.Load()
+		entry.Results = ai.results.Load()
+		if settings.debug {
+			entry.RequestBody = reqBody.String()
+			entry.ResponseBody = lw.body.String()
+		}
+		s.accessLog.write(entry, settings)
+	})
+}
+
+// accessLogAdmin handles admin requests to get the access log settings, or to
+// change the sample rate and debug mode with the sample and debug query
+// parameters. Changed settings last until the config file is reloaded.
+func (s *server) accessLogAdmin(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		settings := s.accessLog.settings.Load()
+		if settings == nil {
+			http.Error(w, "access log is not enabled", http.StatusBadRequest)
+			return
+		}
+		updated := *settings
+		q := r.URL.Query()
+		if v := q.Get("sample"); v != "" {
+			rate, err := strconv.ParseFloat(v, 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				http.Error(w, "sample must be greater than 0 and at most 1", http.StatusBadRequest)
+				return
+			}
+			updated.sampleRate = rate
+		}
+		if v := q.Get("debug"); v != "" {
+			debug, err := strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "debug must be true or false", http.StatusBadRequest)
+				return
+			}
+			updated.debug = debug
+		}
+		// Only replace the settings that were loaded, so that a concurrent
+		// reload is not undone.
+		if !s.accessLog.settings.CompareAndSwap(settings, &updated) {
+			http.Error(w, "access log settings changed, try again", http.StatusConflict)
+			return
+		}
+		log.Infow("Access log settings changed by admin", "sample", updated.sampleRate, "debug", updated.debug)
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		w.Header().Add("Allow", http.MethodPost)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var out struct {
+		Enabled     bool
+		SampleRate  float64 `json:",omitempty"`
+		Debug       bool    `json:",omitempty"`
+		MaxBodySize int     `json:",omitempty"`
+	}
+	if settings := s.accessLog.settings.Load(); settings != nil {
+		out.Enabled = true
+		out.SampleRate = settings.sampleRate
+		out.Debug = settings.debug
+		out.MaxBodySize = settings.maxBodySize
+	}
+	body, err := json.Marshal(out)
+	if err != nil {
+		log.Errorw("Failed to marshal access log settings", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, body)
+}
+
+// accessLogWriter records the status and size of a response, and captures the
+// response body in debug mode.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+	body        *limitedBuffer
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	if w.body != nil {
+		_, _ = w.body.Write(p[:n])
+	}
+	return n, err
+}
+
+// Flush flushes streaming NDJSON responses.
+func (w *accessLogWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// limitedBuffer keeps up to max bytes of what is written to it, and discards
+// the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+type teeReadCloser struct {
+	io.ReadCloser
+	w io.Writer
+}
+
+func (t *teeReadCloser) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	if n > 0 {
+		_, _ = t.w.Write(p[:n])
+	}
+	return n, err
+}
+
+// rotatingFile is a file that is rotated when it reaches its maximum size.
+// Rotated files are renamed with the suffixes .1 to .maxFiles, .1 being the
+// most recent. It is not safe for concurrent use.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.file == nil {
+		// Reopening the file failed when it was rotated.
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return 0, err
+			}
+			log.Warnw("Failed to rotate access log", "err", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		log.Warnw("Failed to close access log", "err", err)
+	}
+	f.file = nil
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		old := f.path + "." + strconv.Itoa(i)
+		if err := os.Rename(old, f.path+"."+strconv.Itoa(i+1)); err != nil && !os.IsNotExist(err) {
+			log.Warnw("Failed to rotate access log", "err", err)
+		}
+	}
+	var err error
+	if f.maxFiles > 0 {
+		err = os.Rename(f.path, f.path+".1")
+	} else {
+		err = os.Remove(f.path)
+	}
+	// Keep writing to the file, even if it could not be rotated.
+	if openErr := f.open(); openErr != nil {
+		return openErr
+	}
+	return err
+}
+
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccessLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer backend.Close()
+	client := http.Client{Transport: &backendTransport{RoundTripper: http.DefaultTransport}}
+
+	logFile := filepath.Join(t.TempDir(), "access.log")
+	s := &server{}
+	s.state.Store(&serverState{})
+	require.NoError(t, s.accessLog.configure(&AccessLogConfig{
+		Output: logFile,
+		Debug:  true,
+		Redact: []string{"ClientIP"},
+	}))
+	defer s.accessLog.close()
+
+	h := s.logAccess(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.Equal(t, "request", string(body))
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, backend.URL, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		setAccessResults(r.Context(), 3)
+		w.WriteHeader(http.StatusTeapot)
+		_, _ = w.Write([]byte("response"))
+	}))
+	r := httptest.NewRequest(http.MethodPut, "/routing/v1/providers?x=1", strings.NewReader("request"))
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	data, err := os.ReadFile(logFile)
+	require.NoError(t, err)
+	var entry accessLogEntry
+	require.NoError(t, json.Unmarshal(data, &entry))
+	require.Equal(t, http.MethodPut, entry.Method)
+	require.Equal(t, "/routing/v1/providers", entry.Path)
+	require.Equal(t, "x=1", entry.Query)
+	require.Equal(t, http.StatusTeapot, entry.Status)
+	require.Equal(t, int64(len("response")), entry.Bytes)
+	require.Equal(t, int32(1), entry.Backends)
+	require.Equal(t, int32(3), entry.Results)
+	require.Equal(t, redactedValue, entry.ClientIP)
+	require.Equal(t, "request", entry.RequestBody)
+	require.Equal(t, "response", entry.ResponseBody)
+
+	// Debug mode is switched off at runtime.
+	rec := httptest.NewRecorder()
+	s.accessLogAdmin(rec, httptest.NewRequest(http.MethodPost, "/accesslog?debug=false", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"Enabled": true, "SampleRate": 1, "MaxBodySize": 65536}`, rec.Body.String())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/routing/v1/providers", strings.NewReader("request")))
+	data, err = os.ReadFile(logFile)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	entry = accessLogEntry{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Empty(t, entry.RequestBody)
+	require.Empty(t, entry.ResponseBody)
+
+	rec = httptest.NewRecorder()
+	s.accessLogAdmin(rec, httptest.NewRequest(http.MethodPost, "/accesslog?sample=2", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// Disabled access log is not written.
+	require.NoError(t, s.accessLog.configure(nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/routing/v1/providers", strings.NewReader("request")))
+	data, err = os.ReadFile(logFile)
+	require.NoError(t, err)
+	require.Len(t, strings.Split(strings.TrimSpace(string(data)), "\n"), 2)
+	rec = httptest.NewRecorder()
+	s.accessLogAdmin(rec, httptest.NewRequest(http.MethodGet, "/accesslog", nil))
+	require.JSONEq(t, `{"Enabled": false}`, rec.Body.String())
+}
+
+func TestAccessLogConfigure(t *testing.T) {
+	var l accessLogger
+	require.ErrorContains(t, l.configure(&AccessLogConfig{Output: accessLogStdout, SampleRate: 1.5}), "sample rate")
+	require.ErrorContains(t, l.configure(&AccessLogConfig{Output: accessLogStdout, Redact: []string{"Status"}}), "cannot be redacted")
+	require.Nil(t, l.settings.Load())
+	require.NoError(t, l.configure(&AccessLogConfig{Output: accessLogStdout, SampleRate: 0.1}))
+	require.Equal(t, 0.1, l.settings.Load().sampleRate)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f, err := openRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		require.NoError(t, err)
+		require.Equal(t, want, string(data))
+	}
+	_, err = os.Stat(path + ".3")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
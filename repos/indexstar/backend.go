@@ -1,10 +1,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	"github.com/ipni/indexstar/metrics"
 	"github.com/mercari/go-circuitbreaker"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
 )
 
 var Matchers struct {
@@ -19,11 +27,23 @@ type (
 		URL() *url.URL
 		CB() *circuitbreaker.CircuitBreaker
 		Matches(r *http.Request) bool
+		// Timeout limits calls to the backend. Zero means no limit other than
+		// the maximum wait for results.
+		Timeout() time.Duration
+		// Weight is the relative weight of the backend, at least 1.
+		Weight() int
+		// Drained reports whether the backend is drained, in which case no
+		// new requests are sent to it.
+		Drained() bool
+		SetDrained(drained bool)
 	}
 	SimpleBackend struct {
 		url     *url.URL
 		cb      *circuitbreaker.CircuitBreaker
 		matcher HttpRequestMatcher
+		timeout time.Duration
+		weight  int
+		drained atomic.Bool
 	}
 )
 
@@ -35,6 +55,27 @@ func (b *SimpleBackend) CB() *circuitbreaker.CircuitBreaker {
 	return b.cb
 }
 
+func (b *SimpleBackend) Timeout() time.Duration {
+	return b.timeout
+}
+
+func (b *SimpleBackend) Weight() int {
+	return b.weight
+}
+
+func (b *SimpleBackend) Drained() bool {
+	return b.drained.Load()
+}
+
+func (b *SimpleBackend) SetDrained(drained bool) {
+	b.drained.Store(drained)
+}
+
+// available returns whether new requests can be sent to the backend.
+func available(b Backend) bool {
+	return !b.Drained() && (b.CB() == nil || b.CB().Ready())
+}
+
 func init() {
 	Matchers.Any = func(*http.Request) bool { return true }
 	Matchers.AnyOf = func(ms ...HttpRequestMatcher) HttpRequestMatcher {
@@ -76,9 +117,80 @@ func NewBackend(u string, cb *circuitbreaker.CircuitBreaker, matcher HttpRequest
 		url:     burl,
 		cb:      cb,
 		matcher: matcher,
+		weight:  1,
+	}, nil
+}
+
+func newBackendFromConfig(bc BackendConfig, cb *circuitbreaker.CircuitBreaker, matcher HttpRequestMatcher) (Backend, error) {
+	burl, err := url.Parse(bc.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SimpleBackend{
+		url:     burl,
+		cb:      cb,
+		matcher: matcher,
+		timeout: time.Duration(bc.Timeout),
+		weight:  max(bc.Weight, 1),
 	}, nil
 }
 
 func (b *SimpleBackend) Matches(r *http.Request) bool {
 	return b.matcher(r)
 }
+
+// backendTransport records metrics of the requests sent to backends, by
+// backend host.
+type backendTransport struct {
+	http.RoundTripper
+}
+
+func (t *backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if ai := accessInfoFrom(req.Context()); ai != nil {
+		ai.backends.Add(1)
+	}
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(req)
+	latency := time.Since(start)
+
+	var status string
+	var failed bool
+	switch {
+	case err == nil:
+		status = strconv.Itoa(resp.StatusCode)
+		failed = resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusNotFound
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		// Requests are canceled when a scatter strategy is satisfied, or
+		// the maximum wait for results passes.
+		status = "canceled"
+	default:
+		status = "error"
+		failed = true
+	}
+	tags := []tag.Mutator{tag.Insert(metrics.Backend, req.URL.Host), tag.Insert(metrics.Status, status)}
+	_ = stats.RecordWithOptions(context.Background(),
+		stats.WithTags(tags...),
+		stats.WithMeasurements(metrics.BackendRequest.M(1), metrics.BackendLatency.M(float64(latency.Milliseconds()))))
+	if failed {
+		_ = stats.RecordWithOptions(context.Background(),
+			stats.WithTags(tags...),
+			stats.WithMeasurements(metrics.BackendError.M(1)))
+	}
+	return resp, err
+}
+
+// recordCircuitState records the circuit breaker state of a backend as 0 for
+// closed, 1 for half-open and 2 for open.
+func recordCircuitState(host string, state circuitbreaker.State) {
+	var value int64
+	switch state {
+	case circuitbreaker.StateHalfOpen:
+		value = 1
+	case circuitbreaker.StateOpen:
+		value = 2
+	}
+	_ = stats.RecordWithOptions(context.Background(),
+		stats.WithTags(tag.Insert(metrics.Backend, host)),
+		stats.WithMeasurements(metrics.BackendCircuit.M(value)))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mercari/go-circuitbreaker"
+)
+
+// backendStatus is the status of a backend listed by the admin API.
+type backendStatus struct {
+	URL      string
+	Kind     string
+	Matchers []MatcherConfig `json:",omitempty"`
+	Timeout  Duration        `json:",omitempty"`
+	Weight   int
+	Circuit  string
+	Counters circuitbreaker.Counters
+	Drained  bool
+}
+
+// listBackends handles admin requests to list the backends with their live
+// circuit breaker state.
+func (s *server) listBackends(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	st := s.state.Load()
+	statuses := make([]backendStatus, 0, len(st.backends))
+	for i, b := range st.backends {
+		bc := st.backendConfigs[i]
+		status := backendStatus{
+			URL:      b.URL().String(),
+			Kind:     bc.Kind,
+			Matchers: bc.Matchers,
+			Timeout:  Duration(b.Timeout()),
+			Weight:   b.Weight(),
+			Drained:  b.Drained(),
+		}
+		if cb := b.CB(); cb != nil {
+			status.Circuit = string(cb.State())
+			status.Counters = cb.Counters()
+		}
+		statuses = append(statuses, status)
+	}
+	body, err := json.Marshal(statuses)
+	if err != nil {
+		log.Errorw("Failed to marshal backends", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, http.StatusOK, body)
+}
+
+// setBackendCircuit handles admin requests to force the circuit of the
+// backends with the url query parameter open or closed, given by the state
+// query parameter. A circuit that is forced open becomes half-open after the
+// circuit open timeout, as usual.
+func (s *server) setBackendCircuit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	var state circuitbreaker.State
+	switch r.URL.Query().Get("state") {
+	case string(circuitbreaker.StateOpen):
+		state = circuitbreaker.StateOpen
+	case string(circuitbreaker.StateClosed):
+		state = circuitbreaker.StateClosed
+	default:
+		http.Error(w, "state must be open or closed", http.StatusBadRequest)
+		return
+	}
+	backends := s.findBackends(w, r)
+	for _, b := range backends {
+		if b.CB() == nil {
+			continue
+		}
+		b.CB().SetState(state)
+		recordCircuitState(b.URL().Host, state)
+		log.Infow("Circuit state set by admin", "backend", b.URL().String(), "state", state)
+	}
+	if len(backends) != 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// drainBackend handles admin requests to drain the backends with the url
+// query parameter. No new requests are sent to a drained backend, and
+// requests in progress complete. DELETE undrains the backends.
+func (s *server) drainBackend(w http.ResponseWriter, r *http.Request) {
+	var drained bool
+	switch r.Method {
+	case http.MethodPost:
+		drained = true
+	case http.MethodDelete:
+	default:
+		w.Header().Set("Allow", http.MethodPost)
+		w.Header().Add("Allow", http.MethodDelete)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	backends := s.findBackends(w, r)
+	for _, b := range backends {
+		b.SetDrained(drained)
+		log.Infow("Backend drain set by admin", "backend", b.URL().String(), "drained", drained)
+	}
+	if len(backends) != 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// findBackends returns the backends with the URL in the url query parameter.
+// If there are none, then an error response is written.
+func (s *server) findBackends(w http.ResponseWriter, r *http.Request) []Backend {
+	u := r.URL.Query().Get("url")
+	if u == "" {
+		http.Error(w, "missing url query parameter", http.StatusBadRequest)
+		return nil
+	}
+	var backends []Backend
+	for _, b := range s.state.Load().backends {
+		if b.URL().String() == u {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == 0 {
+		http.Error(w, "backend not found", http.StatusNotFound)
+	}
+	return backends
+}
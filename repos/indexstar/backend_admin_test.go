@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mercari/go-circuitbreaker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendAdmin(t *testing.T) {
+	cfgFile := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`{
+  "Server": {"CascadeLabels": "ipfs-dht"},
+  "Backends": [
+    {"URL": "http://a.invalid", "Weight": 2},
+    {"URL": "http://b.invalid", "Kind": "cascade"}
+  ]
+}`), 0666))
+	s := &server{
+		cfgBase:      cfgFile,
+		flagBackends: []BackendConfig{{URL: newProvidersBackend(t), Kind: backendKindProviders}},
+	}
+	require.NoError(t, s.Reload())
+
+	do := func(h http.HandlerFunc, method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	rec := do(s.listBackends, http.MethodGet, "/backends")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var statuses []backendStatus
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &statuses))
+	require.Len(t, statuses, 3)
+	require.Equal(t, "http://a.invalid", statuses[0].URL)
+	require.Equal(t, backendKindRegular, statuses[0].Kind)
+	require.Equal(t, 2, statuses[0].Weight)
+	require.Equal(t, string(circuitbreaker.StateClosed), statuses[0].Circuit)
+	require.Equal(t, backendKindCascade, statuses[1].Kind)
+	require.Equal(t, []MatcherConfig{{QueryParam: "cascade", Value: "ipfs-dht"}}, statuses[1].Matchers)
+
+	a := s.state.Load().backends[0]
+	rec = do(s.setBackendCircuit, http.MethodPost, "/backends/circuit?url=http://a.invalid&state=open")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, circuitbreaker.StateOpen, a.CB().State())
+	require.False(t, available(a))
+	rec = do(s.setBackendCircuit, http.MethodPost, "/backends/circuit?url=http://a.invalid&state=closed")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, available(a))
+
+	rec = do(s.setBackendCircuit, http.MethodPost, "/backends/circuit?url=http://a.invalid&state=bad")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = do(s.setBackendCircuit, http.MethodPost, "/backends/circuit?url=http://c.invalid&state=open")
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(s.drainBackend, http.MethodPost, "/backends/drain")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(s.drainBackend, http.MethodPost, "/backends/drain?url=http://b.invalid")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.False(t, available(s.state.Load().backends[1]))
+
+	// Backends stay drained across reloads.
+	require.NoError(t, s.Reload())
+	st := s.state.Load()
+	require.True(t, st.backends[1].Drained())
+	require.False(t, st.backends[0].Drained())
+
+	rec = do(s.drainBackend, http.MethodDelete, "/backends/drain?url=http://b.invalid")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.True(t, available(st.backends[1]))
+
+	rec = do(s.drainBackend, http.MethodGet, "/backends/drain?url=http://b.invalid")
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	// A forced open circuit stays open across reloads, including when the
+	// circuit config of the backend changes.
+	rec = do(s.setBackendCircuit, http.MethodPost, "/backends/circuit?url=http://a.invalid&state=open")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, s.Reload())
+	require.Equal(t, circuitbreaker.StateOpen, s.state.Load().backends[0].CB().State())
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`{
+  "Server": {"CascadeLabels": "ipfs-dht"},
+  "Backends": [
+    {"URL": "http://a.invalid", "Weight": 2, "Circuit": {"HalfOpenSuccesses": 3}},
+    {"URL": "http://b.invalid", "Kind": "cascade"}
+  ]
+}`), 0666))
+	require.NoError(t, s.Reload())
+	a2 := s.state.Load().backends[0]
+	require.NotSame(t, a.CB(), a2.CB())
+	require.Equal(t, circuitbreaker.StateOpen, a2.CB().State())
+	require.False(t, available(a2))
+}
+
+func TestBackendTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusTeapot)
+	}))
+	defer ts.Close()
+
+	c := http.Client{Transport: &backendTransport{RoundTripper: http.DefaultTransport}}
+	resp, err := c.Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
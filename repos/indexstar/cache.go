@@ -0,0 +1,282 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/indexstar/metrics"
+	"github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	// cacheKindJson is the kind of cache entry that holds a merged JSON find
+	// response.
+	cacheKindJson = "json"
+	// cacheKindNDJson is the kind of cache entry that holds the results
+	// gathered for a streaming NDJSON find response.
+	cacheKindNDJson = "ndjson"
+)
+
+type (
+	// findCache is a bounded in-memory cache of find responses. Entries are
+	// evicted least recently used first, and expire after a TTL. Not found
+	// responses are cached with a separate, usually shorter, TTL.
+	findCache struct {
+		mutex       sync.Mutex
+		entries     map[string]*list.Element
+		lru         *list.List
+		size        int
+		ttl         time.Duration
+		negativeTTL time.Duration
+	}
+
+	cacheEntry struct {
+		key     string
+		mh      string
+		expires time.Time
+		// status is the response status, either http.StatusOK or
+		// http.StatusNotFound.
+		status int
+		// body is the merged response of a cacheKindJson entry.
+		body []byte
+		// results are the unique results of a cacheKindNDJson entry.
+		results []encryptedOrPlainResult
+	}
+)
+
+// backendCounts counts the backends that are eligible for a find request, and
+// the backends that answered with a found or not found response. A response
+// is only cached if every eligible backend answered. Backends that are not
+// queried, because their circuit is open or because the scatter strategy was
+// satisfied first, leave the response incomplete.
+type backendCounts struct {
+	eligible int32
+	answered atomic.Int32
+}
+
+// newBackendCounts counts the backends, that are not drained, to which a find
+// request for reqURL is sent.
+func newBackendCounts(backends []Backend, reqURL *url.URL, encrypted bool) *backendCounts {
+	var c backendCounts
+	for _, b := range backends {
+		if !b.Drained() && findEligible(b, reqURL, encrypted) {
+			c.eligible++
+		}
+	}
+	return &c
+}
+
+// complete returns true if every eligible backend answered.
+func (c *backendCounts) complete() bool {
+	return c.answered.Load() == c.eligible
+}
+
+// findEligible returns true if a find request for reqURL is sent to the
+// backend. Double hashed requests are sent to double hashed backends only, and
+// regular requests to regular backends only.
+func findEligible(b Backend, reqURL *url.URL, encrypted bool) bool {
+	_, isDhBackend := b.(dhBackend)
+	_, isProvidersBackend := b.(providersBackend)
+	_, isProvideBackend := b.(provideBackend)
+	if (encrypted != isDhBackend) || isProvidersBackend || isProvideBackend {
+		return false
+	}
+	return b.Matches(&http.Request{URL: reqURL})
+}
+
+// newFindCache creates a new findCache that holds up to size entries. Returns
+// nil, which is a disabled cache, if size or ttl is not positive.
+func newFindCache(size int, ttl, negativeTTL time.Duration) *findCache {
+	if size <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &findCache{
+		entries:     make(map[string]*list.Element),
+		lru:         list.New(),
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+	}
+}
+
+// cacheKey returns the key of a cached response. The query string is part of
+// the key since it determines which cascade backends are queried.
+func cacheKey(kind string, mh multihash.Multihash, encrypted bool, query string) string {
+	return fmt.Sprintf("%s/%t/%s?%s", kind, encrypted, mh.B58String(), query)
+}
+
+// get returns the unexpired entry for the key, and records a cache hit or
+// miss.
+func (c *findCache) get(kind, key string) (*cacheEntry, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mutex.Lock()
+	var entry *cacheEntry
+	elem, ok := c.entries[key]
+	if ok {
+		entry = elem.Value.(*cacheEntry)
+		if time.Now().After(entry.expires) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+			ok = false
+		} else {
+			c.lru.MoveToFront(elem)
+		}
+	}
+	c.mutex.Unlock()
+
+	hit := "no"
+	if ok {
+		hit = "yes"
+	}
+	_ = stats.RecordWithOptions(context.Background(),
+		stats.WithTags(tag.Insert(metrics.Method, kind), tag.Insert(metrics.CacheHit, hit)),
+		stats.WithMeasurements(metrics.CacheLookup.M(1)))
+	if !ok {
+		return nil, false
+	}
+	return entry, true
+}
+
+// put stores an entry for a found or not found response. Other responses are
+// not cached.
+func (c *findCache) put(key string, mh multihash.Multihash, entry *cacheEntry) {
+	if c == nil {
+		return
+	}
+	var ttl time.Duration
+	switch entry.status {
+	case http.StatusOK:
+		ttl = c.ttl
+	case http.StatusNotFound:
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+	entry.key = key
+	entry.mh = string(mh)
+	entry.expires = time.Now().Add(ttl)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// purge removes all entries for the multihash, or all entries if mh is nil.
+// Returns the number of entries removed.
+func (c *findCache) purge(mh multihash.Multihash) int {
+	if c == nil {
+		return 0
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if mh == nil {
+		n := c.lru.Len()
+		c.entries = make(map[string]*list.Element)
+		c.lru.Init()
+		return n
+	}
+	var n int
+	for key, elem := range c.entries {
+		if elem.Value.(*cacheEntry).mh == string(mh) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+// findCached is a findFunc that returns a cached find response if there is
+// one, and otherwise calls doFind and caches its response if every eligible
+// backend answered.
+func (s *server) findCached(ctx context.Context, method, source string, reqURL *url.URL, encrypted bool) (int, []byte) {
+	mh, err := multihashFromPath(reqURL.Path)
+	if err != nil || method != http.MethodGet {
+		rcode, resp, _ := s.doFind(ctx, method, source, reqURL, encrypted)
+		return rcode, resp
+	}
+	key := cacheKey(cacheKindJson, mh, encrypted, reqURL.RawQuery)
+	if entry, ok := s.cache.get(cacheKindJson, key); ok {
+		// Results are only counted for the access log.
+		if entry.status == http.StatusOK && accessInfoFrom(ctx) != nil {
+			if resp, err := model.UnmarshalFindResponse(entry.body); err == nil {
+				setAccessResults(ctx, findResultCount(resp))
+			}
+		}
+		return entry.status, entry.body
+	}
+	rcode, resp, complete := s.doFind(ctx, method, source, reqURL, encrypted)
+	if complete {
+		s.cache.put(key, mh, &cacheEntry{status: rcode, body: resp})
+	}
+	return rcode, resp
+}
+
+// multihashFromPath returns the multihash identified by the last element of a
+// URL path, which is either a CID or a base58 or hex encoded multihash.
+func multihashFromPath(p string) (multihash.Multihash, error) {
+	s := path.Base(p)
+	c, err := cid.Decode(s)
+	if err == nil {
+		return c.Hash(), nil
+	}
+	mh, err := multihash.FromB58String(s)
+	if err != nil {
+		var hexErr error
+		mh, hexErr = multihash.FromHexString(s)
+		if hexErr != nil {
+			return nil, err
+		}
+	}
+	return mh, nil
+}
+
+// purgeCache handles admin requests to purge the find cache. DELETE /cache
+// purges all entries and DELETE /cache/{cid or multihash} purges the entries
+// for one multihash.
+func (s *server) purgeCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	var mh multihash.Multihash
+	if strings.TrimPrefix(strings.TrimSuffix(r.URL.Path, "/"), "/cache") != "" {
+		var err error
+		mh, err = multihashFromPath(r.URL.Path)
+		if err != nil {
+			http.Error(w, "invalid cid or multihash: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	n := s.cache.purge(mh)
+	log.Infow("Purged find cache", "entries", n, "multihash", mh)
+	writeJsonResponse(w, http.StatusOK, []byte(fmt.Sprintf(`{"Purged":%d}`, n)))
+}
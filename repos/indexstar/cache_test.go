@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mercari/go-circuitbreaker"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCache(t *testing.T) {
+	require.Nil(t, newFindCache(0, time.Minute, time.Minute))
+	var disabled *findCache
+	disabled.put("key", nil, &cacheEntry{status: http.StatusOK})
+	_, ok := disabled.get(cacheKindJson, "key")
+	require.False(t, ok)
+
+	mh1, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	mh2, err := multihash.Sum([]byte("lobster"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+
+	c := newFindCache(2, time.Minute, 50*time.Millisecond)
+	key1 := cacheKey(cacheKindJson, mh1, false, "")
+	key2 := cacheKey(cacheKindNDJson, mh1, false, "")
+	key3 := cacheKey(cacheKindJson, mh2, false, "")
+	require.NotEqual(t, key1, cacheKey(cacheKindJson, mh1, true, ""))
+	require.NotEqual(t, key1, cacheKey(cacheKindJson, mh1, false, "cascade=ipfs-dht"))
+
+	// Only found and not found responses are cached.
+	c.put(key1, mh1, &cacheEntry{status: http.StatusInternalServerError})
+	_, ok = c.get(cacheKindJson, key1)
+	require.False(t, ok)
+
+	c.put(key1, mh1, &cacheEntry{status: http.StatusOK, body: []byte("found")})
+	entry, ok := c.get(cacheKindJson, key1)
+	require.True(t, ok)
+	require.Equal(t, []byte("found"), entry.body)
+
+	// Not found responses expire after the negative TTL.
+	c.put(key2, mh1, &cacheEntry{status: http.StatusNotFound})
+	entry, ok = c.get(cacheKindNDJson, key2)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, entry.status)
+	require.Eventually(t, func() bool {
+		_, ok := c.get(cacheKindNDJson, key2)
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+
+	// Least recently used entry is evicted.
+	c.put(key2, mh1, &cacheEntry{status: http.StatusOK})
+	_, ok = c.get(cacheKindJson, key1)
+	require.True(t, ok)
+	c.put(key3, mh2, &cacheEntry{status: http.StatusOK})
+	_, ok = c.get(cacheKindNDJson, key2)
+	require.False(t, ok)
+	_, ok = c.get(cacheKindJson, key1)
+	require.True(t, ok)
+
+	// Purge entries for one multihash, then all entries.
+	require.Equal(t, 1, c.purge(mh1))
+	_, ok = c.get(cacheKindJson, key1)
+	require.False(t, ok)
+	c.put(key1, mh1, &cacheEntry{status: http.StatusOK})
+	require.Equal(t, 2, c.purge(nil))
+	_, ok = c.get(cacheKindJson, key3)
+	require.False(t, ok)
+}
+
+func TestPurgeCache(t *testing.T) {
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, mh)
+
+	s := &server{cache: newFindCache(10, time.Minute, time.Minute)}
+	s.cache.put(cacheKey(cacheKindJson, mh, false, ""), mh, &cacheEntry{status: http.StatusOK})
+	s.cache.put(cacheKey(cacheKindNDJson, mh, false, ""), mh, &cacheEntry{status: http.StatusOK})
+
+	rec := httptest.NewRecorder()
+	s.purgeCache(rec, httptest.NewRequest(http.MethodGet, "/cache", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.purgeCache(rec, httptest.NewRequest(http.MethodDelete, "/cache/bad", nil))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = httptest.NewRecorder()
+	s.purgeCache(rec, httptest.NewRequest(http.MethodDelete, "/cache/"+c.String(), nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"Purged":2}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	s.purgeCache(rec, httptest.NewRequest(http.MethodDelete, "/cache", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.JSONEq(t, `{"Purged":0}`, rec.Body.String())
+}
+
+func TestFindCachedIncomplete(t *testing.T) {
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusNotFound)
+	}))
+	defer notFound.Close()
+	var failing atomic.Bool
+	failing.Store(true)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, "", http.StatusNotFound)
+	}))
+	defer flaky.Close()
+
+	var backends []Backend
+	for _, u := range []string{notFound.URL, flaky.URL} {
+		b, err := NewBackend(u, circuitbreaker.New(circuitbreaker.WithFailOnContextCancel(false)), Matchers.Any)
+		require.NoError(t, err)
+		backends = append(backends, b)
+	}
+	s := &server{cache: newFindCache(10, time.Minute, time.Minute)}
+	s.state.Store(&serverState{backends: backends, resultMaxWait: 5 * time.Second, resultStreamMaxWait: 5 * time.Second})
+
+	mh := multihash.Multihash(cid.MustParse("bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e").Hash())
+	reqURL, err := url.Parse("/multihash/" + mh.B58String())
+	require.NoError(t, err)
+	jsonKey := cacheKey(cacheKindJson, mh, false, "")
+	ndjsonKey := cacheKey(cacheKindNDJson, mh, false, "")
+	findNDJson := func() int {
+		rec := httptest.NewRecorder()
+		s.doFindNDJson(context.Background(), rec, findMethodOrig, reqURL, false, mh, false, nil)
+		return rec.Code
+	}
+
+	// Not found is not cached while a backend fails to answer.
+	rcode, _ := s.findCached(context.Background(), http.MethodGet, findMethodOrig, reqURL, false)
+	require.Equal(t, http.StatusNotFound, rcode)
+	_, ok := s.cache.get(cacheKindJson, jsonKey)
+	require.False(t, ok)
+	require.Equal(t, http.StatusNotFound, findNDJson())
+	_, ok = s.cache.get(cacheKindNDJson, ndjsonKey)
+	require.False(t, ok)
+
+	// Not found is cached once all backends answer.
+	failing.Store(false)
+	rcode, _ = s.findCached(context.Background(), http.MethodGet, findMethodOrig, reqURL, false)
+	require.Equal(t, http.StatusNotFound, rcode)
+	_, ok = s.cache.get(cacheKindJson, jsonKey)
+	require.True(t, ok)
+	require.Equal(t, http.StatusNotFound, findNDJson())
+	_, ok = s.cache.get(cacheKindNDJson, ndjsonKey)
+	require.True(t, ok)
+}
+
+func TestFindCachedStrategyIncomplete(t *testing.T) {
+	mh := multihash.Multihash(cid.MustParse("bafkreifzjut3te2nhyekklss27nh3k72ysco7y32koao5eei66wof36n5e").Hash())
+	pid, err := peer.Decode("12D3KooWHus2xMGRSB3TmHbTtTjuY9HuuKBRqXKg2g2CTSSFMSTL")
+	require.NoError(t, err)
+	found, err := model.MarshalFindResponse(&model.FindResponse{
+		MultihashResults: []model.MultihashResult{{
+			Multihash: mh,
+			ProviderResults: []model.ProviderResult{{
+				ContextID: []byte("ctx"),
+				Provider: &peer.AddrInfo{
+					ID:    pid,
+					Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")},
+				},
+			}},
+		}},
+	})
+	require.NoError(t, err)
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJsonResponse(w, http.StatusOK, found)
+	}))
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer slow.Close()
+
+	var backends []Backend
+	for _, u := range []string{fast.URL, slow.URL} {
+		b, err := NewBackend(u, circuitbreaker.New(circuitbreaker.WithFailOnContextCancel(false)), Matchers.Any)
+		require.NoError(t, err)
+		backends = append(backends, b)
+	}
+	s := &server{cache: newFindCache(10, time.Minute, time.Minute)}
+	s.state.Store(&serverState{
+		backends:      backends,
+		resultMaxWait: 5 * time.Second,
+		strategies: map[string]*scatterStrategy{
+			findMethodOrig: {name: strategyFirstSuccess},
+		},
+	})
+
+	reqURL, err := url.Parse("/multihash/" + mh.B58String())
+	require.NoError(t, err)
+
+	// The first success cancels the query to the slow backend, so the
+	// response does not include every backend and is not cached.
+	rcode, _ := s.findCached(context.Background(), http.MethodGet, findMethodOrig, reqURL, false)
+	require.Equal(t, http.StatusOK, rcode)
+	_, ok := s.cache.get(cacheKindJson, cacheKey(cacheKindJson, mh, false, ""))
+	require.False(t, ok)
+}
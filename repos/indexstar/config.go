@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -22,6 +24,15 @@ const (
 	defaultServerResultStreamMaxWait        = 20 * time.Second
 	defaultServerMaxRequestBodySize  int64  = 8 << 10 // 8KiB
 	defaultServerCascadeLabels       string = ""      // 8KiB
+	defaultServerProvideRouting             = provideRoutingRoundRobin
+
+	defaultServerFindStrategy          = strategyAll
+	defaultServerDelegatedFindStrategy = strategyAll
+	defaultServerMetadataStrategy      = strategyFirstSuccess
+	defaultServerQuorum                = 2
+	defaultServerHedgePercentile       = 95
+	defaultServerHedgeDelay            = 100 * time.Millisecond
+	defaultServerRankResults           = false
 
 	defaultCircuitHalfOpenSuccesses = 10
 	defaultCircuitOpenTimeout       = 0
@@ -31,6 +42,10 @@ const (
 	defaultCascadeCircuitOpenTimeout       = 0
 	defaultCascadeCircuitCounterReset      = 1 * time.Second
 
+	defaultCacheSize        = 10000
+	defaultCacheTTL         = 30 * time.Second
+	defaultCacheNegativeTTL = 5 * time.Second
+
 	// DefaultPathName is the default config dir name.
 	DefaultPathName = ".indexstar"
 	// DefaultPathRoot is the path to the default config dir location.
@@ -53,6 +68,19 @@ var config struct {
 		ResultStreamMaxWait time.Duration
 		MaxRequestBodySize  int64
 		CascadeLabels       string
+		ProvideRouting      string
+		// FindStrategy, DelegatedFindStrategy and MetadataStrategy are the
+		// scatter strategies of non-streaming find, delegated routing find
+		// and find metadata requests.
+		FindStrategy          string
+		DelegatedFindStrategy string
+		MetadataStrategy      string
+		Quorum                int
+		HedgePercentile       int
+		HedgeDelay            time.Duration
+		// RankResults enables ranking of non-streaming find results using
+		// provider information.
+		RankResults bool
 	}
 	Circuit struct {
 		HalfOpenSuccesses int
@@ -64,6 +92,11 @@ var config struct {
 		OpenTimeout       time.Duration
 		CounterReset      time.Duration
 	}
+	Cache struct {
+		Size        int
+		TTL         time.Duration
+		NegativeTTL time.Duration
+	}
 }
 
 func init() {
@@ -77,6 +110,14 @@ func init() {
 	config.Server.ResultStreamMaxWait = getEnvOrDefault[time.Duration]("SERVER_RESULT_STREAM_MAX_WAIT", defaultServerResultStreamMaxWait)
 	config.Server.MaxRequestBodySize = getEnvOrDefault[int64]("SERVER_MAX_REQUEST_BODY_SIZE", defaultServerMaxRequestBodySize)
 	config.Server.CascadeLabels = getEnvOrDefault[string]("SERVER_CASCADE_LABELS", defaultServerCascadeLabels)
+	config.Server.ProvideRouting = getEnvOrDefault[string]("SERVER_PROVIDE_ROUTING", defaultServerProvideRouting)
+	config.Server.FindStrategy = getEnvOrDefault[string]("SERVER_FIND_STRATEGY", defaultServerFindStrategy)
+	config.Server.DelegatedFindStrategy = getEnvOrDefault[string]("SERVER_DELEGATED_FIND_STRATEGY", defaultServerDelegatedFindStrategy)
+	config.Server.MetadataStrategy = getEnvOrDefault[string]("SERVER_METADATA_STRATEGY", defaultServerMetadataStrategy)
+	config.Server.Quorum = getEnvOrDefault[int]("SERVER_QUORUM", defaultServerQuorum)
+	config.Server.HedgePercentile = getEnvOrDefault[int]("SERVER_HEDGE_PERCENTILE", defaultServerHedgePercentile)
+	config.Server.HedgeDelay = getEnvOrDefault[time.Duration]("SERVER_HEDGE_DELAY", defaultServerHedgeDelay)
+	config.Server.RankResults = getEnvOrDefault[bool]("SERVER_RANK_RESULTS", defaultServerRankResults)
 
 	config.Circuit.HalfOpenSuccesses = getEnvOrDefault[int]("CIRCUIT_HALF_OPEN_SUCCESSES", defaultCircuitHalfOpenSuccesses)
 	config.Circuit.OpenTimeout = getEnvOrDefault[time.Duration]("CIRCUIT_OPEN_TIMEOUT", defaultCircuitOpenTimeout)
@@ -85,6 +126,10 @@ func init() {
 	config.CascadeCircuit.HalfOpenSuccesses = getEnvOrDefault[int]("CASCADE_CIRCUIT_HALF_OPEN_SUCCESSES", defaultCascadeCircuitHalfOpenSuccesses)
 	config.CascadeCircuit.OpenTimeout = getEnvOrDefault[time.Duration]("CASCADE_CIRCUIT_OPEN_TIMEOUT", defaultCascadeCircuitOpenTimeout)
 	config.CascadeCircuit.CounterReset = getEnvOrDefault[time.Duration]("CASCADE_CIRCUIT_COUNTER_RESET", defaultCascadeCircuitCounterReset)
+
+	config.Cache.Size = getEnvOrDefault[int]("CACHE_SIZE", defaultCacheSize)
+	config.Cache.TTL = getEnvOrDefault[time.Duration]("CACHE_TTL", defaultCacheTTL)
+	config.Cache.NegativeTTL = getEnvOrDefault[time.Duration]("CACHE_NEGATIVE_TTL", defaultCacheNegativeTTL)
 }
 
 func getEnvOrDefault[T any](key string, def T) T {
@@ -119,6 +164,13 @@ func getEnvOrDefault[T any](key string, def T) T {
 			return def
 		}
 		return any(v).(T)
+	case bool:
+		pv, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Warnf("Failed to parse %s=%s environment variable as bool. Falling back on default %v", key, v, def)
+			return def
+		}
+		return any(pv).(T)
 	default:
 		log.Warnf("Unknown type for %s=%s environment variable. Falling back on default %v", key, v, def)
 		return def
@@ -161,7 +213,10 @@ func PathRoot() (string, error) {
 	return homedir.Expand(DefaultPathRoot)
 }
 
-func Load(filePath string) ([]string, error) {
+// Load reads the config file. If filePath is empty, the default config file
+// is read. A config file that holds a JSON list of URLs, instead of a
+// FileConfig, is read as a list of regular backends.
+func Load(filePath string) (*FileConfig, error) {
 	var err error
 	if filePath == "" {
 		filePath, err = Filename("")
@@ -170,18 +225,215 @@ func Load(filePath string) ([]string, error) {
 		}
 	}
 
-	f, err := os.Open(filePath)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			err = ErrNotInitialized
 		}
 		return nil, err
 	}
-	defer f.Close()
 
-	var urls []string
-	if err = json.NewDecoder(f).Decode(&urls); err != nil {
+	var fc FileConfig
+	if trimmed := bytes.TrimSpace(data); len(trimmed) != 0 && trimmed[0] == '[' {
+		var urls []string
+		if err = json.Unmarshal(trimmed, &urls); err != nil {
+			return nil, err
+		}
+		for _, u := range urls {
+			fc.Backends = append(fc.Backends, BackendConfig{URL: u})
+		}
+		return &fc, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&fc); err != nil {
 		return nil, err
 	}
-	return urls, nil
+	for i, bc := range fc.Backends {
+		if bc.URL == "" {
+			return nil, fmt.Errorf("backend %d has no url", i)
+		}
+		switch bc.Kind {
+		case "", backendKindRegular, backendKindCascade, backendKindDh, backendKindProviders, backendKindProvide:
+		default:
+			return nil, fmt.Errorf("backend %s has unknown kind %q", bc.URL, bc.Kind)
+		}
+	}
+	return &fc, nil
+}
+
+const (
+	backendKindRegular   = "regular"
+	backendKindCascade   = "cascade"
+	backendKindDh        = "dh"
+	backendKindProviders = "providers"
+	backendKindProvide   = "provide"
+)
+
+// FileConfig is the content of the config file. Settings that are not in the
+// config file are taken from environment variables. The config file is
+// reloaded while the server is running.
+type FileConfig struct {
+	Server         ServerConfig
+	Circuit        CircuitConfig
+	CascadeCircuit CircuitConfig
+	Backends       []BackendConfig
+	// RateLimit limits requests per client. Requests are not limited if nil.
+	RateLimit *RateLimitConfig
+	// AccessLog configures access logging. Requests are not logged if nil.
+	AccessLog *AccessLogConfig
+}
+
+// ServerConfig holds the server settings that can be changed by reloading
+// the config file. Zero values are not set.
+type ServerConfig struct {
+	ResultMaxWait         Duration
+	ResultStreamMaxWait   Duration
+	CascadeLabels         string
+	ProvideRouting        string
+	FindStrategy          string
+	DelegatedFindStrategy string
+	MetadataStrategy      string
+	Quorum                int
+	HedgePercentile       int
+	HedgeDelay            Duration
+	// RankResults is not set if nil.
+	RankResults *bool
+}
+
+// CircuitConfig holds circuit breaker settings. Zero values are not set.
+type CircuitConfig struct {
+	HalfOpenSuccesses int
+	OpenTimeout       Duration
+	CounterReset      Duration
+}
+
+// BackendConfig configures one backend.
+type BackendConfig struct {
+	URL string
+	// Kind is one of "regular", "cascade", "dh", "providers" or "provide".
+	// Defaults to "regular".
+	Kind string
+	// Timeout limits calls to the backend, in addition to the maximum wait
+	// for results.
+	Timeout Duration
+	// Circuit overrides the circuit breaker settings for the backend kind.
+	Circuit *CircuitConfig
+	// Matchers limits requests sent to the backend to those with any of the
+	// query parameters. Cascade backends default to matching the cascade
+	// labels.
+	Matchers []MatcherConfig
+	// Weight orders backends for hedged scatters, higher first, and is the
+	// share of provide requests routed round-robin to a provide backend.
+	// Defaults to 1.
+	Weight int
+}
+
+// RateLimitConfig configures per client rate limiting. Clients are limited
+// by IP address, unless the request has a known API key, in which case the
+// limits of the API key apply.
+type RateLimitConfig struct {
+	// TrustedProxies are the IP addresses or CIDR prefixes of proxies whose
+	// X-Forwarded-For header is used to get the client IP address.
+	TrustedProxies []string
+	// Find limits the /cid, /multihash and /metadata routes per client IP.
+	Find *LimitConfig
+	// Delegated limits the /routing/v1 routes per client IP.
+	Delegated *LimitConfig
+	// Providers limits the /providers routes per client IP.
+	Providers *LimitConfig
+	// APIKeys are the API keys that clients send as a bearer token in the
+	// Authorization header.
+	APIKeys []APIKeyConfig
+}
+
+// APIKeyConfig configures the limits of requests with an API key. Routes
+// with no limit are not limited for the API key.
+type APIKeyConfig struct {
+	Key       string
+	Find      *LimitConfig
+	Delegated *LimitConfig
+	Providers *LimitConfig
+}
+
+// LimitConfig configures a token bucket. Rate is the number of requests per
+// second, and Burst is the number of requests allowed at once. Burst defaults
+// to Rate, rounded up. A zero Rate is not limited.
+type LimitConfig struct {
+	Rate  float64
+	Burst int
+}
+
+// AccessLogConfig configures structured access logging.
+type AccessLogConfig struct {
+	// Output is "stdout" or the path of the access log file. Access logging
+	// is disabled if empty.
+	Output string
+	// MaxSize is the size in bytes at which the access log file is rotated.
+	// Defaults to 100MiB.
+	MaxSize int64
+	// MaxFiles is the number of rotated access log files kept. Defaults to 5.
+	MaxFiles int
+	// SampleRate is the fraction of requests that are logged, from 0 to 1.
+	// Defaults to 1.
+	SampleRate float64
+	// Debug enables capture of request and response bodies.
+	Debug bool
+	// MaxBodySize is the number of bytes of each body captured in debug mode.
+	// Defaults to 64KiB.
+	MaxBodySize int
+	// Redact are the names of the fields that are redacted: ClientIP, Path,
+	// Query, RequestBody and ResponseBody.
+	Redact []string
+}
+
+// MatcherConfig matches requests that have a query parameter with a value.
+type MatcherConfig struct {
+	QueryParam string
+	Value      string
+}
+
+// Duration is a time.Duration that is written to JSON as a duration string,
+// such as "5s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		var n int64
+		if json.Unmarshal(data, &n) != nil {
+			return err
+		}
+		*d = Duration(n)
+		return nil
+	}
+	pd, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(pd)
+	return nil
+}
+
+// circuitConfig returns the circuit breaker settings def, overridden by the
+// settings that are set in cc.
+func circuitConfig(def CircuitConfig, cc *CircuitConfig) CircuitConfig {
+	if cc == nil {
+		return def
+	}
+	if cc.HalfOpenSuccesses != 0 {
+		def.HalfOpenSuccesses = cc.HalfOpenSuccesses
+	}
+	if cc.OpenTimeout != 0 {
+		def.OpenTimeout = cc.OpenTimeout
+	}
+	if cc.CounterReset != 0 {
+		def.CounterReset = cc.CounterReset
+	}
+	return def
 }
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -13,4 +18,84 @@ func Test_DefaultConfig(t *testing.T) {
 	require.Equal(t, defaultServerHttpClientTimeout, config.Server.HttpClientTimeout)
 	require.Equal(t, defaultServerMaxRequestBodySize, config.Server.MaxRequestBodySize)
 	require.Equal(t, defaultServerCascadeLabels, config.Server.CascadeLabels)
+	require.Equal(t, defaultServerProvideRouting, config.Server.ProvideRouting)
+	require.Equal(t, defaultServerFindStrategy, config.Server.FindStrategy)
+	require.Equal(t, defaultServerMetadataStrategy, config.Server.MetadataStrategy)
+	require.Equal(t, defaultServerRankResults, config.Server.RankResults)
+	require.Equal(t, defaultCacheSize, config.Cache.Size)
+	require.Equal(t, defaultCacheTTL, config.Cache.TTL)
+	require.Equal(t, defaultCacheNegativeTTL, config.Cache.NegativeTTL)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Load(filepath.Join(dir, "missing"))
+	require.ErrorIs(t, err, ErrNotInitialized)
+
+	// List of URLs is read as regular backends.
+	listFile := filepath.Join(dir, "list")
+	require.NoError(t, os.WriteFile(listFile, []byte(`["http://a.invalid", "http://b.invalid"]`), 0666))
+	fc, err := Load(listFile)
+	require.NoError(t, err)
+	require.Equal(t, []BackendConfig{{URL: "http://a.invalid"}, {URL: "http://b.invalid"}}, fc.Backends)
+
+	cfgFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`{
+  "Server": {"ResultMaxWait": "2s", "CascadeLabels": "ipfs-dht", "FindStrategy": "first-success"},
+  "Circuit": {"HalfOpenSuccesses": 3},
+  "RateLimit": {"TrustedProxies": ["10.0.0.0/8"], "Find": {"Rate": 10}, "APIKeys": [{"Key": "secret"}]},
+  "Backends": [
+    {"URL": "http://regular.invalid", "Timeout": "1s", "Weight": 2},
+    {"URL": "http://cascade.invalid", "Kind": "cascade", "Circuit": {"OpenTimeout": "10s"}},
+    {"URL": "http://dh.invalid", "Kind": "dh", "Matchers": [{"QueryParam": "dh", "Value": "yes"}]},
+    {"URL": "http://provide.invalid", "Kind": "provide"}
+  ]
+}`), 0666))
+	fc, err = Load(cfgFile)
+	require.NoError(t, err)
+	require.Equal(t, Duration(2*time.Second), fc.Server.ResultMaxWait)
+	require.Equal(t, 3, fc.Circuit.HalfOpenSuccesses)
+	require.Len(t, fc.Backends, 4)
+	require.Equal(t, Duration(time.Second), fc.Backends[0].Timeout)
+	require.Equal(t, Duration(10*time.Second), fc.Backends[1].Circuit.OpenTimeout)
+	require.Equal(t, 10.0, fc.RateLimit.Find.Rate)
+
+	st, err := newServerState(fc, []BackendConfig{{URL: "http://providers.invalid", Kind: backendKindProviders}}, nil)
+	require.NoError(t, err)
+	require.Equal(t, 2*time.Second, st.resultMaxWait)
+	require.Equal(t, config.Server.ResultStreamMaxWait, st.resultStreamMaxWait)
+	require.Equal(t, "ipfs-dht", st.cascadeLabels)
+	require.Equal(t, strategyFirstSuccess, st.strategies[findMethodOrig].String())
+	require.Equal(t, config.Server.DelegatedFindStrategy, st.strategies[findMethodDelegated].String())
+	require.Len(t, st.backends, 5)
+	require.Equal(t, LimitConfig{Rate: 10, Burst: 10}, st.limiter.ipLimits[routeFind])
+
+	require.Equal(t, time.Second, st.backends[0].Timeout())
+	require.Equal(t, 2, st.backends[0].Weight())
+	cascade, ok := st.backends[1].(caskadeBackend)
+	require.True(t, ok)
+	require.Equal(t, 1, cascade.Weight())
+	dh, ok := st.backends[2].(dhBackend)
+	require.True(t, ok)
+	_, ok = st.backends[3].(provideBackend)
+	require.True(t, ok)
+	_, ok = st.backends[4].(providersBackend)
+	require.True(t, ok)
+
+	// Cascade backend matches cascade labels, other backends match configured
+	// query parameters.
+	req := httptest.NewRequest(http.MethodGet, "/cid/x?cascade=ipfs-dht", nil)
+	require.True(t, cascade.Matches(req))
+	require.False(t, dh.Matches(req))
+	req = httptest.NewRequest(http.MethodGet, "/cid/x?dh=yes", nil)
+	require.False(t, cascade.Matches(req))
+	require.True(t, dh.Matches(req))
+
+	badFile := filepath.Join(dir, "bad")
+	require.NoError(t, os.WriteFile(badFile, []byte(`{"Backends": [{"URL": "http://a.invalid", "Kind": "fish"}]}`), 0666))
+	_, err = Load(badFile)
+	require.ErrorContains(t, err, "unknown kind")
+	require.NoError(t, os.WriteFile(badFile, []byte(`{"Backend": []}`), 0666))
+	_, err = Load(badFile)
+	require.Error(t, err)
 }
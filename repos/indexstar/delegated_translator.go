@@ -3,58 +3,114 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"hash/crc32"
+	"io"
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 
+	"github.com/ipfs/go-cid"
 	"github.com/ipni/go-libipni/find/model"
 	"github.com/ipni/go-libipni/metadata"
 	"github.com/ipni/indexstar/metrics"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
 )
 
 const (
 	peerSchema = "peer"
+
+	// Cache-Control values of peer responses, the same as used by delegated
+	// routing servers. Not found responses are cached for less time, since
+	// the provider cache may learn about the peer soon.
+	cacheControlFound    = "public, max-age=300, stale-while-revalidate=172800, stale-if-error=172800"
+	cacheControlNotFound = "public, max-age=15, stale-while-revalidate=172800, stale-if-error=172800"
 )
 
 type findFunc func(ctx context.Context, method, source string, req *url.URL, encrypted bool) (int, []byte)
 
-func NewDelegatedTranslator(backend findFunc) (http.Handler, error) {
-	finder := delegatedTranslator{backend}
+// streamFunc streams the results for a multihash as NDJSON written by encode.
+type streamFunc func(ctx context.Context, w http.ResponseWriter, req *url.URL, mh multihash.Multihash, encode ndjsonEncoder)
+
+type provideFunc func(ctx context.Context, req *url.URL, body []byte) (int, []byte)
+
+// peerFunc returns the addresses of a peer, and false if the peer is not
+// known.
+type peerFunc func(ctx context.Context, pid peer.ID) ([]multiaddr.Multiaddr, bool, error)
+
+func NewDelegatedTranslator(backend findFunc, stream streamFunc, provider provideFunc, peers peerFunc) (http.Handler, error) {
+	finder := delegatedTranslator{be: backend, stream: stream, provider: provider, peers: peers}
 	m := http.NewServeMux()
-	m.HandleFunc("/providers", finder.provide)
-	m.HandleFunc("/encrypted/providers", finder.provide)
+	m.HandleFunc("/providers", func(w http.ResponseWriter, r *http.Request) { finder.provide(w, r, false) })
+	m.HandleFunc("/encrypted/providers", func(w http.ResponseWriter, r *http.Request) { finder.provide(w, r, true) })
 	m.HandleFunc("/providers/", func(w http.ResponseWriter, r *http.Request) { finder.find(w, r, false) })
 	m.HandleFunc("/encrypted/providers/", func(w http.ResponseWriter, r *http.Request) { finder.find(w, r, true) })
+	m.HandleFunc("/peers/", finder.peer)
 	return m, nil
 }
 
 type delegatedTranslator struct {
-	be findFunc
+	be       findFunc
+	stream   streamFunc
+	provider provideFunc
+	peers    peerFunc
 }
 
-func (dt *delegatedTranslator) provide(w http.ResponseWriter, r *http.Request) {
+func (dt *delegatedTranslator) provide(w http.ResponseWriter, r *http.Request, encrypted bool) {
 	_ = stats.RecordWithOptions(context.Background(),
 		stats.WithTags(tag.Insert(metrics.Method, r.Method)),
 		stats.WithMeasurements(metrics.HttpDelegatedRoutingMethod.M(1)))
 
 	h := w.Header()
 	h.Add("Access-Control-Allow-Origin", "*")
-	h.Add("Access-Control-Allow-Methods", "GET, OPTIONS")
+	h.Add("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
 	switch r.Method {
 	case http.MethodOptions:
 		w.WriteHeader(http.StatusOK)
+		return
 	case http.MethodPut:
-		http.Error(w, "", http.StatusNotImplemented)
 	default:
-		h.Add("Allow", http.MethodGet)
+		h.Add("Allow", http.MethodPut)
 		h.Add("Allow", http.MethodOptions)
 		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Provide backends do not accept encrypted provide requests.
+	if encrypted || dt.provider == nil {
+		http.Error(w, "", http.StatusNotImplemented)
+		return
+	}
+
+	// Request body size is limited by the server's MaxBytesHandler.
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Warnw("failed to read provide request body", "err", err)
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	// Restore the path prefix stripped by the parent mux, since provide
+	// backends serve the same delegated routing path.
+	uri := *r.URL
+	uri.Path = path.Join("/routing/v1", r.URL.Path)
+	uri.RawPath = ""
+	rcode, resp := dt.provider(r.Context(), &uri, body)
+	if rcode != http.StatusOK {
+		http.Error(w, strings.TrimSpace(string(resp)), rcode)
+		return
 	}
+	writeJsonResponse(w, http.StatusOK, resp)
 }
 
 func (dt *delegatedTranslator) find(w http.ResponseWriter, r *http.Request, encrypted bool) {
@@ -81,6 +137,26 @@ func (dt *delegatedTranslator) find(w http.ResponseWriter, r *http.Request, encr
 
 	// Translate URL by mapping `/providers/{CID}` to `/cid/{CID}`.
 	uri := r.URL.JoinPath("../../cid", cidUrlParam)
+
+	acc, err := getAccepts(r)
+	if err != nil {
+		http.Error(w, "invalid Accept header", http.StatusBadRequest)
+		return
+	}
+	h.Add("Vary", "Accept")
+	// Stream records as they are found when the request accepts NDJSON.
+	// Encrypted results are not delegated routing records, so they are not
+	// streamed.
+	if acc.ndjson && !encrypted && dt.stream != nil {
+		c, err := cid.Decode(cidUrlParam)
+		if err != nil {
+			http.Error(w, "invalid cid: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dt.stream(r.Context(), w, uri, c.Hash(), newDelegatedNDJsonEncoder())
+		return
+	}
+
 	rcode, resp := dt.be(r.Context(), http.MethodGet, findMethodDelegated, uri, encrypted)
 	if rcode != http.StatusOK {
 		http.Error(w, "", rcode)
@@ -106,77 +182,188 @@ func (dt *delegatedTranslator) find(w http.ResponseWriter, r *http.Request, encr
 	res := parsed.MultihashResults[0]
 
 	out := drResp{}
-
-	// Records returned from IPNI via Delegated Routing don't have ContextID in them. Becuase of that,
-	// some records that are valid from the IPNI point of view might look like duplicates from the Delegated Routing point of view.
-	// To make the Delegated Routing output nicer, deduplicate identical records.
-	uniqueProviders := map[uint32]struct{}{}
-	appendIfUnique := func(drp *drProvider) {
-		capacity := len(drp.ID) + len(drp.Schema)
-		for _, proto := range drp.Protocols {
-			capacity += len(proto)
-		}
-		for _, meta := range drp.Metadata {
-			capacity += len(meta)
-		}
-		drpb := make([]byte, 0, capacity)
-		drpb = append(drpb, []byte(drp.ID)...)
-		for _, proto := range drp.Protocols {
-			drpb = append(drpb, []byte(proto)...)
+	unique := drProviderSet{}
+	for i := range res.ProviderResults {
+		drp := newDrProvider(&res.ProviderResults[i])
+		if unique.putIfAbsent(drp) {
+			out.Providers = append(out.Providers, *drp)
 		}
-		drpb = append(drpb, []byte(drp.Schema)...)
-		for _, meta := range drp.Metadata {
-			drpb = append(drpb, meta...)
-		}
-		key := crc32.ChecksumIEEE(drpb)
-		if _, ok := uniqueProviders[key]; ok {
-			return
-		}
-		uniqueProviders[key] = struct{}{}
-		out.Providers = append(out.Providers, *drp)
 	}
 
-	for _, p := range res.ProviderResults {
-		md := metadata.Default.New()
-		err := md.UnmarshalBinary(p.Metadata)
+	setAccessResults(r.Context(), len(out.Providers))
+	outBytes, err := json.Marshal(out)
+	if err != nil {
+		log.Warnw("failed to serialize response", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+	}
+
+	writeJsonResponse(w, http.StatusOK, outBytes)
+}
+
+// peer answers delegated routing requests for the addresses of a peer.
+func (dt *delegatedTranslator) peer(w http.ResponseWriter, r *http.Request) {
+	_ = stats.RecordWithOptions(context.Background(),
+		stats.WithTags(tag.Insert(metrics.Method, r.Method)),
+		stats.WithMeasurements(metrics.HttpDelegatedRoutingMethod.M(1)))
+
+	h := w.Header()
+	h.Add("Access-Control-Allow-Origin", "*")
+	h.Add("Access-Control-Allow-Methods", "GET, OPTIONS")
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodOptions:
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Peer ID may be given as a base58 multihash or as a libp2p-key CID.
+	pid, err := peer.Decode(path.Base(r.URL.Path))
+	if err != nil {
+		http.Error(w, "invalid peer ID: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	acc, err := getAccepts(r)
+	if err != nil {
+		http.Error(w, "invalid Accept header", http.StatusBadRequest)
+		return
+	}
+	var ndjson bool
+	switch {
+	case acc.ndjson:
+		ndjson = true
+	case acc.json || acc.any || !acc.acceptHeaderFound:
+	default:
+		http.Error(w, "unsupported media type", http.StatusBadRequest)
+		return
+	}
+
+	addrs, found, err := dt.peers(r.Context(), pid)
+	if err != nil {
+		log.Warnw("failed to look up peer", "peer", pid, "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	h.Add("Vary", "Accept")
+	if !found {
+		h.Set("Cache-Control", cacheControlNotFound)
+		http.Error(w, "", http.StatusNotFound)
+		return
+	}
+	h.Set("Cache-Control", cacheControlFound)
+	setAccessResults(r.Context(), 1)
+
+	record := drProvider{
+		Schema: peerSchema,
+		ID:     pid,
+		Addrs:  addrs,
+	}
+	if ndjson {
+		outBytes, err := json.Marshal(record)
 		if err != nil {
-			appendIfUnique(&drProvider{
-				Schema: peerSchema,
-				ID:     p.Provider.ID,
-				Addrs:  p.Provider.Addrs,
-			})
-		} else {
-			provider := &drProvider{
-				Schema:   peerSchema,
-				ID:       p.Provider.ID,
-				Addrs:    p.Provider.Addrs,
-				Metadata: make(map[string][]byte),
-			}
-
-			for _, proto := range md.Protocols() {
-				pl := md.Get(proto)
-				plb, _ := pl.MarshalBinary()
-				provider.Protocols = append(provider.Protocols, proto.String())
-				provider.Metadata[proto.String()] = plb
-			}
-
-			appendIfUnique(provider)
+			log.Warnw("failed to serialize response", "err", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+		h.Set("Content-Type", mediaTypeNDJson)
+		if _, err = w.Write(append(outBytes, '\n')); err != nil {
+			log.Errorw("cannot write response", "err", err)
 		}
+		return
 	}
-
-	outBytes, err := json.Marshal(out)
+	outBytes, err := json.Marshal(drPeersResp{Peers: []drProvider{record}})
 	if err != nil {
 		log.Warnw("failed to serialize response", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
+		return
 	}
-
 	writeJsonResponse(w, http.StatusOK, outBytes)
 }
 
+// newDelegatedNDJsonEncoder returns an ndjsonEncoder that writes results as
+// delegated routing records, skipping records that are the same as a record
+// that was already written.
+func newDelegatedNDJsonEncoder() ndjsonEncoder {
+	unique := drProviderSet{}
+	return func(enc *json.Encoder, result *encryptedOrPlainResult) error {
+		if len(result.EncryptedValueKey) != 0 {
+			return nil
+		}
+		drp := newDrProvider(&result.ProviderResult)
+		if !unique.putIfAbsent(drp) {
+			return nil
+		}
+		return enc.Encode(drp)
+	}
+}
+
+// newDrProvider translates a provider result to a delegated routing record.
+func newDrProvider(p *model.ProviderResult) *drProvider {
+	drp := &drProvider{
+		Schema: peerSchema,
+		ID:     p.Provider.ID,
+		Addrs:  p.Provider.Addrs,
+	}
+	md := metadata.Default.New()
+	if err := md.UnmarshalBinary(p.Metadata); err != nil {
+		return drp
+	}
+	drp.Metadata = make(map[string][]byte)
+	for _, proto := range md.Protocols() {
+		pl := md.Get(proto)
+		plb, _ := pl.MarshalBinary()
+		drp.Protocols = append(drp.Protocols, proto.String())
+		drp.Metadata[proto.String()] = plb
+	}
+	return drp
+}
+
+// drProviderSet is a set of delegated routing records.
+//
+// Records returned from IPNI via Delegated Routing don't have ContextID in them. Becuase of that,
+// some records that are valid from the IPNI point of view might look like duplicates from the Delegated Routing point of view.
+// To make the Delegated Routing output nicer, deduplicate identical records.
+type drProviderSet map[uint32]struct{}
+
+// putIfAbsent adds a record to the set, and returns false if the set already
+// has the same record.
+func (s drProviderSet) putIfAbsent(drp *drProvider) bool {
+	capacity := len(drp.ID) + len(drp.Schema)
+	for _, proto := range drp.Protocols {
+		capacity += len(proto)
+	}
+	for _, meta := range drp.Metadata {
+		capacity += len(meta)
+	}
+	drpb := make([]byte, 0, capacity)
+	drpb = append(drpb, []byte(drp.ID)...)
+	for _, proto := range drp.Protocols {
+		drpb = append(drpb, []byte(proto)...)
+	}
+	drpb = append(drpb, []byte(drp.Schema)...)
+	for _, meta := range drp.Metadata {
+		drpb = append(drpb, meta...)
+	}
+	key := crc32.ChecksumIEEE(drpb)
+	if _, ok := s[key]; ok {
+		return false
+	}
+	s[key] = struct{}{}
+	return true
+}
+
 type drResp struct {
 	Providers []drProvider
 }
 
+type drPeersResp struct {
+	Peers []drProvider
+}
+
 type drProvider struct {
 	Protocols []string
 	Schema    string
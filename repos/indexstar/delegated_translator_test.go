@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mercari/go-circuitbreaker"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+type testProviderSource []*model.ProviderInfo
+
+func (s testProviderSource) Fetch(_ context.Context, pid peer.ID) (*model.ProviderInfo, error) {
+	for _, pi := range s {
+		if pi.AddrInfo.ID == pid {
+			return pi, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s testProviderSource) FetchAll(context.Context) ([]*model.ProviderInfo, error) {
+	return s, nil
+}
+
+func (s testProviderSource) String() string { return "test" }
+
+func TestPeers(t *testing.T) {
+	const (
+		providerID = "12D3KooWHus2xMGRSB3TmHbTtTjuY9HuuKBRqXKg2g2CTSSFMSTL"
+		extendedID = "12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK"
+		unknownID  = "12D3KooWBckWLKiYoUX4k3HTrbrSe4DD5SPNTKgP6vKTva1NaRkJ"
+	)
+	pid, err := peer.Decode(providerID)
+	require.NoError(t, err)
+	epid, err := peer.Decode(extendedID)
+	require.NoError(t, err)
+	addr1 := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+	addr2 := multiaddr.StringCast("/ip4/127.0.0.2/tcp/4001")
+
+	pc, err := pcache.New(pcache.WithSource(testProviderSource{{
+		AddrInfo: peer.AddrInfo{ID: pid, Addrs: []multiaddr.Multiaddr{addr1}},
+		ExtendedProviders: &model.ExtendedProviders{
+			Providers: []peer.AddrInfo{{ID: epid, Addrs: []multiaddr.Multiaddr{addr2}}},
+			Contextual: []model.ContextualExtendedProviders{{
+				Providers: []peer.AddrInfo{{ID: epid, Addrs: []multiaddr.Multiaddr{addr1, addr2}}},
+			}},
+		},
+	}}))
+	require.NoError(t, err)
+	// The provider snapshot lists the providers only when it is built.
+	var lists int
+	s := &server{
+		snapshots: newProviderSnapshots(func() []*model.ProviderInfo {
+			lists++
+			return pc.List()
+		}),
+	}
+	s.state.Store(&serverState{pcache: pc})
+
+	translator, err := NewDelegatedTranslator(nil, nil, nil, s.peerAddrs)
+	require.NoError(t, err)
+	do := func(id, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/peers/"+id, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		rec := httptest.NewRecorder()
+		translator.ServeHTTP(rec, r)
+		return rec
+	}
+
+	rec := do(providerID, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, cacheControlFound, rec.Header().Get("Cache-Control"))
+	require.Equal(t, "Accept", rec.Header().Get("Vary"))
+	var resp struct {
+		Peers []struct {
+			Schema string
+			ID     peer.ID
+			Addrs  []string
+		}
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	require.Len(t, resp.Peers, 1)
+	require.Equal(t, peerSchema, resp.Peers[0].Schema)
+	require.Equal(t, pid, resp.Peers[0].ID)
+	require.Equal(t, []string{addr1.String()}, resp.Peers[0].Addrs)
+
+	// Extended provider addresses are found, without duplicates.
+	rec = do(extendedID, mediaTypeNDJson)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, mediaTypeNDJson, rec.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 1)
+	var record struct {
+		ID    peer.ID
+		Addrs []string
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, epid, record.ID)
+	require.Equal(t, []string{addr2.String(), addr1.String()}, record.Addrs)
+
+	rec = do(unknownID, mediaTypeJson)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	require.Equal(t, cacheControlNotFound, rec.Header().Get("Cache-Control"))
+
+	require.Equal(t, http.StatusBadRequest, do("not-a-peer", "").Code)
+	require.Equal(t, http.StatusBadRequest, do(providerID, "text/html").Code)
+	require.Equal(t, 1, lists)
+}
+
+func TestDelegatedFindNDJson(t *testing.T) {
+	pid, err := peer.Decode("12D3KooWHus2xMGRSB3TmHbTtTjuY9HuuKBRqXKg2g2CTSSFMSTL")
+	require.NoError(t, err)
+	bitswap := metadata.Default.New(metadata.Bitswap{})
+	md, err := bitswap.MarshalBinary()
+	require.NoError(t, err)
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, mh)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/cid/"+c.String(), r.URL.Path)
+		require.Equal(t, mediaTypeNDJson, r.Header.Get("Accept"))
+		enc := json.NewEncoder(w)
+		// Results that differ only by context ID are the same delegated
+		// routing record.
+		for _, ctxID := range []string{"a", "b"} {
+			require.NoError(t, enc.Encode(model.ProviderResult{
+				ContextID: []byte(ctxID),
+				Metadata:  md,
+				Provider: &peer.AddrInfo{
+					ID:    pid,
+					Addrs: []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")},
+				},
+			}))
+		}
+	}))
+	defer ts.Close()
+
+	b, err := NewBackend(ts.URL, circuitbreaker.New(circuitbreaker.WithFailOnContextCancel(false)), Matchers.Any)
+	require.NoError(t, err)
+	s := &server{}
+	s.state.Store(&serverState{backends: []Backend{b}, resultStreamMaxWait: 5 * time.Second})
+	translator, err := NewDelegatedTranslator(s.findCached, s.findDelegatedNDJson, nil, nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/providers/"+c.String(), nil)
+	r.Header.Set("Accept", mediaTypeNDJson)
+	rec := httptest.NewRecorder()
+	translator.ServeHTTP(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, mediaTypeNDJson, rec.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 1)
+	var record struct {
+		Schema    string
+		ID        peer.ID
+		Protocols []string
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, peerSchema, record.Schema)
+	require.Equal(t, pid, record.ID)
+	require.Equal(t, []string{"transport-bitswap"}, record.Protocols)
+
+	r = httptest.NewRequest(http.MethodGet, "/providers/not-a-cid", nil)
+	r.Header.Set("Accept", mediaTypeNDJson)
+	rec = httptest.NewRecorder()
+	translator.ServeHTTP(rec, r)
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestDelegatedFindNDJsonFillAddrs(t *testing.T) {
+	pid, err := peer.Decode("12D3KooWHus2xMGRSB3TmHbTtTjuY9HuuKBRqXKg2g2CTSSFMSTL")
+	require.NoError(t, err)
+	unknownID, err := peer.Decode("12D3KooWBckWLKiYoUX4k3HTrbrSe4DD5SPNTKgP6vKTva1NaRkJ")
+	require.NoError(t, err)
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/4001")
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, mh)
+
+	// Neither result has addresses. Only the provider in the provider cache
+	// has addresses to fill in.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := json.NewEncoder(w)
+		for _, id := range []peer.ID{unknownID, pid} {
+			require.NoError(t, enc.Encode(model.ProviderResult{
+				ContextID: []byte("a"),
+				Provider:  &peer.AddrInfo{ID: id},
+			}))
+		}
+	}))
+	defer ts.Close()
+
+	b, err := NewBackend(ts.URL, circuitbreaker.New(circuitbreaker.WithFailOnContextCancel(false)), Matchers.Any)
+	require.NoError(t, err)
+	s := &server{
+		snapshots: newProviderSnapshots(func() []*model.ProviderInfo {
+			return []*model.ProviderInfo{{AddrInfo: peer.AddrInfo{ID: pid, Addrs: []multiaddr.Multiaddr{addr}}}}
+		}),
+	}
+	s.ranker = newRanker(s.snapshots)
+	s.state.Store(&serverState{backends: []Backend{b}, resultStreamMaxWait: 5 * time.Second, rankResults: true})
+	translator, err := NewDelegatedTranslator(s.findCached, s.findDelegatedNDJson, nil, nil)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodGet, "/providers/"+c.String(), nil)
+	r.Header.Set("Accept", mediaTypeNDJson)
+	rec := httptest.NewRecorder()
+	translator.ServeHTTP(rec, r)
+	require.Equal(t, http.StatusOK, rec.Code)
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	require.Len(t, lines, 1)
+	var record struct {
+		ID    peer.ID
+		Addrs []string
+	}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	require.Equal(t, pid, record.ID)
+	require.Equal(t, []string{addr.String()}, record.Addrs)
+}
@@ -29,7 +29,7 @@ const (
 func (s *server) findCid(w http.ResponseWriter, r *http.Request, encrypted bool) {
 	switch r.Method {
 	case http.MethodOptions:
-		handleIPNIOptions(w, false)
+		handleIPNIOptions(w, false, s.state.Load().cascadeLabels)
 	case http.MethodGet:
 		sc := path.Base(r.URL.Path)
 		c, err := cid.Decode(sc)
@@ -49,7 +49,7 @@ func (s *server) findCid(w http.ResponseWriter, r *http.Request, encrypted bool)
 func (s *server) findMultihashSubtree(w http.ResponseWriter, r *http.Request, encrypted bool) {
 	switch r.Method {
 	case http.MethodOptions:
-		handleIPNIOptions(w, false)
+		handleIPNIOptions(w, false, s.state.Load().cascadeLabels)
 	case http.MethodGet:
 		smh := path.Base(r.URL.Path)
 		mh, err := multihash.FromB58String(smh)
@@ -81,13 +81,14 @@ func (s *server) findMetadataSubtree(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 	method := r.Method
 	reqURL := r.URL
+	st := s.state.Load()
 
 	sg := &scatterGather[Backend, []byte]{
-		backends: s.backends,
-		maxWait:  config.Server.ResultMaxWait,
+		backends: st.backends,
+		maxWait:  st.resultMaxWait,
+		strategy: st.metadataStrategy,
 	}
 
-	// TODO: wait for the first successful response instead
 	err := sg.scatter(ctx, func(cctx context.Context, b Backend) (*[]byte, error) {
 		// send metadata requests only to dh backends
 		if _, isDhBackend := b.(dhBackend); !isDhBackend {
@@ -183,15 +184,15 @@ func (s *server) find(w http.ResponseWriter, r *http.Request, mh multihash.Multi
 	// JSON unless only unsupported media types are specified.
 	switch {
 	case acc.ndjson:
-		s.doFindNDJson(r.Context(), w, findMethodOrig, r.URL, false, mh, encrypted)
+		s.doFindNDJson(r.Context(), w, findMethodOrig, r.URL, false, mh, encrypted, nil)
 	case acc.json || acc.any || !acc.acceptHeaderFound:
 		if s.translateNonStreaming {
-			s.doFindNDJson(r.Context(), w, findMethodOrig, r.URL, true, mh, encrypted)
+			s.doFindNDJson(r.Context(), w, findMethodOrig, r.URL, true, mh, encrypted, nil)
 			return
 		}
 		// In a case where the request has no `Accept` header at all, be forgiving and respond with
 		// JSON.
-		rcode, resp := s.doFind(r.Context(), r.Method, findMethodOrig, r.URL, encrypted)
+		rcode, resp := s.findCached(r.Context(), r.Method, findMethodOrig, r.URL, encrypted)
 		if rcode != http.StatusOK {
 			http.Error(w, "", rcode)
 			return
@@ -204,9 +205,13 @@ func (s *server) find(w http.ResponseWriter, r *http.Request, mh multihash.Multi
 	}
 }
 
-func (s *server) doFind(ctx context.Context, method, source string, reqURL *url.URL, encrypted bool) (int, []byte) {
+// doFind queries the backends and merges their responses. The returned bool is
+// true if every eligible backend answered, so that the response is complete.
+func (s *server) doFind(ctx context.Context, method, source string, reqURL *url.URL, encrypted bool) (int, []byte, bool) {
 	start := time.Now()
-	latencyTags := []tag.Mutator{tag.Insert(metrics.Method, method)}
+	st := s.state.Load()
+	strategy := st.strategies[source]
+	latencyTags := []tag.Mutator{tag.Insert(metrics.Method, method), tag.Insert(metrics.Strategy, strategy.String())}
 	loadTags := []tag.Mutator{tag.Insert(metrics.Method, source)}
 	defer func() {
 		_ = stats.RecordWithOptions(context.Background(),
@@ -224,19 +229,18 @@ func (s *server) doFind(ctx context.Context, method, source string, reqURL *url.
 	}
 
 	sg := &scatterGather[Backend, sgResponse]{
-		backends: s.backends,
-		maxWait:  config.Server.ResultMaxWait,
+		backends: st.backends,
+		maxWait:  st.resultMaxWait,
+		strategy: strategy,
 	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	var count int32
+	backends := newBackendCounts(st.backends, reqURL, encrypted)
 	if err := sg.scatter(ctx, func(cctx context.Context, b Backend) (*sgResponse, error) {
-		// forward double hashed requests to double hashed backends only and regular requests to regular backends
-		_, isDhBackend := b.(dhBackend)
-		_, isProvidersBackend := b.(providersBackend)
-		if (encrypted != isDhBackend) || isProvidersBackend {
+		if !findEligible(b, reqURL, encrypted) {
 			return nil, nil
 		}
 
@@ -255,10 +259,6 @@ func (s *server) doFind(ctx context.Context, method, source string, reqURL *url.
 		req.Header.Set("X-Forwarded-Host", req.Host)
 		req.Header.Set("Accept", mediaTypeJson)
 
-		if !b.Matches(req) {
-			return nil, nil
-		}
-
 		resp, err := s.Client.Do(req)
 		if err != nil {
 			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
@@ -287,9 +287,11 @@ func (s *server) doFind(ctx context.Context, method, source string, reqURL *url.
 			if err != nil {
 				return nil, circuitbreaker.MarkAsSuccess(err)
 			}
+			backends.answered.Add(1)
 			return &sgResponse{bknd: b, rsp: providers}, nil
 		case http.StatusNotFound:
 			atomic.AddInt32(&count, 1)
+			backends.answered.Add(1)
 			return nil, nil
 		default:
 			body := string(data)
@@ -303,7 +305,7 @@ func (s *server) doFind(ctx context.Context, method, source string, reqURL *url.
 		}
 	}); err != nil {
 		log.Errorw("Failed to scatter HTTP find request", "err", err)
-		return http.StatusInternalServerError, nil
+		return http.StatusInternalServerError, nil, false
 	}
 
 	// TODO: stream out partial response as they come in.
@@ -326,7 +328,7 @@ outer:
 				if !bytes.Equal(resp.MultihashResults[0].Multihash, r.rsp.MultihashResults[0].Multihash) {
 					// weird / invalid.
 					log.Warnw("conflicting results", "q", reqURL, "first", resp.MultihashResults[0].Multihash, "second", r.rsp.MultihashResults[0].Multihash)
-					return http.StatusInternalServerError, nil
+					return http.StatusInternalServerError, nil, false
 				}
 				for _, pr := range r.rsp.MultihashResults[0].ProviderResults {
 					for _, rr := range resp.MultihashResults[0].ProviderResults {
@@ -347,7 +349,7 @@ outer:
 			} else {
 				if !bytes.Equal(resp.EncryptedMultihashResults[0].Multihash, r.rsp.EncryptedMultihashResults[0].Multihash) {
 					log.Warnw("conflicting encrypted results", "q", reqURL, "first", resp.EncryptedMultihashResults[0].Multihash, "second", r.rsp.EncryptedMultihashResults[0].Multihash)
-					return http.StatusInternalServerError, nil
+					return http.StatusInternalServerError, nil, false
 				}
 				updateFoundFlags(r.bknd)
 				resp.EncryptedMultihashResults[0].EncryptedValueKeys = append(resp.EncryptedMultihashResults[0].EncryptedValueKeys, r.rsp.EncryptedMultihashResults[0].EncryptedValueKeys...)
@@ -358,9 +360,18 @@ outer:
 	_ = stats.RecordWithOptions(context.Background(),
 		stats.WithMeasurements(metrics.FindBackends.M(float64(atomic.LoadInt32(&count)))))
 
+	if st.rankResults && len(resp.MultihashResults) != 0 {
+		ranked := s.ranker.rank(resp.MultihashResults[0].ProviderResults)
+		if len(ranked) == 0 {
+			resp.MultihashResults = nil
+		} else {
+			resp.MultihashResults[0].ProviderResults = ranked
+		}
+	}
+
 	if len(resp.MultihashResults) == 0 && len(resp.EncryptedMultihashResults) == 0 {
 		latencyTags = append(latencyTags, tag.Insert(metrics.Found, "no"))
-		return http.StatusNotFound, nil
+		return http.StatusNotFound, nil, backends.complete()
 	}
 
 	latencyTags = append(latencyTags, tag.Insert(metrics.Found, "yes"))
@@ -376,17 +387,18 @@ outer:
 
 	rs.observeFindResponse(&resp)
 	rs.reportMetrics(source)
+	setAccessResults(ctx, findResultCount(&resp))
 
 	// write out combined.
 	outData, err := model.MarshalFindResponse(&resp)
 	if err != nil {
 		log.Warnw("failed marshal response", "err", err)
-		return http.StatusInternalServerError, nil
+		return http.StatusInternalServerError, nil, false
 	}
-	return http.StatusOK, outData
+	return http.StatusOK, outData, backends.complete()
 }
 
-func handleIPNIOptions(w http.ResponseWriter, post bool) {
+func handleIPNIOptions(w http.ResponseWriter, post bool, cascadeLabels string) {
 	w.Header().Add("Access-Control-Allow-Origin", "*")
 	var methods string
 	if post {
@@ -396,11 +408,24 @@ func handleIPNIOptions(w http.ResponseWriter, post bool) {
 	}
 	w.Header().Add("Access-Control-Allow-Methods", methods)
 	w.Header().Add("Access-Control-Allow-Headers", "Content-Type, Accept")
-	if config.Server.CascadeLabels != "" {
+	if cascadeLabels != "" {
 		// TODO Eventually we might want to propagate OPTIONS queries to backends,
 		//      and dynamically populate cascade labels with some caching config.
 		//      For now this is good enough.
-		w.Header().Add("X-IPNI-Allow-Cascade", config.Server.CascadeLabels)
+		w.Header().Add("X-IPNI-Allow-Cascade", cascadeLabels)
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
+
+// findResultCount returns the number of provider results and encrypted value
+// keys in a find response.
+func findResultCount(resp *model.FindResponse) int {
+	var n int
+	for _, mhr := range resp.MultihashResults {
+		n += len(mhr.ProviderResults)
+	}
+	for _, emhr := range resp.EncryptedMultihashResults {
+		n += len(emhr.EncryptedValueKeys)
+	}
+	return n
+}
@@ -37,8 +37,17 @@ type (
 		graphsyncTransportCount int64
 		unknwonTransportCount   int64
 	}
+
+	// ndjsonEncoder writes a streaming result as an NDJSON line. It may skip
+	// results by not writing anything.
+	ndjsonEncoder func(enc *json.Encoder, result *encryptedOrPlainResult) error
 )
 
+// encodeResult is the ndjsonEncoder that writes results as they are.
+func encodeResult(enc *json.Encoder, result *encryptedOrPlainResult) error {
+	return enc.Encode(result)
+}
+
 func (r resultSet) putIfAbsent(p *encryptedOrPlainResult) bool {
 	// Calculate crc32 hash from provider ID + context ID to check for uniqueness of returned
 	// results. The rationale for using crc32 hashing is that it is fast and good enough
@@ -134,9 +143,29 @@ func (rs *resultStats) reportMetrics(method string) {
 	}
 }
 
-func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source string, reqURL *url.URL, translateNonStreaming bool, mh multihash.Multihash, encrypted bool) {
+// doFindNDJson finds the results for a multihash, and streams them as NDJSON
+// written by encode, or writes them as a single JSON response if
+// translateNonStreaming is true. Streamed results are written as they are if
+// encode is nil.
+func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source string, reqURL *url.URL, translateNonStreaming bool, mh multihash.Multihash, encrypted bool, encode ndjsonEncoder) {
+	if encode == nil {
+		encode = encodeResult
+	}
+	st := s.state.Load()
+	if st.rankResults && !translateNonStreaming {
+		// Streamed results cannot be reordered, but are given addresses from
+		// the provider cache, or dropped, the same as ranked results.
+		encode = s.ranker.fillAddrsEncoder(encode)
+	}
+	key := cacheKey(cacheKindNDJson, mh, encrypted, reqURL.RawQuery)
+	if entry, ok := s.cache.get(cacheKindNDJson, key); ok {
+		s.writeCachedResults(ctx, w, entry, translateNonStreaming, mh, st.rankResults, encode)
+		return
+	}
+
 	start := time.Now()
-	latencyTags := []tag.Mutator{tag.Insert(metrics.Method, http.MethodGet)}
+	// Streaming results are sent as they arrive, so all backends are queried.
+	latencyTags := []tag.Mutator{tag.Insert(metrics.Method, http.MethodGet), tag.Insert(metrics.Strategy, strategyAll)}
 	loadTags := []tag.Mutator{tag.Insert(metrics.Method, source)}
 	defer func() {
 		_ = stats.RecordWithOptions(context.Background(),
@@ -149,13 +178,13 @@ func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source
 
 	var maxWait time.Duration
 	if translateNonStreaming {
-		maxWait = config.Server.ResultMaxWait
+		maxWait = st.resultMaxWait
 	} else {
-		maxWait = config.Server.ResultStreamMaxWait
+		maxWait = st.resultStreamMaxWait
 	}
 
 	sg := &scatterGather[Backend, any]{
-		backends: s.backends,
+		backends: st.backends,
 		maxWait:  maxWait,
 	}
 
@@ -169,11 +198,9 @@ func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source
 
 	resultsChan := make(chan *resultWithBackend, 1)
 	var count int32
+	backends := newBackendCounts(st.backends, reqURL, encrypted)
 	if err := sg.scatter(ctx, func(cctx context.Context, b Backend) (*any, error) {
-		// forward double hashed requests to double hashed backends only and regular requests to regular backends
-		_, isDhBackend := b.(dhBackend)
-		_, isProvidersBackend := b.(providersBackend)
-		if (encrypted != isDhBackend) || isProvidersBackend {
+		if !findEligible(b, reqURL, encrypted) {
 			return nil, nil
 		}
 
@@ -192,10 +219,6 @@ func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source
 		req.Header.Set("X-Forwarded-Host", req.Host)
 		req.Header.Set("Accept", mediaTypeNDJson)
 
-		if !b.Matches(req) {
-			return nil, nil
-		}
-
 		resp, err := s.Client.Do(req)
 		if err != nil {
 			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
@@ -212,6 +235,7 @@ func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source
 		case http.StatusNotFound:
 			io.Copy(io.Discard, resp.Body)
 			atomic.AddInt32(&count, 1)
+			backends.answered.Add(1)
 			return nil, nil
 		default:
 			bb, _ := io.ReadAll(resp.Body)
@@ -243,7 +267,9 @@ func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source
 					}
 					// Sanity check the results in case backends don't respect accept media types;
 					// see: https://github.com/ipni/storetheindex/issues/1209
-					if len(result.EncryptedValueKey) == 0 && (result.Provider.ID == "" || len(result.Provider.Addrs) == 0) {
+					// Results with no addresses are kept when ranking, since
+					// ranking gives them addresses from the provider cache.
+					if len(result.EncryptedValueKey) == 0 && (result.Provider == nil || result.Provider.ID == "" || (len(result.Provider.Addrs) == 0 && !st.rankResults)) {
 						continue
 					}
 
@@ -263,6 +289,7 @@ func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source
 
 					return nil, circuitbreaker.MarkAsSuccess(err)
 				}
+				backends.answered.Add(1)
 				return nil, nil
 			}
 		}
@@ -304,6 +331,10 @@ func (s *server) doFindNDJson(ctx context.Context, w http.ResponseWriter, source
 
 	var rs resultStats
 	var foundCaskade, foundRegular bool
+	// Results are only cached if all backends were gathered from, and every
+	// eligible backend answered.
+	var complete bool
+	var gathered []encryptedOrPlainResult
 LOOP:
 	for {
 		select {
@@ -311,6 +342,7 @@ LOOP:
 			break LOOP
 		case rwb, ok := <-resultsChan:
 			if !ok {
+				complete = backends.complete()
 				break LOOP
 			}
 			result := rwb.rslt
@@ -320,6 +352,9 @@ LOOP:
 			}
 
 			rs.observeResult(result)
+			if s.cache != nil {
+				gathered = append(gathered, *result)
+			}
 
 			_, isCaskade := rwb.bknd.(caskadeBackend)
 			foundCaskade = foundCaskade || isCaskade
@@ -332,7 +367,7 @@ LOOP:
 					provResults = append(provResults, result.ProviderResult)
 				}
 			} else {
-				if err := encoder.Encode(result); err != nil {
+				if err := encode(encoder, result); err != nil {
 					log.Errorw("failed to encode streaming result", "result", result, "err", err)
 					continue
 				}
@@ -349,34 +384,36 @@ LOOP:
 
 	if len(results) == 0 {
 		latencyTags = append(latencyTags, tag.Insert(metrics.Found, "no"))
+		if complete {
+			s.cache.put(key, mh, &cacheEntry{status: http.StatusNotFound})
+		}
 		http.Error(w, "", http.StatusNotFound)
 		return
 	}
+	if translateNonStreaming && st.rankResults {
+		provResults = s.ranker.rank(provResults)
+		if len(provResults) == 0 && len(encValKeys) == 0 {
+			// All results were dropped by ranking.
+			latencyTags = append(latencyTags, tag.Insert(metrics.Found, "no"))
+			http.Error(w, "", http.StatusNotFound)
+			return
+		}
+	}
 
 	rs.reportMetrics(source)
 
-	if translateNonStreaming {
-		var resp model.FindResponse
-		if len(provResults) > 0 {
-			resp.MultihashResults = []model.MultihashResult{
-				{
-					Multihash:       mh,
-					ProviderResults: provResults,
-				},
-			}
-		}
-		if len(encValKeys) > 0 {
-			resp.EncryptedMultihashResults = []model.EncryptedMultihashResult{
-				{
-					Multihash:          mh,
-					EncryptedValueKeys: encValKeys,
-				},
-			}
-		}
+	if !translateNonStreaming {
+		setAccessResults(ctx, len(results))
+	} else {
+		setAccessResults(ctx, len(provResults)+len(encValKeys))
+		resp := newTranslatedFindResponse(mh, provResults, encValKeys)
 		if err := encoder.Encode(resp); err != nil {
 			log.Errorw("Failed to encode translated non streaming response", "err", err)
 		}
 	}
+	if complete {
+		s.cache.put(key, mh, &cacheEntry{status: http.StatusOK, results: gathered})
+	}
 	latencyTags = append(latencyTags, tag.Insert(metrics.Found, "yes"))
 	yesno := func(yn bool) string {
 		if yn {
@@ -388,3 +425,80 @@ LOOP:
 	latencyTags = append(latencyTags, tag.Insert(metrics.FoundCaskade, yesno(foundCaskade)))
 	latencyTags = append(latencyTags, tag.Insert(metrics.FoundRegular, yesno(foundRegular)))
 }
+
+// newTranslatedFindResponse returns the non-streaming find response for the
+// results gathered from streaming NDJSON responses.
+func newTranslatedFindResponse(mh multihash.Multihash, provResults []model.ProviderResult, encValKeys [][]byte) *model.FindResponse {
+	var resp model.FindResponse
+	if len(provResults) > 0 {
+		resp.MultihashResults = []model.MultihashResult{
+			{
+				Multihash:       mh,
+				ProviderResults: provResults,
+			},
+		}
+	}
+	if len(encValKeys) > 0 {
+		resp.EncryptedMultihashResults = []model.EncryptedMultihashResult{
+			{
+				Multihash:          mh,
+				EncryptedValueKeys: encValKeys,
+			},
+		}
+	}
+	return &resp
+}
+
+// findDelegatedNDJson streams the results of a delegated routing find request
+// as NDJSON written by encode.
+func (s *server) findDelegatedNDJson(ctx context.Context, w http.ResponseWriter, reqURL *url.URL, mh multihash.Multihash, encode ndjsonEncoder) {
+	s.doFindNDJson(ctx, w, findMethodDelegated, reqURL, false, mh, false, encode)
+}
+
+// writeCachedResults writes the response for cached NDJSON results, either as
+// a streaming NDJSON response or translated to a non-streaming JSON response.
+// Translated results are ranked if rank is true. Streaming results are written
+// by encode.
+func (s *server) writeCachedResults(ctx context.Context, w http.ResponseWriter, entry *cacheEntry, translateNonStreaming bool, mh multihash.Multihash, rank bool, encode ndjsonEncoder) {
+	if entry.status != http.StatusOK {
+		http.Error(w, "", entry.status)
+		return
+	}
+
+	encoder := json.NewEncoder(w)
+	if translateNonStreaming {
+		var provResults []model.ProviderResult
+		var encValKeys [][]byte
+		for _, result := range entry.results {
+			if len(result.EncryptedValueKey) > 0 {
+				encValKeys = append(encValKeys, result.EncryptedValueKey)
+			} else {
+				provResults = append(provResults, result.ProviderResult)
+			}
+		}
+		if rank {
+			provResults = s.ranker.rank(provResults)
+			if len(provResults) == 0 && len(encValKeys) == 0 {
+				http.Error(w, "", http.StatusNotFound)
+				return
+			}
+		}
+		setAccessResults(ctx, len(provResults)+len(encValKeys))
+		w.Header().Set("Content-Type", mediaTypeJson)
+		if err := encoder.Encode(newTranslatedFindResponse(mh, provResults, encValKeys)); err != nil {
+			log.Errorw("Failed to encode cached translated non streaming response", "err", err)
+		}
+		return
+	}
+
+	setAccessResults(ctx, len(entry.results))
+	w.Header().Set("Content-Type", mediaTypeNDJson)
+	w.Header().Set("Connection", "Keep-Alive")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	for i := range entry.results {
+		if err := encode(encoder, &entry.results[i]); err != nil {
+			log.Errorw("Failed to encode cached streaming result", "err", err)
+			return
+		}
+	}
+}
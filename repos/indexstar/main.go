@@ -53,6 +53,10 @@ func main() {
 				Name:  providersBackendsArg,
 				Usage: "Backends to propagate providers requests to.",
 			},
+			&cli.StringSliceFlag{
+				Name:  provideBackendsArg,
+				Usage: "Backends to forward delegated routing provide (PUT /routing/v1/providers) requests to.",
+			},
 			&cli.BoolFlag{
 				Name:  "translateNonStreaming",
 				Usage: "Whether to translate non-streaming JSON requests to streaming NDJSON requests before scattering to backends.",
@@ -117,13 +121,13 @@ func main() {
 				case err := <-done:
 					return err
 				case <-reloadSig:
-					err := s.Reload(c)
+					err := s.Reload()
 					if err != nil {
 						log.Warnf("couldn't reload servers: %s", err)
 					}
 				case <-timeChan:
 					var changed bool
-					modTime, changed, err = fileChanged(s.cfgBase, modTime)
+					modTime, changed, err = fileChanged(cfgPath, modTime)
 					if err != nil {
 						log.Errorw("Cannot stat config file", "err", err, "path", cfgPath)
 						ticker.Stop()
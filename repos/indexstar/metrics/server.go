@@ -25,6 +25,11 @@ var (
 	FoundRegular, _ = tag.NewKey("foundRegular")
 	Version, _      = tag.NewKey("version")
 	Transport, _    = tag.NewKey("transport")
+	CacheHit, _     = tag.NewKey("cacheHit")
+	Strategy, _     = tag.NewKey("strategy")
+	LimitBy, _      = tag.NewKey("limitBy")
+	Backend, _      = tag.NewKey("backend")
+	Status, _       = tag.NewKey("status")
 )
 
 // Measures
@@ -34,6 +39,12 @@ var (
 	FindLoad                   = stats.Int64("indexstar/find/load", "Amount of calls to find", stats.UnitDimensionless)
 	FindResponse               = stats.Int64("indexstar/find/response", "Find response stats", stats.UnitDimensionless)
 	HttpDelegatedRoutingMethod = stats.Int64("indexstar/http_delegated_routing/load", "Amount of HTTP delegated routing calls by tagged method", stats.UnitDimensionless)
+	CacheLookup                = stats.Int64("indexstar/cache/lookup", "Find cache lookups by response kind and hit", stats.UnitDimensionless)
+	RateLimited                = stats.Int64("indexstar/ratelimit/throttled", "Requests rejected by rate limiting, by route", stats.UnitDimensionless)
+	BackendRequest             = stats.Int64("indexstar/backend/request", "Requests sent to a backend, by response status", stats.UnitDimensionless)
+	BackendLatency             = stats.Float64("indexstar/backend/latency", "Time for a backend to respond to a request", stats.UnitMilliseconds)
+	BackendError               = stats.Int64("indexstar/backend/error", "Failed backend requests, by response status", stats.UnitDimensionless)
+	BackendCircuit             = stats.Int64("indexstar/backend/circuit", "Backend circuit breaker state: 0 closed, 1 half-open, 2 open", stats.UnitDimensionless)
 )
 
 // Views
@@ -41,7 +52,7 @@ var (
 	findLatencyView = &view.View{
 		Measure:     FindLatency,
 		Aggregation: view.Distribution(0, 1, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 200, 300, 400, 500, 1000, 2000, 5000),
-		TagKeys:     []tag.Key{Method, Found, FoundCaskade, FoundRegular},
+		TagKeys:     []tag.Key{Method, Found, FoundCaskade, FoundRegular, Strategy},
 	}
 	findBackendView = &view.View{
 		Measure:     FindBackends,
@@ -62,6 +73,36 @@ var (
 		Aggregation: view.Count(),
 		TagKeys:     []tag.Key{Method},
 	}
+	cacheLookupView = &view.View{
+		Measure:     CacheLookup,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Method, CacheHit},
+	}
+	rateLimitedView = &view.View{
+		Measure:     RateLimited,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Method, LimitBy},
+	}
+	backendRequestView = &view.View{
+		Measure:     BackendRequest,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Backend, Status},
+	}
+	backendLatencyView = &view.View{
+		Measure:     BackendLatency,
+		Aggregation: view.Distribution(0, 1, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 200, 300, 400, 500, 1000, 2000, 5000),
+		TagKeys:     []tag.Key{Backend},
+	}
+	backendErrorView = &view.View{
+		Measure:     BackendError,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Backend, Status},
+	}
+	backendCircuitView = &view.View{
+		Measure:     BackendCircuit,
+		Aggregation: view.LastValue(),
+		TagKeys:     []tag.Key{Backend},
+	}
 )
 
 // Start creates an HTTP router for serving metric info
@@ -73,6 +114,12 @@ func Start(views []*view.View) http.Handler {
 		findLoadView,
 		findResponseView,
 		httpDelegRoutingMethodView,
+		cacheLookupView,
+		rateLimitedView,
+		backendRequestView,
+		backendLatencyView,
+		backendErrorView,
+		backendCircuitView,
 	)
 	if err != nil {
 		log.Errorf("cannot register metrics default views: %s", err)
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// provideRoutingRoundRobin routes each provide request to the next
+	// provide backend in turn.
+	provideRoutingRoundRobin = "round-robin"
+	// provideRoutingPeer routes provide requests for the same provider peer
+	// ID to the same provide backend.
+	provideRoutingPeer = "peer"
+)
+
+// provideRequest is the part of a delegated routing provide request needed to
+// route it to a provide backend.
+type provideRequest struct {
+	Providers []struct {
+		ID      string
+		Payload struct {
+			ID string
+		}
+	}
+}
+
+// doProvide forwards a delegated routing provide request to one of the provide
+// backends. The backend is chosen according to the configured provide routing.
+// If the chosen backend is not available or fails with a server error, then
+// the request is forwarded to the next provide backend.
+func (s *server) doProvide(ctx context.Context, reqURL *url.URL, body []byte) (int, []byte) {
+	st := s.state.Load()
+	var backends []Backend
+	for _, b := range st.backends {
+		if _, ok := b.(provideBackend); ok {
+			backends = append(backends, b)
+		}
+	}
+	if len(backends) == 0 {
+		return http.StatusNotImplemented, nil
+	}
+
+	start := s.provideStart(body, backends, st.provideRouting)
+	rcode := http.StatusServiceUnavailable
+	var resp []byte
+	for i := range backends {
+		b := backends[(start+i)%len(backends)]
+		if !available(b) {
+			continue
+		}
+		status, data, err := s.provideTo(ctx, b, reqURL, body)
+		if b.CB() != nil {
+			err = b.CB().Done(ctx, err)
+		}
+		if err == nil {
+			return status, data
+		}
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			log.Debugw("Provide request to backend ended", "backend", b.URL().Host, "err", err)
+		} else {
+			log.Warnw("Failed to forward provide request to backend", "backend", b.URL().Host, "err", err)
+		}
+		if ctx.Err() != nil {
+			return http.StatusGatewayTimeout, nil
+		}
+		if status != 0 {
+			rcode, resp = status, data
+		} else {
+			rcode, resp = http.StatusBadGateway, nil
+		}
+	}
+	return rcode, resp
+}
+
+// provideTo sends a provide request to a provide backend. An error is returned
+// if the request fails or the backend responds with a server error. Other
+// responses, including client errors, are returned to the caller as is.
+func (s *server) provideTo(ctx context.Context, b Backend, reqURL *url.URL, body []byte) (int, []byte, error) {
+	// Copy the URL from original request and override host/schema to point
+	// to the server.
+	endpoint := *reqURL
+	endpoint.Host = b.URL().Host
+	endpoint.Scheme = b.URL().Scheme
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("X-Forwarded-Host", req.Host)
+	req.Header.Set("Content-Type", mediaTypeJson)
+	req.Header.Set("Accept", mediaTypeJson)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return resp.StatusCode, data, fmt.Errorf("status %d response from backend %s", resp.StatusCode, b.URL().Host)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// provideStart returns the index of the first provide backend to forward a
+// provide request to. Each backend gets a share of requests proportional to
+// its weight.
+func (s *server) provideStart(body []byte, backends []Backend, routing string) int {
+	var total uint32
+	for _, b := range backends {
+		total += uint32(b.Weight())
+	}
+	var pid peer.ID
+	var ok bool
+	if routing == provideRoutingPeer {
+		pid, ok = providePeerID(body)
+	}
+	var point uint32
+	if ok {
+		point = crc32.ChecksumIEEE([]byte(pid)) % total
+	} else {
+		point = (s.provideNext.Add(1) - 1) % total
+	}
+	for i, b := range backends {
+		if point < uint32(b.Weight()) {
+			return i
+		}
+		point -= uint32(b.Weight())
+	}
+	return 0
+}
+
+// providePeerID returns the peer ID of the first provider in a delegated
+// routing provide request.
+func providePeerID(body []byte) (peer.ID, bool) {
+	var req provideRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return "", false
+	}
+	for _, p := range req.Providers {
+		id := p.ID
+		if id == "" {
+			id = p.Payload.ID
+		}
+		if pid, err := peer.Decode(id); err == nil {
+			return pid, true
+		}
+	}
+	return "", false
+}
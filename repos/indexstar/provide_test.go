@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/mercari/go-circuitbreaker"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvidePeerID(t *testing.T) {
+	pid, err := test.RandPeerID()
+	require.NoError(t, err)
+
+	got, ok := providePeerID([]byte(fmt.Sprintf(`{"Providers":[{"Schema":"bitswap","Payload":{"ID":%q}}]}`, pid)))
+	require.True(t, ok)
+	require.Equal(t, pid, got)
+
+	got, ok = providePeerID([]byte(fmt.Sprintf(`{"Providers":[{"Schema":"peer","ID":%q}]}`, pid)))
+	require.True(t, ok)
+	require.Equal(t, pid, got)
+
+	_, ok = providePeerID([]byte(`{"Providers":[{"Schema":"peer","ID":"bad"}]}`))
+	require.False(t, ok)
+	_, ok = providePeerID([]byte(`not json`))
+	require.False(t, ok)
+}
+
+func TestDoProvide(t *testing.T) {
+	var hits [3]atomic.Int32
+	var statuses [3]atomic.Int32
+	var backends []Backend
+	for i := range hits {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			if r.Method != http.MethodPut || r.URL.Path != "/routing/v1/providers" {
+				http.Error(w, "", http.StatusBadRequest)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			status := int(statuses[i].Load())
+			if status == 0 {
+				status = http.StatusOK
+			}
+			w.WriteHeader(status)
+			_, _ = w.Write(body)
+		}))
+		t.Cleanup(ts.Close)
+		b, err := NewBackend(ts.URL, circuitbreaker.New(circuitbreaker.WithFailOnContextCancel(false)), Matchers.Any)
+		require.NoError(t, err)
+		backends = append(backends, provideBackend{Backend: b})
+	}
+
+	reqURL, err := url.Parse("http://indexstar.invalid/routing/v1/providers")
+	require.NoError(t, err)
+	ctx := context.Background()
+	body := []byte(`{"Providers":[]}`)
+
+	// No provide backends.
+	s := &server{}
+	s.state.Store(&serverState{backends: []Backend{testBackend(1)}})
+	rcode, _ := s.doProvide(ctx, reqURL, body)
+	require.Equal(t, http.StatusNotImplemented, rcode)
+
+	// Round-robin across provide backends, ignoring other backends.
+	st := &serverState{
+		backends:       append([]Backend{testBackend(1)}, backends...),
+		provideRouting: provideRoutingRoundRobin,
+	}
+	s.state.Store(st)
+	for i := 0; i < 6; i++ {
+		rcode, resp := s.doProvide(ctx, reqURL, body)
+		require.Equal(t, http.StatusOK, rcode)
+		require.Equal(t, body, resp)
+	}
+	for i := range hits {
+		require.Equal(t, int32(2), hits[i].Swap(0))
+	}
+
+	// Requests for the same provider go to the same backend.
+	st.provideRouting = provideRoutingPeer
+	pid, err := test.RandPeerID()
+	require.NoError(t, err)
+	peerBody := []byte(fmt.Sprintf(`{"Providers":[{"Schema":"peer","ID":%q}]}`, pid))
+	for i := 0; i < 3; i++ {
+		rcode, _ := s.doProvide(ctx, reqURL, peerBody)
+		require.Equal(t, http.StatusOK, rcode)
+	}
+	target := s.provideStart(peerBody, backends, provideRoutingPeer)
+	require.Equal(t, int32(3), hits[target].Swap(0))
+
+	// Server error fails over to the next backend.
+	statuses[target].Store(http.StatusInternalServerError)
+	rcode, _ = s.doProvide(ctx, reqURL, peerBody)
+	require.Equal(t, http.StatusOK, rcode)
+	require.Equal(t, int32(1), hits[target].Swap(0))
+	require.Equal(t, int32(1), hits[(target+1)%len(backends)].Swap(0))
+
+	// Client error is returned without failing over.
+	statuses[target].Store(http.StatusBadRequest)
+	rcode, _ = s.doProvide(ctx, reqURL, peerBody)
+	require.Equal(t, http.StatusBadRequest, rcode)
+	require.Equal(t, int32(1), hits[target].Swap(0))
+	require.Zero(t, hits[(target+1)%len(backends)].Load())
+
+	// All backends failing returns the last server error.
+	for i := range statuses {
+		statuses[i].Store(http.StatusBadGateway)
+	}
+	rcode, _ = s.doProvide(ctx, reqURL, peerBody)
+	require.Equal(t, http.StatusBadGateway, rcode)
+}
+
+func TestProvideStartWeighted(t *testing.T) {
+	heavy, err := newBackendFromConfig(BackendConfig{URL: "http://heavy.invalid", Weight: 3}, nil, Matchers.Any)
+	require.NoError(t, err)
+	light, err := newBackendFromConfig(BackendConfig{URL: "http://light.invalid"}, nil, Matchers.Any)
+	require.NoError(t, err)
+	backends := []Backend{heavy, light}
+
+	s := &server{}
+	var starts []int
+	for i := 0; i < 8; i++ {
+		starts = append(starts, s.provideStart(nil, backends, provideRoutingRoundRobin))
+	}
+	require.Equal(t, []int{0, 0, 0, 1, 0, 0, 0, 1}, starts)
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"path"
+	"sync"
+	"time"
 
+	"github.com/ipni/go-libipni/find/model"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
 )
 
 func (s *server) providers(w http.ResponseWriter, r *http.Request) {
@@ -15,7 +20,8 @@ func (s *server) providers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pinfos := s.pcache.List()
+	pinfos := s.state.Load().pcache.List()
+	setAccessResults(r.Context(), len(pinfos))
 
 	// Write out combined.
 	//
@@ -39,7 +45,7 @@ func (s *server) provider(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pinfo, err := s.pcache.Get(r.Context(), pid)
+	pinfo, err := s.state.Load().pcache.Get(r.Context(), pid)
 	if err != nil {
 		log.Warnw("count not get provider information", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
@@ -50,6 +56,7 @@ func (s *server) provider(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "", http.StatusNotFound)
 		return
 	}
+	setAccessResults(r.Context(), 1)
 
 	outData, err := json.Marshal(pinfo)
 	if err != nil {
@@ -59,3 +66,104 @@ func (s *server) provider(w http.ResponseWriter, r *http.Request) {
 	}
 	writeJsonResponse(w, http.StatusOK, outData)
 }
+
+// peerAddrs returns the addresses of a peer from the provider cache. The
+// addresses are those of the provider with the peer ID, and of the extended
+// providers with the peer ID of any provider.
+func (s *server) peerAddrs(ctx context.Context, pid peer.ID) ([]multiaddr.Multiaddr, bool, error) {
+	pinfo, err := s.state.Load().pcache.Get(ctx, pid)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var found bool
+	var addrs []multiaddr.Multiaddr
+	seen := make(map[string]struct{})
+	add := func(peerAddrs []multiaddr.Multiaddr) {
+		found = true
+		for _, a := range peerAddrs {
+			if _, ok := seen[string(a.Bytes())]; ok {
+				continue
+			}
+			seen[string(a.Bytes())] = struct{}{}
+			addrs = append(addrs, a)
+		}
+	}
+	if pinfo != nil && pinfo.AddrInfo.ID == pid {
+		add(pinfo.AddrInfo.Addrs)
+	}
+	if extAddrs, ok := s.snapshots.get().extended[pid]; ok {
+		add(extAddrs)
+	}
+	return addrs, found, nil
+}
+
+// snapshotRefreshInterval is how often the provider snapshot is refreshed
+// from the provider cache.
+const snapshotRefreshInterval = time.Minute
+
+// providerSnapshot is the provider information in the provider cache at one
+// time, indexed for ranking find results and for looking up peer addresses.
+type providerSnapshot struct {
+	// providers holds provider information by provider ID.
+	providers map[peer.ID]*model.ProviderInfo
+	// extended holds the addresses of extended providers by peer ID. Extended
+	// providers are not in the provider cache by their own peer ID, so they
+	// are found by looking at all providers in the cache.
+	extended map[peer.ID][]multiaddr.Multiaddr
+}
+
+// providerSnapshots keeps a snapshot of the provider cache, so that reading
+// provider information never waits for a provider to be fetched.
+type providerSnapshots struct {
+	list func() []*model.ProviderInfo
+
+	mutex   sync.Mutex
+	snap    *providerSnapshot
+	updated time.Time
+}
+
+func newProviderSnapshots(list func() []*model.ProviderInfo) *providerSnapshots {
+	return &providerSnapshots{
+		list: list,
+	}
+}
+
+// get returns the provider snapshot, refreshing it if it is older than
+// snapshotRefreshInterval.
+func (p *providerSnapshots) get() *providerSnapshot {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.snap != nil && time.Since(p.updated) < snapshotRefreshInterval {
+		return p.snap
+	}
+	infos := p.list()
+	snap := &providerSnapshot{
+		providers: make(map[peer.ID]*model.ProviderInfo, len(infos)),
+		extended:  make(map[peer.ID][]multiaddr.Multiaddr),
+	}
+	for _, pinfo := range infos {
+		snap.providers[pinfo.AddrInfo.ID] = pinfo
+		addExtendedProviders(pinfo, func(ai peer.AddrInfo) {
+			snap.extended[ai.ID] = append(snap.extended[ai.ID], ai.Addrs...)
+		})
+	}
+	p.snap = snap
+	p.updated = time.Now()
+	return snap
+}
+
+func addExtendedProviders(pinfo *model.ProviderInfo, add func(peer.AddrInfo)) {
+	if pinfo.ExtendedProviders == nil {
+		return
+	}
+	for _, ai := range pinfo.ExtendedProviders.Providers {
+		add(ai)
+	}
+	for _, cep := range pinfo.ExtendedProviders.Contextual {
+		for _, ai := range cep.Providers {
+			add(ai)
+		}
+	}
+}
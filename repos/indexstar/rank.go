@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ranker orders find results using the provider information that indexstar
+// keeps in its provider cache. Provider information is read from a snapshot
+// of the cache, so ranking never waits for a provider to be fetched.
+type ranker struct {
+	snapshots *providerSnapshots
+}
+
+func newRanker(snapshots *providerSnapshots) *ranker {
+	return &ranker{
+		snapshots: snapshots,
+	}
+}
+
+// rank orders provider results with active providers first, and then by most
+// recent advertisement. Results with no addresses are given the provider's
+// addresses from the provider cache, and are dropped if there are none.
+// Results that are otherwise equal keep their order.
+func (r *ranker) rank(results []model.ProviderResult) []model.ProviderResult {
+	if r == nil || len(results) == 0 {
+		return results
+	}
+	providers := r.snapshots.get().providers
+
+	type rankedResult struct {
+		result   model.ProviderResult
+		inactive bool
+		lastAd   time.Time
+	}
+	ranked := make([]rankedResult, 0, len(results))
+	for _, result := range results {
+		filled, ok := fillAddrs(result, providers)
+		if !ok {
+			continue
+		}
+		rr := rankedResult{result: filled}
+		if info, ok := providers[result.Provider.ID]; ok {
+			rr.inactive = info.Inactive
+			rr.lastAd, _ = time.Parse(time.RFC3339, info.LastAdvertisementTime)
+		}
+		ranked = append(ranked, rr)
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].inactive != ranked[j].inactive {
+			return !ranked[i].inactive
+		}
+		return ranked[i].lastAd.After(ranked[j].lastAd)
+	})
+
+	out := make([]model.ProviderResult, len(ranked))
+	for i := range ranked {
+		out[i] = ranked[i].result
+	}
+	return out
+}
+
+// fillAddrsEncoder returns an ndjsonEncoder that gives results with no
+// addresses the provider's addresses from the provider cache, and drops them
+// if there are none, before writing them with encode. Streamed results are
+// written as they arrive, so they are not reordered.
+func (r *ranker) fillAddrsEncoder(encode ndjsonEncoder) ndjsonEncoder {
+	providers := r.snapshots.get().providers
+	return func(enc *json.Encoder, result *encryptedOrPlainResult) error {
+		if len(result.EncryptedValueKey) != 0 {
+			return encode(enc, result)
+		}
+		filled, ok := fillAddrs(result.ProviderResult, providers)
+		if !ok {
+			return nil
+		}
+		return encode(enc, &encryptedOrPlainResult{ProviderResult: filled})
+	}
+}
+
+// fillAddrs returns the result with the provider's addresses from providers
+// if the result has no addresses. Returns false if the result still has no
+// addresses. The result passed in is not modified.
+func fillAddrs(result model.ProviderResult, providers map[peer.ID]*model.ProviderInfo) (model.ProviderResult, bool) {
+	if result.Provider == nil {
+		return result, false
+	}
+	if len(result.Provider.Addrs) != 0 {
+		return result, true
+	}
+	info, ok := providers[result.Provider.ID]
+	if !ok || len(info.AddrInfo.Addrs) == 0 {
+		return result, false
+	}
+	result.Provider = &peer.AddrInfo{
+		ID:    result.Provider.ID,
+		Addrs: info.AddrInfo.Addrs,
+	}
+	return result, true
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRank(t *testing.T) {
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/9999")
+	now := time.Now()
+	ids := make([]peer.ID, 5)
+	for i := range ids {
+		ids[i] = peer.ID([]byte{byte('a' + i)})
+	}
+	infos := []*model.ProviderInfo{
+		{AddrInfo: peer.AddrInfo{ID: ids[0]}, LastAdvertisementTime: now.Add(-time.Hour).Format(time.RFC3339)},
+		{AddrInfo: peer.AddrInfo{ID: ids[1]}, LastAdvertisementTime: now.Format(time.RFC3339)},
+		{AddrInfo: peer.AddrInfo{ID: ids[2]}, LastAdvertisementTime: now.Format(time.RFC3339), Inactive: true},
+		{AddrInfo: peer.AddrInfo{ID: ids[3], Addrs: []multiaddr.Multiaddr{addr}}},
+	}
+	var lists int
+	r := newRanker(newProviderSnapshots(func() []*model.ProviderInfo {
+		lists++
+		return infos
+	}))
+
+	result := func(id peer.ID, withAddrs bool) model.ProviderResult {
+		pr := model.ProviderResult{Provider: &peer.AddrInfo{ID: id}}
+		if withAddrs {
+			pr.Provider.Addrs = []multiaddr.Multiaddr{addr}
+		}
+		return pr
+	}
+	results := []model.ProviderResult{
+		result(ids[2], true),
+		result(ids[0], true),
+		result(ids[3], false),
+		result(ids[4], false),
+		result(ids[1], true),
+		{},
+	}
+
+	ranked := r.rank(results)
+	var got []peer.ID
+	for _, pr := range ranked {
+		got = append(got, pr.Provider.ID)
+	}
+	// Inactive last, then most recent advertisement first. Provider with no
+	// addresses anywhere and result with no provider are dropped.
+	require.Equal(t, []peer.ID{ids[1], ids[0], ids[3], ids[2]}, got)
+	require.Equal(t, []multiaddr.Multiaddr{addr}, ranked[2].Provider.Addrs)
+	// Input results are not modified.
+	require.Empty(t, results[2].Provider.Addrs)
+
+	r.rank(results)
+	require.Equal(t, 1, lists, "provider info should be read from snapshot")
+
+	var nilRanker *ranker
+	require.Equal(t, results, nilRanker.rank(results))
+}
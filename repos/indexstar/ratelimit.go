@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipni/indexstar/metrics"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+const (
+	// Routes that are rate limited separately.
+	routeFind      = "find"
+	routeDelegated = "delegated"
+	routeProviders = "providers"
+
+	// Kinds of rate limited clients, used to tag the throttled metric.
+	limitByIP     = "ip"
+	limitByAPIKey = "apiKey"
+
+	// bucketSweepInterval is how often the token buckets that are full are
+	// removed. A full bucket is the same as a new bucket, so this only limits
+	// the memory used by clients that are not active.
+	bucketSweepInterval = time.Minute
+
+	// ipv6ClientBits is the prefix length of the IPv6 addresses that share a
+	// token bucket. A single IPv6 client is usually given a whole /64.
+	ipv6ClientBits = 64
+)
+
+// tokenBucket holds up to burst tokens, and is refilled at rate tokens per
+// second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit LimitConfig, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  float64(limit.Burst),
+		tokens: float64(limit.Burst),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// take takes a token from the bucket. If there is no token, then it returns
+// how long until there is one.
+func (b *tokenBucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+type bucketKey struct {
+	route  string
+	client string
+	apiKey bool
+}
+
+// rateLimiter limits requests per route with a token bucket per client. The
+// limiter is part of the serverState, so reloading the config file starts all
+// clients with full buckets.
+type rateLimiter struct {
+	trustedProxies []netip.Prefix
+	// ipLimits are the limits of clients by IP address, by route.
+	ipLimits map[string]LimitConfig
+	// keyLimits are the limits of clients by API key, by route.
+	keyLimits map[string]map[string]LimitConfig
+
+	mutex     sync.Mutex
+	buckets   map[bucketKey]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter creates a rateLimiter from its config. It returns nil if rc
+// is nil.
+func newRateLimiter(rc *RateLimitConfig) (*rateLimiter, error) {
+	if rc == nil {
+		return nil, nil
+	}
+	l := &rateLimiter{
+		keyLimits: make(map[string]map[string]LimitConfig, len(rc.APIKeys)),
+		buckets:   make(map[bucketKey]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+	for _, tp := range rc.TrustedProxies {
+		prefix, err := parsePrefix(tp)
+		if err != nil {
+			return nil, fmt.Errorf("bad trusted proxy %q: %w", tp, err)
+		}
+		l.trustedProxies = append(l.trustedProxies, prefix)
+	}
+	var err error
+	l.ipLimits, err = limitsByRoute(rc.Find, rc.Delegated, rc.Providers)
+	if err != nil {
+		return nil, err
+	}
+	for i, kc := range rc.APIKeys {
+		if kc.Key == "" {
+			return nil, fmt.Errorf("api key %d is empty", i)
+		}
+		if _, ok := l.keyLimits[kc.Key]; ok {
+			return nil, fmt.Errorf("api key %d is a duplicate", i)
+		}
+		l.keyLimits[kc.Key], err = limitsByRoute(kc.Find, kc.Delegated, kc.Providers)
+		if err != nil {
+			return nil, fmt.Errorf("api key %d: %w", i, err)
+		}
+	}
+	return l, nil
+}
+
+// limitsByRoute validates the route limits and sets their default burst.
+// Routes that are not limited are left out.
+func limitsByRoute(find, delegated, providers *LimitConfig) (map[string]LimitConfig, error) {
+	limits := make(map[string]LimitConfig, 3)
+	for route, lc := range map[string]*LimitConfig{
+		routeFind:      find,
+		routeDelegated: delegated,
+		routeProviders: providers,
+	} {
+		if lc == nil {
+			continue
+		}
+		if lc.Rate < 0 || lc.Burst < 0 {
+			return nil, fmt.Errorf("%s limit must not be negative", route)
+		}
+		if lc.Rate == 0 {
+			continue
+		}
+		limit := *lc
+		if limit.Burst == 0 {
+			limit.Burst = max(int(math.Ceil(limit.Rate)), 1)
+		}
+		limits[route] = limit
+	}
+	return limits, nil
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// take takes a token from the bucket of the client for the route. It returns
+// the kind of client, and how long until the client can retry if there is no
+// token. Requests with an unknown API key are limited by IP address, the same
+// as requests without an API key.
+func (l *rateLimiter) take(route string, r *http.Request, now time.Time) (string, time.Duration) {
+	limitBy := limitByIP
+	var bk bucketKey
+	var limit LimitConfig
+	key, _ := apiKey(r)
+	if limits, ok := l.keyLimits[key]; ok {
+		limitBy = limitByAPIKey
+		bk = bucketKey{route: route, client: key, apiKey: true}
+		limit = limits[route]
+	} else {
+		bk = bucketKey{route: route, client: ipBucket(l.clientIP(r))}
+		limit = l.ipLimits[route]
+	}
+	if limit.Rate == 0 {
+		return limitBy, 0
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if now.Sub(l.lastSweep) >= bucketSweepInterval {
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[bk]
+	if !ok {
+		b = newTokenBucket(limit, now)
+		l.buckets[bk] = b
+	}
+	if ok, retryAfter := b.take(now); !ok {
+		return limitBy, retryAfter
+	}
+	return limitBy, 0
+}
+
+// ipBucket returns the client that the token bucket of the IP address is
+// shared by. IPv6 addresses in the same /64 share a bucket, so that a client
+// cannot get more requests by changing its address within its prefix.
+func ipBucket(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil || !addr.Is6() {
+		return ip
+	}
+	return netip.PrefixFrom(addr, ipv6ClientBits).Masked().String()
+}
+
+// clientIP returns the IP address of the client that sent the request. If the
+// request is from a trusted proxy, then the client is the address before the
+// last trusted proxy in the X-Forwarded-For header. A nil rateLimiter trusts
+// no proxies.
+func (l *rateLimiter) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !l.trusted(addr) {
+		return addr.String()
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+		if !l.trusted(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func (l *rateLimiter) trusted(addr netip.Addr) bool {
+	if l == nil {
+		return false
+	}
+	for _, prefix := range l.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiKey returns the API key given as a bearer token in the Authorization
+// header.
+func apiKey(r *http.Request) (string, bool) {
+	scheme, key, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	key = strings.TrimSpace(key)
+	return key, key != ""
+}
+
+// limit rate limits requests to a route before they are passed to handler.
+// Requests over the limit get a 429 response with a Retry-After header.
+func (s *server) limit(route string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := s.state.Load().limiter
+		// CORS preflight requests are not limited, since browsers send them
+		// before requests with an API key.
+		if l == nil || r.Method == http.MethodOptions {
+			handler(w, r)
+			return
+		}
+		limitBy, retryAfter := l.take(route, r, time.Now())
+		if retryAfter > 0 {
+			_ = stats.RecordWithOptions(context.Background(),
+				stats.WithTags(tag.Insert(metrics.Method, route), tag.Insert(metrics.LimitBy, limitBy)),
+				stats.WithMeasurements(metrics.RateLimited.M(1)))
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "", http.StatusTooManyRequests)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(LimitConfig{Rate: 2, Burst: 3}, now)
+	for i := 0; i < 3; i++ {
+		ok, _ := b.take(now)
+		require.True(t, ok)
+	}
+	ok, retryAfter := b.take(now)
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, retryAfter)
+	require.False(t, b.full(now))
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _ = b.take(now)
+	require.True(t, ok)
+
+	// Bucket does not fill beyond burst.
+	now = now.Add(time.Hour)
+	require.True(t, b.full(now))
+	require.Equal(t, float64(3), b.tokens)
+}
+
+func TestRateLimiterClientIP(t *testing.T) {
+	l, err := newRateLimiter(&RateLimitConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"},
+	})
+	require.NoError(t, err)
+
+	req := func(remote string, xff ...string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/cid/x", nil)
+		r.RemoteAddr = remote
+		for _, v := range xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		return r
+	}
+	// X-Forwarded-For is ignored from untrusted clients.
+	require.Equal(t, "1.2.3.4", l.clientIP(req("1.2.3.4:1234", "5.6.7.8")))
+	require.Equal(t, "5.6.7.8", l.clientIP(req("10.1.1.1:1234", "5.6.7.8")))
+	// Client is the address before the last trusted proxy.
+	require.Equal(t, "5.6.7.8", l.clientIP(req("10.1.1.1:1234", "9.9.9.9, 5.6.7.8", "192.168.1.1")))
+	// All trusted hops uses the first hop.
+	require.Equal(t, "10.2.2.2", l.clientIP(req("10.1.1.1:1234", "10.2.2.2")))
+	// Bad hop stops at the last trusted address.
+	require.Equal(t, "192.168.1.1", l.clientIP(req("10.1.1.1:1234", "bad, 192.168.1.1")))
+	require.Equal(t, "10.1.1.1", l.clientIP(req("10.1.1.1:1234")))
+	require.Equal(t, "1.2.3.4", l.clientIP(req("[::ffff:1.2.3.4]:1234")))
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	l, err := newRateLimiter(nil)
+	require.NoError(t, err)
+	require.Nil(t, l)
+
+	l, err = newRateLimiter(&RateLimitConfig{
+		Find:      &LimitConfig{Rate: 0.5},
+		Delegated: &LimitConfig{},
+		APIKeys:   []APIKeyConfig{{Key: "k", Providers: &LimitConfig{Rate: 10, Burst: 20}}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]LimitConfig{routeFind: {Rate: 0.5, Burst: 1}}, l.ipLimits)
+	require.Equal(t, map[string]LimitConfig{routeProviders: {Rate: 10, Burst: 20}}, l.keyLimits["k"])
+
+	_, err = newRateLimiter(&RateLimitConfig{TrustedProxies: []string{"bad"}})
+	require.ErrorContains(t, err, "bad trusted proxy")
+	_, err = newRateLimiter(&RateLimitConfig{Find: &LimitConfig{Rate: -1}})
+	require.Error(t, err)
+	_, err = newRateLimiter(&RateLimitConfig{APIKeys: []APIKeyConfig{{Key: "k"}, {Key: "k"}}})
+	require.ErrorContains(t, err, "duplicate")
+	_, err = newRateLimiter(&RateLimitConfig{APIKeys: []APIKeyConfig{{}}})
+	require.ErrorContains(t, err, "empty")
+}
+
+func TestLimit(t *testing.T) {
+	l, err := newRateLimiter(&RateLimitConfig{
+		Find:    &LimitConfig{Rate: 0.1, Burst: 2},
+		APIKeys: []APIKeyConfig{{Key: "unlimited"}, {Key: "limited", Find: &LimitConfig{Rate: 0.1, Burst: 1}}},
+	})
+	require.NoError(t, err)
+	s := &server{}
+	s.state.Store(&serverState{limiter: l})
+
+	var handled int
+	h := s.limit(routeFind, func(w http.ResponseWriter, r *http.Request) {
+		handled++
+	})
+	providersHandler := s.limit(routeProviders, func(w http.ResponseWriter, r *http.Request) {
+		handled++
+	})
+	do := func(h http.HandlerFunc, remote, key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/cid/x", nil)
+		r.RemoteAddr = remote
+		if key != "" {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, r)
+		return rec
+	}
+
+	require.Equal(t, http.StatusOK, do(h, "1.2.3.4:1", "").Code)
+	require.Equal(t, http.StatusOK, do(h, "1.2.3.4:1", "").Code)
+	rec := do(h, "1.2.3.4:1", "")
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "10", rec.Header().Get("Retry-After"))
+	require.Equal(t, 2, handled)
+
+	// Other clients and routes have their own limits.
+	require.Equal(t, http.StatusOK, do(h, "5.6.7.8:1", "").Code)
+	require.Equal(t, http.StatusOK, do(providersHandler, "1.2.3.4:1", "").Code)
+
+	// Requests with an API key use the limits of the key.
+	for i := 0; i < 5; i++ {
+		require.Equal(t, http.StatusOK, do(h, "1.2.3.4:1", "unlimited").Code)
+	}
+	require.Equal(t, http.StatusOK, do(h, "1.2.3.4:1", "limited").Code)
+	require.Equal(t, http.StatusTooManyRequests, do(h, "5.6.7.8:1", "limited").Code)
+	// Unknown API keys are limited by IP address.
+	require.Equal(t, http.StatusTooManyRequests, do(h, "1.2.3.4:1", "unknown").Code)
+	require.Equal(t, http.StatusOK, do(h, "9.9.9.9:1", "unknown").Code)
+
+	// IPv6 clients are limited by /64.
+	require.Equal(t, http.StatusOK, do(h, "[2001:db8:1:2::1]:1", "").Code)
+	require.Equal(t, http.StatusOK, do(h, "[2001:db8:1:2::2]:1", "").Code)
+	require.Equal(t, http.StatusTooManyRequests, do(h, "[2001:db8:1:2:ffff::3]:1", "").Code)
+	require.Equal(t, http.StatusOK, do(h, "[2001:db8:1:3::1]:1", "").Code)
+
+	// No limiter does not limit.
+	s.state.Store(&serverState{})
+	require.Equal(t, http.StatusOK, do(h, "1.2.3.4:1", "unknown").Code)
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"errors"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,55 +15,118 @@ type scatterGather[B Backend, R any] struct {
 	wg       sync.WaitGroup
 	out      chan R
 	maxWait  time.Duration
+	// strategy determines how many backend results are waited for. Nil waits
+	// for all backends.
+	strategy *scatterStrategy
 }
 
 func (sg *scatterGather[B, R]) scatter(ctx context.Context, forEach func(context.Context, B) (*R, error)) error {
 	sg.start = time.Now()
 	sg.out = make(chan R, 1)
-	for _, backend := range sg.backends {
 
-		if backend.CB() != nil && !backend.CB().Ready() {
-			continue
-		}
+	// Remaining backend calls are canceled once the strategy is satisfied.
+	sctx, cancel := context.WithCancel(ctx)
+	needed := sg.strategy.needed()
+	var successes atomic.Int32
+	// ended is signaled when a backend call ends without a result, so that a
+	// hedged scatter can query the next backend without waiting.
+	ended := make(chan struct{}, len(sg.backends))
 
-		sg.wg.Add(1)
-		go func(target B) {
-			defer sg.wg.Done()
+	call := func(target B) {
+		defer sg.wg.Done()
 
-			select {
-			case <-ctx.Done():
+		select {
+		case <-sctx.Done():
+			if ctx.Err() != nil {
 				log.Errorw("context is done before completing scatter", "err", ctx.Err())
-				return
-			default:
 			}
+			return
+		default:
+		}
 
-			cctx, cancel := context.WithTimeout(ctx, sg.maxWait)
-			sout, err := forEach(cctx, target)
-			cancel()
-			if target.CB() != nil {
-				err = target.CB().Done(cctx, err)
+		wait := sg.maxWait
+		if timeout := target.Timeout(); timeout > 0 && timeout < wait {
+			wait = timeout
+		}
+		callStart := time.Now()
+		cctx, cancelCall := context.WithTimeout(sctx, wait)
+		sout, err := forEach(cctx, target)
+		cancelCall()
+		if target.CB() != nil {
+			err = target.CB().Done(cctx, err)
+		}
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				log.Debugw("Scatter on target canceled", "target", target.URL().Host)
+			} else if errors.Is(err, context.DeadlineExceeded) {
+				log.Debugw("failed to scatter on target because context deadline exceeded", "target", target.URL().Host, "maxWait", wait)
+			} else {
+				log.Errorw("failed to scatter on target", "target", target.URL().Host, "err", err, "maxWait", wait)
 			}
-			if err != nil {
-				if errors.Is(err, context.Canceled) {
-					log.Debugw("Scatter on target canceled", "target", target.URL().Host)
-				} else if errors.Is(err, context.DeadlineExceeded) {
-					log.Debugw("failed to scatter on target because context deadline exceeded", "target", target.URL().Host, "maxWait", sg.maxWait)
-				} else {
-					log.Errorw("failed to scatter on target", "target", target.URL().Host, "err", err, "maxWait", sg.maxWait)
+			ended <- struct{}{}
+			return
+		}
+		if sout == nil {
+			ended <- struct{}{}
+			return
+		}
+		sg.strategy.observe(time.Since(callStart))
+		select {
+		case <-ctx.Done():
+			return
+		case sg.out <- *sout:
+		}
+		if needed != 0 && int(successes.Add(1)) >= needed {
+			cancel()
+		}
+	}
+
+	if sg.strategy.hedged() {
+		// Query one backend at a time, highest weight first, querying the
+		// next one when the hedge delay passes or the previous call ends
+		// without a result.
+		backends := make([]B, len(sg.backends))
+		copy(backends, sg.backends)
+		sort.SliceStable(backends, func(i, j int) bool {
+			return backends[i].Weight() > backends[j].Weight()
+		})
+		sg.wg.Add(1)
+		go func() {
+			defer sg.wg.Done()
+			for _, backend := range backends {
+				if sctx.Err() != nil {
+					return
 				}
-				return
-			}
-			if sout != nil {
+				if !available(backend) {
+					continue
+				}
+				sg.wg.Add(1)
+				go call(backend)
+
+				timer := time.NewTimer(sg.strategy.hedgeDelay())
 				select {
-				case <-ctx.Done():
-				case sg.out <- *sout:
+				case <-sctx.Done():
+					timer.Stop()
+					return
+				case <-ended:
+					timer.Stop()
+				case <-timer.C:
 				}
 			}
-		}(backend)
+		}()
+	} else {
+		for _, backend := range sg.backends {
+			if !available(backend) {
+				continue
+			}
+			sg.wg.Add(1)
+			go call(backend)
+		}
 	}
 	go func() {
 		defer close(sg.out)
 		sg.wg.Wait()
+		cancel()
 	}()
 	return nil
 }
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,6 +30,14 @@ func (t testBackend) CB() *circuitbreaker.CircuitBreaker { return nil }
 
 func (t testBackend) Matches(*http.Request) bool { return false }
 
+func (t testBackend) Timeout() time.Duration { return 0 }
+
+func (t testBackend) Weight() int { return 1 }
+
+func (t testBackend) Drained() bool { return false }
+
+func (t testBackend) SetDrained(bool) {}
+
 func TestScatterGather_GathersExpectedResults(t *testing.T) {
 	subject := scatterGather[testBackend, string]{
 		backends: []testBackend{testBackend(1), testBackend(2), testBackend(3), testBackend(4), testBackend(5)},
@@ -131,3 +140,137 @@ func TestScatterGather_GathersNothingWhenContextIsCancelled(t *testing.T) {
 	}
 	require.Len(t, gotResults, 0)
 }
+
+func TestScatterGather_FirstSuccessCancelsRemaining(t *testing.T) {
+	subject := scatterGather[testBackend, string]{
+		backends: []testBackend{testBackend(1), testBackend(2), testBackend(3)},
+		maxWait:  2 * time.Second,
+		strategy: &scatterStrategy{name: strategyFirstSuccess},
+	}
+	var calls, canceled atomic.Int32
+	ctx := context.Background()
+	err := subject.scatter(ctx, func(cctx context.Context, i testBackend) (*string, error) {
+		calls.Add(1)
+		if i != 1 {
+			<-cctx.Done()
+			if errors.Is(cctx.Err(), context.Canceled) {
+				canceled.Add(1)
+			}
+			return nil, cctx.Err()
+		}
+		str := fmt.Sprintf("%d fish", i)
+		return &str, nil
+	})
+	require.NoError(t, err)
+
+	var gotResults []string
+	for got := range subject.gather(ctx) {
+		gotResults = append(gotResults, got)
+	}
+	require.Equal(t, []string{"1 fish"}, gotResults)
+	// Backends not yet called when canceled are not called at all.
+	require.Equal(t, calls.Load()-1, canceled.Load())
+	require.Less(t, time.Since(subject.start), time.Second)
+}
+
+func TestScatterGather_QuorumCancelsRemaining(t *testing.T) {
+	subject := scatterGather[testBackend, string]{
+		backends: []testBackend{testBackend(1), testBackend(2), testBackend(3)},
+		maxWait:  2 * time.Second,
+		strategy: &scatterStrategy{name: strategyQuorum, quorum: 2},
+	}
+	ctx := context.Background()
+	err := subject.scatter(ctx, func(cctx context.Context, i testBackend) (*string, error) {
+		if i == 3 {
+			<-cctx.Done()
+			return nil, cctx.Err()
+		}
+		str := fmt.Sprintf("%d fish", i)
+		return &str, nil
+	})
+	require.NoError(t, err)
+
+	var gotResults []string
+	for got := range subject.gather(ctx) {
+		gotResults = append(gotResults, got)
+	}
+	require.ElementsMatch(t, []string{"1 fish", "2 fish"}, gotResults)
+	require.Less(t, time.Since(subject.start), time.Second)
+}
+
+func TestScatterGather_HedgedQueriesNextBackend(t *testing.T) {
+	hedgeDelay := 100 * time.Millisecond
+	subject := scatterGather[testBackend, string]{
+		backends: []testBackend{testBackend(1), testBackend(2), testBackend(3)},
+		maxWait:  2 * time.Second,
+		strategy: &scatterStrategy{name: strategyHedged, hedgeDelayInit: hedgeDelay},
+	}
+	var called [4]atomic.Int64
+	ctx := context.Background()
+	err := subject.scatter(ctx, func(cctx context.Context, i testBackend) (*string, error) {
+		called[i].Store(int64(time.Since(subject.start)))
+		if i == 1 {
+			// Slow backend.
+			<-cctx.Done()
+			return nil, cctx.Err()
+		}
+		str := fmt.Sprintf("%d fish", i)
+		return &str, nil
+	})
+	require.NoError(t, err)
+
+	var gotResults []string
+	for got := range subject.gather(ctx) {
+		gotResults = append(gotResults, got)
+	}
+	require.Equal(t, []string{"2 fish"}, gotResults)
+	require.GreaterOrEqual(t, time.Duration(called[2].Load()), hedgeDelay)
+	require.Zero(t, called[3].Load())
+	require.Less(t, time.Since(subject.start), time.Second)
+}
+
+func TestScatterGather_HedgedDoesNotWaitOnNoResult(t *testing.T) {
+	subject := scatterGather[testBackend, string]{
+		backends: []testBackend{testBackend(1), testBackend(2)},
+		maxWait:  2 * time.Second,
+		strategy: &scatterStrategy{name: strategyHedged, hedgeDelayInit: time.Minute},
+	}
+	ctx := context.Background()
+	err := subject.scatter(ctx, func(cctx context.Context, i testBackend) (*string, error) {
+		if i == 1 {
+			return nil, nil
+		}
+		str := fmt.Sprintf("%d fish", i)
+		return &str, nil
+	})
+	require.NoError(t, err)
+
+	var gotResults []string
+	for got := range subject.gather(ctx) {
+		gotResults = append(gotResults, got)
+	}
+	require.Equal(t, []string{"2 fish"}, gotResults)
+	require.Less(t, time.Since(subject.start), time.Second)
+}
+
+func TestScatterStrategy_HedgeDelay(t *testing.T) {
+	var all *scatterStrategy
+	require.Equal(t, strategyAll, all.String())
+	require.Zero(t, all.needed())
+	require.False(t, all.hedged())
+
+	s := &scatterStrategy{name: strategyHedged, hedgePercentile: 95, hedgeDelayInit: time.Second}
+	require.Equal(t, 1, s.needed())
+	for i := 1; i < minLatencySamples; i++ {
+		s.observe(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, time.Second, s.hedgeDelay())
+
+	for i := minLatencySamples; i <= 2*latencySamples; i++ {
+		s.observe(time.Duration(i) * time.Millisecond)
+	}
+	// Only the most recent samples, 101ms to 200ms, are kept.
+	require.Equal(t, 195*time.Millisecond, s.hedgeDelay())
+
+	require.Equal(t, strategyAll, newScatterStrategy("unknown", 2, 95, time.Second).String())
+}
@@ -4,17 +4,18 @@ import (
 	"bytes"
 	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 
 	logging "github.com/ipfs/go-log/v2"
-	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/go-libipni/find/model"
 	"github.com/ipni/indexstar/metrics"
-	"github.com/mercari/go-circuitbreaker"
 	"github.com/urfave/cli/v2"
 )
 
@@ -30,6 +31,7 @@ const (
 	cascadeBackendsArg   = "cascadeBackends"
 	dhBackendsArg        = "dhBackends"
 	providersBackendsArg = "providersBackends"
+	provideBackendsArg   = "provideBackends"
 )
 
 type server struct {
@@ -38,12 +40,23 @@ type server struct {
 	net.Listener
 	metricsListener       net.Listener
 	cfgBase               string
-	backends              []Backend
+	flagBackends          []BackendConfig
 	translateNonStreaming bool
 
+	// state is replaced when the config file is reloaded.
+	state       atomic.Pointer[serverState]
+	reloadMutex sync.Mutex
+
 	indexPage            []byte
 	indexPageCompileTime time.Time
-	pcache               *pcache.ProviderCache
+	cache                *findCache
+	snapshots            *providerSnapshots
+	ranker               *ranker
+	accessLog            accessLogger
+
+	// provideNext is the index of the next provide backend to try when
+	// provide requests are routed round-robin.
+	provideNext atomic.Uint32
 }
 
 // caskadeBackend is a marker for caskade backends
@@ -59,6 +72,12 @@ type providersBackend struct {
 	Backend
 }
 
+// provideBackend is a marker for backends that delegated routing provide
+// requests are forwarded to.
+type provideBackend struct {
+	Backend
+}
+
 func NewServer(c *cli.Context) (*server, error) {
 	bound, err := net.Listen("tcp", c.String("listen"))
 	if err != nil {
@@ -68,22 +87,20 @@ func NewServer(c *cli.Context) (*server, error) {
 	if err != nil {
 		return nil, err
 	}
-	servers := c.StringSlice(backendsArg)
-	cascadeServers := c.StringSlice(cascadeBackendsArg)
-	dhServers := c.StringSlice(dhBackendsArg)
-	providersServers := c.StringSlice(providersBackendsArg)
 
-	if len(servers) == 0 {
-		if !c.IsSet("config") {
-			return nil, fmt.Errorf("no backends specified")
-		}
-		servers, err = Load(c.String("config"))
-		if err != nil {
-			return nil, fmt.Errorf("could not load backends from config: %w", err)
-		}
+	fc, err := Load(c.String("config"))
+	noConfig := errors.Is(err, ErrNotInitialized) && !c.IsSet("config")
+	if err != nil && !noConfig {
+		return nil, fmt.Errorf("could not load config: %w", err)
 	}
-
-	backends, err := loadBackends(servers, cascadeServers, dhServers, providersServers)
+	if noConfig {
+		fc = &FileConfig{}
+	}
+	// Backends given by flags are used in addition to the backends in the
+	// config file. The default regular backends are only used when there is
+	// no config file.
+	flagBackends := flagBackendConfigs(c, c.IsSet(backendsArg) || noConfig)
+	state, err := newServerState(fc, flagBackends, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -102,24 +119,7 @@ func NewServer(c *cli.Context) (*server, error) {
 
 	httpClient := http.Client{
 		Timeout:   config.Server.HttpClientTimeout,
-		Transport: t,
-	}
-
-	var providerSources []pcache.ProviderSource
-	for _, backend := range backends {
-		// do not send providers requests to not providers backends
-		if _, ok := backend.(providersBackend); !ok {
-			continue
-		}
-		httpSrc, err := pcache.NewHTTPSource(backend.URL().String(), &httpClient)
-		if err != nil {
-			return nil, fmt.Errorf("cannot create http provider source: %w", err)
-		}
-		providerSources = append(providerSources, httpSrc)
-	}
-	pc, err := pcache.New(pcache.WithSource(providerSources...))
-	if err != nil {
-		return nil, fmt.Errorf("cannot create provider cache: %w", err)
+		Transport: &backendTransport{RoundTripper: t},
 	}
 
 	indexTemplate, err := template.ParseFS(webUI, "index.html")
@@ -136,124 +136,52 @@ func NewServer(c *cli.Context) (*server, error) {
 	}
 	compileTime := time.Now()
 
-	return &server{
+	s := &server{
 		Context:               c.Context,
 		Client:                httpClient,
 		cfgBase:               c.String("config"),
+		flagBackends:          flagBackends,
 		Listener:              bound,
 		metricsListener:       mb,
-		backends:              backends,
 		translateNonStreaming: c.Bool("translateNonStreaming"),
 		indexPage:             indexPageBuf.Bytes(),
 		indexPageCompileTime:  compileTime,
-		pcache:                pc,
-	}, nil
-}
-
-func loadBackends(servers, cascadeServers, dhServers, providersServers []string) ([]Backend, error) {
-	newBackendFunc := func(s string) (Backend, error) {
-		return NewBackend(s, circuitbreaker.New(
-			circuitbreaker.WithFailOnContextCancel(false),
-			circuitbreaker.WithHalfOpenMaxSuccesses(int64(config.Circuit.HalfOpenSuccesses)),
-			circuitbreaker.WithOpenTimeout(config.Circuit.OpenTimeout),
-			circuitbreaker.WithCounterResetInterval(config.Circuit.CounterReset),
-			circuitbreaker.WithOnStateChangeHookFn(func(from, to circuitbreaker.State) {
-				log.Infof("circuit state for %s changed from %s to %s", s, from, to)
-			})), Matchers.Any)
-	}
-
-	backends := make([]Backend, 0, len(servers)+len(dhServers)+len(providersServers)+len(cascadeServers))
-	for _, s := range servers {
-		b, err := newBackendFunc(s)
-		if err != nil {
-			return nil, fmt.Errorf("failed to instantiate backend: %w", err)
-		}
-		backends = append(backends, b)
+		cache:                 newFindCache(config.Cache.Size, config.Cache.TTL, config.Cache.NegativeTTL),
 	}
-	for _, s := range dhServers {
-		b, err := newBackendFunc(s)
-		if err != nil {
-			return nil, fmt.Errorf("failed to instantiate dh backend: %w", err)
-		}
-		backends = append(backends, dhBackend{Backend: b})
-	}
-	for _, s := range providersServers {
-		b, err := newBackendFunc(s)
-		if err != nil {
-			return nil, fmt.Errorf("failed to instantiate provider backend: %w", err)
-		}
-		backends = append(backends, providersBackend{Backend: b})
-	}
-
-	for _, cs := range cascadeServers {
-		matcher := Matchers.Any
-		if config.Server.CascadeLabels != "" {
-			labels := strings.Split(config.Server.CascadeLabels, ",")
-			if len(labels) > 0 {
-				labelMatchers := make([]HttpRequestMatcher, 0, len(labels))
-				for _, label := range labels {
-					labelMatchers = append(labelMatchers, Matchers.QueryParam("cascade", label))
-				}
-				matcher = Matchers.AnyOf(labelMatchers...)
-			}
-		}
-		b, err := NewBackend(cs, circuitbreaker.New(
-			circuitbreaker.WithFailOnContextCancel(false),
-			circuitbreaker.WithHalfOpenMaxSuccesses(int64(config.CascadeCircuit.HalfOpenSuccesses)),
-			circuitbreaker.WithOpenTimeout(config.CascadeCircuit.OpenTimeout),
-			circuitbreaker.WithCounterResetInterval(config.CascadeCircuit.CounterReset),
-			circuitbreaker.WithOnStateChangeHookFn(func(from, to circuitbreaker.State) {
-				log.Infof("cascade circuit state for %s changed from %s to %s", cs, from, to)
-			})), matcher)
-		if err != nil {
-			return nil, fmt.Errorf("failed to instantiate cascade backend: %w", err)
-		}
-		backends = append(backends, caskadeBackend{Backend: b})
-	}
-
-	if len(backends) == 0 {
-		return nil, fmt.Errorf("no backends specified")
-	}
-	return backends, nil
-}
-
-func (s *server) Reload(cctx *cli.Context) error {
-	surls, err := Load(s.cfgBase)
-	if err != nil {
-		return err
+	s.snapshots = newProviderSnapshots(func() []*model.ProviderInfo {
+		return s.state.Load().pcache.List()
+	})
+	s.ranker = newRanker(s.snapshots)
+	if err = setProviderCache(state, nil, &s.Client); err != nil {
+		return nil, err
 	}
-	b, err := loadBackends(surls,
-		cctx.StringSlice(cascadeBackendsArg),
-		cctx.StringSlice(dhBackendsArg),
-		cctx.StringSlice(providersBackendsArg))
-	if err != nil {
-		return err
+	if err = s.accessLog.configure(fc.AccessLog); err != nil {
+		return nil, fmt.Errorf("bad access log config: %w", err)
 	}
-	s.backends = b
-
-	return nil
+	s.state.Store(state)
+	return s, nil
 }
 
 func (s *server) Serve() chan error {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/cid/", func(w http.ResponseWriter, r *http.Request) { s.findCid(w, r, false) })
-	mux.HandleFunc("/encrypted/cid/", func(w http.ResponseWriter, r *http.Request) { s.findCid(w, r, true) })
-	mux.HandleFunc("/multihash/", func(w http.ResponseWriter, r *http.Request) { s.findMultihashSubtree(w, r, false) })
-	mux.HandleFunc("/encrypted/multihash/", func(w http.ResponseWriter, r *http.Request) { s.findMultihashSubtree(w, r, true) })
-	mux.HandleFunc("/metadata/", s.findMetadataSubtree)
-	mux.HandleFunc("/providers", s.providers)
-	mux.HandleFunc("/providers/", s.provider)
+	mux.HandleFunc("/cid/", s.limit(routeFind, func(w http.ResponseWriter, r *http.Request) { s.findCid(w, r, false) }))
+	mux.HandleFunc("/encrypted/cid/", s.limit(routeFind, func(w http.ResponseWriter, r *http.Request) { s.findCid(w, r, true) }))
+	mux.HandleFunc("/multihash/", s.limit(routeFind, func(w http.ResponseWriter, r *http.Request) { s.findMultihashSubtree(w, r, false) }))
+	mux.HandleFunc("/encrypted/multihash/", s.limit(routeFind, func(w http.ResponseWriter, r *http.Request) { s.findMultihashSubtree(w, r, true) }))
+	mux.HandleFunc("/metadata/", s.limit(routeFind, s.findMetadataSubtree))
+	mux.HandleFunc("/providers", s.limit(routeProviders, s.providers))
+	mux.HandleFunc("/providers/", s.limit(routeProviders, s.provider))
 	mux.HandleFunc("/health", s.health)
 
 	ec := make(chan error)
-	delegated, err := NewDelegatedTranslator(s.doFind)
+	delegated, err := NewDelegatedTranslator(s.findCached, s.findDelegatedNDJson, s.doProvide, s.peerAddrs)
 	if err != nil {
 		ec <- err
 		close(ec)
 		return ec
 	}
 	// Strip prefix URI since DelegatedTranslator uses a nested mux.
-	mux.Handle("/routing/v1/", http.StripPrefix("/routing/v1", delegated))
+	mux.HandleFunc("/routing/v1/", s.limit(routeDelegated, http.StripPrefix("/routing/v1", delegated).ServeHTTP))
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// Do not fall back on web-ui on unknown paths. Instead, strictly check the path and
@@ -272,7 +200,7 @@ func (s *server) Serve() chan error {
 	})
 
 	serv := http.Server{
-		Handler: http.MaxBytesHandler(mux, config.Server.MaxRequestBodySize),
+		Handler: s.logAccess(http.MaxBytesHandler(mux, config.Server.MaxRequestBodySize)),
 	}
 	go func() {
 		log.Infow("finder http server listening", "listen_addr", s.Listener.Addr())
@@ -285,6 +213,15 @@ func (s *server) Serve() chan error {
 	metricsMux := http.NewServeMux()
 	metricsMux.Handle("/metrics", metrics.Start(nil))
 	metricsMux.Handle("/pprof", metrics.WithProfile())
+	// The admin endpoints are served on the metrics listener, which is
+	// not meant to be publicly reachable.
+	metricsMux.HandleFunc("/cache", s.purgeCache)
+	metricsMux.HandleFunc("/cache/", s.purgeCache)
+	metricsMux.HandleFunc("/reload", s.reload)
+	metricsMux.HandleFunc("/backends", s.listBackends)
+	metricsMux.HandleFunc("/backends/circuit", s.setBackendCircuit)
+	metricsMux.HandleFunc("/backends/drain", s.drainBackend)
+	metricsMux.HandleFunc("/accesslog", s.accessLogAdmin)
 	metricsServ := http.Server{
 		Handler: http.MaxBytesHandler(metricsMux, config.Server.MaxRequestBodySize),
 	}
@@ -305,6 +242,9 @@ func (s *server) Serve() chan error {
 			log.Warnw("failed shutdown", "err", err)
 			ec <- err
 		}
+		if err = s.accessLog.close(); err != nil {
+			log.Warnw("failed to close access log", "err", err)
+		}
 	}()
 	return ec
 }
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/mercari/go-circuitbreaker"
+	"github.com/urfave/cli/v2"
+)
+
+// serverState is the configuration that is changed by reloading the config
+// file while the server is running. It is replaced atomically, and each
+// request uses the serverState that is current when the request starts.
+type serverState struct {
+	backends []Backend
+	// backendConfigs are the configs of the backends, in the same order, with
+	// their kind and matchers set.
+	backendConfigs      []BackendConfig
+	resultMaxWait       time.Duration
+	resultStreamMaxWait time.Duration
+	cascadeLabels       string
+	provideRouting      string
+	rankResults         bool
+	// strategies are the scatter strategies of non-streaming find requests,
+	// by find method.
+	strategies       map[string]*scatterStrategy
+	metadataStrategy *scatterStrategy
+	// limiter is nil if requests are not rate limited.
+	limiter *rateLimiter
+	// circuits are the circuit breakers of the backends, by backend kind and
+	// URL, so that they are kept across reloads.
+	circuits map[string]backendCircuit
+	// pcache reads provider information from the providers backends.
+	pcache *pcache.ProviderCache
+}
+
+// backendCircuit is the circuit breaker of a backend and the circuit config it
+// was created with.
+type backendCircuit struct {
+	cb     *circuitbreaker.CircuitBreaker
+	config CircuitConfig
+}
+
+// newServerState creates a serverState from the config file and the backends
+// given by flags. Settings that are not in the config file are taken from
+// environment variables. The circuit breakers of backends in prev, which may
+// be nil, are kept for backends with the same URL and circuit config.
+func newServerState(fc *FileConfig, flagBackends []BackendConfig, prev *serverState) (*serverState, error) {
+	sc := fc.Server
+	st := &serverState{
+		resultMaxWait:       config.Server.ResultMaxWait,
+		resultStreamMaxWait: config.Server.ResultStreamMaxWait,
+		cascadeLabels:       config.Server.CascadeLabels,
+		provideRouting:      config.Server.ProvideRouting,
+		rankResults:         config.Server.RankResults,
+	}
+	if sc.ResultMaxWait != 0 {
+		st.resultMaxWait = time.Duration(sc.ResultMaxWait)
+	}
+	if sc.ResultStreamMaxWait != 0 {
+		st.resultStreamMaxWait = time.Duration(sc.ResultStreamMaxWait)
+	}
+	if sc.CascadeLabels != "" {
+		st.cascadeLabels = sc.CascadeLabels
+	}
+	if sc.ProvideRouting != "" {
+		st.provideRouting = sc.ProvideRouting
+	}
+	if sc.RankResults != nil {
+		st.rankResults = *sc.RankResults
+	}
+
+	findStrategy := stringOrDefault(sc.FindStrategy, config.Server.FindStrategy)
+	delegatedFindStrategy := stringOrDefault(sc.DelegatedFindStrategy, config.Server.DelegatedFindStrategy)
+	metadataStrategy := stringOrDefault(sc.MetadataStrategy, config.Server.MetadataStrategy)
+	quorum := config.Server.Quorum
+	if sc.Quorum != 0 {
+		quorum = sc.Quorum
+	}
+	hedgePercentile := config.Server.HedgePercentile
+	if sc.HedgePercentile != 0 {
+		hedgePercentile = sc.HedgePercentile
+	}
+	hedgeDelay := config.Server.HedgeDelay
+	if sc.HedgeDelay != 0 {
+		hedgeDelay = time.Duration(sc.HedgeDelay)
+	}
+	st.strategies = map[string]*scatterStrategy{
+		findMethodOrig:      newScatterStrategy(findStrategy, quorum, hedgePercentile, hedgeDelay),
+		findMethodDelegated: newScatterStrategy(delegatedFindStrategy, quorum, hedgePercentile, hedgeDelay),
+	}
+	st.metadataStrategy = newScatterStrategy(metadataStrategy, quorum, hedgePercentile, hedgeDelay)
+
+	circuit := circuitConfig(CircuitConfig{
+		HalfOpenSuccesses: config.Circuit.HalfOpenSuccesses,
+		OpenTimeout:       Duration(config.Circuit.OpenTimeout),
+		CounterReset:      Duration(config.Circuit.CounterReset),
+	}, &fc.Circuit)
+	cascadeCircuit := circuitConfig(CircuitConfig{
+		HalfOpenSuccesses: config.CascadeCircuit.HalfOpenSuccesses,
+		OpenTimeout:       Duration(config.CascadeCircuit.OpenTimeout),
+		CounterReset:      Duration(config.CascadeCircuit.CounterReset),
+	}, &fc.CascadeCircuit)
+
+	bcs := make([]BackendConfig, 0, len(fc.Backends)+len(flagBackends))
+	bcs = append(bcs, fc.Backends...)
+	bcs = append(bcs, flagBackends...)
+	var err error
+	st.limiter, err = newRateLimiter(fc.RateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("bad rate limit config: %w", err)
+	}
+	var prevCircuits map[string]backendCircuit
+	if prev != nil {
+		prevCircuits = prev.circuits
+	}
+	st.backends, st.backendConfigs, st.circuits, err = loadBackends(bcs, st.cascadeLabels, circuit, cascadeCircuit, prevCircuits)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// setProviderCache sets the provider cache of st, which reads from the
+// providers backends of st. The provider cache of prev, which may be nil, is
+// kept if it reads from the same backends, so that it stays populated across
+// reloads.
+func setProviderCache(st, prev *serverState, client *http.Client) error {
+	urls := providerSourceURLs(st.backends)
+	if prev != nil && slices.Equal(urls, providerSourceURLs(prev.backends)) {
+		st.pcache = prev.pcache
+		return nil
+	}
+	sources := make([]pcache.ProviderSource, 0, len(urls))
+	for _, u := range urls {
+		httpSrc, err := pcache.NewHTTPSource(u, client)
+		if err != nil {
+			return fmt.Errorf("cannot create http provider source: %w", err)
+		}
+		sources = append(sources, httpSrc)
+	}
+	pc, err := pcache.New(pcache.WithSource(sources...))
+	if err != nil {
+		return fmt.Errorf("cannot create provider cache: %w", err)
+	}
+	st.pcache = pc
+	return nil
+}
+
+// providerSourceURLs returns the URLs of the providers backends.
+func providerSourceURLs(backends []Backend) []string {
+	var urls []string
+	for _, backend := range backends {
+		// do not send providers requests to not providers backends
+		if _, ok := backend.(providersBackend); !ok {
+			continue
+		}
+		urls = append(urls, backend.URL().String())
+	}
+	return urls
+}
+
+func stringOrDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// flagBackendConfigs returns the configs of the backends given by flags.
+// Regular backends are included only if regular is true.
+func flagBackendConfigs(c *cli.Context, regular bool) []BackendConfig {
+	var bcs []BackendConfig
+	add := func(kind string, urls []string) {
+		for _, u := range urls {
+			bcs = append(bcs, BackendConfig{URL: u, Kind: kind})
+		}
+	}
+	if regular {
+		add(backendKindRegular, c.StringSlice(backendsArg))
+	}
+	add(backendKindDh, c.StringSlice(dhBackendsArg))
+	add(backendKindProviders, c.StringSlice(providersBackendsArg))
+	add(backendKindProvide, c.StringSlice(provideBackendsArg))
+	add(backendKindCascade, c.StringSlice(cascadeBackendsArg))
+	return bcs
+}
+
+// loadBackends creates the backends from their configs. It also returns the
+// configs with the backend kind and matchers set, and the circuit breakers of
+// the backends. A circuit breaker in prevCircuits is used again for a backend
+// with the same kind, URL and circuit config.
+func loadBackends(bcs []BackendConfig, cascadeLabels string, circuit, cascadeCircuit CircuitConfig, prevCircuits map[string]backendCircuit) ([]Backend, []BackendConfig, map[string]backendCircuit, error) {
+	backends := make([]Backend, 0, len(bcs))
+	loaded := make([]BackendConfig, 0, len(bcs))
+	circuits := make(map[string]backendCircuit, len(bcs))
+	for _, bc := range bcs {
+		kind := stringOrDefault(bc.Kind, backendKindRegular)
+		bc.Kind = kind
+		cc, hookName := circuit, "circuit"
+		if kind == backendKindCascade {
+			cc, hookName = cascadeCircuit, "cascade circuit"
+		}
+		cc = circuitConfig(cc, bc.Circuit)
+		u := bc.URL
+		circuitKey := kind + " " + u
+		// host is set once the backend is created, before any state change.
+		var host string
+		// A backend listed more than once does not share its breaker.
+		_, listed := circuits[circuitKey]
+		var cb *circuitbreaker.CircuitBreaker
+		if prevCircuit, ok := prevCircuits[circuitKey]; ok && !listed && prevCircuit.config == cc {
+			cb = prevCircuit.cb
+		} else {
+			cb = circuitbreaker.New(
+				circuitbreaker.WithFailOnContextCancel(false),
+				circuitbreaker.WithHalfOpenMaxSuccesses(int64(cc.HalfOpenSuccesses)),
+				circuitbreaker.WithOpenTimeout(time.Duration(cc.OpenTimeout)),
+				circuitbreaker.WithCounterResetInterval(time.Duration(cc.CounterReset)),
+				circuitbreaker.WithOnStateChangeHookFn(func(from, to circuitbreaker.State) {
+					log.Infof("%s state for %s changed from %s to %s", hookName, u, from, to)
+					recordCircuitState(host, to)
+				}))
+		}
+		if !listed {
+			circuits[circuitKey] = backendCircuit{cb: cb, config: cc}
+		}
+
+		matcher := Matchers.Any
+		var labelMatchers []HttpRequestMatcher
+		if len(bc.Matchers) != 0 {
+			for _, m := range bc.Matchers {
+				labelMatchers = append(labelMatchers, Matchers.QueryParam(m.QueryParam, m.Value))
+			}
+		} else if kind == backendKindCascade && cascadeLabels != "" {
+			for _, label := range strings.Split(cascadeLabels, ",") {
+				labelMatchers = append(labelMatchers, Matchers.QueryParam("cascade", label))
+				bc.Matchers = append(bc.Matchers, MatcherConfig{QueryParam: "cascade", Value: label})
+			}
+		}
+		if len(labelMatchers) != 0 {
+			matcher = Matchers.AnyOf(labelMatchers...)
+		}
+
+		b, err := newBackendFromConfig(bc, cb, matcher)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to instantiate %s backend: %w", kind, err)
+		}
+		host = b.URL().Host
+		recordCircuitState(host, cb.State())
+		switch kind {
+		case backendKindCascade:
+			b = caskadeBackend{Backend: b}
+		case backendKindDh:
+			b = dhBackend{Backend: b}
+		case backendKindProviders:
+			b = providersBackend{Backend: b}
+		case backendKindProvide:
+			b = provideBackend{Backend: b}
+		}
+		backends = append(backends, b)
+		loaded = append(loaded, bc)
+	}
+
+	if len(backends) == 0 {
+		return nil, nil, nil, fmt.Errorf("no backends specified")
+	}
+	return backends, loaded, circuits, nil
+}
+
+// Reload reloads the config file, and atomically replaces the server state.
+// Requests in progress complete using the previous state.
+func (s *server) Reload() error {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	fc, err := Load(s.cfgBase)
+	if err != nil {
+		return fmt.Errorf("could not load config: %w", err)
+	}
+	prev := s.state.Load()
+	st, err := newServerState(fc, s.flagBackends, prev)
+	if err != nil {
+		return err
+	}
+	if err = setProviderCache(st, prev, &s.Client); err != nil {
+		return err
+	}
+	if err = s.accessLog.configure(fc.AccessLog); err != nil {
+		return fmt.Errorf("bad access log config: %w", err)
+	}
+	// Backends stay drained across reloads, and open circuits, such as those
+	// forced open by an admin, stay open when a backend gets a new circuit
+	// breaker because its circuit config changed.
+	if prev != nil {
+		drained := make(map[string]struct{})
+		open := make(map[string]struct{})
+		for _, b := range prev.backends {
+			if b.Drained() {
+				drained[b.URL().String()] = struct{}{}
+			}
+			if cb := b.CB(); cb != nil && cb.State() == circuitbreaker.StateOpen {
+				open[b.URL().String()] = struct{}{}
+			}
+		}
+		for _, b := range st.backends {
+			if _, ok := drained[b.URL().String()]; ok {
+				b.SetDrained(true)
+			}
+			if _, ok := open[b.URL().String()]; ok && b.CB() != nil && b.CB().State() != circuitbreaker.StateOpen {
+				b.CB().SetState(circuitbreaker.StateOpen)
+				recordCircuitState(b.URL().Host, circuitbreaker.StateOpen)
+			}
+		}
+	}
+	s.state.Store(st)
+	// Cached responses may be from backends that were removed.
+	s.cache.purge(nil)
+	log.Infow("Reloaded config", "backends", len(st.backends))
+	return nil
+}
+
+// reload handles admin requests to reload the config file.
+func (s *server) reload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.Reload(); err != nil {
+		log.Warnw("Cannot reload config", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
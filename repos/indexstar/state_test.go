@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	providersURL := newProvidersBackend(t)
+	cfgFile := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`["http://a.invalid"]`), 0666))
+
+	s := &server{
+		cfgBase: cfgFile,
+		flagBackends: []BackendConfig{
+			{URL: "http://dh.invalid", Kind: backendKindDh},
+			{URL: providersURL, Kind: backendKindProviders},
+		},
+		cache: newFindCache(10, time.Minute, time.Minute),
+	}
+	require.NoError(t, s.Reload())
+	st := s.state.Load()
+	require.Len(t, st.backends, 3)
+	require.Equal(t, "a.invalid", st.backends[0].URL().Host)
+	require.NotNil(t, st.pcache)
+
+	mh, err := multihash.Sum([]byte("fish"), multihash.SHA2_256, -1)
+	require.NoError(t, err)
+	s.cache.put(cacheKey(cacheKindJson, mh, false, ""), mh, &cacheEntry{status: http.StatusOK})
+
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`{"Backends": [{"URL": "http://b.invalid"}, {"URL": "http://c.invalid"}]}`), 0666))
+	rec := httptest.NewRecorder()
+	s.reload(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	// Requests that started before reload keep using the previous state.
+	require.Len(t, st.backends, 3)
+	newSt := s.state.Load()
+	require.NotSame(t, st, newSt)
+	require.Len(t, newSt.backends, 4)
+	require.Equal(t, "b.invalid", newSt.backends[0].URL().Host)
+	require.Equal(t, "c.invalid", newSt.backends[1].URL().Host)
+	require.Zero(t, s.cache.purge(nil))
+
+	// Unchanged backends keep their circuit breakers, and the provider cache
+	// is kept while the providers backends are unchanged.
+	require.Same(t, st.backends[1].CB(), newSt.backends[2].CB())
+	require.Same(t, st.backends[2].CB(), newSt.backends[3].CB())
+	require.Same(t, st.pcache, newSt.pcache)
+
+	// Changing the circuit config of a backend replaces its circuit breaker.
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`{"Backends": [{"URL": "http://b.invalid", "Circuit": {"HalfOpenSuccesses": 3}}, {"URL": "http://c.invalid"}]}`), 0666))
+	require.NoError(t, s.Reload())
+	cbSt := s.state.Load()
+	require.NotSame(t, newSt.backends[0].CB(), cbSt.backends[0].CB())
+	require.Same(t, newSt.backends[1].CB(), cbSt.backends[1].CB())
+
+	// Adding a providers backend replaces the provider cache.
+	s.flagBackends = append(s.flagBackends, BackendConfig{URL: providersURL + "/other", Kind: backendKindProviders})
+	require.NoError(t, s.Reload())
+	pcSt := s.state.Load()
+	require.NotSame(t, cbSt.pcache, pcSt.pcache)
+	newSt = pcSt
+
+	// Invalid config keeps the current state.
+	require.NoError(t, os.WriteFile(cfgFile, []byte(`{"Backends": [{"Kind": "dh"}]}`), 0666))
+	rec = httptest.NewRecorder()
+	s.reload(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	require.Same(t, newSt, s.state.Load())
+
+	rec = httptest.NewRecorder()
+	s.reload(rec, httptest.NewRequest(http.MethodGet, "/reload", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+// newProvidersBackend starts a providers backend that has no providers, and
+// returns its URL.
+func newProvidersBackend(t *testing.T) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJsonResponse(w, http.StatusOK, []byte("[]"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
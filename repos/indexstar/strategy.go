@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// strategyAll waits for results from all backends, up to the maximum
+	// wait time.
+	strategyAll = "all"
+	// strategyFirstSuccess waits for the first backend result, and then
+	// cancels the remaining backend calls.
+	strategyFirstSuccess = "first-success"
+	// strategyQuorum waits for a quorum of backend results, and then cancels
+	// the remaining backend calls.
+	strategyQuorum = "quorum"
+	// strategyHedged queries one backend at a time, querying the next backend
+	// if there is no result within a percentile of recent backend latencies.
+	// Remaining backend calls are canceled on the first result.
+	strategyHedged = "hedged"
+
+	// latencySamples is the number of recent backend latencies kept to
+	// calculate the hedge delay.
+	latencySamples = 100
+	// minLatencySamples is the number of backend latencies needed before the
+	// hedge delay is calculated from them.
+	minLatencySamples = 10
+)
+
+// scatterStrategy determines how many backend results a scatter waits for.
+// A nil scatterStrategy is strategyAll.
+type scatterStrategy struct {
+	name            string
+	quorum          int
+	hedgePercentile int
+	hedgeDelayInit  time.Duration
+
+	mutex     sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+// newScatterStrategy creates a scatterStrategy from its name. Unknown names
+// fall back on strategyAll.
+func newScatterStrategy(name string, quorum, hedgePercentile int, hedgeDelay time.Duration) *scatterStrategy {
+	switch name {
+	case strategyAll, strategyFirstSuccess, strategyQuorum, strategyHedged:
+	default:
+		log.Warnw("Unknown scatter strategy, falling back on default", "strategy", name, "default", strategyAll)
+		name = strategyAll
+	}
+	return &scatterStrategy{
+		name:            name,
+		quorum:          quorum,
+		hedgePercentile: hedgePercentile,
+		hedgeDelayInit:  hedgeDelay,
+	}
+}
+
+// String returns the strategy name, which is used to tag latency metrics.
+func (s *scatterStrategy) String() string {
+	if s == nil {
+		return strategyAll
+	}
+	return s.name
+}
+
+// needed returns the number of backend results after which the remaining
+// backend calls are canceled, or zero to wait for all backends.
+func (s *scatterStrategy) needed() int {
+	if s == nil {
+		return 0
+	}
+	switch s.name {
+	case strategyFirstSuccess, strategyHedged:
+		return 1
+	case strategyQuorum:
+		return max(s.quorum, 1)
+	default:
+		return 0
+	}
+}
+
+func (s *scatterStrategy) hedged() bool {
+	return s != nil && s.name == strategyHedged
+}
+
+// observe records the latency of a backend call that returned a result.
+func (s *scatterStrategy) observe(latency time.Duration) {
+	if !s.hedged() {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.latencies) < latencySamples {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+	s.latencies[s.next] = latency
+	s.next = (s.next + 1) % latencySamples
+}
+
+// hedgeDelay returns how long to wait for a backend result before querying the
+// next backend. This is the configured percentile of recent backend latencies,
+// or the configured initial hedge delay until there are enough latencies.
+func (s *scatterStrategy) hedgeDelay() time.Duration {
+	s.mutex.Lock()
+	if len(s.latencies) < minLatencySamples {
+		s.mutex.Unlock()
+		return s.hedgeDelayInit
+	}
+	sorted := make([]time.Duration, len(s.latencies))
+	copy(sorted, s.latencies)
+	s.mutex.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := (len(sorted)*min(max(s.hedgePercentile, 1), 100) + 99) / 100
+	return sorted[i-1]
+}
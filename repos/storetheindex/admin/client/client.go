@@ -23,7 +23,9 @@ import (
 
 const (
 	assignedPath        = "assigned"
+	backupPath          = "backup"
 	freezePath          = "freeze"
+	gcPath              = "gc"
 	importPath          = "import"
 	importProvidersPath = "importproviders"
 	ingestPath          = "ingest"
@@ -344,6 +346,44 @@ func (c *Client) Unassign(ctx context.Context, peerID peer.ID) error {
 	return c.ingestRequest(ctx, peerID, "unassign", http.MethodPut, nil)
 }
 
+// ListSyncs gets the progress of the syncs with publishers that are in
+// progress.
+func (c *Client) ListSyncs(ctx context.Context) ([]model.SyncProgress, error) {
+	u := c.baseURL.JoinPath(ingestPath, "syncs")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apierror.FromResponse(resp.StatusCode, body)
+	}
+
+	var syncs []model.SyncProgress
+	err = json.Unmarshal(body, &syncs)
+	if err != nil {
+		return nil, err
+	}
+
+	return syncs, nil
+}
+
+// CancelSync cancels the sync with the publisher that is in progress.
+func (c *Client) CancelSync(ctx context.Context, publisherID peer.ID) error {
+	return c.ingestRequest(ctx, publisherID, "syncs", http.MethodDelete, nil)
+}
+
 // Allow configures the indexer to allow the peer to publish messages and
 // provide content.
 func (c *Client) Allow(ctx context.Context, peerID peer.ID) error {
@@ -418,6 +458,40 @@ func (c *Client) SetLogLevels(ctx context.Context, sysLvl map[string]string) err
 	return nil
 }
 
+// Backup writes a backup archive of the indexer state to w. The config file,
+// the private key in the config file, and the temporary datastore are
+// included in the archive if requested.
+func (c *Client) Backup(ctx context.Context, w io.Writer, withConfig, withIdentity, withTmp bool) error {
+	u := c.baseURL.JoinPath(backupPath)
+	values := u.Query()
+	values.Set("config", strconv.FormatBool(withConfig))
+	values.Set("identity", strconv.FormatBool(withIdentity))
+	values.Set("tmp", strconv.FormatBool(withTmp))
+	u.RawQuery = values.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return apierror.FromResponse(resp.StatusCode, body)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
 func (c *Client) Status(ctx context.Context) (*model.Status, error) {
 	u := c.baseURL.JoinPath(statusPath)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
@@ -449,6 +523,39 @@ func (c *Client) Status(ctx context.Context) (*model.Status, error) {
 	return &status, nil
 }
 
+// GCStatus gets the progress and statistics of garbage collection run by the
+// indexer daemon.
+func (c *Client) GCStatus(ctx context.Context) (*model.GCStatus, error) {
+	u := c.baseURL.JoinPath(gcPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apierror.FromResponse(resp.StatusCode, body)
+	}
+
+	var status model.GCStatus
+	err = json.Unmarshal(body, &status)
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
 func (c *Client) GetAllTelemetry(ctx context.Context) (map[string]rate.Rate, error) {
 	u := c.baseURL.JoinPath(telemetryPath)
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
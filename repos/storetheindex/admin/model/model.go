@@ -1,6 +1,9 @@
 package model
 
 import (
+	"time"
+
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/peer"
 )
 
@@ -14,8 +17,83 @@ type Handoff struct {
 	FrozenURL string
 }
 
+// GCStatus is the progress and statistics of garbage collection run by the
+// indexer daemon.
+type GCStatus struct {
+	Running         bool
+	Provider        peer.ID `json:",omitempty"`
+	ProvidersDone   int
+	ProvidersFailed int
+	ProvidersTotal  int
+	RunStarted      time.Time
+	RunEnded        time.Time
+	NextRun         time.Time
+	Stats           GCStats
+}
+
+// GCStats holds garbage collection statistics totaled over all GC runs.
+type GCStats struct {
+	AdsProcessed    int
+	CarsDataSize    int64
+	CarsRemoved     int
+	CtxIDsKept      int
+	CtxIDsRemoved   int
+	EmptyAds        int
+	IndexAdsKept    int
+	IndexAdsRemoved int
+	IndexesRemoved  int
+	RemovalAds      int
+	ReusedCtxIDs    int
+	TimeElapsed     time.Duration
+}
+
+// Eviction describes index content evicted by retention-based eviction.
+type Eviction struct {
+	Time      time.Time
+	Provider  peer.ID
+	ContextID []byte `json:",omitempty"`
+	Usage     float64
+}
+
+// SyncProgress is the progress of a sync with a publisher that is in
+// progress.
+type SyncProgress struct {
+	Publisher     peer.ID
+	Target        cid.Cid
+	Started       time.Time
+	Elapsed       time.Duration
+	Fetching      bool
+	AdsFetched    int
+	Segment       int
+	Processing    bool
+	AdsToProcess  int
+	AdsProcessed  int
+	CurrentAd     cid.Cid
+	EntriesChunks int
+	Canceled      bool
+}
+
+// RetentionStatus is the state of retention-based eviction.
+type RetentionStatus struct {
+	Evicting         bool
+	Usage            float64
+	HighWaterPercent float64
+	LowWaterPercent  float64
+	Strategy         string
+	ProvidersEvicted int
+	ContextsEvicted  int
+	Recent           []Eviction
+}
+
 type Status struct {
 	Frozen bool
 	ID     peer.ID
 	Usage  float64
+	// FreezeAtPercent is the disk usage percent at which the indexer freezes.
+	// Zero if the indexer does not freeze.
+	FreezeAtPercent float64 `json:",omitempty"`
+	// Ingested is the total number of multihashes ingested since the indexer
+	// started.
+	Ingested  uint64           `json:",omitempty"`
+	Retention *RetentionStatus `json:",omitempty"`
 }
@@ -147,6 +147,25 @@ func daemonAction(cctx *cli.Context) error {
 		}
 	}
 
+	// Create admin HTTP server
+	var adminServer *server.Server
+	adminAddr := cfg.Daemon.AdminAddr
+	if cctx.String("listen-admin") != "" {
+		adminAddr = cctx.String("listen-admin")
+	}
+	if adminAddr != "none" {
+		adminNetAddr, err := mautil.MultiaddrStringToNetAddr(adminAddr)
+		if err != nil {
+			return fmt.Errorf("bad admin address %s: %w", adminAddr, err)
+		}
+
+		adminServer, err = server.NewAdmin(adminNetAddr.String(), assigner,
+			server.WithVersion(cctx.App.Version))
+		if err != nil {
+			return err
+		}
+	}
+
 	svrErrChan := make(chan error, 3)
 
 	log.Info("Starting http servers")
@@ -158,6 +177,14 @@ func daemonAction(cctx *cli.Context) error {
 	} else {
 		fmt.Println("http server:\t disabled")
 	}
+	if adminServer != nil {
+		go func() {
+			svrErrChan <- adminServer.Start()
+		}()
+		fmt.Println("admin server:\t", adminAddr)
+	} else {
+		fmt.Println("admin server:\t disabled")
+	}
 
 	// Output message to user (not to log).
 	fmt.Println("Daemon is ready")
@@ -181,6 +208,11 @@ func daemonAction(cctx *cli.Context) error {
 			finalErr = fmt.Errorf("error shutting down http server: %w", err)
 		}
 	}
+	if adminServer != nil {
+		if err = adminServer.Close(); err != nil {
+			finalErr = fmt.Errorf("error shutting down admin server: %w", err)
+		}
+	}
 
 	if err = assigner.Close(); err != nil {
 		finalErr = fmt.Errorf("error closing assigner: %w", err)
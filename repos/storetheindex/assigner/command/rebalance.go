@@ -0,0 +1,109 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ipni/go-libipni/mautil"
+	"github.com/ipni/storetheindex/assigner/core"
+	"github.com/urfave/cli/v2"
+)
+
+var RebalanceCmd = &cli.Command{
+	Name:  "rebalance",
+	Usage: "Move publishers off of overloaded indexers",
+	Description: `Requests that the running assigner daemon move publishers off of indexers
+whose disk usage is within the configured freeze headroom of their freeze
+threshold. Publishers are moved to indexers that are not overloaded, using
+handoff, and are then unassigned from the overloaded indexers. Content already
+indexed remains on the overloaded indexers.`,
+	Flags:  rebalanceFlags,
+	Action: rebalanceAction,
+}
+
+var rebalanceFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "assigner",
+		Usage:   "Host or host:port of assigner admin server. Defaults to admin address in config.",
+		EnvVars: []string{"ASSIGNER_ADMIN"},
+		Aliases: []string{"a"},
+	},
+	&cli.IntFlag{
+		Name:  "max",
+		Usage: "Maximum number of publishers to move off of each overloaded indexer, 0 for all",
+	},
+}
+
+func rebalanceAction(cctx *cli.Context) error {
+	maxMoves := cctx.Int("max")
+	if maxMoves < 0 {
+		return fmt.Errorf("max must not be negative")
+	}
+
+	adminURL, err := assignerAdminURL(cctx.String("assigner"))
+	if err != nil {
+		return err
+	}
+	u, err := url.JoinPath(adminURL, "rebalance")
+	if err != nil {
+		return err
+	}
+	u += "?max=" + strconv.Itoa(maxMoves)
+
+	req, err := http.NewRequestWithContext(cctx.Context, http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rebalance failed: %s: %s", http.StatusText(resp.StatusCode), strings.TrimSpace(string(body)))
+	}
+
+	var stats core.RebalanceStats
+	if err = json.Unmarshal(body, &stats); err != nil {
+		return fmt.Errorf("cannot decode rebalance response: %w", err)
+	}
+
+	fmt.Println("Rebalance complete")
+	fmt.Println("   Overloaded indexers: ", stats.Overloaded)
+	fmt.Println("   Publishers moved:    ", stats.Moved)
+	fmt.Println("   Publishers remaining:", stats.Remaining)
+	return nil
+}
+
+// assignerAdminURL returns the URL of the assigner admin server, from the
+// given host or from the admin address in the config file.
+func assignerAdminURL(host string) (string, error) {
+	if host == "" {
+		cfg, err := loadConfig("")
+		if err != nil {
+			return "", err
+		}
+		if cfg.Daemon.AdminAddr == "none" {
+			return "", fmt.Errorf("admin server is disabled in config")
+		}
+		netAddr, err := mautil.MultiaddrStringToNetAddr(cfg.Daemon.AdminAddr)
+		if err != nil {
+			return "", fmt.Errorf("bad admin address in config: %w", err)
+		}
+		host = netAddr.String()
+	}
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		host = "http://" + host
+	}
+	return host, nil
+}
@@ -13,6 +13,13 @@ type Assignment struct {
 	// FilterIPs, when true, removes any private, loopback, or unspecified IP
 	// addresses from provider and publisher addresses.
 	FilterIPs bool
+	// FreezeHeadroom is the disk usage percent, below an indexer's freeze
+	// threshold, at which the indexer stops being assigned new publishers.
+	// An indexer that is within this headroom is considered overloaded, and
+	// its publishers can be moved to other indexers by rebalancing. A
+	// negative value disables this, so that indexers are assigned publishers
+	// until they freeze.
+	FreezeHeadroom float64
 	// PoolInterval is how often to poll indexers for status.
 	PollInterval sticfg.Duration
 	// IndexerPool is the set of indexers the pool.
@@ -42,6 +49,11 @@ type Indexer struct {
 	// publisher is assigned to n of the indexers that has the publisher in
 	// PresetPeers, where n is PresetReplication.
 	PresetPeers []string
+	// Weight is the capacity of this indexer relative to other indexers in
+	// the pool. An indexer with weight 2 is assigned twice as many publishers
+	// as an otherwise equal indexer with weight 1. A value <= 0 is the same
+	// as 1.
+	Weight float64
 }
 
 func NewIndexer() Indexer {
@@ -51,6 +63,7 @@ func NewIndexer() Indexer {
 // NewDiscovery returns Discovery with values set to their defaults.
 func NewAssignment() Assignment {
 	return Assignment{
+		FreezeHeadroom:    5.0,
 		PollInterval:      sticfg.Duration(5 * time.Minute),
 		Policy:            NewPolicy(),
 		PubSubTopic:       "/indexer/ingest/mainnet",
@@ -63,6 +76,9 @@ func NewAssignment() Assignment {
 func (c *Assignment) populateUnset() {
 	def := NewAssignment()
 
+	if c.FreezeHeadroom == 0 {
+		c.FreezeHeadroom = def.FreezeHeadroom
+	}
 	if c.PollInterval == 0 {
 		c.PollInterval = def.PollInterval
 	}
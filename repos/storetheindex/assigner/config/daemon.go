@@ -2,6 +2,9 @@ package config
 
 // Daemon stores daemon settings.
 type Daemon struct {
+	// AdminAddr is the admin HTTP host multiaddr. Set to "none" to disable
+	// the admin HTTP server.
+	AdminAddr string
 	// HTTPAddr is the HTTP host multiaddr for receiving direct announce
 	// messages. Set to "none" to disable HTTP hosting.
 	HTTPAddr string
@@ -15,8 +18,9 @@ type Daemon struct {
 // NewDaemon returns Addresses with values set to their defaults.
 func NewDaemon() Daemon {
 	return Daemon{
-		HTTPAddr: "/ip4/0.0.0.0/tcp/3001",
-		P2PAddr:  "/ip4/0.0.0.0/tcp/3003",
+		AdminAddr: "/ip4/127.0.0.1/tcp/3002",
+		HTTPAddr:  "/ip4/0.0.0.0/tcp/3001",
+		P2PAddr:   "/ip4/0.0.0.0/tcp/3003",
 	}
 }
 
@@ -24,6 +28,9 @@ func NewDaemon() Daemon {
 func (c *Daemon) populateUnset() {
 	def := NewDaemon()
 
+	if c.AdminAddr == "" {
+		c.AdminAddr = def.AdminAddr
+	}
 	if c.HTTPAddr == "" {
 		c.HTTPAddr = def.HTTPAddr
 	}
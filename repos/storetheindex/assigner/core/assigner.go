@@ -16,6 +16,7 @@ import (
 	"github.com/ipni/go-libipni/announce"
 	ingestclient "github.com/ipni/go-libipni/ingest/client"
 	adminclient "github.com/ipni/storetheindex/admin/client"
+	"github.com/ipni/storetheindex/admin/model"
 	"github.com/ipni/storetheindex/assigner/config"
 	"github.com/ipni/storetheindex/peerutil"
 	"github.com/libp2p/go-libp2p/core/host"
@@ -30,10 +31,15 @@ const pollFrozenTimeout = 2 * time.Minute
 type Assigner struct {
 	// assigned maps a publisher to a set of indexers.
 	assigned map[peer.ID]*assignment
+	// freezeHeadroom is the disk usage percent below the freeze threshold at
+	// which an indexer is overloaded. Negative if disabled.
+	freezeHeadroom float64
 	// indexerPool is the set of indexers to assign publishers to.
 	indexerPool []indexerInfo
 	// initDone is true when assignments have been read from all indexers.
 	initDone bool
+	// loadMutex protects the load of each indexer in the pool.
+	loadMutex sync.Mutex
 	// mutex protects assigned.
 	mutex   sync.Mutex
 	p2pHost host.Host
@@ -107,7 +113,25 @@ type indexerInfo struct {
 	frozen      bool
 	id          peer.ID
 	initDone    bool
+	load        indexerLoad
 	needHandoff map[peer.ID]struct{}
+	weight      float64
+}
+
+// indexerLoad is the load of an indexer, as reported by its status.
+type indexerLoad struct {
+	// usage is the disk usage percent of the indexer's value store.
+	usage float64
+	// freezeAt is the disk usage percent at which the indexer freezes, or 0
+	// if the indexer does not freeze.
+	freezeAt float64
+	// ingested is the total number of multihashes ingested by the indexer.
+	ingested uint64
+	// ingestRate is the number of multihashes per second ingested by the
+	// indexer between the last two status updates.
+	ingestRate float64
+	// updated is when the load was last updated.
+	updated time.Time
 }
 
 // assignedCount returns the number of publishers assigned to this indexer.
@@ -121,6 +145,26 @@ func (ii *indexerInfo) addAssignedCount(delta int) int {
 	return int(atomic.AddInt32(&ii.assigned, int32(delta)))
 }
 
+// score returns how loaded the indexer is relative to its capacity. Indexers
+// with a lower score are assigned publishers first. The score is the number of
+// assigned publishers divided by the indexer's weight, increased according to
+// the indexer's disk usage relative to its freeze threshold, and according to
+// its ingest rate relative to maxRate. The loadMutex must be held.
+func (ii *indexerInfo) score(maxRate float64) float64 {
+	weight := ii.weight
+	if weight <= 0 {
+		weight = 1
+	}
+	score := float64(ii.assignedCount()+1) / weight
+	if ii.load.freezeAt > 0 && ii.load.usage > 0 {
+		score *= 1 + ii.load.usage/ii.load.freezeAt
+	}
+	if maxRate > 0 {
+		score *= 1 + ii.load.ingestRate/maxRate
+	}
+	return score
+}
+
 // NewAssigner created a new assigner core that handles announce messages and
 // assigns them to the indexers configured in the indexer pool.
 func NewAssigner(ctx context.Context, cfg config.Assignment, p2pHost host.Host) (*Assigner, error) {
@@ -163,15 +207,16 @@ func NewAssigner(ctx context.Context, cfg config.Assignment, p2pHost host.Host)
 	}
 
 	a := &Assigner{
-		assigned:    make(map[peer.ID]*assignment),
-		indexerPool: indexerPool,
-		p2pHost:     p2pHost,
-		policy:      policy,
-		presets:     presets,
-		presetRepl:  presetRepl,
-		receiver:    rcvr,
-		replication: replication,
-		watchDone:   make(chan struct{}),
+		assigned:       make(map[peer.ID]*assignment),
+		freezeHeadroom: cfg.FreezeHeadroom,
+		indexerPool:    indexerPool,
+		p2pHost:        p2pHost,
+		policy:         policy,
+		presets:        presets,
+		presetRepl:     presetRepl,
+		receiver:       rcvr,
+		replication:    replication,
+		watchDone:      make(chan struct{}),
 	}
 
 	// Get the publishers currently assigned to each indexer in the pool. If
@@ -389,6 +434,8 @@ func indexersFromConfig(cfgIndexerPool []config.Indexer) ([]indexerInfo, map[pee
 			return nil, nil, err
 		}
 
+		iInfo.weight = cfgIndexerPool[i].Weight
+
 		indexers = append(indexers, iInfo)
 
 		// Add indexer to each publisher's preset list.
@@ -671,7 +718,7 @@ func (a *Assigner) makeAssignments(ctx context.Context, amsg announce.Announce,
 	if usesPresets {
 		candidates = make([]int, 0, len(preset)-len(asmt.indexers))
 		for _, indexerNum := range preset {
-			if !a.indexerPool[indexerNum].frozen && !asmt.hasIndexer(indexerNum) {
+			if !a.indexerPool[indexerNum].frozen && !asmt.hasIndexer(indexerNum) && !a.overloaded(indexerNum) {
 				candidates = append(candidates, indexerNum)
 			}
 		}
@@ -679,7 +726,7 @@ func (a *Assigner) makeAssignments(ctx context.Context, amsg announce.Announce,
 	} else {
 		candidates = make([]int, 0, len(a.indexerPool)-len(asmt.indexers))
 		for i := range a.indexerPool {
-			if !a.indexerPool[i].frozen && !asmt.hasIndexer(i) {
+			if !a.indexerPool[i].frozen && !asmt.hasIndexer(i) && !a.overloaded(i) {
 				candidates = append(candidates, i)
 			}
 		}
@@ -782,6 +829,7 @@ func (a *Assigner) checkFrozen(ctx context.Context, indexerNum int) (bool, error
 	if err != nil {
 		return false, fmt.Errorf("error requesting status: %w", err)
 	}
+	a.updateLoad(indexerNum, status)
 
 	return status.Frozen, nil
 }
@@ -832,14 +880,14 @@ func (a *Assigner) handoffFrozen(ctx context.Context, indexerNum int) error {
 			// Find an indexer, that has this publisher as a preset, that the
 			// publisher is not already assigned to.
 			for _, i := range preset {
-				if i != indexerNum && !a.indexerPool[i].frozen && !asmt.hasIndexer(i) {
+				if i != indexerNum && !a.indexerPool[i].frozen && !asmt.hasIndexer(i) && !a.overloaded(i) {
 					candidates = append(candidates, i)
 				}
 			}
 		} else {
 			// Find an indexer that the publisher is not already assigned to.
 			for i := range a.indexerPool {
-				if i != indexerNum && !a.indexerPool[i].frozen && !asmt.hasIndexer(i) {
+				if i != indexerNum && !a.indexerPool[i].frozen && !asmt.hasIndexer(i) && !a.overloaded(i) {
 					candidates = append(candidates, i)
 				}
 			}
@@ -891,7 +939,7 @@ func (a *Assigner) handoffFrozen(ctx context.Context, indexerNum int) error {
 
 type indexerSlice struct {
 	indexers []int
-	counts   map[int]int
+	scores   map[int]float64
 	prefs    map[int]bool
 }
 
@@ -904,8 +952,8 @@ func (x indexerSlice) Less(i, j int) bool {
 	nj := x.indexers[j]
 	pi := x.prefs[ni]
 	if pi == x.prefs[nj] {
-		// Both preferred or both not preferred, sort by assigned count.
-		return x.counts[ni] < x.counts[nj]
+		// Both preferred or both not preferred, sort by score.
+		return x.scores[ni] < x.scores[nj]
 	}
 	return pi
 }
@@ -914,23 +962,64 @@ func (x indexerSlice) Less(i, j int) bool {
 func (x indexerSlice) Swap(i, j int) { x.indexers[i], x.indexers[j] = x.indexers[j], x.indexers[i] }
 
 func (a *Assigner) orderCandidates(indexers []int, preferred []int) {
-	// Sort indexer list by preferred, then least-assigned-first.
-	counts := map[int]int{}
+	// Sort indexer list by preferred, then least-loaded-first.
+	scores := make(map[int]float64, len(indexers))
+	a.loadMutex.Lock()
+	var maxRate float64
 	for _, n := range indexers {
-		counts[n] = a.indexerPool[n].assignedCount()
+		maxRate = max(maxRate, a.indexerPool[n].load.ingestRate)
 	}
+	for _, n := range indexers {
+		scores[n] = a.indexerPool[n].score(maxRate)
+	}
+	a.loadMutex.Unlock()
 	prefs := map[int]bool{}
 	for _, p := range preferred {
 		prefs[p] = true
 	}
 	iSlice := indexerSlice{
 		indexers: indexers,
-		counts:   counts,
+		scores:   scores,
 		prefs:    prefs,
 	}
 	sort.Sort(&iSlice)
 }
 
+// updateLoad records the load of an indexer from its status.
+func (a *Assigner) updateLoad(indexerNum int, status *model.Status) {
+	now := time.Now()
+
+	a.loadMutex.Lock()
+	defer a.loadMutex.Unlock()
+
+	load := &a.indexerPool[indexerNum].load
+	// If the ingested count is lower than before, then the indexer restarted
+	// and the previous ingest rate is kept until the next update.
+	if !load.updated.IsZero() && status.Ingested >= load.ingested {
+		if elapsed := now.Sub(load.updated).Seconds(); elapsed > 0 {
+			load.ingestRate = float64(status.Ingested-load.ingested) / elapsed
+		}
+	}
+	load.ingested = status.Ingested
+	load.usage = status.Usage
+	load.freezeAt = status.FreezeAtPercent
+	load.updated = now
+}
+
+// overloaded returns true if the indexer's disk usage is within the freeze
+// headroom of its freeze threshold. An overloaded indexer is not assigned new
+// publishers.
+func (a *Assigner) overloaded(indexerNum int) bool {
+	if a.freezeHeadroom < 0 {
+		return false
+	}
+	a.loadMutex.Lock()
+	defer a.loadMutex.Unlock()
+
+	load := a.indexerPool[indexerNum].load
+	return load.freezeAt > 0 && load.usage >= load.freezeAt-a.freezeHeadroom
+}
+
 func (a *Assigner) assignIndexer(ctx context.Context, indexerNum int, amsg announce.Announce) error {
 	indexer := a.indexerPool[indexerNum]
 
@@ -981,6 +1070,7 @@ func (a *Assigner) getAssignments(ctx context.Context, indexerNum int) (peer.ID,
 	if err != nil {
 		return "", false, nil, nil, fmt.Errorf("cannot get indexer status: %w", err)
 	}
+	a.updateLoad(indexerNum, status)
 	if status.Frozen {
 		return status.ID, true, assigned, nil, nil
 	}
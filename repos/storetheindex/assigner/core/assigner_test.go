@@ -830,6 +830,91 @@ func TestAssignerNoIndexers(t *testing.T) {
 	}
 }
 
+func TestRebalance(t *testing.T) {
+	newAdminHandler := func(id, pubID peer.ID, usage float64, reqs chan<- string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			defer r.Body.Close()
+			if r.Method == "GET" {
+				switch r.URL.String() {
+				case "/ingest/assigned":
+					data, err := json.Marshal([]model.Assigned{{Publisher: pubID}})
+					if err != nil {
+						panic(err.Error())
+					}
+					writeJsonResponse(w, http.StatusOK, data)
+				case "/ingest/preferred":
+					writeJsonResponse(w, http.StatusNoContent, nil)
+				case "/status":
+					data, err := json.Marshal(&model.Status{
+						ID:              id,
+						Usage:           usage,
+						FreezeAtPercent: 90.0,
+					})
+					if err != nil {
+						panic(err.Error())
+					}
+					writeJsonResponse(w, http.StatusOK, data)
+				default:
+					http.Error(w, "", http.StatusNotFound)
+				}
+				return
+			}
+			reqs <- r.Method + " " + r.URL.String()
+			writeJsonResponse(w, http.StatusOK, nil)
+		}
+	}
+
+	reqs1 := make(chan string, 1)
+	reqs2 := make(chan string, 1)
+	fakeIndexer1 := newTestIndexer(newAdminHandler(serverID, peer1ID, 88.0, reqs1))
+	defer fakeIndexer1.close()
+	fakeIndexer2 := newTestIndexer(newAdminHandler(server2ID, peer2ID, 10.0, reqs2))
+	defer fakeIndexer2.close()
+
+	cfgAssignment := config.Assignment{
+		FreezeHeadroom: 5.0,
+		IndexerPool: []config.Indexer{
+			{
+				AdminURL:  fakeIndexer1.adminServer.URL,
+				FindURL:   fakeIndexer1.findServer.URL,
+				IngestURL: fakeIndexer1.ingestServer.URL,
+			},
+			{
+				AdminURL:  fakeIndexer2.adminServer.URL,
+				FindURL:   fakeIndexer2.findServer.URL,
+				IngestURL: fakeIndexer2.ingestServer.URL,
+			},
+		},
+		Policy: config.Policy{
+			Allow: true,
+		},
+		PubSubTopic: "testtopic",
+		Replication: 1,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assigner, err := core.NewAssigner(ctx, cfgAssignment, nil)
+	require.NoError(t, err)
+	defer assigner.Close()
+	require.Equal(t, []int{0}, assigner.Assigned(peer1ID))
+
+	stats, err := assigner.Rebalance(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, core.RebalanceStats{Overloaded: 1, Moved: 1}, stats)
+
+	require.Equal(t, "POST /ingest/handoff/"+peer1IDStr, <-reqs2)
+	require.Equal(t, "PUT /ingest/unassign/"+peer1IDStr, <-reqs1)
+	require.Equal(t, []int{1}, assigner.Assigned(peer1ID))
+	require.Equal(t, []int{0, 2}, assigner.IndexerAssignedCounts())
+
+	// Nothing left to move off of overloaded indexer.
+	stats, err = assigner.Rebalance(ctx, 0)
+	require.NoError(t, err)
+	require.Equal(t, core.RebalanceStats{Overloaded: 1}, stats)
+}
+
 type testIndexer struct {
 	adminServer  *httptest.Server
 	findServer   *httptest.Server
@@ -38,3 +38,54 @@ func TestOrdering(t *testing.T) {
 	assigner.orderCandidates(candidates, []int{2, 0, 1})
 	require.Equal(t, []int{2, 1, 0, 5, 9, 8, 7, 6, 4, 3}, candidates)
 }
+
+func TestOrderingByLoad(t *testing.T) {
+	pool := make([]indexerInfo, 4)
+	for i := range pool {
+		pool[i].assigned = 3
+	}
+
+	assigner := &Assigner{
+		indexerPool: pool,
+	}
+	candidates := []int{0, 1, 2, 3}
+
+	// Indexer with greater capacity weight is assigned first.
+	assigner.indexerPool[2].weight = 2
+	assigner.orderCandidates(candidates, nil)
+	require.Equal(t, 2, candidates[0])
+
+	// Indexer with high disk usage is assigned last.
+	assigner.indexerPool[2].weight = 0
+	assigner.indexerPool[2].load = indexerLoad{usage: 80, freezeAt: 90}
+	assigner.indexerPool[1].load = indexerLoad{usage: 10, freezeAt: 90}
+	assigner.orderCandidates(candidates, nil)
+	require.Equal(t, []int{1, 2}, candidates[2:])
+
+	// Indexer with high ingest rate is assigned after others.
+	assigner.indexerPool[0].load = indexerLoad{ingestRate: 1000}
+	assigner.indexerPool[3].load = indexerLoad{ingestRate: 10}
+	assigner.orderCandidates(candidates, nil)
+	require.Equal(t, []int{3, 1, 2, 0}, candidates)
+
+	// Preferred indexer is still first.
+	assigner.orderCandidates(candidates, []int{2})
+	require.Equal(t, []int{2, 3, 1, 0}, candidates)
+}
+
+func TestOverloaded(t *testing.T) {
+	assigner := &Assigner{
+		freezeHeadroom: 5,
+		indexerPool:    make([]indexerInfo, 3),
+	}
+	assigner.indexerPool[0].load = indexerLoad{usage: 84, freezeAt: 90}
+	assigner.indexerPool[1].load = indexerLoad{usage: 85, freezeAt: 90}
+	assigner.indexerPool[2].load = indexerLoad{usage: 95}
+
+	require.False(t, assigner.overloaded(0))
+	require.True(t, assigner.overloaded(1))
+	require.False(t, assigner.overloaded(2), "indexer that does not freeze is never overloaded")
+
+	assigner.freezeHeadroom = -1
+	require.False(t, assigner.overloaded(1))
+}
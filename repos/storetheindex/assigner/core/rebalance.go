@@ -0,0 +1,191 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	adminclient "github.com/ipni/storetheindex/admin/client"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// RebalanceStats describes the result of rebalancing publishers.
+type RebalanceStats struct {
+	// Overloaded is the number of overloaded indexers.
+	Overloaded int
+	// Moved is the number of publishers moved off of overloaded indexers.
+	Moved int
+	// Remaining is the number of publishers that could not be moved.
+	Remaining int
+}
+
+// Rebalance moves publishers off of overloaded indexers and onto indexers that
+// are not overloaded. An indexer is overloaded when its disk usage is within
+// the configured freeze headroom of its freeze threshold. At most maxMoves
+// publishers are moved off of each overloaded indexer, or all if maxMoves is
+// 0.
+//
+// Each publisher is moved using handoff, the same as when an indexer freezes.
+// The indexer that a publisher is moved to continues indexing from where the
+// overloaded indexer stopped. The publisher is then unassigned from the
+// overloaded indexer, which keeps the content already indexed from the
+// publisher.
+func (a *Assigner) Rebalance(ctx context.Context, maxMoves int) (RebalanceStats, error) {
+	var stats RebalanceStats
+	if len(a.indexerPool) == 0 || a.freezeHeadroom < 0 {
+		return stats, nil
+	}
+
+	// Get current load of all indexers.
+	statusCtx, cancel := context.WithTimeout(ctx, pollFrozenTimeout)
+	for i := range a.indexerPool {
+		if !a.indexerPool[i].initDone || a.indexerPool[i].frozen {
+			continue
+		}
+		if _, err := a.checkFrozen(statusCtx, i); err != nil {
+			log.Errorw("Cannot get indexer status", "err", err, "indexer", i)
+		}
+	}
+	cancel()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for i := range a.indexerPool {
+		if !a.indexerPool[i].initDone || a.indexerPool[i].frozen || len(a.indexerPool[i].needHandoff) != 0 {
+			continue
+		}
+		if !a.overloaded(i) {
+			continue
+		}
+		stats.Overloaded++
+
+		moved, remaining, err := a.moveOff(ctx, i, maxMoves)
+		stats.Moved += moved
+		stats.Remaining += remaining
+		if err != nil {
+			return stats, err
+		}
+	}
+
+	log.Infow("Rebalance complete", "overloaded", stats.Overloaded, "moved", stats.Moved, "remaining", stats.Remaining)
+	return stats, nil
+}
+
+// moveOff moves up to maxMoves publishers off of the overloaded indexer. The
+// assigner mutex must be held. Returns the number of publishers moved and the
+// number that could not be moved.
+func (a *Assigner) moveOff(ctx context.Context, indexerNum, maxMoves int) (int, int, error) {
+	log := log.With("overloadedIndexer", indexerNum)
+
+	// Build sorted list of publishers assigned to the overloaded indexer, so
+	// that publishers are moved in a consistent order.
+	var pubIDs []peer.ID
+	for pubID, asmt := range a.assigned {
+		if asmt.hasIndexer(indexerNum) {
+			pubIDs = append(pubIDs, pubID)
+		}
+	}
+	sort.Slice(pubIDs, func(i, j int) bool {
+		return bytes.Compare([]byte(pubIDs[i]), []byte(pubIDs[j])) < 0
+	})
+	if maxMoves > 0 && len(pubIDs) > maxMoves {
+		pubIDs = pubIDs[:maxMoves]
+	}
+
+	fromURL := a.indexerPool[indexerNum].adminURL
+	fromCl, err := adminclient.New(fromURL)
+	if err != nil {
+		return 0, len(pubIDs), fmt.Errorf("cannot create admin client: %w", err)
+	}
+
+	var moved int
+	candidates := make([]int, 0, len(a.indexerPool))
+
+	for _, pubID := range pubIDs {
+		asmt := a.assigned[pubID]
+
+		required := a.replication
+		preset, usesPresets := a.presets[pubID]
+		if usesPresets {
+			required = a.presetRepl
+		}
+		// If the publisher is already assigned to more indexers than required,
+		// as when unassigning it after a previous move failed, then only
+		// unassign it from the overloaded indexer.
+		if len(asmt.indexers) > required {
+			if a.unassignOverloaded(ctx, fromCl, pubID, asmt, indexerNum) {
+				moved++
+			}
+			continue
+		}
+
+		candidates = candidates[:0]
+		if usesPresets {
+			for _, i := range preset {
+				if i != indexerNum && !a.indexerPool[i].frozen && !asmt.hasIndexer(i) && !a.overloaded(i) {
+					candidates = append(candidates, i)
+				}
+			}
+		} else {
+			for i := range a.indexerPool {
+				if i != indexerNum && !a.indexerPool[i].frozen && !asmt.hasIndexer(i) && !a.overloaded(i) {
+					candidates = append(candidates, i)
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			log.Warnw("No indexers to move publisher to", "publisher", pubID)
+			continue
+		}
+
+		a.orderCandidates(candidates, asmt.preferred)
+
+		moveTo := -1
+		for _, candNum := range candidates {
+			err = a.handoffPublisher(ctx, pubID, indexerNum, candNum)
+			if err != nil {
+				log.Errorw("Could not handoff publisher to indexer", "err", err, "publisher", pubID, "targetIndexer", candNum)
+				if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+					return moved, len(pubIDs) - moved, err
+				}
+				continue // try another candidate
+			}
+			moveTo = candNum
+			break
+		}
+		if moveTo == -1 {
+			continue
+		}
+		asmt.addIndexer(moveTo)
+		a.indexerPool[moveTo].addAssignedCount(1)
+		a.notifyAssignment(pubID, moveTo)
+
+		log.Infow("Handed off publisher from overloaded indexer", "publisher", pubID, "targetIndexer", moveTo)
+
+		// Stop the overloaded indexer from indexing more content from the
+		// publisher. If this fails, the publisher remains assigned to both
+		// indexers, and unassigning it from the overloaded indexer is retried
+		// on the next rebalance.
+		if a.unassignOverloaded(ctx, fromCl, pubID, asmt, indexerNum) {
+			moved++
+		}
+	}
+
+	return moved, len(pubIDs) - moved, nil
+}
+
+// unassignOverloaded unassigns a publisher from an overloaded indexer. Returns
+// true if the publisher was unassigned.
+func (a *Assigner) unassignOverloaded(ctx context.Context, cl *adminclient.Client, pubID peer.ID, asmt *assignment, indexerNum int) bool {
+	if err := cl.Unassign(ctx, pubID); err != nil {
+		log.Errorw("Could not unassign publisher from overloaded indexer", "err", err, "publisher", pubID, "overloadedIndexer", indexerNum)
+		return false
+	}
+	asmt.removeIndexer(indexerNum)
+	a.indexerPool[indexerNum].addAssignedCount(-1)
+	log.Infow("Unassigned publisher from overloaded indexer", "publisher", pubID, "overloadedIndexer", indexerNum)
+	return true
+}
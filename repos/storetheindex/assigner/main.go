@@ -40,6 +40,7 @@ func main() {
 		Commands: []*cli.Command{
 			command.DaemonCmd,
 			command.InitCmd,
+			command.RebalanceCmd,
 		},
 	}
 
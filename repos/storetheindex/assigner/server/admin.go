@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/ipni/storetheindex/assigner/core"
+)
+
+// NewAdmin creates a server that handles administrative requests for the
+// assigner. This server should only be reachable by administrators.
+func NewAdmin(listen string, assigner *core.Assigner, options ...Option) (*Server, error) {
+	opts, err := getOpts(options)
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	// There is no write timeout, since rebalancing can take longer than the
+	// usual write timeout.
+	server := &http.Server{
+		Handler:     mux,
+		ReadTimeout: opts.readTimeout,
+	}
+	s := &Server{
+		assigner: assigner,
+		server:   server,
+		listener: l,
+	}
+
+	s.healthMsg = "assigner admin ready"
+	if opts.version != "" {
+		s.healthMsg += " " + opts.version
+	}
+
+	mux.HandleFunc("/rebalance", s.rebalance)
+	mux.HandleFunc("/health", s.health)
+
+	return s, nil
+}
+
+// POST /rebalance?max=<n>
+func (s *Server) rebalance(w http.ResponseWriter, r *http.Request) {
+	if !methodOK(w, r, http.MethodPost) {
+		return
+	}
+
+	var maxMoves int
+	if maxStr := r.URL.Query().Get("max"); maxStr != "" {
+		var err error
+		maxMoves, err = strconv.Atoi(maxStr)
+		if err != nil || maxMoves < 0 {
+			http.Error(w, "max must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+	}
+
+	stats, err := s.assigner.Rebalance(r.Context(), maxMoves)
+	if err != nil {
+		log.Errorw("Rebalance incomplete", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		log.Errorw("Cannot encode rebalance stats", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(data); err != nil {
+		log.Errorw("Cannot write rebalance response", "err", err)
+	}
+}
@@ -2,6 +2,7 @@ package server_test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -191,3 +192,33 @@ func assign(ctx context.Context, indexer, peerIDStr string) error {
 	}
 	return nil
 }
+
+func TestRebalanceEndpoint(t *testing.T) {
+	cfgAssignment := config.Assignment{
+		Policy: config.Policy{
+			Allow: true,
+		},
+		PubSubTopic: "testtopic",
+	}
+	assigner, err := core.NewAssigner(context.Background(), cfgAssignment, nil)
+	require.NoError(t, err)
+	defer assigner.Close()
+
+	s, err := server.NewAdmin("127.0.0.1:0", assigner)
+	require.NoError(t, err)
+	go s.Start()
+	defer s.Close()
+
+	resp, err := http.Post(s.URL()+"/rebalance?max=-1", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Post(s.URL()+"/rebalance?max=10", "", nil)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var stats core.RebalanceStats
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&stats))
+	require.Zero(t, stats)
+}
@@ -2,11 +2,11 @@ package carstore
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 
 	"github.com/ipfs/go-cid"
 	car "github.com/ipld/go-car/v2"
@@ -54,24 +54,6 @@ func (e EntryBlock) EntryChunk() (*schema.EntryChunk, error) {
 	return chunk, nil
 }
 
-type gzipReadCloser struct {
-	r   io.ReadCloser
-	gzr *gzip.Reader
-}
-
-func (g gzipReadCloser) Read(p []byte) (n int, err error) {
-	return g.gzr.Read(p)
-}
-
-func (g gzipReadCloser) Close() error {
-	err := g.gzr.Close()
-	if err != nil {
-		g.r.Close()
-		return err
-	}
-	return g.r.Close()
-}
-
 // NewReader creates a CarReader that reads CAR files from the given filestore
 // and returns advertisements and entries.
 func NewReader(fileStore filestore.Interface, options ...Option) (*CarReader, error) {
@@ -86,11 +68,7 @@ func NewReader(fileStore filestore.Interface, options ...Option) (*CarReader, er
 }
 
 func carFilePath(adCid cid.Cid, compAlg string) string {
-	carPath := adCid.String() + CarFileSuffix
-	if compAlg == Gzip {
-		carPath += GzipFileSuffix
-	}
-	return carPath
+	return adCid.String() + CarSuffix(compAlg)
 }
 
 // CarPath returns the name of the CAR file being read.
@@ -103,28 +81,56 @@ func (cr CarReader) Compression() string {
 	return cr.compAlg
 }
 
+// probeOrder returns the compression methods to look for CAR files with,
+// starting with the reader's configured compression.
+func (cr CarReader) probeOrder() []string {
+	algs := make([]string, 0, len(compressions))
+	algs = append(algs, cr.compAlg)
+	for _, compAlg := range compressions {
+		if compAlg != cr.compAlg {
+			algs = append(algs, compAlg)
+		}
+	}
+	return algs
+}
+
+// FindCar returns information about the advertisement's CAR file. The CAR file
+// with the configured compression is looked for first, and then CAR files
+// with any other compression, so that a mirror that holds CAR files with
+// different compression is fully readable. Returns fs.ErrNotExist if no CAR
+// file is found.
+func (cr CarReader) FindCar(ctx context.Context, adCid cid.Cid) (*filestore.File, error) {
+	for _, compAlg := range cr.probeOrder() {
+		file, err := cr.fileStore.Head(ctx, carFilePath(adCid, compAlg))
+		if !errors.Is(err, fs.ErrNotExist) {
+			return file, err
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
 // Read reads an advertisement CAR file, identitfied by the advertisement CID
 // and returns the advertisement data and a channel to read blocks of multihash
-// entries. Returns fs.ErrNotExist if file is not found.
+// entries. The CAR file is found as with FindCar, and is decompressed
+// according to its suffix. Returns fs.ErrNotExist if file is not found.
 func (cr CarReader) Read(ctx context.Context, adCid cid.Cid, skipEntries bool) (*AdBlock, error) {
-	carPath := cr.CarPath(adCid)
-	_, r, err := cr.fileStore.Get(ctx, carPath)
+	var compAlg string
+	var r io.ReadCloser
+	err := fs.ErrNotExist
+	for _, compAlg = range cr.probeOrder() {
+		_, r, err = cr.fileStore.Get(ctx, carFilePath(adCid, compAlg))
+		if !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var rc io.ReadCloser
-	if cr.compAlg == Gzip {
-		gzr, err := gzip.NewReader(r)
-		if err != nil {
-			return nil, err
-		}
-		rc = &gzipReadCloser{
-			r:   r,
-			gzr: gzr,
-		}
-	} else {
-		rc = r
+	rc, err := NewDecompressReader(r, compAlg)
+	if err != nil {
+		r.Close()
+		return nil, err
 	}
 
 	cbr, err := car.NewBlockReader(rc)
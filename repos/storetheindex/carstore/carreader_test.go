@@ -2,9 +2,11 @@ package carstore_test
 
 import (
 	"context"
+	"io/fs"
 	"testing"
 
 	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-test/random"
 	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
 	"github.com/ipni/storetheindex/carstore"
 	"github.com/ipni/storetheindex/filestore"
@@ -97,3 +99,73 @@ func TestRead(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, adCid, headCid)
 }
+
+func TestReadCompression(t *testing.T) {
+	for _, compAlg := range []string{carstore.Gzip, carstore.Zstd, ""} {
+		t.Run("compress-"+compAlg, func(t *testing.T) {
+			dstore := datastore.NewMapDatastore()
+			fileStore, err := filestore.NewLocal(t.TempDir())
+			require.NoError(t, err)
+			carw, err := carstore.NewWriter(dstore, fileStore, carstore.WithCompress(compAlg))
+			require.NoError(t, err)
+
+			adLink, _, _, _, _ := storeRandomIndexAndAd(t, 3, []byte("car-test-metadata"), nil, dstore)
+			adCid := adLink.(cidlink.Link).Cid
+
+			ctx := context.Background()
+			carInfo, err := carw.Write(ctx, adCid, false, false)
+			require.NoError(t, err)
+			require.Equal(t, adCid.String()+carstore.CarSuffix(compAlg), carInfo.Path)
+
+			detected, ok := carstore.FileCompression(carInfo.Path)
+			require.True(t, ok)
+			require.Equal(t, compAlg, detected)
+
+			carr, err := carstore.NewReader(fileStore, carstore.WithCompress(compAlg))
+			require.NoError(t, err)
+			adBlock, err := carr.Read(ctx, adCid, false)
+			require.NoError(t, err)
+			var count int
+			for entBlock := range adBlock.Entries {
+				require.NoError(t, entBlock.Err)
+				count++
+			}
+			require.Equal(t, 3, count)
+		})
+	}
+}
+
+func TestReadMixedCompression(t *testing.T) {
+	dstore := datastore.NewMapDatastore()
+	fileStore, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	carw, err := carstore.NewWriter(dstore, fileStore, carstore.WithCompress(carstore.Gzip))
+	require.NoError(t, err)
+
+	adLink, _, _, _, _ := storeRandomIndexAndAd(t, 3, []byte("car-test-metadata"), nil, dstore)
+	adCid := adLink.(cidlink.Link).Cid
+
+	ctx := context.Background()
+	carInfo, err := carw.Write(ctx, adCid, false, false)
+	require.NoError(t, err)
+
+	// A reader configured for another compression still finds and reads the
+	// CAR file, as in a mirror that is partly recompressed.
+	carr, err := carstore.NewReader(fileStore, carstore.WithCompress(carstore.Zstd))
+	require.NoError(t, err)
+	file, err := carr.FindCar(ctx, adCid)
+	require.NoError(t, err)
+	require.Equal(t, carInfo.Path, file.Path)
+
+	adBlock, err := carr.Read(ctx, adCid, false)
+	require.NoError(t, err)
+	var count int
+	for entBlock := range adBlock.Entries {
+		require.NoError(t, entBlock.Err)
+		count++
+	}
+	require.Equal(t, 3, count)
+
+	_, err = carr.FindCar(ctx, random.Cids(1)[0])
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
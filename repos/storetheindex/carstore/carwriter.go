@@ -3,7 +3,6 @@ package carstore
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
@@ -212,39 +211,42 @@ func (cw *CarWriter) write(ctx context.Context, adCid cid.Cid, ad schema.Adverti
 		return nil, err
 	}
 
-	if cw.compAlg == Gzip {
-		gzTmpName := carTmpName + GzipFileSuffix
-		gzFile, err := os.Create(gzTmpName)
+	if cw.compAlg != "" {
+		compTmpName := carTmpName + strings.TrimPrefix(CarSuffix(cw.compAlg), CarFileSuffix)
+		compFile, err := os.Create(compTmpName)
 		if err != nil {
-			return nil, fmt.Errorf("cannot create gzip file: %w", err)
+			return nil, fmt.Errorf("cannot create %s file: %w", cw.compAlg, err)
 		}
-		defer os.Remove(gzTmpName)
-		defer gzFile.Close()
+		defer os.Remove(compTmpName)
+		defer compFile.Close()
 
-		wbuf := bufio.NewWriter(gzFile)
-		gzw := gzip.NewWriter(wbuf)
-		_, err = io.Copy(gzw, carFile)
+		wbuf := bufio.NewWriter(compFile)
+		compw, err := NewCompressWriter(wbuf, cw.compAlg)
 		if err != nil {
-			return nil, fmt.Errorf("cannot write gzip file: %w", err)
+			return nil, err
+		}
+		_, err = io.Copy(compw, carFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write compressed file: %w", err)
 		}
 		if err = carFile.Close(); err != nil {
 			// Since data in car file has already been written, an error from close
 			// is not critical, so only log warning.
 			log.Warnw("Error closing temporary car file", "err", err, "name", carFile.Name())
 		}
-		// Close gzip writer; finish writing gzip data to buffer.
-		if err = gzw.Close(); err != nil {
-			return nil, fmt.Errorf("cannot close gzip writer: %w", err)
+		// Close compression writer; finish writing compressed data to buffer.
+		if err = compw.Close(); err != nil {
+			return nil, fmt.Errorf("cannot close compression writer: %w", err)
 		}
 		// Flush buffered data to file.
 		if err = wbuf.Flush(); err != nil {
-			return nil, fmt.Errorf("cannot write gzip file: %w", err)
+			return nil, fmt.Errorf("cannot write compressed file: %w", err)
 		}
-		_, err = gzFile.Seek(0, io.SeekStart)
+		_, err = compFile.Seek(0, io.SeekStart)
 		if err != nil {
 			return nil, err
 		}
-		carFile = gzFile
+		carFile = compFile
 	}
 
 	carInfo, err := cw.fileStore.Put(ctx, carPath, carFile)
@@ -0,0 +1,135 @@
+package carstore
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ParseCompression returns the name of the compression method identified by
+// alg. An empty string is returned for no compression.
+func ParseCompression(alg string) (string, error) {
+	switch alg {
+	case Gzip, "gz":
+		return Gzip, nil
+	case Zstd, "zst":
+		return Zstd, nil
+	case "", "none", "nil", "null":
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported compression: %s", alg)
+}
+
+// CarSuffix returns the suffix of CAR files compressed using the named
+// compression method.
+func CarSuffix(compAlg string) string {
+	switch compAlg {
+	case Gzip:
+		return CarFileSuffix + GzipFileSuffix
+	case Zstd:
+		return CarFileSuffix + ZstdFileSuffix
+	}
+	return CarFileSuffix
+}
+
+// compressions are the names of all supported compression methods, with the
+// empty string, for no compression, last since its CAR suffix is a suffix of
+// the others.
+var compressions = []string{Gzip, Zstd, ""}
+
+// FileCompression returns the name of the compression method used for a CAR
+// file, as detected from the file name suffix. An empty string is returned if
+// the file is not compressed. False is returned if the file is not a CAR file.
+func FileCompression(name string) (string, bool) {
+	for _, compAlg := range compressions {
+		if strings.HasSuffix(name, CarSuffix(compAlg)) {
+			return compAlg, true
+		}
+	}
+	return "", false
+}
+
+type gzipReadCloser struct {
+	r   io.ReadCloser
+	gzr *gzip.Reader
+}
+
+func (g gzipReadCloser) Read(p []byte) (n int, err error) {
+	return g.gzr.Read(p)
+}
+
+func (g gzipReadCloser) Close() error {
+	err := g.gzr.Close()
+	if err != nil {
+		g.r.Close()
+		return err
+	}
+	return g.r.Close()
+}
+
+type zstdReadCloser struct {
+	r    io.ReadCloser
+	zstr *zstd.Decoder
+}
+
+func (z zstdReadCloser) Read(p []byte) (n int, err error) {
+	return z.zstr.Read(p)
+}
+
+func (z zstdReadCloser) Close() error {
+	z.zstr.Close()
+	return z.r.Close()
+}
+
+// NewDecompressReader returns a ReadCloser that reads data from r that was
+// compressed using the named compression method. Closing the returned
+// ReadCloser closes r.
+func NewDecompressReader(r io.ReadCloser, compAlg string) (io.ReadCloser, error) {
+	switch compAlg {
+	case Gzip:
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return &gzipReadCloser{
+			r:   r,
+			gzr: gzr,
+		}, nil
+	case Zstd:
+		zstr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return &zstdReadCloser{
+			r:    r,
+			zstr: zstr,
+		}, nil
+	case "":
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", compAlg)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// NewCompressWriter returns a WriteCloser that compresses data, using the
+// named compression method, and writes it to w. The returned WriteCloser must
+// be closed to finish writing compressed data. Closing it does not close w.
+func NewCompressWriter(w io.Writer, compAlg string) (io.WriteCloser, error) {
+	switch compAlg {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	case "":
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unsupported compression: %s", compAlg)
+}
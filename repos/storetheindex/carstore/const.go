@@ -5,9 +5,11 @@ const (
 	CarFileSuffix  = ".car"
 	GzipFileSuffix = ".gz"
 	HeadFileSuffix = ".head"
+	ZstdFileSuffix = ".zst"
 )
 
 // Compression methods.
 const (
 	Gzip = "gzip"
+	Zstd = "zstd"
 )
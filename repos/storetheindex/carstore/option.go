@@ -26,14 +26,8 @@ func getOpts(opts []Option) (config, error) {
 // WithCompress configures file compression.
 func WithCompress(alg string) Option {
 	return func(c *config) error {
-		switch alg {
-		case Gzip, "gz":
-			c.compAlg = Gzip
-		case "", "none", "nil", "null":
-			c.compAlg = ""
-		default:
-			return fmt.Errorf("unsupported compression: %s", alg)
-		}
-		return nil
+		var err error
+		c.compAlg, err = ParseCompression(alg)
+		return err
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipni/storetheindex/admin/client"
@@ -40,6 +41,8 @@ var syncCmd = &cli.Command{
 	Action: syncAction,
 	Subcommands: []*cli.Command{
 		listPendinSyncsCmd,
+		syncProgressCmd,
+		cancelSyncCmd,
 	},
 }
 
@@ -49,6 +52,28 @@ var listPendinSyncsCmd = &cli.Command{
 	Action: listPendingSyncsAction,
 }
 
+var syncProgressCmd = &cli.Command{
+	Name:   "progress",
+	Usage:  "Show progress of syncs that are in progress.",
+	Flags:  []cli.Flag{indexerHostFlag},
+	Action: syncProgressAction,
+}
+
+var cancelSyncCmd = &cli.Command{
+	Name:  "cancel",
+	Usage: "Cancel a sync that is in progress.",
+	Flags: []cli.Flag{
+		indexerHostFlag,
+		&cli.StringFlag{
+			Name:     "pubid",
+			Usage:    "Publisher peer ID",
+			Aliases:  []string{"p"},
+			Required: true,
+		},
+	},
+	Action: cancelSyncAction,
+}
+
 var adminSyncFlags = []cli.Flag{
 	indexerHostFlag,
 	&cli.StringFlag{
@@ -238,6 +263,54 @@ func listPendingSyncsAction(cctx *cli.Context) error {
 	return nil
 }
 
+func syncProgressAction(cctx *cli.Context) error {
+	cl, err := client.New(cliIndexer(cctx, "admin"))
+	if err != nil {
+		return err
+	}
+	syncs, err := cl.ListSyncs(cctx.Context)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Syncs in progress:", len(syncs))
+	for _, s := range syncs {
+		fmt.Println("Publisher:", s.Publisher)
+		fmt.Println("    Target:        ", s.Target)
+		fmt.Println("    Elapsed:       ", s.Elapsed.Round(time.Second))
+		if s.Fetching {
+			fmt.Println("    Ads fetched:   ", s.AdsFetched)
+			fmt.Println("    Segment:       ", s.Segment)
+		}
+		if s.Processing {
+			fmt.Printf("    Ads processed:  %d of %d\n", s.AdsProcessed, s.AdsToProcess)
+			fmt.Println("    Current ad:    ", s.CurrentAd)
+			fmt.Println("    Entries chunks:", s.EntriesChunks)
+		}
+		if s.Canceled {
+			fmt.Println("    Canceled")
+		}
+	}
+	return nil
+}
+
+func cancelSyncAction(cctx *cli.Context) error {
+	cl, err := client.New(cliIndexer(cctx, "admin"))
+	if err != nil {
+		return err
+	}
+	peerID, err := peer.Decode(cctx.String("pubid"))
+	if err != nil {
+		return err
+	}
+	err = cl.CancelSync(cctx.Context, peerID)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Canceled sync with publisher", peerID)
+	return nil
+}
+
 func syncAction(cctx *cli.Context) error {
 	cl, err := client.New(cliIndexer(cctx, "admin"))
 	if err != nil {
@@ -0,0 +1,352 @@
+package command
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ipni/storetheindex/admin/client"
+	"github.com/ipni/storetheindex/config"
+	"github.com/ipni/storetheindex/fsutil"
+	"github.com/ipni/storetheindex/internal/backup"
+	"github.com/urfave/cli/v2"
+)
+
+var BackupCmd = &cli.Command{
+	Name:  "backup",
+	Usage: "Write a backup archive of a running indexer",
+	Description: `Writes a gzip-compressed archive of the indexer datastore and value store,
+created by the running indexer daemon using its admin API. The datastore is
+read from a snapshot taken before the value store snapshot, so the archive is
+consistent without stopping the indexer. Advertisements processed after the
+snapshot is taken are processed again after restoring.
+
+A pebble value store is written as the files of a pebble checkpoint, which is
+taken in a directory next to the value store so that its files are hard-linked
+instead of copied. A memory value store is written as an export of all
+multihashes and their values.
+
+A dhstore value store is not included in the archive. The config file, with or
+without the indexer's private key, and the temporary datastore are included
+only if requested.
+
+The archive is verified as it is written, and is only moved to the output path
+if complete.`,
+	Flags:  backupFlags,
+	Action: backupAction,
+}
+
+var RestoreCmd = &cli.Command{
+	Name:  "restore",
+	Usage: "Restore a backup archive into a new indexer repo",
+	Description: `Restores the datastore and value store from a backup archive into the repo at
+STORETHEINDEX_PATH. The datastore, temporary datastore and value store
+directories of the repo must be empty or not exist.
+
+With --config, the config file in the archive is written to the repo, which
+must not already have a config file. Unless --identity is also given, a new
+identity is created for the restored indexer. Without --config, the repo must
+already be initialized.
+
+A pebble checkpoint in the archive is restored by writing its files into the
+value store directory, and can only be restored into a pebble value store.
+Exported multihashes and values are restored into whatever value store type the
+config specifies.`,
+	Flags:  restoreFlags,
+	Action: restoreAction,
+}
+
+var backupFlags = []cli.Flag{
+	indexerHostFlag,
+	&cli.StringFlag{
+		Name:    "output",
+		Usage:   "Path of archive file to write. Defaults to a name containing the current time.",
+		Aliases: []string{"o"},
+	},
+	&cli.BoolFlag{
+		Name:  "config",
+		Usage: "Include the config file in the archive",
+	},
+	&cli.BoolFlag{
+		Name:  "identity",
+		Usage: "Include the private key in the archived config file",
+	},
+	&cli.BoolFlag{
+		Name:  "tmp",
+		Usage: "Include the temporary datastore in the archive",
+	},
+}
+
+var restoreFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "input",
+		Usage:    "Path of archive file to restore",
+		Aliases:  []string{"i"},
+		Required: true,
+	},
+	&cli.BoolFlag{
+		Name:  "config",
+		Usage: "Restore the config file from the archive",
+	},
+	&cli.BoolFlag{
+		Name:  "identity",
+		Usage: "Restore the identity in the archived config, instead of creating a new identity",
+	},
+}
+
+func backupAction(cctx *cli.Context) error {
+	if cctx.Bool("identity") && !cctx.Bool("config") {
+		return errors.New("identity can only be included with config")
+	}
+
+	outPath := cctx.String("output")
+	if outPath == "" {
+		outPath = fmt.Sprintf("storetheindex-%s.backup.gz", time.Now().UTC().Format("20060102T150405Z"))
+	}
+	if fsutil.FileExists(outPath) {
+		return fmt.Errorf("output file %s already exists", outPath)
+	}
+
+	cl, err := client.New(cliIndexer(cctx, "admin"))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := outPath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+	defer file.Close()
+
+	fmt.Println("Writing backup to", outPath)
+	start := time.Now()
+
+	bw := bufio.NewWriter(file)
+	gzw := gzip.NewWriter(bw)
+
+	// Verify the archive while it is written.
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := cl.Backup(cctx.Context, io.MultiWriter(gzw, pw), cctx.Bool("config"), cctx.Bool("identity"), cctx.Bool("tmp"))
+		pw.CloseWithError(err)
+		errCh <- err
+	}()
+
+	var stats backup.Stats
+	var manifest backup.Manifest
+	ar, err := backup.NewReader(pr)
+	if err == nil {
+		manifest = ar.Manifest()
+		stats, err = ar.Restore(cctx.Context, backup.Target{})
+	}
+	// Unblock the writer if verification stopped early.
+	pr.CloseWithError(err)
+	if bkErr := <-errCh; bkErr != nil && !errors.Is(bkErr, io.ErrClosedPipe) {
+		return fmt.Errorf("cannot get backup from indexer: %w", bkErr)
+	}
+	if err != nil {
+		return fmt.Errorf("backup archive from indexer is not valid: %w", err)
+	}
+
+	if err = gzw.Close(); err != nil {
+		return err
+	}
+	if err = bw.Flush(); err != nil {
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, outPath); err != nil {
+		return err
+	}
+
+	fmt.Println("Backup complete")
+	printBackupStats(manifest, stats)
+	fmt.Println("   Elapsed:           ", time.Since(start).String())
+	return nil
+}
+
+func restoreAction(cctx *cli.Context) error {
+	restoreConfig := cctx.Bool("config")
+	if cctx.Bool("identity") && !restoreConfig {
+		return errors.New("identity can only be restored with config")
+	}
+
+	file, err := os.Open(cctx.String("input"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	gzr, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("cannot read compressed archive: %w", err)
+	}
+	defer gzr.Close()
+
+	ar, err := backup.NewReader(gzr)
+	if err != nil {
+		return err
+	}
+	manifest := ar.Manifest()
+
+	configRoot, err := config.PathRoot()
+	if err != nil {
+		return err
+	}
+	if err = fsutil.DirWritable(configRoot); err != nil {
+		return err
+	}
+	configFile, err := config.Path(configRoot, "")
+	if err != nil {
+		return err
+	}
+
+	if restoreConfig {
+		if !manifest.Config {
+			return errors.New("archive does not contain config")
+		}
+		if fsutil.FileExists(configFile) {
+			return config.ErrInitialized
+		}
+		var cfg config.Config
+		if err = json.Unmarshal(ar.Config(), &cfg); err != nil {
+			return fmt.Errorf("cannot decode config from archive: %w", err)
+		}
+		if cctx.Bool("identity") {
+			if !manifest.Identity {
+				return errors.New("archive does not contain identity")
+			}
+		} else {
+			cfg.Identity, err = config.CreateIdentity(os.Stderr)
+			if err != nil {
+				return err
+			}
+		}
+		if err = cfg.Save(configFile); err != nil {
+			return err
+		}
+		fmt.Println("Restored config to", configFile)
+	}
+
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return err
+	}
+	if err = setLoggingConfig(cfg.Logging); err != nil {
+		return err
+	}
+
+	checkDirs := []string{cfg.Datastore.Dir, cfg.Datastore.TmpDir}
+	if cfg.Indexer.ValueStoreType == vstorePebble {
+		checkDirs = append(checkDirs, cfg.Indexer.ValueStoreDir)
+	}
+	for _, dir := range checkDirs {
+		dirPath, err := config.Path(configRoot, dir)
+		if err != nil {
+			return err
+		}
+		if err = checkDirEmpty(dirPath); err != nil {
+			return err
+		}
+	}
+
+	ctx := cctx.Context
+	var target backup.Target
+
+	dstore, _, err := createDatastore(ctx, cfg.Datastore.Dir, cfg.Datastore.Type, false)
+	if err != nil {
+		return err
+	}
+	defer dstore.Close()
+	target.Datastore = dstore
+
+	if manifest.TmpDatastore {
+		dsTmp, _, err := createDatastore(ctx, cfg.Datastore.TmpDir, cfg.Datastore.TmpType, false)
+		if err != nil {
+			return err
+		}
+		defer dsTmp.Close()
+		target.TmpDatastore = dsTmp
+	}
+
+	if manifest.Checkpoint {
+		if cfg.Indexer.ValueStoreType == vstorePebble {
+			target.ValueStoreDir, err = config.Path(configRoot, cfg.Indexer.ValueStoreDir)
+			if err != nil {
+				return err
+			}
+		} else {
+			fmt.Println("Not restoring pebble checkpoint into", cfg.Indexer.ValueStoreType, "value store")
+		}
+	} else if manifest.ValueStore {
+		switch cfg.Indexer.ValueStoreType {
+		case vstoreDHStore, vstoreMemory:
+			fmt.Println("Not restoring value store into", cfg.Indexer.ValueStoreType, "value store")
+		default:
+			valueStore, _, _, err := createValueStore(ctx, cfg.Indexer)
+			if err != nil {
+				return err
+			}
+			defer valueStore.Close()
+			target.Indexer = valueStore
+		}
+	}
+
+	fmt.Println("Restoring backup created", manifest.Created.Format(time.RFC3339), "by indexer", manifest.PeerID)
+	start := time.Now()
+
+	stats, err := ar.Restore(ctx, target)
+	if err != nil {
+		return fmt.Errorf("cannot restore backup: %w", err)
+	}
+
+	fmt.Println("Restore complete")
+	printBackupStats(manifest, stats)
+	fmt.Println("   Elapsed:           ", time.Since(start).String())
+	return nil
+}
+
+func printBackupStats(manifest backup.Manifest, stats backup.Stats) {
+	fmt.Println("   Archive version:   ", manifest.Version)
+	fmt.Println("   Config:            ", manifest.Config)
+	fmt.Println("   Identity:          ", manifest.Identity)
+	fmt.Println("   Datastore keys:    ", stats.DatastoreKeys)
+	if manifest.TmpDatastore {
+		fmt.Println("   Tmp datastore keys:", stats.TmpDatastoreKeys)
+	}
+	if manifest.Checkpoint {
+		fmt.Println("   Checkpoint files:  ", stats.CheckpointFiles)
+		fmt.Println("   Checkpoint bytes:  ", stats.CheckpointBytes)
+	} else if manifest.ValueStore {
+		fmt.Println("   Multihashes:       ", stats.Multihashes)
+	} else {
+		fmt.Println("   Value store:        not included,", manifest.ValueStoreType)
+	}
+}
+
+// checkDirEmpty returns an error if the directory exists and is not empty.
+func checkDirEmpty(dirPath string) error {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	if len(entries) != 0 {
+		return fmt.Errorf("cannot restore into non-empty directory %s", dirPath)
+	}
+	return nil
+}
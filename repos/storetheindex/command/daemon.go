@@ -20,18 +20,24 @@ import (
 	"github.com/ipni/go-indexer-core/engine"
 	"github.com/ipni/go-indexer-core/store/dhstore"
 	"github.com/ipni/go-indexer-core/store/memory"
-	"github.com/ipni/go-indexer-core/store/pebble"
 	"github.com/ipni/go-libipni/mautil"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/ipni/storetheindex/carstore"
 	"github.com/ipni/storetheindex/config"
+	"github.com/ipni/storetheindex/filestore"
 	"github.com/ipni/storetheindex/fsutil"
+	"github.com/ipni/storetheindex/gc/reaper"
 	"github.com/ipni/storetheindex/internal/ingest"
 	"github.com/ipni/storetheindex/internal/registry"
+	"github.com/ipni/storetheindex/internal/retention"
+	"github.com/ipni/storetheindex/internal/valuestore/pebble"
 	httpadmin "github.com/ipni/storetheindex/server/admin"
 	httpfind "github.com/ipni/storetheindex/server/find"
 	httpingest "github.com/ipni/storetheindex/server/ingest"
 	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/urfave/cli/v2"
 )
@@ -161,40 +167,10 @@ func daemonAction(cctx *cli.Context) error {
 	// Create indexer core
 	indexerCore := engine.New(valueStore, engine.WithCache(resultCache))
 
-	// Create registry
-	reg, err := registry.New(cctx.Context, cfg.Discovery, dstore,
-		registry.WithFreezer(freezeDirs, cfg.Indexer.FreezeAtPercent))
-	if err != nil {
-		return fmt.Errorf("cannot create provider registry: %s", err)
-	}
-	defer reg.Close()
-
-	// Create find HTTP server
-	var findSvr *httpfind.Server
-	findAddr := cfg.Addresses.Finder
-	if cctx.String("listen-finder") != "" {
-		findAddr = cctx.String("listen-finder")
-	}
-	if findAddr != "" && findAddr != "none" {
-		findNetAddr, err := mautil.MultiaddrStringToNetAddr(findAddr)
-		if err != nil {
-			return fmt.Errorf("bad find address %s: %s", findAddr, err)
-		}
-		findSvr, err = httpfind.New(findNetAddr.String(), indexerCore, reg,
-			httpfind.WithReadTimeout(time.Duration(cfg.Finder.ApiReadTimeout)),
-			httpfind.WithWriteTimeout(time.Duration(cfg.Finder.ApiWriteTimeout)),
-			httpfind.WithMaxConnections(cfg.Finder.MaxConnections),
-			httpfind.WithHomepage(cfg.Finder.Webpage),
-			httpfind.WithVersion(cctx.App.Version),
-		)
-		if err != nil {
-			return err
-		}
-	}
-
 	var (
 		ingester       *ingest.Ingester
 		p2pHost        host.Host
+		p2pmaddr       multiaddr.Multiaddr
 		peeringService *peering.PeeringService
 	)
 
@@ -203,13 +179,13 @@ func daemonAction(cctx *cli.Context) error {
 		return err
 	}
 
-	// Create libp2p host and servers.
+	// Create libp2p host.
 	p2pAddr := cfg.Addresses.P2PAddr
 	if cctx.String("listen-p2p") != "" {
 		p2pAddr = cctx.String("listen-p2p")
 	}
 	if p2pAddr != "" && p2pAddr != "none" {
-		p2pmaddr, err := multiaddr.NewMultiaddr(p2pAddr)
+		p2pmaddr, err = multiaddr.NewMultiaddr(p2pAddr)
 		if err != nil {
 			return fmt.Errorf("bad p2p address %s: %s", p2pAddr, err)
 		}
@@ -229,14 +205,69 @@ func daemonAction(cctx *cli.Context) error {
 			return err
 		}
 		defer p2pHost.Close()
+	}
+
+	// Create registry
+	reg, err := registry.New(cctx.Context, cfg.Discovery, dstore,
+		registry.WithFreezer(freezeDirs, cfg.Indexer.FreezeAtPercent),
+		registry.WithLibp2pProbe(p2pHost != nil))
+	if err != nil {
+		return fmt.Errorf("cannot create provider registry: %s", err)
+	}
+	defer reg.Close()
+
+	// Create find HTTP server
+	var findSvr *httpfind.Server
+	var ingestCore indexer.Interface = indexerCore
+	findAddr := cfg.Addresses.Finder
+	if cctx.String("listen-finder") != "" {
+		findAddr = cctx.String("listen-finder")
+	}
+	if findAddr != "" && findAddr != "none" {
+		findNetAddr, err := mautil.MultiaddrStringToNetAddr(findAddr)
+		if err != nil {
+			return fmt.Errorf("bad find address %s: %s", findAddr, err)
+		}
+		var mirrorReader *carstore.CarReader
+		if cfg.Ingest.AdvertisementMirror.Publish {
+			mirrorReader, err = newMirrorReader(cfg.Ingest.AdvertisementMirror)
+			if err != nil {
+				return err
+			}
+		}
+		findSvr, err = httpfind.New(findNetAddr.String(), indexerCore, reg,
+			httpfind.WithCacheSize(cfg.Finder.CacheSize),
+			httpfind.WithCacheMaxAge(time.Duration(cfg.Finder.CacheMaxAge)),
+			httpfind.WithReadTimeout(time.Duration(cfg.Finder.ApiReadTimeout)),
+			httpfind.WithWriteTimeout(time.Duration(cfg.Finder.ApiWriteTimeout)),
+			httpfind.WithMaxBatchSize(cfg.Finder.MaxBatchSize),
+			httpfind.WithMaxConnections(cfg.Finder.MaxConnections),
+			httpfind.WithHomepage(cfg.Finder.Webpage),
+			httpfind.WithMirror(mirrorReader, privKey, cfg.Ingest.PubSubTopic),
+			httpfind.WithMirrorDatastore(dstore),
+			httpfind.WithVersion(cctx.App.Version),
+		)
+		if err != nil {
+			return err
+		}
+		// Changes to the indexed values must invalidate cached find results.
+		ingestCore = findSvr.CacheInvalidator(indexerCore)
+	}
+	if cfg.Retention.Enable && cfg.Retention.Strategy == retention.StrategyContext {
+		// Record when each context ID is first indexed, so that the oldest can
+		// be evicted.
+		ingestCore = retention.NewTracker(ingestCore, dstore)
+	}
 
+	// Create libp2p servers.
+	if p2pHost != nil {
 		// Do not resend direct announce messages if using an assigner service.
 		if cfg.Discovery.UseAssigner {
 			cfg.Ingest.ResendDirectAnnounce = false
 		}
 
 		// Initialize ingester.
-		ingester, err = ingest.NewIngester(cfg.Ingest, p2pHost, indexerCore, reg, dstore, dsTmp)
+		ingester, err = ingest.NewIngester(cfg.Ingest, p2pHost, ingestCore, reg, dstore, dsTmp)
 		if err != nil {
 			return err
 		}
@@ -280,13 +311,39 @@ func daemonAction(cctx *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("bad ingest address %s: %s", ingestAddr, err)
 		}
-		ingestSvr, err = httpingest.New(ingestNetAddr.String(), indexerCore, ingester, reg,
+		ingestSvr, err = httpingest.New(ingestNetAddr.String(), ingestCore, ingester, reg,
 			httpingest.WithVersion(cctx.App.Version))
 		if err != nil {
 			return err
 		}
 	}
 
+	// Start garbage collection of the local value store.
+	var gcSchedule *reaper.Schedule
+	if cfg.GC.Interval != 0 {
+		gcSchedule, err = startGC(cfg, ingestCore, reg)
+		if err != nil {
+			return fmt.Errorf("cannot start garbage collection: %w", err)
+		}
+		log.Infow("Garbage collection enabled", "interval", cfg.GC.Interval)
+	}
+
+	// Start retention-based eviction of index content.
+	var retent *retention.Retention
+	if cfg.Retention.Enable {
+		if cfg.Indexer.FreezeAtPercent > 0 && cfg.Retention.HighWaterPercent >= cfg.Indexer.FreezeAtPercent {
+			log.Warnw("Retention high-water mark is not below freeze threshold, indexer may freeze before evicting content",
+				"highWater", cfg.Retention.HighWaterPercent, "freezeAt", cfg.Indexer.FreezeAtPercent)
+		}
+		compacter, _ := valueStore.(retention.Compacter)
+		retent, err = retention.New(cfg.Retention, freezeDirs, ingestCore, reg, dstore, compacter)
+		if err != nil {
+			return fmt.Errorf("cannot start retention: %w", err)
+		}
+		log.Infow("Retention-based eviction enabled", "strategy", cfg.Retention.Strategy,
+			"highWater", cfg.Retention.HighWaterPercent, "lowWater", cfg.Retention.LowWaterPercent)
+	}
+
 	reloadErrsChan := make(chan chan error, 1)
 
 	// Create admin HTTP server
@@ -300,7 +357,14 @@ func daemonAction(cctx *cli.Context) error {
 		if err != nil {
 			return fmt.Errorf("bad admin address %s: %s", adminAddr, err)
 		}
-		adminSvr, err = httpadmin.New(adminNetAddr.String(), peerID, indexerCore, ingester, reg, reloadErrsChan)
+		cfgFile, err := config.Path("", "")
+		if err != nil {
+			return err
+		}
+		adminSvr, err = httpadmin.New(adminNetAddr.String(), peerID, ingestCore, ingester, reg, reloadErrsChan,
+			httpadmin.WithBackup(dstore, dsTmp, valueStore, vsDir, cfgFile, cfg.Indexer.ValueStoreType),
+			httpadmin.WithGCSchedule(gcSchedule),
+			httpadmin.WithRetention(retent))
 		if err != nil {
 			return err
 		}
@@ -475,6 +539,13 @@ func daemonAction(cctx *cli.Context) error {
 		}
 	}
 
+	if retent != nil {
+		retent.Close()
+	}
+	if gcSchedule != nil {
+		gcSchedule.Close()
+	}
+
 	// If ingester set, close ingester
 	if ingester != nil {
 		if err = ingester.Close(); err != nil {
@@ -494,6 +565,67 @@ func daemonAction(cctx *cli.Context) error {
 	return finalErr
 }
 
+// startGC creates a reaper that removes deleted index content from the value
+// store, and schedules it to run for all registered providers. The reaper
+// creates its own libp2p host, since syncing on the daemon's host would replace
+// the stream handlers used by ingestion.
+func startGC(cfg *config.Config, idxr indexer.Interface, reg *registry.Registry) (*reaper.Schedule, error) {
+	if cfg.Indexer.ValueStoreType == vstoreDHStore {
+		return nil, errors.New("not supported for dhstore value store, use gc daemon command")
+	}
+
+	dsDir, err := config.Path("", cfg.Datastore.Dir+"-gc")
+	if err != nil {
+		return nil, err
+	}
+	dsTmpDir, err := config.Path("", cfg.Datastore.TmpDir+"-gc")
+	if err != nil {
+		return nil, err
+	}
+
+	pc, err := pcache.New(pcache.WithPreload(false),
+		pcache.WithRefreshInterval(0),
+		pcache.WithSource(reg.ProviderSource()),
+		pcache.WithTTL(time.Minute))
+	if err != nil {
+		return nil, err
+	}
+
+	var fileStore filestore.Interface
+	cfgMirror := cfg.Ingest.AdvertisementMirror
+	if cfgMirror.Write {
+		fileStore, err = filestore.MakeFilestore(cfgMirror.Storage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	grim, err := reaper.New(idxr, fileStore,
+		reaper.WithCarCompress(cfgMirror.Compress),
+		reaper.WithCarDelete(cfgMirror.Write),
+		reaper.WithDatastoreDir(dsDir),
+		reaper.WithDatastoreTempDir(dsTmpDir),
+		reaper.WithDeleteNotFound(cfg.GC.DeleteNotFound),
+		reaper.WithHttpTimeout(time.Duration(cfg.Ingest.HttpSyncTimeout)),
+		reaper.WithPCache(pc),
+		reaper.WithRemoveRate(cfg.GC.RemoveRate),
+		reaper.WithTopicName(cfg.Ingest.PubSubTopic),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := func() []peer.ID {
+		infos := reg.AllProviderInfo()
+		pids := make([]peer.ID, len(infos))
+		for i, info := range infos {
+			pids[i] = info.AddrInfo.ID
+		}
+		return pids
+	}
+	return reaper.NewSchedule(grim, time.Duration(cfg.GC.Interval), providers), nil
+}
+
 func createValueStore(ctx context.Context, cfgIndexer config.Indexer) (indexer.Interface, int, string, error) {
 	var dir string
 	var err error
@@ -676,3 +808,19 @@ func reloadPeering(cfg config.Peering, peeringService *peering.PeeringService, p
 
 	return peeringService, nil
 }
+
+// newMirrorReader creates a CAR reader for publishing the advertisement chains
+// in the read mirror.
+func newMirrorReader(cfgMirror config.Mirror) (*carstore.CarReader, error) {
+	if !cfgMirror.Read {
+		return nil, errors.New("mirror publishing requires mirror read to be enabled")
+	}
+	readStore, err := filestore.MakeFilestore(cfgMirror.Retrieval)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create car file retrieval for mirror publishing: %w", err)
+	}
+	if readStore == nil {
+		return nil, errors.New("mirror publishing enabled with no retrieval backend")
+	}
+	return carstore.NewReader(readStore, carstore.WithCompress(cfgMirror.Compress))
+}
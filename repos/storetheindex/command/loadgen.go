@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	mathrand "math/rand"
+	"os"
 	"strings"
 	"time"
 
@@ -19,6 +20,26 @@ var loadGenCmd = &cli.Command{
 	Usage:  "Generate fake provider load for the indexer",
 	Flags:  loadGenFlags,
 	Action: loadGenAction,
+	Subcommands: []*cli.Command{
+		loadGenFindCmd,
+	},
+}
+
+var loadGenFindCmd = &cli.Command{
+	Name:  "find",
+	Usage: "Generate or replay find request load for an indexer or indexstar",
+	Description: `Sends find requests to the /cid, /multihash or /routing/v1/providers endpoint
+of a storetheindex or indexstar instance, and reports latency percentiles,
+response status codes, and the ratio of found to not found results.
+
+Keys are generated the same way that loadgen providers generate multihashes, so
+that requests find the content indexed from the providers. Set --provider-seed
+to the seed in the loadgen config, and --providers to the number of concurrent
+loadgen providers. Keys are chosen
+uniformly or with a Zipf distribution, which concentrates requests on fewer
+keys. Alternatively, a file of captured request paths can be replayed.`,
+	Flags:  loadGenFindFlags,
+	Action: loadGenFindAction,
 }
 
 var loadGenVerifyCmd = &cli.Command{
@@ -85,6 +106,105 @@ func loadGenAction(cctx *cli.Context) error {
 	return nil
 }
 
+var loadGenFindFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "target",
+		Usage:   "HTTP address of the indexer or indexstar find endpoint",
+		EnvVars: []string{"STORETHEINDEX_LISTEN_FINDER_HTTP"},
+		Aliases: []string{"t"},
+		Value:   "http://localhost:3000",
+	},
+	&cli.StringFlag{
+		Name:  "endpoint",
+		Usage: "Find endpoint to request generated keys from: cid, multihash, or routing",
+		Value: loadgen.FindEndpointCid,
+	},
+	&cli.Float64Flag{
+		Name:  "rate",
+		Usage: "Requests per second, 0 for as fast as concurrency allows",
+	},
+	&cli.IntFlag{
+		Name:    "concurrency",
+		Usage:   "Number of concurrent requests",
+		Aliases: []string{"c"},
+		Value:   8,
+	},
+	&cli.DurationFlag{
+		Name:  "duration",
+		Usage: "How long to send requests for, 0 for no limit",
+	},
+	&cli.IntFlag{
+		Name:    "requests",
+		Usage:   "Total number of requests to send, 0 for no limit",
+		Aliases: []string{"n"},
+	},
+	&cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "Timeout for each request",
+		Value: 10 * time.Second,
+	},
+	&cli.Uint64Flag{
+		Name:  "providers",
+		Usage: "Number of loadgen providers to generate keys for",
+		Value: 1,
+	},
+	&cli.Uint64Flag{
+		Name:  "provider-seed",
+		Usage: "Seed of the first loadgen provider, as set in the loadgen config",
+	},
+	&cli.Uint64Flag{
+		Name:  "entries",
+		Usage: "Number of entries per provider to generate keys from",
+		Value: 1000,
+	},
+	&cli.StringFlag{
+		Name:  "key-dist",
+		Usage: "Distribution of generated keys: uniform or zipf",
+		Value: loadgen.KeyDistUniform,
+	},
+	&cli.Float64Flag{
+		Name:  "zipf-s",
+		Usage: "Zipf distribution s parameter, must be > 1",
+		Value: 1.1,
+	},
+	&cli.Int64Flag{
+		Name:  "rand-seed",
+		Usage: "Seed for the random choice of keys",
+	},
+	&cli.StringFlag{
+		Name:  "replay",
+		Usage: "File of captured request paths to replay instead of generating keys",
+	},
+}
+
+func loadGenFindAction(cctx *cli.Context) error {
+	if cctx.Int("requests") == 0 && cctx.Duration("duration") == 0 && cctx.String("replay") == "" {
+		fmt.Println("Sending requests until interrupted")
+	}
+
+	stats, err := loadgen.RunFindLoad(cctx.Context, loadgen.FindOpts{
+		TargetURL:    cctx.String("target"),
+		Endpoint:     cctx.String("endpoint"),
+		Rate:         cctx.Float64("rate"),
+		Concurrency:  cctx.Int("concurrency"),
+		Duration:     cctx.Duration("duration"),
+		Requests:     cctx.Int("requests"),
+		Timeout:      cctx.Duration("timeout"),
+		Providers:    cctx.Uint64("providers"),
+		ProviderSeed: cctx.Uint64("provider-seed"),
+		Entries:      cctx.Uint64("entries"),
+		KeyDist:      cctx.String("key-dist"),
+		ZipfS:        cctx.Float64("zipf-s"),
+		RandSeed:     cctx.Int64("rand-seed"),
+		ReplayFile:   cctx.String("replay"),
+	})
+	if err != nil {
+		return err
+	}
+	stats.Print(os.Stdout)
+	return nil
+}
+
 var loadGenVerifyFlags = []cli.Flag{
 	&cli.Uint64Flag{
 		Name:     "concurrentProviders",
@@ -0,0 +1,413 @@
+package loadgen
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	FindEndpointCid       = "cid"
+	FindEndpointMultihash = "multihash"
+	FindEndpointRouting   = "routing"
+
+	KeyDistUniform = "uniform"
+	KeyDistZipf    = "zipf"
+)
+
+// FindOpts configures the find traffic generated by RunFindLoad.
+type FindOpts struct {
+	// TargetURL is the base URL of the storetheindex or indexstar find
+	// server to send requests to.
+	TargetURL string
+	// Endpoint selects the find endpoint that generated keys are looked up
+	// with: FindEndpointCid, FindEndpointMultihash or FindEndpointRouting.
+	Endpoint string
+	// Rate is the number of requests per second to send. A value of 0 sends
+	// requests as fast as the concurrency allows.
+	Rate float64
+	// Concurrency is the number of requests that can be in progress at once.
+	Concurrency int
+	// Duration is how long to send requests for. A value of 0 means no time
+	// limit.
+	Duration time.Duration
+	// Requests is the total number of requests to send. A value of 0 means no
+	// limit. If there is no time or request limit, then generated traffic is
+	// sent until canceled, and replayed traffic is sent once.
+	Requests int
+	// Timeout is the timeout for each request.
+	Timeout time.Duration
+
+	// Providers is the number of load generator providers that keys are
+	// generated for. Keys are generated the same way as the load generator
+	// providers generate multihashes, so that they can be found.
+	Providers uint64
+	// ProviderSeed is the seed of the first load generator provider. This is
+	// the seed in the load generator config, and the providers have the seeds
+	// ProviderSeed through ProviderSeed+Providers-1.
+	ProviderSeed uint64
+	// Entries is the number of entries, per provider, that keys are
+	// generated from.
+	Entries uint64
+	// KeyDist is how generated keys are distributed over the entries:
+	// KeyDistUniform or KeyDistZipf.
+	KeyDist string
+	// ZipfS is the Zipf distribution s parameter, and must be > 1. Larger
+	// values concentrate requests on fewer keys.
+	ZipfS float64
+	// RandSeed seeds the random choice of keys. It does not change which keys
+	// can be generated.
+	RandSeed int64
+
+	// ReplayFile is a file of captured request paths to replay, one per line,
+	// instead of generating keys. Lines may also be full URLs or be prefixed
+	// with an HTTP method. Empty lines and lines starting with '#' are
+	// ignored.
+	ReplayFile string
+}
+
+// FindStats is the result of generating find traffic.
+type FindStats struct {
+	// Requests is the number of requests sent.
+	Requests int
+	// Errors is the number of requests that failed without a response.
+	Errors int
+	// Elapsed is how long requests were sent for.
+	Elapsed time.Duration
+	// StatusCodes is the number of responses with each status code.
+	StatusCodes map[int]int
+	// Found is the number of requests that found results.
+	Found int
+	// NotFound is the number of requests that found no results.
+	NotFound int
+	// Latencies holds the latency of each response, sorted.
+	Latencies []time.Duration
+}
+
+// Percentile returns the latency at the given percentile, from 0 to 100.
+func (s *FindStats) Percentile(p float64) time.Duration {
+	if len(s.Latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(s.Latencies))*p/100.0+0.5) - 1
+	if i < 0 {
+		i = 0
+	} else if i >= len(s.Latencies) {
+		i = len(s.Latencies) - 1
+	}
+	return s.Latencies[i]
+}
+
+// Print writes a report of the stats.
+func (s *FindStats) Print(w io.Writer) {
+	fmt.Fprintln(w, "Requests:   ", s.Requests)
+	fmt.Fprintln(w, "Elapsed:    ", s.Elapsed.Round(time.Millisecond))
+	if s.Elapsed > 0 {
+		fmt.Fprintf(w, "Rate:        %.1f/s\n", float64(s.Requests)/s.Elapsed.Seconds())
+	}
+	fmt.Fprintln(w, "Errors:     ", s.Errors)
+
+	codes := make([]int, 0, len(s.StatusCodes))
+	for code := range s.StatusCodes {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	fmt.Fprintln(w, "Status codes:")
+	for _, code := range codes {
+		fmt.Fprintf(w, "   %d %-22s %d\n", code, http.StatusText(code), s.StatusCodes[code])
+	}
+
+	if total := s.Found + s.NotFound; total != 0 {
+		fmt.Fprintf(w, "Found:       %d (%.1f%%)\n", s.Found, 100*float64(s.Found)/float64(total))
+		fmt.Fprintf(w, "Not found:   %d (%.1f%%)\n", s.NotFound, 100*float64(s.NotFound)/float64(total))
+	}
+
+	if len(s.Latencies) != 0 {
+		fmt.Fprintln(w, "Latency:")
+		for _, p := range []float64{50, 90, 95, 99, 99.9} {
+			fmt.Fprintf(w, "   p%-5v %s\n", p, s.Percentile(p))
+		}
+		fmt.Fprintf(w, "   max    %s\n", s.Latencies[len(s.Latencies)-1])
+	}
+}
+
+// RunFindLoad sends find requests to the target, as configured by opts, and
+// returns statistics about the responses. Requests are sent until the
+// configured limits are reached or until the context is canceled.
+func RunFindLoad(ctx context.Context, opts FindOpts) (*FindStats, error) {
+	baseURL, err := url.Parse(opts.TargetURL)
+	if err != nil {
+		return nil, fmt.Errorf("bad target url: %w", err)
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+
+	var nextPath func() (string, bool)
+	if opts.ReplayFile != "" {
+		paths, err := readReplayFile(opts.ReplayFile)
+		if err != nil {
+			return nil, err
+		}
+		nextPath = replayPaths(paths, opts.Requests == 0 && opts.Duration == 0)
+	} else {
+		nextPath, err = generatePaths(opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Duration != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	client := &http.Client{
+		Timeout: opts.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConnsPerHost: opts.Concurrency,
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	stats := &FindStats{
+		StatusCodes: make(map[int]int),
+	}
+	var statsMutex sync.Mutex
+
+	paths := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(opts.Concurrency)
+	for i := 0; i < opts.Concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for p := range paths {
+				code, found, latency, err := sendFind(ctx, client, baseURL, p)
+				if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+					// Request interrupted by end of run.
+					if ctx.Err() != nil {
+						continue
+					}
+				}
+				statsMutex.Lock()
+				stats.Requests++
+				if err != nil {
+					stats.Errors++
+				} else {
+					stats.StatusCodes[code]++
+					stats.Latencies = append(stats.Latencies, latency)
+					switch {
+					case found:
+						stats.Found++
+					case code == http.StatusNotFound:
+						stats.NotFound++
+					}
+				}
+				statsMutex.Unlock()
+			}
+		}()
+	}
+
+	var ticker *time.Ticker
+	if opts.Rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+	}
+
+	start := time.Now()
+sendLoop:
+	for sent := 0; opts.Requests == 0 || sent < opts.Requests; sent++ {
+		p, ok := nextPath()
+		if !ok {
+			break
+		}
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				break sendLoop
+			}
+		}
+		select {
+		case paths <- p:
+		case <-ctx.Done():
+			break sendLoop
+		}
+	}
+	close(paths)
+	wg.Wait()
+	stats.Elapsed = time.Since(start)
+
+	sort.Slice(stats.Latencies, func(i, j int) bool {
+		return stats.Latencies[i] < stats.Latencies[j]
+	})
+	return stats, nil
+}
+
+// sendFind sends a find request and returns the response status code, whether
+// the response contained results, and the request latency.
+func sendFind(ctx context.Context, client *http.Client, baseURL *url.URL, reqPath string) (int, bool, time.Duration, error) {
+	u, err := baseURL.Parse(reqPath)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	latency := time.Since(start)
+	if err != nil {
+		return 0, false, 0, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, false, latency, nil
+	}
+	found := true
+	// Delegated routing may respond with an empty provider list instead of
+	// 404 when nothing is found.
+	if strings.HasPrefix(u.Path, "/routing/v1/") {
+		var routingResp struct {
+			Providers []json.RawMessage
+		}
+		if json.Unmarshal(body, &routingResp) == nil && len(routingResp.Providers) == 0 {
+			found = false
+		}
+	}
+	return resp.StatusCode, found, latency, nil
+}
+
+// generatePaths returns a function that generates request paths for keys
+// chosen from the configured key distribution.
+func generatePaths(opts FindOpts) (func() (string, bool), error) {
+	if opts.Providers == 0 || opts.Entries == 0 {
+		return nil, errors.New("number of providers and entries must be greater than 0")
+	}
+
+	var pathPrefix string
+	switch opts.Endpoint {
+	case FindEndpointCid, "":
+		pathPrefix = "/cid/"
+	case FindEndpointMultihash:
+		pathPrefix = "/multihash/"
+	case FindEndpointRouting:
+		pathPrefix = "/routing/v1/providers/"
+	default:
+		return nil, fmt.Errorf("unknown find endpoint %q", opts.Endpoint)
+	}
+
+	rng := mathrand.New(mathrand.NewSource(opts.RandSeed))
+	total := opts.Providers * opts.Entries
+	var nextKey func() uint64
+	switch opts.KeyDist {
+	case KeyDistUniform, "":
+		nextKey = func() uint64 {
+			return uint64(rng.Int63n(int64(total)))
+		}
+	case KeyDistZipf:
+		zipf := mathrand.NewZipf(rng, opts.ZipfS, 1, total-1)
+		if zipf == nil {
+			return nil, errors.New("zipf s parameter must be greater than 1")
+		}
+		nextKey = zipf.Uint64
+	default:
+		return nil, fmt.Errorf("unknown key distribution %q", opts.KeyDist)
+	}
+
+	return func() (string, bool) {
+		key := nextKey()
+		mh, err := GenerateMH(opts.ProviderSeed+key%opts.Providers, key/opts.Providers)
+		if err != nil {
+			panic(err)
+		}
+		return pathPrefix + keyString(mh, opts.Endpoint), true
+	}, nil
+}
+
+func keyString(mh multihash.Multihash, endpoint string) string {
+	if endpoint == FindEndpointMultihash {
+		return mh.B58String()
+	}
+	return cid.NewCidV1(cid.Raw, mh).String()
+}
+
+// replayPaths returns a function that returns each path in order. If once is
+// false, then the paths are repeated.
+func replayPaths(paths []string, once bool) func() (string, bool) {
+	var i int
+	return func() (string, bool) {
+		if i == len(paths) {
+			if once {
+				return "", false
+			}
+			i = 0
+		}
+		p := paths[i]
+		i++
+		return p, true
+	}
+}
+
+func readReplayFile(fileName string) ([]string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Remove method from lines such as "GET /cid/bafy...".
+		if fields := strings.Fields(line); len(fields) > 1 {
+			line = fields[1]
+		}
+		// Keep only path and query from full URLs.
+		if strings.HasPrefix(line, "http://") || strings.HasPrefix(line, "https://") {
+			u, err := url.Parse(line)
+			if err != nil {
+				return nil, fmt.Errorf("bad url in replay file: %w", err)
+			}
+			line = u.RequestURI()
+		}
+		if !strings.HasPrefix(line, "/") {
+			return nil, fmt.Errorf("bad request path in replay file: %s", line)
+		}
+		paths = append(paths, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, errors.New("no request paths in replay file")
+	}
+	return paths, nil
+}
@@ -0,0 +1,118 @@
+package loadgen
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunFindLoad(t *testing.T) {
+	// Only entries from the provider with seed 5 are found.
+	var mutex sync.Mutex
+	var reqPaths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		reqPaths = append(reqPaths, r.URL.Path)
+		mutex.Unlock()
+
+		c, err := cid.Decode(strings.TrimPrefix(r.URL.Path, "/cid/"))
+		if err != nil {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+		for i := uint64(0); i < 10; i++ {
+			mh, _ := GenerateMH(5, i)
+			if string(mh) == string(c.Hash()) {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		http.Error(w, "", http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	stats, err := RunFindLoad(context.Background(), FindOpts{
+		TargetURL:    ts.URL,
+		Endpoint:     FindEndpointCid,
+		Concurrency:  4,
+		Requests:     200,
+		Providers:    2,
+		ProviderSeed: 4,
+		Entries:      10,
+		KeyDist:      KeyDistZipf,
+		ZipfS:        1.5,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 200, stats.Requests)
+	require.Zero(t, stats.Errors)
+	require.Len(t, stats.Latencies, 200)
+	require.Equal(t, 200, stats.Found+stats.NotFound)
+	require.NotZero(t, stats.Found)
+	require.NotZero(t, stats.NotFound)
+	require.Equal(t, stats.Found, stats.StatusCodes[http.StatusOK])
+	require.Equal(t, stats.NotFound, stats.StatusCodes[http.StatusNotFound])
+	require.LessOrEqual(t, stats.Percentile(50), stats.Percentile(99))
+	require.Equal(t, stats.Latencies[199], stats.Percentile(100))
+
+	// Replay captured requests once.
+	replayFile := filepath.Join(t.TempDir(), "replay.txt")
+	mh, _ := GenerateMH(5, 1)
+	c := cid.NewCidV1(cid.Raw, mh).String()
+	replay := "# captured requests\n" +
+		"/cid/" + c + "\n" +
+		"GET /cid/bafkqaaa\n" +
+		"\n" +
+		ts.URL + "/cid/" + c + "\n"
+	require.NoError(t, os.WriteFile(replayFile, []byte(replay), 0666))
+
+	reqPaths = nil
+	stats, err = RunFindLoad(context.Background(), FindOpts{
+		TargetURL:   ts.URL,
+		Concurrency: 1,
+		ReplayFile:  replayFile,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Requests)
+	require.Equal(t, 2, stats.Found)
+	require.Equal(t, 1, stats.NotFound)
+	require.Equal(t, []string{"/cid/" + c, "/cid/bafkqaaa", "/cid/" + c}, reqPaths)
+
+	// Replay repeats until time limit, at the requested rate.
+	stats, err = RunFindLoad(context.Background(), FindOpts{
+		TargetURL:   ts.URL,
+		Rate:        100,
+		Concurrency: 2,
+		Duration:    200 * time.Millisecond,
+		ReplayFile:  replayFile,
+	})
+	require.NoError(t, err)
+	require.Greater(t, stats.Requests, 3)
+	require.LessOrEqual(t, stats.Requests, 21)
+}
+
+func TestGeneratePaths(t *testing.T) {
+	_, err := generatePaths(FindOpts{Providers: 1, Entries: 10, Endpoint: "bad"})
+	require.Error(t, err)
+	_, err = generatePaths(FindOpts{Providers: 1, Entries: 10, KeyDist: KeyDistZipf, ZipfS: 1})
+	require.Error(t, err)
+
+	next, err := generatePaths(FindOpts{Providers: 1, Entries: 10, Endpoint: FindEndpointRouting})
+	require.NoError(t, err)
+	p, ok := next()
+	require.True(t, ok)
+	require.True(t, strings.HasPrefix(p, "/routing/v1/providers/"))
+
+	next, err = generatePaths(FindOpts{Providers: 1, Entries: 10, Endpoint: FindEndpointMultihash})
+	require.NoError(t, err)
+	p, _ = next()
+	require.True(t, strings.HasPrefix(p, "/multihash/Qm"))
+}
@@ -0,0 +1,243 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-indexer-core/engine"
+	"github.com/ipni/storetheindex/carstore"
+	"github.com/ipni/storetheindex/filestore"
+	"github.com/ipni/storetheindex/internal/ingest"
+	"github.com/ipni/storetheindex/internal/retention"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/urfave/cli/v2"
+)
+
+var ReindexCmd = &cli.Command{
+	Name:  "reindex",
+	Usage: "Rebuild the value store from the CAR mirror",
+	Description: `Rebuilds the value store and processed advertisement markers using only the
+advertisements and entries stored in the read mirror. The indexer daemon must
+not be running.
+
+The advertisement chain of each publisher that has a head file in the read
+mirror is walked from its head, and every advertisement in the chain is applied
+for its provider from oldest to newest, in the same way the ingester applies
+it. Advertisements whose context ID is removed later in the chain by the same
+provider are skipped. Walking a chain stops at the first advertisement that is
+not in the mirror, since older advertisements cannot be reached without it.
+
+Progress is recorded for each publisher after each advertisement is applied, so
+an interrupted reindex continues where it left off when run again. Use
+--restart to reindex all advertisements from the start of each chain.`,
+	Flags:  reindexFlags,
+	Action: reindexAction,
+}
+
+var reindexFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:    "concurrency",
+		Usage:   "Number of publishers to reindex concurrently. Setting 0 uses number of cores.",
+		Aliases: []string{"c"},
+		Value:   0,
+	},
+	&cli.StringSliceFlag{
+		Name:  "pid",
+		Usage: "Publisher's peer ID, multiple allowed. Reindexes all publishers in the mirror if not specified.",
+	},
+	&cli.BoolFlag{
+		Name:  "restart",
+		Usage: "Discard previous reindex progress and reindex from the start of each chain",
+	},
+}
+
+func reindexAction(cctx *cli.Context) error {
+	cfg, err := loadConfig("")
+	if err != nil {
+		return err
+	}
+
+	if err = setLoggingConfig(cfg.Logging); err != nil {
+		return err
+	}
+
+	ctx := cctx.Context
+	concurrency := cctx.Int("concurrency")
+	if concurrency < 0 {
+		return errors.New("concurrency value must be greater than 0")
+	}
+	if concurrency == 0 {
+		concurrency = runtime.NumCPU()
+	}
+
+	cfgMirror := cfg.Ingest.AdvertisementMirror
+	if !cfgMirror.Read {
+		return errors.New("read mirror not enabled")
+	}
+	readStore, err := filestore.MakeFilestore(cfgMirror.Retrieval)
+	if err != nil {
+		return fmt.Errorf("cannot create car file storage for read mirror: %w", err)
+	}
+	if readStore == nil {
+		return errors.New("read mirror is enabled with no storage backend")
+	}
+	carReader, err := carstore.NewReader(readStore, carstore.WithCompress(cfgMirror.Compress))
+	if err != nil {
+		return err
+	}
+
+	var publishers []peer.ID
+	pids := cctx.StringSlice("pid")
+	if len(pids) != 0 {
+		publishers = make([]peer.ID, len(pids))
+		for i, pid := range pids {
+			publishers[i], err = peer.Decode(pid)
+			if err != nil {
+				return fmt.Errorf("invalid peer ID %s: %s", pid, err)
+			}
+		}
+	} else {
+		publishers, err = mirrorPublishers(ctx, readStore)
+		if err != nil {
+			return err
+		}
+	}
+	if len(publishers) == 0 {
+		fmt.Println("No publishers to reindex")
+		return nil
+	}
+
+	valueStore, minKeyLen, _, err := createValueStore(ctx, cfg.Indexer)
+	if err != nil {
+		return err
+	}
+	indexerCore := engine.New(valueStore)
+	defer indexerCore.Close()
+
+	dstore, _, err := createDatastore(ctx, cfg.Datastore.Dir, cfg.Datastore.Type, false)
+	if err != nil {
+		return err
+	}
+	defer dstore.Close()
+	if err = updateDatastore(ctx, dstore); err != nil {
+		return fmt.Errorf("cannot update datastore: %w", err)
+	}
+
+	var ingestCore indexer.Interface = indexerCore
+	// Track context IDs the same as the daemon does, so that context
+	// retention can evict reindexed content.
+	if cfg.Retention.Enable && cfg.Retention.Strategy == retention.StrategyContext {
+		ingestCore = retention.NewTracker(indexerCore, dstore)
+	}
+
+	reindexer := ingest.NewReindexer(carReader, ingestCore, dstore, max(minKeyLen, cfg.Ingest.MinimumKeyLength))
+	if cctx.Bool("restart") {
+		if err = reindexer.Reset(ctx); err != nil {
+			return fmt.Errorf("cannot reset reindex progress: %w", err)
+		}
+	}
+
+	return reindexPublishers(ctx, reindexer, publishers, concurrency)
+}
+
+// mirrorPublishers returns the publishers that have a head file in the mirror.
+func mirrorPublishers(ctx context.Context, fileStore filestore.Interface) ([]peer.ID, error) {
+	var publishers []peer.ID
+	files, errs := fileStore.List(ctx, "/", false)
+	for file := range files {
+		name, ok := strings.CutSuffix(file.Path, carstore.HeadFileSuffix)
+		if !ok {
+			continue
+		}
+		publisher, err := peer.Decode(strings.TrimPrefix(name, "/"))
+		if err != nil {
+			fmt.Println("Ignoring head file with invalid publisher ID:", file.Path)
+			continue
+		}
+		publishers = append(publishers, publisher)
+	}
+	if err := <-errs; err != nil {
+		return nil, fmt.Errorf("cannot list head files in read mirror: %w", err)
+	}
+	return publishers, nil
+}
+
+func reindexPublishers(ctx context.Context, reindexer *ingest.Reindexer, publishers []peer.ID, concurrency int) error {
+	fmt.Println("Reindexing", len(publishers), "publishers from CAR mirror. concurrency =", concurrency)
+
+	var (
+		mutex      sync.Mutex
+		sum        ingest.ReindexStats
+		failed     int
+		incomplete int
+		wg         sync.WaitGroup
+	)
+	start := time.Now()
+
+	pubCh := make(chan peer.ID)
+	for g := 0; g < min(concurrency, len(publishers)); g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for publisher := range pubCh {
+				st, err := reindexer.Reindex(ctx, publisher)
+				mutex.Lock()
+				if err != nil {
+					if !errors.Is(err, context.Canceled) {
+						fmt.Println("Failed to reindex publisher", publisher, ":", err)
+					}
+					failed++
+				}
+				if st.Incomplete {
+					incomplete++
+				}
+				sum.Ads += st.Ads
+				sum.Removals += st.Removals
+				sum.Skipped += st.Skipped
+				sum.NoEntries += st.NoEntries
+				sum.Multihashes += st.Multihashes
+				mutex.Unlock()
+			}
+		}()
+	}
+
+sendLoop:
+	for _, publisher := range publishers {
+		select {
+		case pubCh <- publisher:
+		case <-ctx.Done():
+			break sendLoop
+		}
+	}
+	close(pubCh)
+	wg.Wait()
+
+	var err error
+	if ctx.Err() != nil {
+		err = ctx.Err()
+		fmt.Println("Reindex canceled. Run again to resume.")
+	} else if failed != 0 {
+		fmt.Println("Reindex finished with errors. Run again to resume.")
+		err = fmt.Errorf("failed to reindex %d publishers", failed)
+	} else {
+		fmt.Println("Reindex complete")
+	}
+
+	fmt.Println("   Elapsed:          ", time.Since(start).String())
+	fmt.Println("   Publishers:       ", len(publishers))
+	fmt.Println("   Failed:           ", failed)
+	fmt.Println("   Incomplete chains:", incomplete)
+	fmt.Println("   Advertisements:   ", sum.Ads)
+	fmt.Println("   Removals:         ", sum.Removals)
+	fmt.Println("   Skipped:          ", sum.Skipped)
+	fmt.Println("   Missing entries:  ", sum.NoEntries)
+	fmt.Println("   Multihashes:      ", sum.Multihashes)
+
+	return err
+}
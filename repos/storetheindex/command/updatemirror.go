@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ipni/storetheindex/carstore"
@@ -17,8 +20,17 @@ import (
 )
 
 var UpdateMirrorCmd = &cli.Command{
-	Name:   "update-mirror",
-	Usage:  "Update CAR files from read mirror to write mirror",
+	Name:  "update-mirror",
+	Usage: "Update CAR files from read mirror to write mirror",
+	Description: `Updates existing CAR files in the write mirror that differ in size from
+those in the read mirror.
+
+With --recompress, CAR files in the read mirror are instead recompressed into
+the write mirror using the configured mirror compression. The compression of
+each read mirror CAR file is detected from its file suffix. Head files, and CAR
+files that already have the configured compression, are copied unchanged.
+Files already in the write mirror are skipped, so an interrupted recompression
+can be resumed by running the command again.`,
 	Flags:  updateMirrorFlags,
 	Action: updateMirrorAction,
 }
@@ -30,6 +42,15 @@ var updateMirrorFlags = []cli.Flag{
 		Aliases: []string{"c"},
 		Value:   0,
 	},
+	&cli.BoolFlag{
+		Name:  "recompress",
+		Usage: "Recompress CAR files from read mirror into write mirror using configured compression",
+	},
+	&cli.DurationFlag{
+		Name:  "progress",
+		Usage: "Interval at which to report recompression progress. Setting 0 disables progress reporting.",
+		Value: 30 * time.Second,
+	},
 }
 
 type stats struct {
@@ -48,7 +69,7 @@ func updateMirrorAction(cctx *cli.Context) error {
 		return err
 	}
 
-	carSuffix, err := getCARSuffix(cfgMirror)
+	compAlg, err := carstore.ParseCompression(cfgMirror.Compress)
 	if err != nil {
 		return err
 	}
@@ -62,6 +83,11 @@ func updateMirrorAction(cctx *cli.Context) error {
 		concurrency = runtime.NumCPU()
 	}
 
+	if cctx.Bool("recompress") {
+		return recompressMirror(ctx, readStore, writeStore, compAlg, concurrency, cctx.Duration("progress"))
+	}
+	carSuffix := carstore.CarSuffix(compAlg)
+
 	fmt.Println("Updating existing CARs in", writeStore.Type(), "write mirror from CARs in", readStore.Type(), "read mirror. concurrency =", concurrency)
 
 	statsOut := make(chan stats)
@@ -184,12 +210,191 @@ func getMirrorConfig() (config.Mirror, error) {
 	return cfgMirror, nil
 }
 
-func getCARSuffix(cfgMirror config.Mirror) (string, error) {
-	switch cfgMirror.Compress {
-	case carstore.Gzip, "gz":
-		return carstore.CarFileSuffix + carstore.GzipFileSuffix, nil
-	case "", "none", "nil", "null":
-		return carstore.CarFileSuffix, nil
+type recompressStats struct {
+	checked, skipped, recompressed, copied, rdErrs, wrErrs atomic.Int64
+	bytesRead, bytesWritten                                atomic.Int64
+}
+
+func (st *recompressStats) print() {
+	fmt.Println("   Checked:      ", st.checked.Load())
+	fmt.Println("   Skipped:      ", st.skipped.Load())
+	fmt.Println("   Recompressed: ", st.recompressed.Load())
+	fmt.Println("   Copied:       ", st.copied.Load())
+	fmt.Println("   Read errors:  ", st.rdErrs.Load())
+	fmt.Println("   Write errors: ", st.wrErrs.Load())
+	fmt.Println("   Bytes read:   ", st.bytesRead.Load())
+	fmt.Println("   Bytes written:", st.bytesWritten.Load())
+}
+
+// recompressMirror writes each CAR file in the read mirror, that is not
+// already compressed with compAlg, to the write mirror compressed with
+// compAlg. All other files, such as head files, are copied unchanged. Files
+// that already exist in the write mirror are skipped.
+func recompressMirror(ctx context.Context, readStore, writeStore filestore.Interface, compAlg string, concurrency int, progress time.Duration) error {
+	compName := compAlg
+	if compName == "" {
+		compName = "none"
+	}
+	fmt.Println("Recompressing CARs from", readStore.Type(), "read mirror into", writeStore.Type(), "write mirror. compression =", compName, "concurrency =", concurrency)
+
+	var st recompressStats
+	start := time.Now()
+
+	if progress != 0 {
+		ticker := time.NewTicker(progress)
+		defer ticker.Stop()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					fmt.Println("Recompression progress after", time.Since(start).Round(time.Second).String())
+					st.print()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	var wg sync.WaitGroup
+	filesChan, errs := readStore.List(ctx, "/", false)
+	for g := 0; g < concurrency; g++ {
+		wg.Add(1)
+		go func(files <-chan *filestore.File) {
+			defer wg.Done()
+			for rdFile := range files {
+				st.checked.Add(1)
+
+				srcAlg, isCar := carstore.FileCompression(rdFile.Path)
+				recompress := isCar && srcAlg != compAlg
+				wrPath := rdFile.Path
+				if recompress {
+					basePath := strings.TrimSuffix(rdFile.Path, carstore.CarSuffix(srcAlg))
+					wrPath = basePath + carstore.CarSuffix(compAlg)
+				}
+				_, err := writeStore.Head(ctx, wrPath)
+				if err == nil {
+					st.skipped.Add(1)
+					continue
+				}
+				if !errors.Is(err, fs.ErrNotExist) {
+					fmt.Println("Cannot get info for file in write mirror:", err)
+					st.wrErrs.Add(1)
+					continue
+				}
+
+				var wrFile *filestore.File
+				if recompress {
+					wrFile, err = recompressCar(ctx, readStore, writeStore, rdFile.Path, srcAlg, wrPath, compAlg)
+				} else {
+					wrFile, err = copyFile(ctx, readStore, writeStore, rdFile.Path)
+				}
+				if err != nil {
+					var rdErr readError
+					if errors.As(err, &rdErr) {
+						fmt.Println("Cannot read from read mirror:", err)
+						st.rdErrs.Add(1)
+					} else {
+						fmt.Println("Failed to write CAR to write mirror:", err)
+						st.wrErrs.Add(1)
+					}
+					continue
+				}
+				if recompress {
+					st.recompressed.Add(1)
+				} else {
+					st.copied.Add(1)
+				}
+				st.bytesRead.Add(rdFile.Size)
+				st.bytesWritten.Add(wrFile.Size)
+			}
+		}(filesChan)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	err := <-errs
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Println("Mirror recompression canceled. Run again to resume.")
+			err = nil
+		} else {
+			fmt.Println("Mirror recompression ended due to error. Run again to resume.")
+			err = fmt.Errorf("error listing files in read mirror: %w", err)
+		}
+	} else {
+		fmt.Println("Mirror recompression complete")
+	}
+
+	fmt.Println("   Elapsed:      ", elapsed.String())
+	st.print()
+
+	return err
+}
+
+// readError is an error reading a CAR file from the read mirror.
+type readError struct {
+	err error
+}
+
+func (e readError) Error() string { return e.err.Error() }
+func (e readError) Unwrap() error { return e.err }
+
+// copyFile copies the file at path from the read mirror to the write mirror.
+func copyFile(ctx context.Context, readStore, writeStore filestore.Interface, path string) (*filestore.File, error) {
+	_, rc, err := readStore.Get(ctx, path)
+	if err != nil {
+		return nil, readError{err}
+	}
+	defer rc.Close()
+
+	wrFile, err := writeStore.Put(ctx, path, rc)
+	if err != nil {
+		// Do not leave a partial file that would be skipped when resuming.
+		_ = writeStore.Delete(ctx, path)
+		return nil, err
+	}
+	return wrFile, nil
+}
+
+// recompressCar reads the CAR file at rdPath, decompresses it, and writes it
+// to wrPath compressed with compAlg.
+func recompressCar(ctx context.Context, readStore, writeStore filestore.Interface, rdPath, srcAlg, wrPath, compAlg string) (*filestore.File, error) {
+	_, rc, err := readStore.Get(ctx, rdPath)
+	if err != nil {
+		return nil, readError{err}
+	}
+	rc, err = carstore.NewDecompressReader(rc, srcAlg)
+	if err != nil {
+		return nil, readError{err}
+	}
+	defer rc.Close()
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		compw, err := carstore.NewCompressWriter(pw, compAlg)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err = io.Copy(compw, rc); err != nil {
+			pw.CloseWithError(readError{err})
+			return
+		}
+		pw.CloseWithError(compw.Close())
+	}()
+
+	wrFile, err := writeStore.Put(ctx, wrPath, pr)
+	pr.Close()
+	<-done
+	if err != nil {
+		// Do not leave a partial file that would be skipped when resuming.
+		_ = writeStore.Delete(ctx, wrPath)
+		return nil, err
 	}
-	return "", fmt.Errorf("unsupported compression: %s", cfgMirror.Compress)
+	return wrFile, nil
 }
@@ -0,0 +1,79 @@
+package command
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/ipni/storetheindex/carstore"
+	"github.com/ipni/storetheindex/filestore"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecompressMirror(t *testing.T) {
+	ctx := context.Background()
+	readStore, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	writeStore, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+
+	carData := map[string][]byte{
+		"ad1": bytes.Repeat([]byte("ad1-data"), 100),
+		"ad2": bytes.Repeat([]byte("ad2-data"), 100),
+	}
+	for name, data := range carData {
+		var buf bytes.Buffer
+		w, err := carstore.NewCompressWriter(&buf, carstore.Gzip)
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		_, err = readStore.Put(ctx, name+carstore.CarSuffix(carstore.Gzip), &buf)
+		require.NoError(t, err)
+	}
+	// Head files, and CARs that already have the target compression, are
+	// copied unchanged.
+	_, err = readStore.Put(ctx, "provider"+carstore.HeadFileSuffix, bytes.NewBufferString("ad1"))
+	require.NoError(t, err)
+	zstdCar := []byte("already-zstd")
+	_, err = readStore.Put(ctx, "ad3"+carstore.CarSuffix(carstore.Zstd), bytes.NewReader(zstdCar))
+	require.NoError(t, err)
+
+	checkWritten := func() {
+		for name, data := range carData {
+			_, rc, err := writeStore.Get(ctx, name+carstore.CarSuffix(carstore.Zstd))
+			require.NoError(t, err)
+			rc, err = carstore.NewDecompressReader(rc, carstore.Zstd)
+			require.NoError(t, err)
+			out, err := io.ReadAll(rc)
+			require.NoError(t, err)
+			require.NoError(t, rc.Close())
+			require.Equal(t, data, out)
+		}
+	}
+
+	err = recompressMirror(ctx, readStore, writeStore, carstore.Zstd, 2, 0)
+	require.NoError(t, err)
+	checkWritten()
+
+	for name, data := range map[string][]byte{
+		"provider" + carstore.HeadFileSuffix:      []byte("ad1"),
+		"ad3" + carstore.CarSuffix(carstore.Zstd): zstdCar,
+	} {
+		_, rc, err := writeStore.Get(ctx, name)
+		require.NoError(t, err)
+		out, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		require.Equal(t, data, out)
+	}
+	_, err = writeStore.Head(ctx, "ad1"+carstore.CarSuffix(carstore.Gzip))
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Running again skips CARs that are already recompressed.
+	err = recompressMirror(ctx, readStore, writeStore, carstore.Zstd, 2, 0)
+	require.NoError(t, err)
+	checkWritten()
+}
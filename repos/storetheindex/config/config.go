@@ -19,10 +19,12 @@ type Config struct {
 	Datastore Datastore // datastore config
 	Discovery Discovery // provider pubsub peers
 	Finder    Finder    // finder code configuration
+	GC        GC        // daemon garbage collection configuration
 	Indexer   Indexer   // indexer code configuration
 	Ingest    Ingest    // ingestion related configuration.
 	Logging   Logging   // logging configuration.
 	Peering   Peering   // peering service configuration.
+	Retention Retention // retention-based eviction configuration.
 }
 
 const (
@@ -103,10 +105,12 @@ func Load(filePath string) (*Config, error) {
 		Datastore: NewDatastore(),
 		Discovery: NewDiscovery(),
 		Finder:    NewFinder(),
+		GC:        NewGC(),
 		Indexer:   NewIndexer(),
 		Ingest:    NewIngest(),
 		Logging:   NewLogging(),
 		Peering:   NewPeering(),
+		Retention: NewRetention(),
 	}
 
 	if err = json.NewDecoder(f).Decode(&cfg); err != nil {
@@ -179,7 +183,9 @@ func (c *Config) populateUnset() {
 	c.Datastore.populateUnset()
 	c.Discovery.populateUnset()
 	c.Finder.populateUnset()
+	c.GC.populateUnset()
 	c.Indexer.populateUnset()
 	c.Ingest.populateUnset()
 	c.Logging.populateUnset()
+	c.Retention.populateUnset()
 }
@@ -42,6 +42,24 @@ type Discovery struct {
 	DeactivateAfter Duration
 	// PollOverrides configures polling for specific providers.
 	PollOverrides []Polling
+	// ProbeInterval is the amount of time between checks of the reachability
+	// of each provider's addresses. HTTP addresses are checked with a HEAD
+	// request and all others with a libp2p connection. A zero value disables
+	// reachability probing.
+	ProbeInterval Duration
+	// ProbeTimeout is the maximum amount of time to wait for a single address
+	// to respond to a reachability probe.
+	ProbeTimeout Duration
+	// UnreachableAfter is the amount of time that an address must remain
+	// unreachable before UnreachableAddrs is applied to it. Only applies if
+	// ProbeInterval is non-zero.
+	UnreachableAfter Duration
+	// UnreachableAddrs determines what is done with provider addresses that
+	// have been unreachable for longer than UnreachableAfter. A value of
+	// "last" returns them after all other addresses in find responses, and
+	// "drop" removes them from find responses. Any other value, including the
+	// default empty string, returns all addresses in their advertised order.
+	UnreachableAddrs string
 	// RemoveOldAssignments, if true, removes persisted assignments of previous
 	// versions. When false, previous versions of persisted assignments are
 	// migrated. Only applies if UseAssigner is true.
@@ -98,6 +116,8 @@ func NewDiscovery() Discovery {
 		PollRetryAfter:   Duration(5 * time.Hour),
 		PollStopAfter:    defaultStopAfter,
 		DeactivateAfter:  defaultStopAfter,
+		ProbeTimeout:     Duration(10 * time.Second),
+		UnreachableAfter: Duration(24 * time.Hour),
 	}
 }
 
@@ -122,4 +142,10 @@ func (c *Discovery) populateUnset() {
 		// This means no inactive grace period for providers by default.
 		c.DeactivateAfter = def.PollStopAfter
 	}
+	if c.ProbeTimeout == 0 {
+		c.ProbeTimeout = def.ProbeTimeout
+	}
+	if c.UnreachableAfter == 0 {
+		c.UnreachableAfter = def.UnreachableAfter
+	}
 }
@@ -13,6 +13,18 @@ type Finder struct {
 	// out writes of the response. A value of zero sets the default and a
 	// negative value means there will be no timeout.
 	ApiWriteTimeout Duration
+	// CacheMaxAge is the maximum time that find results are kept in the find
+	// result cache. Responses served from the cache allow clients and proxies
+	// to cache them for this long. A value of zero sets the default.
+	CacheMaxAge Duration
+	// CacheSize is the number of multihashes whose find results are kept in
+	// the find result cache. A value of zero sets the default and a negative
+	// value disables the cache.
+	CacheSize int
+	// MaxBatchSize is the maximum number of CIDs and multihashes that may be
+	// looked up in a single batch find request. A value of zero sets the
+	// default.
+	MaxBatchSize int
 	// MaxConnections is maximum number of simultaneous connections that the
 	// HTTP server will accept. A value of zero sets the default and a negative
 	// value means there is no limit.
@@ -26,6 +38,9 @@ func NewFinder() Finder {
 	return Finder{
 		ApiReadTimeout:  Duration(30 * time.Second),
 		ApiWriteTimeout: Duration(30 * time.Second),
+		CacheMaxAge:     Duration(time.Minute),
+		CacheSize:       10_000,
+		MaxBatchSize:    1000,
 		MaxConnections:  8_000,
 		Webpage:         "https://web-ipni.cid.contact/",
 	}
@@ -40,6 +55,15 @@ func (f *Finder) populateUnset() {
 	if f.ApiWriteTimeout == 0 {
 		f.ApiWriteTimeout = def.ApiWriteTimeout
 	}
+	if f.CacheMaxAge == 0 {
+		f.CacheMaxAge = def.CacheMaxAge
+	}
+	if f.CacheSize == 0 {
+		f.CacheSize = def.CacheSize
+	}
+	if f.MaxBatchSize == 0 {
+		f.MaxBatchSize = def.MaxBatchSize
+	}
 	if f.MaxConnections == 0 {
 		f.MaxConnections = def.MaxConnections
 	}
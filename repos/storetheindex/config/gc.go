@@ -0,0 +1,34 @@
+package config
+
+// GC configures garbage collection run by the indexer daemon. This removes
+// multihashes for removed context IDs and deleted providers from a local
+// value store, such as pebble, that cannot be accessed by a separate GC
+// process while the daemon is running.
+type GC struct {
+	// DeleteNotFound removes all index content for providers that are no
+	// longer in the registry.
+	DeleteNotFound bool
+	// Interval is the time between GC runs. A value of zero disables GC in the
+	// daemon.
+	Interval Duration
+	// RemoveRate is the maximum number of multihashes per second that GC
+	// removes from the value store, so that GC does not starve find requests.
+	// A value of zero sets the default and a negative value means there is no
+	// limit.
+	RemoveRate int
+}
+
+// NewGC returns GC with values set to their defaults.
+func NewGC() GC {
+	return GC{
+		RemoveRate: 10_000,
+	}
+}
+
+// populateUnset replaces zero-values in the config with default values.
+func (c *GC) populateUnset() {
+	def := NewGC()
+	if c.RemoveRate == 0 {
+		c.RemoveRate = def.RemoveRate
+	}
+}
@@ -27,10 +27,12 @@ func InitWithIdentity(identity Identity) (*Config, error) {
 		Datastore: NewDatastore(),
 		Discovery: NewDiscovery(),
 		Finder:    NewFinder(),
+		GC:        NewGC(),
 		Identity:  identity,
 		Indexer:   NewIndexer(),
 		Ingest:    NewIngest(),
 		Logging:   NewLogging(),
+		Retention: NewRetention(),
 	}
 
 	return conf, nil
@@ -13,7 +13,12 @@ type Mirror struct {
 	Read bool
 	// Write specifies to write advertisement content to the Storage mirror.
 	Write bool
-	// Compress specifies how to compress files. One of: "gzip", "none".
+	// Publish serves the advertisement chains in the Retrieval mirror from the
+	// find server, using the ipnisync HTTP protocol. Each provider's chain is
+	// published at /mirror/<provider-id>/ so that other indexers can sync it
+	// from this indexer. Requires Read.
+	Publish bool
+	// Compress specifies how to compress files. One of: "gzip", "zstd", "none".
 	// Defaults to "gzip" if unspecified.
 	Compress string
 	// Retrieval configures the backing file store for mirror read operations.
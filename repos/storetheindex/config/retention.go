@@ -0,0 +1,65 @@
+package config
+
+import (
+	"time"
+)
+
+// Retention configures eviction of index content when storage usage gets too
+// high, as an alternative to freezing the indexer. When usage reaches
+// HighWaterPercent, content is evicted until usage drops below
+// LowWaterPercent. If usage still reaches Indexer.FreezeAtPercent, then the
+// indexer is frozen.
+type Retention struct {
+	// BatchSize is the number of providers, or context IDs, that are evicted
+	// each time usage is checked while above the low-water mark. A value of
+	// zero evicts one provider or 1000 context IDs.
+	BatchSize int
+	// CheckInterval is the time between storage usage checks. A pebble value
+	// store is compacted after each batch is evicted, and the next check is
+	// an interval after compaction finishes. A value of zero sets the
+	// default.
+	CheckInterval Duration
+	// Enable turns on retention-based eviction.
+	Enable bool
+	// HighWaterPercent is the percent of storage used at which eviction
+	// starts. A value of zero sets the default.
+	HighWaterPercent float64
+	// LowWaterPercent is the percent of storage used below which eviction
+	// stops. A value of zero sets the default.
+	LowWaterPercent float64
+	// Protect is a list of provider IDs whose content is never evicted.
+	Protect []string
+	// Strategy selects what is evicted. The "provider" strategy evicts all
+	// content of the providers that have gone the longest without publishing
+	// an advertisement. The "context" strategy evicts the context IDs that
+	// were first indexed longest ago, from any provider. Defaults to
+	// "provider".
+	Strategy string
+}
+
+// NewRetention returns Retention with values set to their defaults.
+func NewRetention() Retention {
+	return Retention{
+		CheckInterval:    Duration(time.Minute),
+		HighWaterPercent: 80.0,
+		LowWaterPercent:  70.0,
+		Strategy:         "provider",
+	}
+}
+
+// populateUnset replaces zero-values in the config with default values.
+func (c *Retention) populateUnset() {
+	def := NewRetention()
+	if c.CheckInterval == 0 {
+		c.CheckInterval = def.CheckInterval
+	}
+	if c.HighWaterPercent == 0 {
+		c.HighWaterPercent = def.HighWaterPercent
+	}
+	if c.LowWaterPercent == 0 {
+		c.LowWaterPercent = def.LowWaterPercent
+	}
+	if c.Strategy == "" {
+		c.Strategy = def.Strategy
+	}
+}
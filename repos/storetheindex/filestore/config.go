@@ -7,12 +7,15 @@ import (
 
 // Config configures a particular file store implementation.
 type Config struct {
-	// Type is the type of file store to use: "", "local", "s3"
+	// Type is the type of file store to use: "", "local", "s3", "tiered"
 	Type string
 	// Local configures storing files in local filesystem.
 	Local LocalConfig
 	// S3 configures storing files in S3.
 	S3 S3Config
+	// Tiered configures storing files in local filesystem and uploading them
+	// to S3 in the background. The S3 storage is configured by S3.
+	Tiered TieredConfig
 }
 
 type LocalConfig struct {
@@ -20,6 +23,18 @@ type LocalConfig struct {
 	BasePath string
 }
 
+type TieredConfig struct {
+	// BasePath is the filesystem directory where files are stored before they
+	// are uploaded, and where uploaded files are cached.
+	BasePath string
+	// MaxLocalSize is the maximum number of bytes of uploaded files to keep in
+	// BasePath. A value of zero means there is no limit.
+	MaxLocalSize int64
+	// UploadConcurrency is the number of files uploaded concurrently. A value
+	// of zero uses the default.
+	UploadConcurrency int
+}
+
 type S3Config struct {
 	BucketName string
 
@@ -34,7 +49,9 @@ type S3Config struct {
 	SecretKey string
 }
 
-// MakeFilestore creates a new storage system of the configured type.
+// MakeFilestore creates a new storage system of the configured type. A tiered
+// file store is created once for each base path, and is returned again by
+// later calls with the same config.
 func MakeFilestore(cfg Config) (Interface, error) {
 	switch cfg.Type {
 	case "local":
@@ -45,6 +62,11 @@ func MakeFilestore(cfg Config) (Interface, error) {
 			WithRegion(cfg.S3.Region),
 			WithKeys(cfg.S3.AccessKey, cfg.S3.SecretKey),
 		)
+	case "tiered":
+		// All file stores with the same tiered base path share one Tiered
+		// file store, which is the only one that uploads and evicts files in
+		// that path.
+		return openSharedTiered(cfg)
 	case "":
 		return nil, errors.New("storage type not defined")
 	case "none":
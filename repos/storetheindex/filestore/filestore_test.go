@@ -2,13 +2,17 @@ package filestore_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/ipni/storetheindex/filestore"
 	"github.com/ipni/storetheindex/fsutil"
@@ -32,11 +36,16 @@ const (
 )
 
 func TestS3(t *testing.T) {
-	const bucketName = "testbucket"
+	const (
+		bucketName       = "testbucket"
+		tieredBucketName = "tieredbucket"
+	)
 
 	tempDir := t.TempDir()
 	err := os.MkdirAll(filepath.Join(tempDir, bucketName), 0755)
 	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(tempDir, tieredBucketName), 0755)
+	require.NoError(t, err)
 
 	p := localstack.Preset(
 		localstack.WithServices(localstack.S3),
@@ -76,6 +85,28 @@ func TestS3(t *testing.T) {
 	t.Run("test-S3-Delete", func(t *testing.T) {
 		testDelete(t, fileStore)
 	})
+
+	t.Run("test-Tiered-S3", func(t *testing.T) {
+		remote, err := filestore.NewS3(tieredBucketName,
+			filestore.WithEndpoint(fmt.Sprintf("http://%s/", localS3.Address(localstack.APIPort))),
+			filestore.WithKeys("abcd1234", "1qaz2wsx"))
+		require.NoError(t, err)
+		tiered, err := filestore.NewTiered(t.TempDir(), remote)
+		require.NoError(t, err)
+		defer tiered.Close()
+
+		testPut(t, tiered)
+		testHead(t, tiered)
+		testGet(t, tiered)
+		testList(t, tiered)
+
+		ctx := context.Background()
+		require.NoError(t, tiered.Flush(ctx))
+		_, err = remote.Head(ctx, fileName3)
+		require.NoError(t, err)
+
+		testDelete(t, tiered)
+	})
 }
 
 func TestLocal(t *testing.T) {
@@ -108,6 +139,162 @@ func TestLocal(t *testing.T) {
 	})
 }
 
+func TestTiered(t *testing.T) {
+	remote, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	fileStore, err := filestore.NewTiered(t.TempDir(), remote)
+	require.NoError(t, err)
+	defer fileStore.Close()
+	require.Equal(t, "tiered", fileStore.Type())
+
+	t.Run("test-Tiered-Put", func(t *testing.T) {
+		testPut(t, fileStore)
+	})
+
+	t.Run("test-Tiered-Head", func(t *testing.T) {
+		testHead(t, fileStore)
+	})
+
+	t.Run("test-Tiered-Get", func(t *testing.T) {
+		testGet(t, fileStore)
+	})
+
+	t.Run("test-Tiered-List", func(t *testing.T) {
+		testList(t, fileStore)
+	})
+
+	// Uploaded files are in remote storage.
+	ctx := context.Background()
+	require.NoError(t, fileStore.Flush(ctx))
+	fileInfo, err := remote.Head(ctx, fileName3)
+	require.NoError(t, err)
+	require.Equal(t, int64(len(data3)), fileInfo.Size)
+
+	t.Run("test-Tiered-Delete", func(t *testing.T) {
+		testDelete(t, fileStore)
+	})
+
+	_, err = remote.Head(ctx, fileName3)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// failingStore is a file store that fails to put files while failing is set.
+type failingStore struct {
+	filestore.Interface
+	failing atomic.Bool
+}
+
+func (f *failingStore) Put(ctx context.Context, path string, r io.Reader) (*filestore.File, error) {
+	if f.failing.Load() {
+		return nil, errors.New("remote unavailable")
+	}
+	return f.Interface.Put(ctx, path, r)
+}
+
+func TestTieredUploadRetryAndEvict(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	local, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	remote := &failingStore{Interface: local}
+	remote.failing.Store(true)
+
+	// Local storage holds only 2 files once uploaded.
+	maxSize := int64(2 * len(data))
+	fileStore, err := filestore.NewTiered(localDir, remote,
+		filestore.WithMaxLocalSize(maxSize),
+		filestore.WithRetryDelay(10*time.Millisecond))
+	require.NoError(t, err)
+
+	names := []string{fileName, fileName1, fileName2}
+	for _, name := range names {
+		_, err = fileStore.Put(ctx, name, strings.NewReader(data))
+		require.NoError(t, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.Equal(t, 3, fileStore.Pending())
+
+	// Queued uploads survive restart.
+	require.NoError(t, fileStore.Close())
+	fileStore, err = filestore.NewTiered(localDir, remote,
+		filestore.WithMaxLocalSize(maxSize),
+		filestore.WithRetryDelay(10*time.Millisecond))
+	require.NoError(t, err)
+	defer fileStore.Close()
+	require.Equal(t, 3, fileStore.Pending())
+
+	// Files are readable before being uploaded.
+	_, r, err := fileStore.Get(ctx, fileName1)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	remote.failing.Store(false)
+	flushCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, fileStore.Flush(flushCtx))
+
+	// All files uploaded, and one local copy evicted.
+	var localCount int
+	for _, name := range names {
+		_, err = remote.Head(ctx, name)
+		require.NoError(t, err)
+		if fsutil.FileExists(filepath.Join(localDir, "files", name)) {
+			localCount++
+		}
+	}
+	require.Equal(t, 2, localCount)
+
+	// Evicted files are read from remote storage.
+	for _, name := range names {
+		_, r, err = fileStore.Get(ctx, name)
+		require.NoError(t, err)
+		buf, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, data, string(buf))
+	}
+}
+
+func TestTieredConcurrentPutEvict(t *testing.T) {
+	ctx := context.Background()
+	remote, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	// Local storage holds only one uploaded file, so files are evicted while
+	// new versions are put.
+	fileStore, err := filestore.NewTiered(t.TempDir(), remote,
+		filestore.WithMaxLocalSize(int64(len(data))))
+	require.NoError(t, err)
+	defer fileStore.Close()
+
+	names := []string{fileName, fileName1, fileName2}
+	var wg sync.WaitGroup
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				_, err := fileStore.Put(ctx, name, strings.NewReader(data))
+				require.NoError(t, err)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	flushCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	require.NoError(t, fileStore.Flush(flushCtx))
+
+	// The last version of every file is uploaded.
+	for _, name := range names {
+		_, r, err := remote.Get(ctx, name)
+		require.NoError(t, err)
+		buf, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, data, string(buf))
+	}
+}
+
 func TestMakeFilestore(t *testing.T) {
 	cfg := filestore.Config{
 		Type: "none",
@@ -134,6 +321,69 @@ func TestMakeFilestore(t *testing.T) {
 	require.NotNil(t, fs)
 }
 
+func TestMakeFilestoreTieredShared(t *testing.T) {
+	cfg := filestore.Config{
+		Type: "tiered",
+		S3: filestore.S3Config{
+			BucketName: "testbucket",
+			Region:     "us-east-1",
+		},
+		Tiered: filestore.TieredConfig{
+			BasePath:     t.TempDir(),
+			MaxLocalSize: 1024,
+		},
+	}
+	fs1, err := filestore.MakeFilestore(cfg)
+	require.NoError(t, err)
+	tiered, ok := fs1.(*filestore.Tiered)
+	require.True(t, ok)
+
+	// Same config returns the same file store.
+	fs2, err := filestore.MakeFilestore(cfg)
+	require.NoError(t, err)
+	require.Same(t, fs1, fs2)
+
+	// Different config with same base path is an error.
+	cfg2 := cfg
+	cfg2.Tiered.MaxLocalSize = 2048
+	_, err = filestore.MakeFilestore(cfg2)
+	require.ErrorContains(t, err, "different config")
+
+	// After closing, a new file store is created.
+	require.NoError(t, tiered.Close())
+	fs3, err := filestore.MakeFilestore(cfg2)
+	require.NoError(t, err)
+	require.NotSame(t, fs1, fs3)
+	require.NoError(t, fs3.(*filestore.Tiered).Close())
+}
+
+func TestTieredQueuedMissingFile(t *testing.T) {
+	ctx := context.Background()
+	localDir := t.TempDir()
+	local, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	remote := &failingStore{Interface: local}
+	remote.failing.Store(true)
+
+	fileStore, err := filestore.NewTiered(localDir, remote)
+	require.NoError(t, err)
+	_, err = fileStore.Put(ctx, fileName, strings.NewReader(data))
+	require.NoError(t, err)
+	require.NoError(t, fileStore.Close())
+
+	// Simulate stopping after the file was queued, but before it was moved
+	// into place.
+	require.NoError(t, os.Remove(filepath.Join(localDir, "files", fileName)))
+
+	fileStore, err = filestore.NewTiered(localDir, remote)
+	require.NoError(t, err)
+	defer fileStore.Close()
+	require.Zero(t, fileStore.Pending())
+
+	_, err = fileStore.Head(ctx, fileName)
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
 func testPut(t *testing.T, fileStore filestore.Interface) {
 	fileInfo, err := fileStore.Put(context.Background(), fileName, strings.NewReader(data))
 	require.NoError(t, err)
@@ -1,7 +1,9 @@
 package filestore
 
 import (
+	"errors"
 	"fmt"
+	"time"
 )
 
 type s3Config struct {
@@ -44,3 +46,62 @@ func WithKeys(accessKey, secretKey string) S3Option {
 		return nil
 	}
 }
+
+const (
+	defaultRetryDelay        = time.Second
+	defaultUploadConcurrency = 4
+)
+
+type tieredConfig struct {
+	maxLocalSize      int64
+	retryDelay        time.Duration
+	uploadConcurrency int
+}
+
+type TieredOption func(*tieredConfig) error
+
+func getTieredOpts(opts []TieredOption) (tieredConfig, error) {
+	cfg := tieredConfig{
+		retryDelay:        defaultRetryDelay,
+		uploadConcurrency: defaultUploadConcurrency,
+	}
+	for i, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return tieredConfig{}, fmt.Errorf("option %d error: %s", i, err)
+		}
+	}
+	return cfg, nil
+}
+
+// WithMaxLocalSize sets the maximum number of bytes of uploaded files to keep
+// in local storage. Files waiting to be uploaded are never evicted, so local
+// storage may exceed this size while uploads are pending. A value of zero
+// means there is no limit.
+func WithMaxLocalSize(size int64) TieredOption {
+	return func(c *tieredConfig) error {
+		c.maxLocalSize = size
+		return nil
+	}
+}
+
+// WithRetryDelay sets the delay before retrying the first failed upload of a
+// file. The delay doubles for each subsequent failure, up to five minutes.
+func WithRetryDelay(d time.Duration) TieredOption {
+	return func(c *tieredConfig) error {
+		if d <= 0 {
+			return errors.New("retry delay must be greater than zero")
+		}
+		c.retryDelay = d
+		return nil
+	}
+}
+
+// WithUploadConcurrency sets the number of files uploaded concurrently.
+func WithUploadConcurrency(n int) TieredOption {
+	return func(c *tieredConfig) error {
+		if n > 0 {
+			c.uploadConcurrency = n
+		}
+		return nil
+	}
+}
@@ -0,0 +1,732 @@
+package filestore
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-log/v2"
+)
+
+var tieredLogger = log.Logger("filestore/tiered")
+
+var (
+	// sharedTiered holds the Tiered file stores created by MakeFilestore, by
+	// base path. Independent Tiered file stores using the same base path would
+	// each upload and evict the same files, so only one is created for each
+	// base path.
+	sharedTiered      = make(map[string]*tieredShare)
+	sharedTieredMutex sync.Mutex
+)
+
+type tieredShare struct {
+	cfg    Config
+	tiered *Tiered
+}
+
+const (
+	tieredFilesDir = "files"
+	tieredQueueDir = "upload-queue"
+
+	maxRetryDelay = 5 * time.Minute
+)
+
+// Tiered is a file store that writes files to the local file system and then
+// uploads them to a remote file store, such as S3, in the background. Files
+// waiting to be uploaded are recorded in a durable queue so that uploads
+// resume after a restart, and failed uploads are retried. Files are read from
+// local storage if present, and otherwise from the remote file store. Local
+// copies of uploaded files are evicted, least recently used first, to keep
+// local storage under a size limit.
+type Tiered struct {
+	basePath string
+	local    *Local
+	queueDir string
+	remote   Interface
+
+	maxLocalSize int64
+	retryDelay   time.Duration
+
+	mutex sync.Mutex
+	// notify is closed and replaced whenever uploads are queued or finished.
+	notify chan struct{}
+	// pending holds the files waiting to be uploaded.
+	pending map[string]*upload
+	// uploading holds the paths of the files being uploaded. Only one version
+	// of a file is uploaded at a time, so that an older version never
+	// overwrites a newer one in the remote file store.
+	uploading map[string]struct{}
+	// uploaded holds local files that are uploaded and may be evicted, with
+	// the least recently used at the front.
+	uploaded   *list.List
+	uploadedAt map[string]*list.Element
+	localSize  int64
+
+	closing chan struct{}
+	done    sync.WaitGroup
+}
+
+type upload struct {
+	attempts int
+	next     time.Time
+	size     int64
+}
+
+type localFile struct {
+	path string
+	size int64
+}
+
+// NewTiered creates a Tiered file store that keeps local files in basePath and
+// uploads them to the remote file store. Uploads that were queued when the
+// file store was last used are resumed.
+func NewTiered(basePath string, remote Interface, options ...TieredOption) (*Tiered, error) {
+	if remote == nil {
+		return nil, errors.New("tiered filestore requires remote file store")
+	}
+	opts, err := getTieredOpts(options)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(basePath) {
+		return nil, errors.New("base path must be absolute")
+	}
+	local, err := NewLocal(filepath.Join(basePath, tieredFilesDir))
+	if err != nil {
+		return nil, err
+	}
+	queueDir := filepath.Join(basePath, tieredQueueDir)
+	if err = os.MkdirAll(queueDir, 0755); err != nil {
+		return nil, err
+	}
+
+	t := &Tiered{
+		basePath:     filepath.Clean(basePath),
+		local:        local,
+		queueDir:     queueDir,
+		remote:       remote,
+		maxLocalSize: opts.maxLocalSize,
+		retryDelay:   opts.retryDelay,
+		notify:       make(chan struct{}),
+		pending:      make(map[string]*upload),
+		uploading:    make(map[string]struct{}),
+		uploaded:     list.New(),
+		uploadedAt:   make(map[string]*list.Element),
+		closing:      make(chan struct{}),
+	}
+	if err = t.loadQueue(); err != nil {
+		return nil, fmt.Errorf("cannot load upload queue: %w", err)
+	}
+	if err = t.loadLocal(); err != nil {
+		return nil, fmt.Errorf("cannot read local files: %w", err)
+	}
+	t.evict()
+
+	for i := 0; i < opts.uploadConcurrency; i++ {
+		t.done.Add(1)
+		go t.uploader()
+	}
+	return t, nil
+}
+
+// Close stops uploading files. Files that are not yet uploaded remain queued
+// and are uploaded when the file store is used again.
+func (t *Tiered) Close() error {
+	sharedTieredMutex.Lock()
+	if share, ok := sharedTiered[t.basePath]; ok && share.tiered == t {
+		delete(sharedTiered, t.basePath)
+	}
+	sharedTieredMutex.Unlock()
+
+	close(t.closing)
+	t.done.Wait()
+	return nil
+}
+
+// openSharedTiered returns the Tiered file store for the base path in cfg,
+// creating it if it is not already open. Returns an error if the open file
+// store was created with a different config.
+func openSharedTiered(cfg Config) (*Tiered, error) {
+	basePath := filepath.Clean(cfg.Tiered.BasePath)
+
+	sharedTieredMutex.Lock()
+	defer sharedTieredMutex.Unlock()
+
+	if share, ok := sharedTiered[basePath]; ok {
+		if share.cfg != cfg {
+			return nil, fmt.Errorf("tiered file store with base path %s is already open with a different config", basePath)
+		}
+		return share.tiered, nil
+	}
+
+	s3, err := NewS3(cfg.S3.BucketName,
+		WithEndpoint(cfg.S3.Endpoint),
+		WithRegion(cfg.S3.Region),
+		WithKeys(cfg.S3.AccessKey, cfg.S3.SecretKey),
+	)
+	if err != nil {
+		return nil, err
+	}
+	t, err := NewTiered(basePath, s3,
+		WithMaxLocalSize(cfg.Tiered.MaxLocalSize),
+		WithUploadConcurrency(cfg.Tiered.UploadConcurrency),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sharedTiered[basePath] = &tieredShare{
+		cfg:    cfg,
+		tiered: t,
+	}
+	return t, nil
+}
+
+// Flush waits until all queued files are uploaded, or until the context is
+// canceled.
+func (t *Tiered) Flush(ctx context.Context) error {
+	for {
+		t.mutex.Lock()
+		if len(t.pending) == 0 {
+			t.mutex.Unlock()
+			return nil
+		}
+		notify := t.notify
+		t.mutex.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Pending returns the number of files waiting to be uploaded.
+func (t *Tiered) Pending() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.pending)
+}
+
+func (t *Tiered) Delete(ctx context.Context, relPath string) error {
+	t.mutex.Lock()
+	if up, ok := t.pending[relPath]; ok {
+		delete(t.pending, relPath)
+		t.localSize -= up.size
+		if err := t.removeQueued(relPath); err != nil {
+			t.mutex.Unlock()
+			return err
+		}
+	}
+	t.forget(relPath)
+	err := t.local.Delete(ctx, relPath)
+	t.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	return t.remote.Delete(ctx, relPath)
+}
+
+func (t *Tiered) Get(ctx context.Context, relPath string) (*File, io.ReadCloser, error) {
+	file, rc, err := t.local.Get(ctx, relPath)
+	if err == nil {
+		t.touch(relPath)
+		return file, rc, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		tieredLogger.Errorw("Cannot read local file, reading from remote", "err", err, "path", relPath)
+	}
+	return t.remote.Get(ctx, relPath)
+}
+
+func (t *Tiered) Head(ctx context.Context, relPath string) (*File, error) {
+	file, err := t.local.Head(ctx, relPath)
+	if err == nil {
+		return file, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		tieredLogger.Errorw("Cannot stat local file, checking remote", "err", err, "path", relPath)
+	}
+	return t.remote.Head(ctx, relPath)
+}
+
+// List lists the files in the remote file store along with any files that are
+// waiting to be uploaded.
+func (t *Tiered) List(ctx context.Context, relPath string, recursive bool) (<-chan *File, <-chan error) {
+	fc := make(chan *File)
+	ec := make(chan error, 1)
+
+	pendingFiles := t.listPending(ctx, relPath, recursive)
+	remoteFiles, remoteErrs := t.remote.List(ctx, relPath, recursive)
+
+	go func() {
+		defer close(fc)
+		defer close(ec)
+
+		send := func(file *File) bool {
+			select {
+			case fc <- file:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		// Merge the sorted pending and remote files, preferring information
+		// about pending files since the remote may have an older version.
+		for remoteFile := range remoteFiles {
+			for len(pendingFiles) != 0 && pendingFiles[0].Path <= remoteFile.Path {
+				if !send(pendingFiles[0]) {
+					ec <- ctx.Err()
+					return
+				}
+				same := pendingFiles[0].Path == remoteFile.Path
+				pendingFiles = pendingFiles[1:]
+				if same {
+					remoteFile = nil
+					break
+				}
+			}
+			if remoteFile != nil && !send(remoteFile) {
+				ec <- ctx.Err()
+				return
+			}
+		}
+		if err := <-remoteErrs; err != nil {
+			ec <- err
+			return
+		}
+		for _, file := range pendingFiles {
+			if !send(file) {
+				ec <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	return fc, ec
+}
+
+func (t *Tiered) Put(ctx context.Context, relPath string, reader io.Reader) (*File, error) {
+	tmpName, err := t.writeTemp(reader)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpName)
+
+	// Queue the file and move it into place while holding the lock, so that
+	// evict cannot remove the new file as the local copy of a previous
+	// version that was already uploaded.
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// The queue entry is durable before the file is moved into place. If the
+	// indexer stops in between, the queue entry is for a previous version of
+	// the file, which is uploaded again, or for a missing file, which is
+	// removed from the queue when the queue is loaded.
+	prev, wasPending := t.pending[relPath]
+	if !wasPending {
+		if err = t.writeQueued(relPath); err != nil {
+			return nil, fmt.Errorf("cannot queue file for upload: %w", err)
+		}
+	}
+
+	file, err := t.moveLocal(tmpName, relPath)
+	if err != nil {
+		if !wasPending {
+			t.removeQueued(relPath)
+		}
+		return nil, err
+	}
+
+	// Forget any previous version of the file.
+	if wasPending {
+		t.localSize -= prev.size
+	}
+	t.forget(relPath)
+
+	t.pending[relPath] = &upload{
+		size: file.Size,
+	}
+	t.localSize += file.Size
+	t.notifyChange()
+	return file, nil
+}
+
+// writeTemp writes the file data to a temporary file, and returns the name of
+// the temporary file.
+func (t *Tiered) writeTemp(reader io.Reader) (string, error) {
+	tmpFile, err := os.CreateTemp(t.queueDir, "put-*.tmp")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmpFile.Name()
+
+	if reader != nil {
+		if _, err = io.Copy(tmpFile, reader); err != nil {
+			tmpFile.Close()
+			os.Remove(tmpName)
+			return "", err
+		}
+	}
+	if err = tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return "", err
+	}
+	if err = tmpFile.Close(); err != nil {
+		os.Remove(tmpName)
+		return "", err
+	}
+	return tmpName, nil
+}
+
+// moveLocal renames the temporary file to the local file, so that an upload
+// reading a previous version of the file is not affected. The caller must hold
+// the mutex.
+func (t *Tiered) moveLocal(tmpName, relPath string) (*File, error) {
+	absPath := filepath.Join(t.local.basePath, filepath.FromSlash(relPath))
+	if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpName, absPath); err != nil {
+		return nil, err
+	}
+	if err := syncDir(filepath.Dir(absPath)); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		Modified: fi.ModTime(),
+		Path:     relPath,
+		Size:     fi.Size(),
+	}, nil
+}
+
+func (t *Tiered) Type() string {
+	return "tiered"
+}
+
+func (t *Tiered) uploader() {
+	defer t.done.Done()
+
+	for {
+		relPath, up, wait, notify := t.nextUpload()
+		if up == nil {
+			var timer *time.Timer
+			var timeout <-chan time.Time
+			if wait != 0 {
+				timer = time.NewTimer(wait)
+				timeout = timer.C
+			}
+			select {
+			case <-notify:
+			case <-timeout:
+			case <-t.closing:
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			select {
+			case <-t.closing:
+				return
+			default:
+			}
+			continue
+		}
+		t.upload(relPath, up)
+	}
+}
+
+// nextUpload returns a file that is ready to upload. If there is no file
+// ready, then it returns the time to wait until a file is ready for retry, and
+// a channel that is closed when the uploads change.
+func (t *Tiered) nextUpload() (string, *upload, time.Duration, <-chan struct{}) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for relPath, up := range t.pending {
+		if _, ok := t.uploading[relPath]; ok {
+			continue
+		}
+		if !up.next.After(now) {
+			t.uploading[relPath] = struct{}{}
+			return relPath, up, 0, nil
+		}
+		if d := up.next.Sub(now); wait == 0 || d < wait {
+			wait = d
+		}
+	}
+	return "", nil, wait, t.notify
+}
+
+func (t *Tiered) upload(relPath string, up *upload) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-t.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	_, rc, err := t.local.Get(ctx, relPath)
+	if err == nil {
+		_, err = t.remote.Put(ctx, relPath, rc)
+		rc.Close()
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	defer t.notifyChange()
+	delete(t.uploading, relPath)
+
+	cur, ok := t.pending[relPath]
+	if !ok {
+		// File was deleted while uploading.
+		if err == nil {
+			if err = t.remote.Delete(ctx, relPath); err != nil {
+				tieredLogger.Errorw("Cannot delete uploaded file that was deleted", "err", err, "path", relPath)
+			}
+		}
+		return
+	}
+	if cur != up {
+		// File was replaced while uploading, so upload the new version.
+		return
+	}
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			// Local file is gone, so there is nothing to upload.
+			tieredLogger.Errorw("Queued file missing from local storage", "path", relPath)
+			delete(t.pending, relPath)
+			t.localSize -= up.size
+			t.removeQueued(relPath)
+			return
+		}
+		up.attempts++
+		delay := t.retryDelay << min(up.attempts-1, 16)
+		if delay > maxRetryDelay || delay <= 0 {
+			delay = maxRetryDelay
+		}
+		up.next = time.Now().Add(delay)
+		tieredLogger.Warnw("Failed to upload file, will retry", "err", err, "path", relPath, "attempts", up.attempts, "retryIn", delay.String())
+		return
+	}
+
+	delete(t.pending, relPath)
+	if err = t.removeQueued(relPath); err != nil {
+		tieredLogger.Errorw("Cannot remove file from upload queue", "err", err, "path", relPath)
+	}
+	t.uploadedAt[relPath] = t.uploaded.PushBack(localFile{
+		path: relPath,
+		size: up.size,
+	})
+	tieredLogger.Debugw("Uploaded file", "path", relPath, "size", up.size)
+	t.evict()
+}
+
+// evict removes the least recently used local copies of uploaded files until
+// local storage is under the size limit.
+func (t *Tiered) evict() {
+	if t.maxLocalSize <= 0 {
+		return
+	}
+	for t.localSize > t.maxLocalSize && t.uploaded.Len() != 0 {
+		lf := t.uploaded.Remove(t.uploaded.Front()).(localFile)
+		delete(t.uploadedAt, lf.path)
+		t.localSize -= lf.size
+		if err := t.local.Delete(context.Background(), lf.path); err != nil {
+			tieredLogger.Errorw("Cannot evict local file", "err", err, "path", lf.path)
+		}
+	}
+}
+
+// forget stops tracking a local copy of an uploaded file.
+func (t *Tiered) forget(relPath string) {
+	if elem, ok := t.uploadedAt[relPath]; ok {
+		lf := t.uploaded.Remove(elem).(localFile)
+		delete(t.uploadedAt, relPath)
+		t.localSize -= lf.size
+	}
+}
+
+// touch marks a local copy of an uploaded file as recently used.
+func (t *Tiered) touch(relPath string) {
+	t.mutex.Lock()
+	if elem, ok := t.uploadedAt[relPath]; ok {
+		t.uploaded.MoveToBack(elem)
+	}
+	t.mutex.Unlock()
+}
+
+func (t *Tiered) notifyChange() {
+	close(t.notify)
+	t.notify = make(chan struct{})
+}
+
+func (t *Tiered) listPending(ctx context.Context, relPath string, recursive bool) []*File {
+	prefix := strings.TrimPrefix(relPath, "/")
+
+	t.mutex.Lock()
+	var paths []string
+	for pendPath := range t.pending {
+		rest, ok := strings.CutPrefix(pendPath, prefix)
+		if !ok {
+			continue
+		}
+		if !recursive && pendPath != prefix && strings.Contains(strings.TrimPrefix(rest, "/"), "/") {
+			continue
+		}
+		paths = append(paths, pendPath)
+	}
+	t.mutex.Unlock()
+
+	sort.Strings(paths)
+	files := make([]*File, 0, len(paths))
+	for _, pendPath := range paths {
+		file, err := t.local.Head(ctx, pendPath)
+		if err != nil {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+func (t *Tiered) queuePath(relPath string) string {
+	sum := sha256.Sum256([]byte(relPath))
+	return filepath.Join(t.queueDir, hex.EncodeToString(sum[:]))
+}
+
+// writeQueued durably records that the file is queued for upload. The queue
+// entry is written to a temporary file that is renamed into place, so that a
+// partly written entry is never loaded.
+func (t *Tiered) writeQueued(relPath string) error {
+	tmpFile, err := os.CreateTemp(t.queueDir, "queue-*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmpFile.Name()
+	if _, err = tmpFile.WriteString(relPath); err == nil {
+		err = tmpFile.Sync()
+	}
+	if cerr := tmpFile.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, t.queuePath(relPath))
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return syncDir(t.queueDir)
+}
+
+// syncDir syncs a directory so that renames of files in it are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (t *Tiered) removeQueued(relPath string) error {
+	err := os.Remove(t.queuePath(relPath))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// loadQueue reads the files that are queued for upload.
+func (t *Tiered) loadQueue() error {
+	entries, err := os.ReadDir(t.queueDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			// Incomplete write from before restart.
+			os.Remove(filepath.Join(t.queueDir, entry.Name()))
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(t.queueDir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		relPath := string(data)
+		file, err := t.local.Head(context.Background(), relPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Stopped after queuing file, but before moving it into place.
+				tieredLogger.Warnw("Queued file missing from local storage", "path", relPath)
+				t.removeQueued(relPath)
+				continue
+			}
+			return err
+		}
+		t.pending[relPath] = &upload{
+			size: file.Size,
+		}
+		t.localSize += file.Size
+	}
+	if len(t.pending) != 0 {
+		tieredLogger.Infow("Resuming queued uploads", "count", len(t.pending))
+	}
+	return nil
+}
+
+// loadLocal reads the local files that are already uploaded, least recently
+// modified first.
+func (t *Tiered) loadLocal() error {
+	files, errs := t.local.List(context.Background(), "", true)
+	var uploaded []*File
+	for file := range files {
+		if _, ok := t.pending[file.Path]; ok {
+			continue
+		}
+		uploaded = append(uploaded, file)
+	}
+	if err := <-errs; err != nil {
+		return err
+	}
+	sort.Slice(uploaded, func(i, j int) bool {
+		return uploaded[i].Modified.Before(uploaded[j].Modified)
+	})
+	for _, file := range uploaded {
+		t.uploadedAt[file.Path] = t.uploaded.PushBack(localFile{
+			path: file.Path,
+			size: file.Size,
+		})
+		t.localSize += file.Size
+	}
+	return nil
+}
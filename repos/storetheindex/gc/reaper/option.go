@@ -29,6 +29,7 @@ type config struct {
 	httpTimeout       time.Duration
 	p2pHost           host.Host
 	pcache            *pcache.ProviderCache
+	removeRate        int
 	segmentSize       int
 	syncSegSize       int
 	topic             string
@@ -137,6 +138,16 @@ func WithPCache(pc *pcache.ProviderCache) Option {
 	}
 }
 
+// WithRemoveRate limits the number of multihashes per second that are removed
+// from the indexer, so that GC does not starve other users of the indexer. A
+// value of zero or less means there is no limit.
+func WithRemoveRate(rate int) Option {
+	return func(c *config) error {
+		c.removeRate = rate
+		return nil
+	}
+}
+
 // WithSegmentSize sets the size of the segments that the ad chain is broken
 // into for processing after syncing.
 func WithSegmentSize(size int) Option {
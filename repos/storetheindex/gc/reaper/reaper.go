@@ -178,6 +178,10 @@ func New(idxr indexer.Interface, fileStore filestore.Interface, options ...Optio
 		opts.dstoreTmpDir = os.TempDir()
 	}
 
+	if opts.removeRate > 0 {
+		idxr = newThrottledIndexer(idxr, opts.removeRate)
+	}
+
 	return &Reaper{
 		carDelete:   opts.carDelete,
 		carReader:   carReader,
@@ -321,6 +325,9 @@ func (r *Reaper) datastoreExists(ctx context.Context, providerID peer.ID) (bool,
 		if !errors.Is(err, fs.ErrNotExist) {
 			return false, err
 		}
+		if r.fileStore == nil {
+			return false, nil
+		}
 		_, err = r.fileStore.Head(ctx, ArchiveName(providerID))
 		if err != nil {
 			if !errors.Is(err, fs.ErrNotExist) {
@@ -393,10 +400,12 @@ func (r *Reaper) removeProvider(ctx context.Context, providerID peer.ID) error {
 		dstore.Close()
 
 		// Delete gc-datastore archive.
-		name := ArchiveName(providerID)
-		err = r.fileStore.Delete(ctx, name)
-		if err != nil && !errors.Is(err, fs.ErrNotExist) {
-			log.Errorw("Cannot delete datastore archive for provider", "err", err, "name", name)
+		if r.fileStore != nil {
+			name := ArchiveName(providerID)
+			err = r.fileStore.Delete(ctx, name)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				log.Errorw("Cannot delete datastore archive for provider", "err", err, "name", name)
+			}
 		}
 		// Delete gc-datastore.
 		dstoreDir := filepath.Join(r.dsDir, dstoreDirName(providerID))
@@ -582,14 +591,14 @@ func (r *Reaper) deleteCarFile(ctx context.Context, adCid cid.Cid) (int64, error
 	if r.carReader == nil || !r.carDelete {
 		return 0, nil
 	}
-	carPath := r.carReader.CarPath(adCid)
-	file, err := r.fileStore.Head(ctx, carPath)
+	file, err := r.carReader.FindCar(ctx, adCid)
 	if err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
 			return 0, nil
 		}
 		return 0, err
 	}
+	carPath := file.Path
 	if err = r.fileStore.Delete(ctx, carPath); err != nil {
 		return 0, fmt.Errorf("failed to remove CAR file: %w", err)
 	}
@@ -125,6 +125,46 @@ func TestReaper(t *testing.T) {
 	gc2.Close()
 }
 
+func TestSchedule(t *testing.T) {
+	tmpDir := t.TempDir()
+	fileStore, err := filestore.NewLocal(tmpDir)
+	require.NoError(t, err)
+
+	pc, err := pcache.New(pcache.WithSource(newMockSource(pid1)))
+	require.NoError(t, err)
+
+	gc, err := reaper.New(memory.New(), fileStore,
+		reaper.WithDatastoreDir(filepath.Join(tmpDir, "gcdatastore")),
+		reaper.WithDatastoreTempDir(filepath.Join(tmpDir, "gctmpdata")),
+		reaper.WithPCache(pc),
+		reaper.WithRemoveRate(1000),
+		reaper.WithTopicName(testTopic),
+	)
+	require.NoError(t, err)
+
+	providers := func() []peer.ID {
+		return []peer.ID{pid1, pid3}
+	}
+	sched := reaper.NewSchedule(gc, 100*time.Millisecond, providers)
+	defer sched.Close()
+
+	require.False(t, sched.Progress().NextRun.IsZero())
+	require.Eventually(t, func() bool {
+		return !sched.Progress().RunEnded.IsZero()
+	}, 5*time.Second, 50*time.Millisecond)
+
+	progress := sched.Progress()
+	require.Equal(t, 2, progress.ProvidersTotal)
+	require.Equal(t, 2, progress.ProvidersDone)
+	// Provider pid3 is not found.
+	require.Equal(t, 1, progress.ProvidersFailed)
+	require.Empty(t, progress.Provider)
+
+	pids, err := gc.DatastoreProviders()
+	require.NoError(t, err)
+	require.Equal(t, []peer.ID{pid1}, pids)
+}
+
 func newMockSource(pids ...peer.ID) *mockSource {
 	s := &mockSource{}
 	for _, pid := range pids {
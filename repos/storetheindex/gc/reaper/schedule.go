@@ -0,0 +1,198 @@
+package reaper
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// Progress describes the state of scheduled GC.
+type Progress struct {
+	// Running is true while a GC run is in progress.
+	Running bool
+	// Provider is the provider that GC is currently processing.
+	Provider peer.ID `json:",omitempty"`
+	// ProvidersDone is the number of providers processed in the current or
+	// most recent run, including those that failed.
+	ProvidersDone int
+	// ProvidersFailed is the number of providers that GC failed to process in
+	// the current or most recent run.
+	ProvidersFailed int
+	// ProvidersTotal is the number of providers to process in the current or
+	// most recent run.
+	ProvidersTotal int
+	// RunStarted is the time that the current or most recent run started.
+	RunStarted time.Time
+	// RunEnded is the time that the most recent run ended.
+	RunEnded time.Time
+	// NextRun is the time that the next run is scheduled to start.
+	NextRun time.Time
+	// Stats holds the GC statistics totaled over all runs.
+	Stats GCStats
+}
+
+// Schedule runs GC for all providers at a regular interval.
+type Schedule struct {
+	cancel    context.CancelFunc
+	done      chan struct{}
+	interval  time.Duration
+	mutex     sync.Mutex
+	progress  Progress
+	providers func() []peer.ID
+	reaper    *Reaper
+}
+
+// NewSchedule starts running GC with the reaper, every interval, for the
+// providers returned by the providers function. If the reaper is configured to
+// delete providers that are not found, then GC also runs for providers that
+// have a GC datastore but are not returned by the providers function. The
+// schedule closes the reaper when the schedule is closed.
+func NewSchedule(grim *Reaper, interval time.Duration, providers func() []peer.ID) *Schedule {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Schedule{
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		interval:  interval,
+		providers: providers,
+		reaper:    grim,
+	}
+	s.progress.NextRun = time.Now().Add(interval)
+	go s.run(ctx)
+	return s
+}
+
+// Close stops scheduled GC, canceling any run in progress, and waits for it to
+// stop.
+func (s *Schedule) Close() {
+	s.cancel()
+	<-s.done
+	s.reaper.Close()
+}
+
+// Progress returns the current state of scheduled GC.
+func (s *Schedule) Progress() Progress {
+	s.mutex.Lock()
+	p := s.progress
+	s.mutex.Unlock()
+	p.Stats = s.reaper.Stats()
+	return p
+}
+
+func (s *Schedule) run(ctx context.Context) {
+	defer close(s.done)
+
+	timer := time.NewTimer(s.interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			s.runGC(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			timer.Reset(s.interval)
+			s.setNextRun()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *Schedule) setNextRun() {
+	s.mutex.Lock()
+	s.progress.NextRun = time.Now().Add(s.interval)
+	s.mutex.Unlock()
+}
+
+func (s *Schedule) runGC(ctx context.Context) {
+	pids := s.providers()
+	if s.reaper.delNotFound {
+		dsPids, err := s.reaper.DatastoreProviders()
+		if err != nil {
+			log.Errorw("Cannot list providers with gc datastores", "err", err)
+		}
+		pidSet := make(map[peer.ID]struct{}, len(pids))
+		for _, pid := range pids {
+			pidSet[pid] = struct{}{}
+		}
+		for _, pid := range dsPids {
+			if _, ok := pidSet[pid]; !ok {
+				pids = append(pids, pid)
+			}
+		}
+	}
+
+	s.mutex.Lock()
+	s.progress = Progress{
+		Running:        true,
+		ProvidersTotal: len(pids),
+		RunStarted:     time.Now(),
+		RunEnded:       s.progress.RunEnded,
+	}
+	s.mutex.Unlock()
+
+	log.Infow("Starting GC for all providers", "count", len(pids))
+	var failed int
+	for i, pid := range pids {
+		s.mutex.Lock()
+		s.progress.Provider = pid
+		s.progress.ProvidersDone = i
+		s.progress.ProvidersFailed = failed
+		s.mutex.Unlock()
+
+		err := s.reaper.Reap(ctx, pid)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				log.Infow("GC shutdown while processing provider", "provider", pid)
+				break
+			}
+			log.Errorw("Failed GC for provider", "err", err, "provider", pid)
+			failed++
+		}
+	}
+
+	s.mutex.Lock()
+	s.progress.Running = false
+	s.progress.Provider = ""
+	s.progress.ProvidersFailed = failed
+	if ctx.Err() == nil {
+		s.progress.ProvidersDone = len(pids)
+	}
+	s.progress.RunEnded = time.Now()
+	elapsed := s.progress.RunEnded.Sub(s.progress.RunStarted)
+	s.mutex.Unlock()
+
+	log.Infow("Finished GC for all providers", "success", len(pids)-failed, "fail", failed, "elapsed", elapsed.String(), "stats", s.reaper.Stats().String())
+}
+
+// DatastoreProviders returns the IDs of providers that have a GC datastore in
+// the datastore directory.
+func (r *Reaper) DatastoreProviders() ([]peer.ID, error) {
+	entries, err := os.ReadDir(r.dsDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	prefix := dstoreDirName("")
+	var pids []peer.ID
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), prefix)
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		pid, err := peer.Decode(name)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
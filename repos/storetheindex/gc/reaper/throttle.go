@@ -0,0 +1,48 @@
+package reaper
+
+import (
+	"sync"
+	"time"
+
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/multiformats/go-multihash"
+)
+
+// throttledIndexer is an indexer.Interface that limits the rate at which
+// multihashes are removed.
+type throttledIndexer struct {
+	indexer.Interface
+
+	mutex sync.Mutex
+	next  time.Time
+	rate  int
+}
+
+func newThrottledIndexer(idxr indexer.Interface, rate int) *throttledIndexer {
+	return &throttledIndexer{
+		Interface: idxr,
+		rate:      rate,
+	}
+}
+
+func (t *throttledIndexer) Remove(value indexer.Value, mhs ...multihash.Multihash) error {
+	t.wait(len(mhs))
+	return t.Interface.Remove(value, mhs...)
+}
+
+// wait blocks until the previously removed multihashes are within the rate
+// limit, and reserves the time needed to remove count more multihashes.
+func (t *throttledIndexer) wait(count int) {
+	t.mutex.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	delay := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(count) * time.Second / time.Duration(t.rate))
+	t.mutex.Unlock()
+
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
@@ -0,0 +1,758 @@
+// Package backup writes and restores an archive of the indexer state. The
+// archive holds a snapshot of the datastore, optionally a snapshot of the
+// temporary datastore, a snapshot of the value store, and optionally the
+// indexer config.
+//
+// A value store that implements Checkpointer, which the pebble value store
+// does, is archived as the files of a checkpoint, and is restored by writing
+// those files into an empty value store directory. Any other local value store
+// is archived as a logical export of its multihashes and values, read through
+// indexer.Iter, and is restored by putting them into the target value store.
+//
+// The archive is a stream of length-prefixed records following a header and
+// manifest, and ends with a record that holds the number of records written.
+// This allows an archive to be written while the indexer is running, without
+// knowing the size of the snapshots in advance, and allows a truncated archive
+// to be detected.
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// Version is the version of the archive format. Version 2 added value store
+// checkpoint files.
+const Version = 2
+
+const (
+	recEnd byte = iota
+	recConfig
+	recDatastore
+	recTmpDatastore
+	recValues
+	recValueStoreFile
+)
+
+const (
+	// checkpointChunkSize is the size of the chunks that a checkpoint file is
+	// split into, each written as a separate record.
+	checkpointChunkSize = 4 << 20
+	// maxFieldSize is the maximum size of a single field in a record.
+	maxFieldSize = 64 << 20
+	// restoreBatchSize is the number of datastore keys or multihashes that
+	// are written at once when restoring.
+	restoreBatchSize = 4096
+)
+
+var magic = []byte("ipni-indexer-backup\n")
+
+// ErrTruncated is returned when an archive ends before its end record.
+var ErrTruncated = errors.New("backup archive is truncated")
+
+// Manifest describes the contents of an archive.
+type Manifest struct {
+	// Version is the archive format version.
+	Version int
+	// Created is when the archive was created.
+	Created time.Time
+	// PeerID is the peer ID of the indexer that the archive was created from.
+	PeerID string
+	// ValueStoreType is the type of value store the archive was created from.
+	ValueStoreType string
+	// Config is true if the archive contains the indexer config.
+	Config bool
+	// Identity is true if the config in the archive contains the indexer's
+	// private key.
+	Identity bool
+	// TmpDatastore is true if the archive contains the temporary datastore.
+	TmpDatastore bool
+	// ValueStore is true if the archive contains the value store. This is
+	// false if the value store is not local to the indexer.
+	ValueStore bool
+	// Checkpoint is true if the value store is archived as the files of a
+	// checkpoint, instead of as multihashes and values.
+	Checkpoint bool `json:",omitempty"`
+}
+
+// Stats counts the data written to or restored from an archive.
+type Stats struct {
+	DatastoreKeys    int
+	TmpDatastoreKeys int
+	Multihashes      int
+	// CheckpointFiles is the number of value store checkpoint files.
+	CheckpointFiles int
+	// CheckpointBytes is the total size of value store checkpoint files.
+	CheckpointBytes int64
+}
+
+// Checkpointer is a value store that writes a consistent copy of its files to
+// a directory, which must not already exist.
+type Checkpointer interface {
+	Checkpoint(dir string) error
+}
+
+// Source is the indexer state to write to an archive.
+type Source struct {
+	// Config is the encoded indexer config. Not written if nil.
+	Config []byte
+	// Identity is true if Config contains the private key.
+	Identity bool
+	// Datastore is the indexer datastore.
+	Datastore datastore.Datastore
+	// TmpDatastore is the temporary datastore. Not written if nil.
+	TmpDatastore datastore.Datastore
+	// Indexer is the value store. Not written if nil.
+	Indexer indexer.Interface
+	// PeerID is the peer ID of the indexer.
+	PeerID peer.ID
+	// ValueStoreType is the type of the value store.
+	ValueStoreType string
+	// TempDir is the directory that a value store checkpoint is written to
+	// while it is archived. The checkpoint is quickest to write and takes the
+	// least space if TempDir is on the same file system as the value store.
+	// The default temporary directory is used if empty.
+	TempDir string
+}
+
+// Target is where the state in an archive is restored to.
+type Target struct {
+	// Datastore is the indexer datastore.
+	Datastore datastore.Batching
+	// TmpDatastore is the temporary datastore. The temporary datastore in the
+	// archive is not restored if nil.
+	TmpDatastore datastore.Batching
+	// Indexer is the value store. The multihashes and values in the archive
+	// are not restored if nil.
+	Indexer indexer.Interface
+	// ValueStoreDir is the directory that value store checkpoint files are
+	// restored into. The directory must be empty or not exist. The checkpoint
+	// files in the archive are not restored if empty.
+	ValueStoreDir string
+}
+
+// Write writes an archive of the state in src to w.
+//
+// The datastore snapshot is taken before the value store snapshot or
+// checkpoint. Values are always stored before an advertisement is marked as
+// processed, so any advertisement marked processed in the archive has its
+// content in the archived value store. Advertisements processed after the
+// datastore snapshot are processed again after restoring.
+func Write(ctx context.Context, w io.Writer, src Source) (Stats, error) {
+	var stats Stats
+
+	checkpointer, _ := src.Indexer.(Checkpointer)
+
+	manifest := Manifest{
+		Version:        Version,
+		Created:        time.Now().UTC(),
+		PeerID:         src.PeerID.String(),
+		ValueStoreType: src.ValueStoreType,
+		Config:         src.Config != nil,
+		Identity:       src.Config != nil && src.Identity,
+		TmpDatastore:   src.TmpDatastore != nil,
+		ValueStore:     src.Indexer != nil,
+		Checkpoint:     checkpointer != nil,
+	}
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return stats, err
+	}
+
+	// Take snapshots of all stores before writing anything.
+	dsResults, err := src.Datastore.Query(ctx, query.Query{})
+	if err != nil {
+		return stats, fmt.Errorf("cannot query datastore: %w", err)
+	}
+	defer dsResults.Close()
+
+	var tmpResults query.Results
+	if src.TmpDatastore != nil {
+		tmpResults, err = src.TmpDatastore.Query(ctx, query.Query{})
+		if err != nil {
+			return stats, fmt.Errorf("cannot query temporary datastore: %w", err)
+		}
+		defer tmpResults.Close()
+	}
+
+	var iter indexer.Iterator
+	var cpDir string
+	if checkpointer != nil {
+		tmpDir, err := os.MkdirTemp(src.TempDir, "backup-checkpoint-")
+		if err != nil {
+			return stats, fmt.Errorf("cannot create checkpoint directory: %w", err)
+		}
+		defer os.RemoveAll(tmpDir)
+		cpDir = filepath.Join(tmpDir, "valuestore")
+		if err = checkpointer.Checkpoint(cpDir); err != nil {
+			return stats, fmt.Errorf("cannot checkpoint value store: %w", err)
+		}
+	} else if src.Indexer != nil {
+		iter, err = src.Indexer.Iter()
+		if err != nil {
+			return stats, fmt.Errorf("cannot iterate value store: %w", err)
+		}
+		defer iter.Close()
+	}
+
+	aw := &archiveWriter{
+		w: bufio.NewWriterSize(w, 1<<20),
+	}
+	aw.write(magic)
+	aw.writeUvarint(Version)
+	aw.writeField(manifestData)
+
+	if src.Config != nil {
+		aw.writeRecord(recConfig, src.Config)
+	}
+
+	stats.DatastoreKeys, err = aw.writeDatastore(ctx, recDatastore, dsResults)
+	if err != nil {
+		return stats, fmt.Errorf("cannot write datastore: %w", err)
+	}
+	if tmpResults != nil {
+		stats.TmpDatastoreKeys, err = aw.writeDatastore(ctx, recTmpDatastore, tmpResults)
+		if err != nil {
+			return stats, fmt.Errorf("cannot write temporary datastore: %w", err)
+		}
+	}
+	if iter != nil {
+		stats.Multihashes, err = aw.writeValues(ctx, iter)
+		if err != nil {
+			return stats, fmt.Errorf("cannot write value store: %w", err)
+		}
+	} else if cpDir != "" {
+		stats.CheckpointFiles, stats.CheckpointBytes, err = aw.writeCheckpoint(ctx, cpDir)
+		if err != nil {
+			return stats, fmt.Errorf("cannot write value store checkpoint: %w", err)
+		}
+	}
+
+	if aw.err == nil {
+		aw.err = aw.w.WriteByte(recEnd)
+	}
+	aw.writeUvarint(uint64(aw.count))
+	if aw.err == nil {
+		aw.err = aw.w.Flush()
+	}
+	return stats, aw.err
+}
+
+type archiveWriter struct {
+	w     *bufio.Writer
+	count int
+	err   error
+	buf   [binary.MaxVarintLen64]byte
+}
+
+func (aw *archiveWriter) write(data []byte) {
+	if aw.err != nil {
+		return
+	}
+	_, aw.err = aw.w.Write(data)
+}
+
+func (aw *archiveWriter) writeUvarint(x uint64) {
+	n := binary.PutUvarint(aw.buf[:], x)
+	aw.write(aw.buf[:n])
+}
+
+func (aw *archiveWriter) writeField(data []byte) {
+	aw.writeUvarint(uint64(len(data)))
+	aw.write(data)
+}
+
+func (aw *archiveWriter) writeRecord(recType byte, fields ...[]byte) {
+	if aw.err != nil {
+		return
+	}
+	aw.err = aw.w.WriteByte(recType)
+	for _, field := range fields {
+		aw.writeField(field)
+	}
+	aw.count++
+}
+
+func (aw *archiveWriter) writeDatastore(ctx context.Context, recType byte, results query.Results) (int, error) {
+	var count int
+	for result := range results.Next() {
+		if result.Error != nil {
+			return count, result.Error
+		}
+		aw.writeRecord(recType, []byte(result.Key), result.Value)
+		if aw.err != nil {
+			return count, aw.err
+		}
+		count++
+		if count%restoreBatchSize == 0 && ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+	}
+	return count, nil
+}
+
+func (aw *archiveWriter) writeValues(ctx context.Context, iter indexer.Iterator) (int, error) {
+	var codec indexer.BinaryValueCodec
+	var count int
+	for {
+		mh, values, err := iter.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return count, err
+		}
+		if aw.err != nil {
+			return count, aw.err
+		}
+		aw.err = aw.w.WriteByte(recValues)
+		aw.writeField(mh)
+		aw.writeUvarint(uint64(len(values)))
+		for _, value := range values {
+			data, err := codec.MarshalValue(value)
+			if err != nil {
+				return count, err
+			}
+			aw.writeField(data)
+		}
+		aw.count++
+		count++
+		if count%restoreBatchSize == 0 && ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+	}
+	return count, aw.err
+}
+
+// writeCheckpoint writes each file in the checkpoint directory as a series of
+// records that hold the file name and the next chunk of file data. A file
+// always has at least one record, so that empty files are restored.
+func (aw *archiveWriter) writeCheckpoint(ctx context.Context, dir string) (int, int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, 0, err
+	}
+	buf := make([]byte, checkpointChunkSize)
+	var count int
+	var size int64
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return count, size, ctx.Err()
+		}
+		if !entry.Type().IsRegular() {
+			return count, size, fmt.Errorf("checkpoint contains non-regular file %s", entry.Name())
+		}
+		n, err := aw.writeCheckpointFile(filepath.Join(dir, entry.Name()), buf)
+		if err != nil {
+			return count, size, err
+		}
+		size += n
+		count++
+	}
+	return count, size, aw.err
+}
+
+func (aw *archiveWriter) writeCheckpointFile(filePath string, buf []byte) (int64, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	name := []byte(filepath.Base(filePath))
+	var size int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n != 0 || size == 0 {
+			aw.writeRecord(recValueStoreFile, name, buf[:n])
+			if aw.err != nil {
+				return size, aw.err
+			}
+			size += int64(n)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return size, nil
+			}
+			return size, err
+		}
+	}
+}
+
+// Reader reads an archive.
+type Reader struct {
+	r        *bufio.Reader
+	manifest Manifest
+	config   []byte
+	count    int
+	// cpFile is the name of the checkpoint file currently being read.
+	cpFile string
+}
+
+// NewReader reads the header, manifest and config of the archive read from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	hdr := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, hdr); err != nil || !bytes.Equal(hdr, magic) {
+		return nil, errors.New("not a backup archive")
+	}
+	ver, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, ErrTruncated
+	}
+	if ver > Version {
+		return nil, fmt.Errorf("unsupported backup archive version %d", ver)
+	}
+
+	ar := &Reader{
+		r: br,
+	}
+	manifestData, err := ar.readField()
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(manifestData, &ar.manifest); err != nil {
+		return nil, fmt.Errorf("cannot decode manifest: %w", err)
+	}
+
+	if ar.manifest.Config {
+		recType, err := ar.r.ReadByte()
+		if err != nil {
+			return nil, ErrTruncated
+		}
+		if recType != recConfig {
+			return nil, errors.New("missing config in backup archive")
+		}
+		if ar.config, err = ar.readField(); err != nil {
+			return nil, err
+		}
+		ar.count++
+	}
+	return ar, nil
+}
+
+// Manifest returns the manifest of the archive.
+func (ar *Reader) Manifest() Manifest {
+	return ar.manifest
+}
+
+// Config returns the encoded indexer config in the archive, or nil if the
+// archive does not contain the config.
+func (ar *Reader) Config() []byte {
+	return ar.config
+}
+
+// Restore writes the state in the archive to the target stores. The target
+// stores should be empty. Any stores in the archive that do not have a target
+// are read and discarded, so restoring to an empty Target verifies that the
+// archive is complete. The returned Stats count the contents of the archive.
+func (ar *Reader) Restore(ctx context.Context, target Target) (Stats, error) {
+	var stats Stats
+	var codec indexer.BinaryValueCodec
+
+	var dsBatch, tmpBatch *datastoreBatch
+	if target.Datastore != nil {
+		dsBatch = newDatastoreBatch(ctx, target.Datastore)
+	}
+	if target.TmpDatastore != nil {
+		tmpBatch = newDatastoreBatch(ctx, target.TmpDatastore)
+	}
+	var valBatch *valueBatch
+	if target.Indexer != nil {
+		valBatch = newValueBatch(target.Indexer)
+	}
+	var cpFiles *checkpointFiles
+	if target.ValueStoreDir != "" {
+		cpFiles = &checkpointFiles{
+			dir: target.ValueStoreDir,
+		}
+		defer cpFiles.close()
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		recType, err := ar.r.ReadByte()
+		if err != nil {
+			return stats, ErrTruncated
+		}
+		switch recType {
+		case recEnd:
+			count, err := binary.ReadUvarint(ar.r)
+			if err != nil {
+				return stats, ErrTruncated
+			}
+			if int(count) != ar.count {
+				return stats, fmt.Errorf("backup archive has %d records, expected %d", ar.count, count)
+			}
+			if dsBatch != nil {
+				if err = dsBatch.commit(); err != nil {
+					return stats, err
+				}
+			}
+			if tmpBatch != nil {
+				if err = tmpBatch.commit(); err != nil {
+					return stats, err
+				}
+			}
+			if valBatch != nil {
+				if err = valBatch.flush(); err != nil {
+					return stats, err
+				}
+				if err = target.Indexer.Flush(); err != nil {
+					return stats, fmt.Errorf("cannot flush value store: %w", err)
+				}
+			}
+			if cpFiles != nil {
+				if err = cpFiles.close(); err != nil {
+					return stats, fmt.Errorf("cannot restore value store checkpoint: %w", err)
+				}
+			}
+			return stats, nil
+		case recDatastore, recTmpDatastore:
+			key, err := ar.readField()
+			if err != nil {
+				return stats, err
+			}
+			value, err := ar.readField()
+			if err != nil {
+				return stats, err
+			}
+			if recType == recDatastore {
+				if dsBatch != nil {
+					err = dsBatch.put(datastore.RawKey(string(key)), value)
+				}
+				stats.DatastoreKeys++
+			} else {
+				if tmpBatch != nil {
+					err = tmpBatch.put(datastore.RawKey(string(key)), value)
+				}
+				stats.TmpDatastoreKeys++
+			}
+			if err != nil {
+				return stats, fmt.Errorf("cannot restore datastore: %w", err)
+			}
+		case recValues:
+			mh, err := ar.readField()
+			if err != nil {
+				return stats, err
+			}
+			n, err := binary.ReadUvarint(ar.r)
+			if err != nil {
+				return stats, ErrTruncated
+			}
+			for i := uint64(0); i < n; i++ {
+				data, err := ar.readField()
+				if err != nil {
+					return stats, err
+				}
+				if valBatch == nil {
+					continue
+				}
+				value, err := codec.UnmarshalValue(data)
+				if err != nil {
+					return stats, fmt.Errorf("cannot decode value: %w", err)
+				}
+				if err = valBatch.put(value, multihash.Multihash(mh)); err != nil {
+					return stats, fmt.Errorf("cannot restore value store: %w", err)
+				}
+			}
+			stats.Multihashes++
+		case recValueStoreFile:
+			name, err := ar.readField()
+			if err != nil {
+				return stats, err
+			}
+			data, err := ar.readField()
+			if err != nil {
+				return stats, err
+			}
+			if err = checkFileName(string(name)); err != nil {
+				return stats, err
+			}
+			if string(name) != ar.cpFile {
+				ar.cpFile = string(name)
+				stats.CheckpointFiles++
+			}
+			stats.CheckpointBytes += int64(len(data))
+			if cpFiles != nil {
+				if err = cpFiles.write(string(name), data); err != nil {
+					return stats, fmt.Errorf("cannot restore value store checkpoint: %w", err)
+				}
+			}
+		default:
+			return stats, fmt.Errorf("unknown record type %d in backup archive", recType)
+		}
+		ar.count++
+	}
+}
+
+func (ar *Reader) readField() ([]byte, error) {
+	size, err := binary.ReadUvarint(ar.r)
+	if err != nil {
+		return nil, ErrTruncated
+	}
+	if size > maxFieldSize {
+		return nil, fmt.Errorf("backup archive field too large: %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err = io.ReadFull(ar.r, data); err != nil {
+		return nil, ErrTruncated
+	}
+	return data, nil
+}
+
+// checkFileName returns an error if a checkpoint file name is not the name of
+// a file in the value store directory.
+func checkFileName(name string) error {
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+		return fmt.Errorf("invalid checkpoint file name %q in backup archive", name)
+	}
+	return nil
+}
+
+// checkpointFiles writes the checkpoint files in an archive into a directory.
+// The records of each file are consecutive in the archive, so only one file is
+// open at a time.
+type checkpointFiles struct {
+	dir  string
+	name string
+	file *os.File
+}
+
+func (c *checkpointFiles) write(name string, data []byte) error {
+	if name != c.name {
+		if err := c.close(); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(c.dir, 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(filepath.Join(c.dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return err
+		}
+		c.name = name
+		c.file = f
+	}
+	_, err := c.file.Write(data)
+	return err
+}
+
+// close syncs and closes the file currently being written.
+func (c *checkpointFiles) close() error {
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Sync()
+	if cerr := c.file.Close(); err == nil {
+		err = cerr
+	}
+	c.file = nil
+	return err
+}
+
+type datastoreBatch struct {
+	ctx    context.Context
+	dstore datastore.Batching
+	batch  datastore.Batch
+	count  int
+}
+
+func newDatastoreBatch(ctx context.Context, dstore datastore.Batching) *datastoreBatch {
+	return &datastoreBatch{
+		ctx:    ctx,
+		dstore: dstore,
+	}
+}
+
+func (b *datastoreBatch) put(key datastore.Key, value []byte) error {
+	if b.batch == nil {
+		var err error
+		if b.batch, err = b.dstore.Batch(b.ctx); err != nil {
+			return err
+		}
+	}
+	if err := b.batch.Put(b.ctx, key, value); err != nil {
+		return err
+	}
+	b.count++
+	if b.count == restoreBatchSize {
+		return b.commit()
+	}
+	return nil
+}
+
+func (b *datastoreBatch) commit() error {
+	if b.batch == nil {
+		return nil
+	}
+	err := b.batch.Commit(b.ctx)
+	b.batch = nil
+	b.count = 0
+	return err
+}
+
+type valueKey struct {
+	providerID peer.ID
+	contextID  string
+}
+
+// valueBatch groups multihashes by value so that each value is put into the
+// value store with many multihashes at once.
+type valueBatch struct {
+	idxr   indexer.Interface
+	values map[valueKey]indexer.Value
+	mhs    map[valueKey][]multihash.Multihash
+	count  int
+}
+
+func newValueBatch(idxr indexer.Interface) *valueBatch {
+	return &valueBatch{
+		idxr:   idxr,
+		values: make(map[valueKey]indexer.Value),
+		mhs:    make(map[valueKey][]multihash.Multihash),
+	}
+}
+
+func (b *valueBatch) put(value indexer.Value, mh multihash.Multihash) error {
+	vk := valueKey{
+		providerID: value.ProviderID,
+		contextID:  string(value.ContextID),
+	}
+	b.values[vk] = value
+	b.mhs[vk] = append(b.mhs[vk], mh)
+	b.count++
+	if b.count == restoreBatchSize {
+		return b.flush()
+	}
+	return nil
+}
+
+func (b *valueBatch) flush() error {
+	for vk, mhs := range b.mhs {
+		if err := b.idxr.Put(b.values[vk], mhs...); err != nil {
+			return err
+		}
+		delete(b.mhs, vk)
+		delete(b.values, vk)
+	}
+	b.count = 0
+	return nil
+}
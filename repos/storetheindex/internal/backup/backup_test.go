@@ -0,0 +1,159 @@
+package backup_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-test/random"
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-indexer-core/engine"
+	"github.com/ipni/go-indexer-core/store/memory"
+	"github.com/ipni/storetheindex/internal/backup"
+	"github.com/ipni/storetheindex/internal/valuestore/pebble"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	pid := random.Peers(1)[0]
+
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	dsTmp := dssync.MutexWrap(datastore.NewMapDatastore())
+	for i := 0; i < 10; i++ {
+		require.NoError(t, dstore.Put(ctx, datastore.NewKey("/sync/"+random.Peers(1)[0].String()), random.Bytes(32)))
+	}
+	require.NoError(t, dsTmp.Put(ctx, datastore.NewKey("/tmp-key"), []byte("tmp-value")))
+
+	ind := engine.New(memory.New())
+	defer ind.Close()
+	value1 := indexer.Value{ProviderID: pid, ContextID: []byte("ctx-1"), MetadataBytes: []byte("meta-1")}
+	value2 := indexer.Value{ProviderID: pid, ContextID: []byte("ctx-2"), MetadataBytes: []byte("meta-2")}
+	mhs1 := random.Multihashes(100)
+	mhs2 := random.Multihashes(50)
+	require.NoError(t, ind.Put(value1, mhs1...))
+	require.NoError(t, ind.Put(value2, mhs2...))
+	// Multihash indexed by two values.
+	require.NoError(t, ind.Put(value2, mhs1[0]))
+
+	var buf bytes.Buffer
+	stats, err := backup.Write(ctx, &buf, backup.Source{
+		Config:         []byte(`{"Version":1}`),
+		Datastore:      dstore,
+		TmpDatastore:   dsTmp,
+		Indexer:        ind,
+		PeerID:         pid,
+		ValueStoreType: "memory",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 10, stats.DatastoreKeys)
+	require.Equal(t, 1, stats.TmpDatastoreKeys)
+	require.Equal(t, 150, stats.Multihashes)
+
+	archive := buf.Bytes()
+	ar, err := backup.NewReader(bytes.NewReader(archive))
+	require.NoError(t, err)
+	manifest := ar.Manifest()
+	require.Equal(t, backup.Version, manifest.Version)
+	require.Equal(t, pid.String(), manifest.PeerID)
+	require.True(t, manifest.Config)
+	require.False(t, manifest.Identity)
+	require.True(t, manifest.TmpDatastore)
+	require.True(t, manifest.ValueStore)
+	require.Equal(t, []byte(`{"Version":1}`), ar.Config())
+
+	newDstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	newInd := engine.New(memory.New())
+	defer newInd.Close()
+	rstats, err := ar.Restore(ctx, backup.Target{
+		Datastore: newDstore,
+		Indexer:   newInd,
+	})
+	require.NoError(t, err)
+	require.Equal(t, stats, rstats)
+
+	results, err := dstore.Query(ctx, query.Query{})
+	require.NoError(t, err)
+	entries, err := results.Rest()
+	require.NoError(t, err)
+	for _, entry := range entries {
+		val, err := newDstore.Get(ctx, datastore.NewKey(entry.Key))
+		require.NoError(t, err)
+		require.Equal(t, entry.Value, val)
+	}
+
+	vals, found, err := newInd.Get(mhs1[0])
+	require.NoError(t, err)
+	require.True(t, found)
+	require.ElementsMatch(t, []indexer.Value{value1, value2}, vals)
+	for _, mh := range mhs2 {
+		vals, found, err = newInd.Get(mh)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, []indexer.Value{value2}, vals)
+	}
+
+	// A truncated archive is detected.
+	ar, err = backup.NewReader(bytes.NewReader(archive[:len(archive)-10]))
+	require.NoError(t, err)
+	_, err = ar.Restore(ctx, backup.Target{})
+	require.ErrorIs(t, err, backup.ErrTruncated)
+
+	_, err = backup.NewReader(bytes.NewReader([]byte("not an archive")))
+	require.Error(t, err)
+}
+
+func TestBackupRestoreCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	pid := random.Peers(1)[0]
+
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	require.NoError(t, dstore.Put(ctx, datastore.NewKey("/sync/"+pid.String()), random.Bytes(32)))
+
+	vs, err := pebble.New(t.TempDir(), nil)
+	require.NoError(t, err)
+	defer vs.Close()
+	value := indexer.Value{ProviderID: pid, ContextID: []byte("ctx-1"), MetadataBytes: []byte("meta-1")}
+	mhs := random.Multihashes(100)
+	require.NoError(t, vs.Put(value, mhs...))
+
+	var buf bytes.Buffer
+	stats, err := backup.Write(ctx, &buf, backup.Source{
+		Datastore:      dstore,
+		Indexer:        vs,
+		PeerID:         pid,
+		ValueStoreType: "pebble",
+		TempDir:        t.TempDir(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, stats.DatastoreKeys)
+	require.Zero(t, stats.Multihashes)
+	require.NotZero(t, stats.CheckpointFiles)
+
+	ar, err := backup.NewReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.True(t, ar.Manifest().ValueStore)
+	require.True(t, ar.Manifest().Checkpoint)
+
+	vsDir := filepath.Join(t.TempDir(), "valuestore")
+	rstats, err := ar.Restore(ctx, backup.Target{
+		Datastore:     dssync.MutexWrap(datastore.NewMapDatastore()),
+		ValueStoreDir: vsDir,
+	})
+	require.NoError(t, err)
+	require.Equal(t, stats, rstats)
+
+	newVS, err := pebble.New(vsDir, nil)
+	require.NoError(t, err)
+	defer newVS.Close()
+	for _, mh := range mhs {
+		vals, found, err := newVS.Get(mh)
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, []indexer.Value{value}, vals)
+	}
+}
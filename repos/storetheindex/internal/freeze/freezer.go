@@ -133,6 +133,11 @@ func (f *Freezer) Dirs() []string {
 	return f.paths
 }
 
+// FreezeAt returns the disk usage percent at which the indexer freezes.
+func (f *Freezer) FreezeAt() float64 {
+	return f.freezeAt
+}
+
 // Usage returns the disk usage of the most used directory.
 func (f *Freezer) Usage() (*disk.UsageStats, error) {
 	var mostUsed *disk.UsageStats
@@ -43,6 +43,9 @@ const (
 	// adProcessedFrozenPrefix identifies all advertisements processed while in
 	// frozen mode. Used for unfreezing.
 	adProcessedFrozenPrefix = "/adF/"
+	// reindexPrefix identifies the last advertisement reindexed from the CAR
+	// mirror for each publisher.
+	reindexPrefix = "/reindex/"
 	// metricsUpdateInterval determines how ofter to update ingestion metrics.
 	metricsUpdateInterval = time.Minute
 )
@@ -122,6 +125,9 @@ type Ingester struct {
 	syncInProgressMu   sync.Mutex
 	syncInProgress     map[peer.ID]*dagsync.SyncFinished
 
+	// Progress of syncs in progress.
+	syncs *syncTracker
+
 	// Context and cancel function used to cancel all workers.
 	cancelWorkers context.CancelFunc
 	workersCtx    context.Context
@@ -140,6 +146,8 @@ type Ingester struct {
 
 	// Ingest rates
 	ingestRates *rate.Map
+	// mhsIngested is the total number of multihashes ingested.
+	mhsIngested atomic.Uint64
 
 	skip500EntsErr atomic.Bool
 
@@ -174,6 +182,7 @@ func NewIngester(cfg config.Ingest, h host.Host, idxr indexer.Interface, reg *re
 		stopWorker:              make(chan struct{}),
 
 		syncInProgress: make(map[peer.ID]*dagsync.SyncFinished),
+		syncs:          newSyncTracker(cfg.SyncSegmentDepthLimit),
 
 		minKeyLen: cfg.MinimumKeyLength,
 
@@ -237,6 +246,12 @@ func (ing *Ingester) GetIngestRate(peerID peer.ID) (rate.Rate, bool) {
 	return ing.ingestRates.Get(string(peerID))
 }
 
+// MultihashesIngested returns the total number of multihashes ingested since
+// the ingester was started.
+func (ing *Ingester) MultihashesIngested() uint64 {
+	return ing.mhsIngested.Load()
+}
+
 func (ing *Ingester) MultihashesFromMirror() uint64 {
 	return ing.mhsFromMirror.Load()
 }
@@ -245,7 +260,7 @@ func (ing *Ingester) Skip500EntriesError(skip bool) {
 	ing.skip500EntsErr.Store(skip)
 }
 
-func (ing *Ingester) generalDagsyncBlockHook(_ peer.ID, c cid.Cid, actions dagsync.SegmentSyncActions) {
+func (ing *Ingester) generalDagsyncBlockHook(pubID peer.ID, c cid.Cid, actions dagsync.SegmentSyncActions) {
 	// The only kind of block we should get by loading CIDs here should be
 	// Advertisement.
 	//
@@ -258,9 +273,17 @@ func (ing *Ingester) generalDagsyncBlockHook(_ peer.ID, c cid.Cid, actions dagsy
 	//
 	// Therefore, we only attempt to load advertisements here and signal
 	// failure if the load fails.
-	if ad, err := ing.loadAd(c); err != nil {
+	ad, err := ing.loadAd(c)
+	if err != nil {
 		actions.FailSync(err)
-	} else if ad.PreviousID != nil {
+		return
+	}
+	if ing.syncs.adFetched(pubID, c) {
+		// Stop fetching advertisements at the end of the current segment.
+		actions.FailSync(ErrSyncCanceled)
+		return
+	}
+	if ad.PreviousID != nil {
 		actions.SetNextSyncCid(ad.PreviousID.(cidlink.Link).Cid)
 	} else {
 		actions.SetNextSyncCid(cid.Undef)
@@ -430,18 +453,23 @@ func (ing *Ingester) Sync(ctx context.Context, peerInfo peer.AddrInfo, depth int
 	syncDone, cancel := ing.onAdProcessed(peerInfo.ID)
 	defer cancel()
 
-	syncCtx := ctx
+	var syncCtx context.Context
+	var syncCancel context.CancelFunc
 	if ing.syncTimeout != 0 {
-		var syncCancel context.CancelFunc
 		syncCtx, syncCancel = context.WithTimeout(ctx, ing.syncTimeout)
-		defer syncCancel()
+	} else {
+		syncCtx, syncCancel = context.WithCancel(ctx)
 	}
+	defer syncCancel()
 
 	// Start syncing. Notifications for the finished sync are sent
 	// asynchronously. Sync with cid.Undef so that the latest head is queried
 	// by dagsync via head-publisher.
+	clearCancel := ing.syncs.setFetchCancel(peerInfo.ID, syncCancel)
 	c, err := ing.sub.SyncAdChain(syncCtx, peerInfo, opts...)
+	clearCancel()
 	if err != nil {
+		ing.syncs.fetchDone(peerInfo.ID, false)
 		ing.reg.SetLastError(peerInfo.ID, err)
 		return cid.Undef, fmt.Errorf("failed to sync: %w", err)
 	}
@@ -776,8 +804,13 @@ func (ing *Ingester) autoSync() {
 				ID:    pubID,
 				Addrs: []multiaddr.Multiaddr{pubAddr},
 			}
-			_, err := ing.sub.SyncAdChain(ctx, peerInfo)
+			syncCtx, syncCancel := context.WithCancel(ctx)
+			defer syncCancel()
+			clearCancel := ing.syncs.setFetchCancel(pubID, syncCancel)
+			_, err := ing.sub.SyncAdChain(syncCtx, peerInfo)
+			clearCancel()
 			if err != nil {
+				ing.syncs.fetchDone(pubID, false)
 				log.Errorw("Failed to auto-sync with publisher", "err", err)
 				ing.reg.SetLastError(provID, fmt.Errorf("auto-sync failed: %s", err))
 				return
@@ -848,8 +881,12 @@ func (ing *Ingester) ingestWorker(ctx context.Context, syncFinishedEvents <-chan
 			}
 
 			pubID := event.PeerID
+			canceled := ing.syncs.fetchDone(pubID, event.Err == nil)
 
 			if event.Err != nil {
+				if errors.Is(event.Err, ErrSyncCanceled) {
+					continue
+				}
 				provID, ok := ing.reg.ProviderByPublisher(pubID)
 				if ok {
 					log.Debug("Setting last error for provider", "provider", provID, "publisher", pubID)
@@ -858,6 +895,20 @@ func (ing *Ingester) ingestWorker(ctx context.Context, syncFinishedEvents <-chan
 				continue
 			}
 
+			if canceled {
+				// The sync was canceled after all advertisements were
+				// fetched, so do not process them.
+				log.Infow("Skipping advertisement chain from canceled sync", "publisher", pubID)
+				ing.rewindLatestSync(pubID)
+				ing.inEvents <- adProcessedEvent{
+					publisher: pubID,
+					headAdCid: event.Cid,
+					adCid:     event.Cid,
+					err:       ErrSyncCanceled,
+				}
+				continue
+			}
+
 			log.Debugw("ingest worker processing raw ad chain", "publisher", pubID)
 
 			if ing.putNextSyncFin(event) {
@@ -914,13 +965,32 @@ func (ing *Ingester) getNextSyncFin(pubID peer.ID) *dagsync.SyncFinished {
 // assignment for a provider, then workers are given the next work assignment
 // for that provider.
 func (ing *Ingester) processRawAdChain(ctx context.Context, syncFinished dagsync.SyncFinished, wkrNum int) {
+	publisher := syncFinished.PeerID
+	log := log.With("publisher", publisher, "worker", wkrNum)
+
+	ctx, ok := ing.syncs.beginProcess(ctx, publisher, syncFinished.Cid, syncFinished.Count)
+	defer func() {
+		if ing.syncs.processDone(publisher) {
+			log.Info("Stopped processing advertisement chain from canceled sync")
+			ing.rewindLatestSync(publisher)
+		}
+	}()
+	if !ok {
+		log.Info("Skipping advertisement chain from canceled sync")
+		ing.inEvents <- adProcessedEvent{
+			publisher: publisher,
+			headAdCid: syncFinished.Cid,
+			adCid:     syncFinished.Cid,
+			err:       ErrSyncCanceled,
+		}
+		return
+	}
+
 	if syncFinished.Count == 0 {
 		// Attempted sync, but already up to data. Nothing to do.
 		return
 	}
 
-	publisher := syncFinished.PeerID
-	log := log.With("publisher", publisher, "worker", wkrNum)
 	log.Infow("Advertisement chain synced", "length", syncFinished.Count)
 
 	var rmCount int64
@@ -1004,6 +1074,7 @@ func (ing *Ingester) processRawAdChain(ctx context.Context, syncFinished dagsync
 	}
 
 	nonRmCount := totalAds - rmCount
+	ing.syncs.setAdsToProcess(publisher, int(totalAds))
 
 	log.Debugw("Created ad stack", "providers", len(adsGroupedByProvider), "ads", totalAds, "rmCount", rmCount)
 
@@ -1075,6 +1146,7 @@ func (ing *Ingester) ingestWorkerLogic(ctx context.Context, provider, publisher
 			log.Debug("Sent ad processed event for canceled processing")
 			return
 		}
+		ing.syncs.adProcessing(publisher, ai.cid)
 
 		processed, _ := ing.adAlreadyProcessed(ai.cid)
 		if processed {
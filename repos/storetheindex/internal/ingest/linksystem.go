@@ -168,6 +168,7 @@ func (ing *Ingester) ingestAd(ctx context.Context, publisherID peer.ID, adCid ci
 			// Record multihashes rate per provider.
 			elapsed = now.Sub(entsSyncStart)
 			ing.ingestRates.Update(string(headProvider.ID), uint64(mhCount), elapsed)
+			ing.mhsIngested.Add(uint64(mhCount))
 
 			// Record how long entries sync took.
 			elapsedMsec = float64(elapsed.Nanoseconds()) / 1e6
@@ -501,6 +502,7 @@ func (ing *Ingester) ingestEntriesFromPublisher(ctx context.Context, ad schema.A
 		// Node was loaded previously, so must be a permanent data error.
 		return 0, adIngestError{adIngestEntryChunkErr, fmt.Errorf("failed to load first entry chunk: %w", err)}
 	}
+	ing.syncs.entriesChunkSynced(publisherID)
 
 	err = ing.indexAdMultihashes(ad, providerID, chunk.Entries, log)
 	if err != nil {
@@ -525,6 +527,7 @@ func (ing *Ingester) ingestEntriesFromPublisher(ctx context.Context, ad schema.A
 				actions.FailSync(adIngestError{adIngestIndexerErr, fmt.Errorf("failed to load entry chunk: %w", err)})
 				return
 			}
+			ing.syncs.entriesChunkSynced(publisherID)
 			err = ing.indexAdMultihashes(ad, providerID, chunk.Entries, log)
 			if err != nil {
 				actions.FailSync(adIngestError{adIngestIndexerErr, fmt.Errorf("failed to ingest entry chunk: %w", err)})
@@ -643,12 +646,23 @@ func (ing *Ingester) ingestEntriesFromCar(ctx context.Context, ad schema.Adverti
 // indexAdMultihashes filters out invalid multihashes and indexes those
 // remaining in the indexer core.
 func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, providerID peer.ID, mhs []multihash.Multihash, log *zap.SugaredLogger) error {
+	// No code path should ever allow this, so it is a programming error if
+	// this ever happens.
+	if ad.IsRm {
+		panic("removing individual multihashes not allowed")
+	}
+
 	value := indexer.Value{
 		ProviderID:    providerID,
 		ContextID:     ad.ContextID,
 		MetadataBytes: ad.Metadata,
 	}
+	return indexMultihashes(ing.indexer, ing.minKeyLen, value, mhs, log)
+}
 
+// indexMultihashes removes multihashes that cannot be decoded or that have a
+// digest shorter than minKeyLen, and puts the remaining multihashes into idxr.
+func indexMultihashes(idxr indexer.Interface, minKeyLen int, value indexer.Value, mhs []multihash.Multihash, log *zap.SugaredLogger) error {
 	// Iterate over multihashes and remove bad ones.
 	var badMultihashCount int
 	for i := 0; i < len(mhs); {
@@ -660,7 +674,7 @@ func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, providerID peer
 				log.Warnw("Ignoring bad multihash", "err", err)
 			}
 			badMultihash = true
-		} else if len(decoded.Digest) < ing.minKeyLen {
+		} else if len(decoded.Digest) < minKeyLen {
 			log.Warnw("Multihash digest too short, ignoring", "digestSize", len(decoded.Digest))
 			badMultihash = true
 		}
@@ -681,13 +695,7 @@ func (ing *Ingester) indexAdMultihashes(ad schema.Advertisement, providerID peer
 		return nil
 	}
 
-	// No code path should ever allow this, so it is a programming error if
-	// this ever happens.
-	if ad.IsRm {
-		panic("removing individual multihashes not allowed")
-	}
-
-	if err := ing.indexer.Put(value, mhs...); err != nil {
+	if err := idxr.Put(value, mhs...); err != nil {
 		return fmt.Errorf("%w: cannot put multihashes into indexer: %w", errInternal, err)
 	}
 	log.Infow("Indexed multihashes from chunk", "count", len(mhs), "sample", mhs[0].B58String())
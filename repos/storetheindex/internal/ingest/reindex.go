@@ -0,0 +1,302 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/storetheindex/carstore"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ReindexStats describes the advertisements reindexed from a publisher's
+// advertisement chain.
+type ReindexStats struct {
+	// Ads is the number of advertisements reindexed.
+	Ads int
+	// Removals is the number of removal advertisements applied.
+	Removals int
+	// Skipped is the number of advertisements skipped because their context ID
+	// is removed later in the chain.
+	Skipped int
+	// NoEntries is the number of advertisements that have an entries link, but
+	// whose CAR file has no entries data.
+	NoEntries int
+	// Multihashes is the number of multihashes indexed.
+	Multihashes int
+	// Incomplete is true if walking the advertisement chain stopped at an
+	// advertisement that is not in the CAR mirror, so older advertisements
+	// were not reindexed.
+	Incomplete bool
+}
+
+// Reindexer rebuilds index content and processed advertisement markers using
+// only the advertisements and entries stored in the CAR mirror.
+//
+// Head files are written by publisher, so the advertisement chain of each
+// publisher is walked from the head in the publisher's head file. Every
+// advertisement in the chain is applied for its own provider, from oldest to
+// newest, in the same way that the ingester applies a synced chain. The last
+// advertisement reindexed is recorded for each publisher, so that an
+// interrupted reindex resumes where it left off.
+type Reindexer struct {
+	carReader *carstore.CarReader
+	dstore    datastore.Datastore
+	idxr      indexer.Interface
+	minKeyLen int
+}
+
+type reindexAdInfo struct {
+	cid      cid.Cid
+	provider peer.ID
+	skip     bool
+}
+
+// NewReindexer creates a Reindexer that reads from carReader and writes index
+// content to idxr and advertisement markers to dstore. Multihashes with
+// digests shorter than minKeyLen are not indexed.
+func NewReindexer(carReader *carstore.CarReader, idxr indexer.Interface, dstore datastore.Datastore, minKeyLen int) *Reindexer {
+	return &Reindexer{
+		carReader: carReader,
+		dstore:    dstore,
+		idxr:      idxr,
+		minKeyLen: minKeyLen,
+	}
+}
+
+// Reset removes the record of reindexing progress for all publishers, so that
+// the next reindex starts again from the beginning of each chain.
+func (r *Reindexer) Reset(ctx context.Context) error {
+	results, err := r.dstore.Query(ctx, query.Query{
+		Prefix:   reindexPrefix,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for result := range results.Next() {
+		if result.Error != nil {
+			results.Close()
+			return fmt.Errorf("cannot read reindex progress: %w", result.Error)
+		}
+		keys = append(keys, result.Entry.Key)
+	}
+	results.Close()
+
+	for _, key := range keys {
+		if err = r.dstore.Delete(ctx, datastore.NewKey(key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reindex reindexes the advertisements in the publisher's chain that have not
+// already been reindexed. Each advertisement is applied for the provider in
+// the advertisement, which may be different from the publisher.
+func (r *Reindexer) Reindex(ctx context.Context, publisher peer.ID) (ReindexStats, error) {
+	log := log.With("publisher", publisher)
+	var stats ReindexStats
+
+	headCid, err := r.carReader.ReadHead(ctx, publisher)
+	if err != nil {
+		return stats, fmt.Errorf("cannot read head file: %w", err)
+	}
+
+	progressKey := datastore.NewKey(reindexPrefix + publisher.String())
+	var lastCid cid.Cid
+	data, err := r.dstore.Get(ctx, progressKey)
+	if err != nil {
+		if !errors.Is(err, datastore.ErrNotFound) {
+			return stats, fmt.Errorf("cannot read reindex progress: %w", err)
+		}
+	} else {
+		_, lastCid, err = cid.CidFromBytes(data)
+		if err != nil {
+			return stats, fmt.Errorf("cannot decode reindex progress: %w", err)
+		}
+	}
+	if headCid == lastCid {
+		log.Info("Advertisement chain already reindexed")
+		return stats, nil
+	}
+
+	// Walk the chain from newest to oldest, stopping at the last ad already
+	// reindexed.
+	var adInfos []reindexAdInfo
+	rmCtxID := make(map[string]struct{})
+	for adCid := headCid; adCid != cid.Undef && adCid != lastCid; {
+		adBlock, err := r.carReader.Read(ctx, adCid, true)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Warnw("Advertisement not in CAR mirror, cannot reindex older advertisements", "adCid", adCid)
+				stats.Incomplete = true
+				break
+			}
+			return stats, fmt.Errorf("cannot read advertisement %s: %w", adCid, err)
+		}
+		ad, err := adBlock.Advertisement()
+		if err != nil {
+			return stats, fmt.Errorf("cannot decode advertisement %s: %w", adCid, err)
+		}
+
+		providerID, err := peer.Decode(ad.Provider)
+		if err != nil {
+			log.Errorw("Failed to get provider from advertisement, skipping", "adCid", adCid, "err", err)
+			adCid = ad.PreviousCid()
+			continue
+		}
+
+		ai := reindexAdInfo{
+			cid:      adCid,
+			provider: providerID,
+		}
+		// A removal only deletes the context of the provider that removed it.
+		rmKey := providerID.String() + "/" + string(ad.ContextID)
+		if ad.IsRm {
+			rmCtxID[rmKey] = struct{}{}
+		} else if _, ok := rmCtxID[rmKey]; ok {
+			// This ad was deleted by a later remove.
+			ai.skip = true
+		}
+		adInfos = append(adInfos, ai)
+
+		adCid = ad.PreviousCid()
+	}
+
+	total := len(adInfos)
+	log.Infow("Reindexing advertisements from CAR mirror", "headAdCid", headCid, "numAdsToProcess", total)
+
+	// Apply ads from oldest to newest.
+	for i := total - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			return stats, ctx.Err()
+		}
+		ai := adInfos[i]
+		if ai.skip {
+			log.Infow("Skipping advertisement with deleted context", "adCid", ai.cid)
+			stats.Skipped++
+		} else {
+			err = r.reindexAd(ctx, ai.provider, ai.cid, &stats)
+			if err != nil {
+				return stats, fmt.Errorf("cannot reindex advertisement %s: %w", ai.cid, err)
+			}
+			stats.Ads++
+		}
+		if err = r.markReindexed(ctx, publisher, ai.cid); err != nil {
+			return stats, err
+		}
+	}
+
+	// Record the head as reindexed, even if the head ad could not be decoded.
+	if err = r.dstore.Put(ctx, progressKey, headCid.Bytes()); err != nil {
+		return stats, fmt.Errorf("cannot save reindex progress: %w", err)
+	}
+
+	log.Infow("Finished reindexing advertisements", "ads", stats.Ads, "removals", stats.Removals,
+		"skipped", stats.Skipped, "multihashes", stats.Multihashes, "incomplete", stats.Incomplete)
+	return stats, nil
+}
+
+// reindexAd applies a single advertisement to the indexer for the provider in
+// the advertisement.
+func (r *Reindexer) reindexAd(ctx context.Context, providerID peer.ID, adCid cid.Cid, stats *ReindexStats) error {
+	// Create a context to cancel reading entries.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	adBlock, err := r.carReader.Read(ctx, adCid, false)
+	if err != nil {
+		return err
+	}
+	ad, err := adBlock.Advertisement()
+	if err != nil {
+		return err
+	}
+
+	if ad.IsRm {
+		if err = r.idxr.RemoveProviderContext(providerID, ad.ContextID); err != nil {
+			return fmt.Errorf("failed to remove provider context: %w", err)
+		}
+		stats.Removals++
+		return nil
+	}
+
+	// If the ad has no metadata, then it is only for updating provider
+	// addresses, or it is malformed. Either way there is nothing to index.
+	if len(ad.Metadata) == 0 {
+		return nil
+	}
+
+	value := indexer.Value{
+		ContextID:     ad.ContextID,
+		MetadataBytes: ad.Metadata,
+		ProviderID:    providerID,
+	}
+
+	if ad.Entries == nil || ad.Entries == schema.NoEntries {
+		if err = r.idxr.Put(value); err != nil {
+			return fmt.Errorf("failed to update metadata: %w", err)
+		}
+		return nil
+	}
+
+	if adBlock.Entries == nil {
+		stats.NoEntries++
+		return nil
+	}
+	entsCid := ad.Entries.(cidlink.Link).Cid
+	log := log.With("provider", providerID, "adCid", adCid, "entriesKind", "CarEntryChunk")
+
+	first := true
+	for entryBlock := range adBlock.Entries {
+		if entryBlock.Err != nil {
+			return entryBlock.Err
+		}
+		if first {
+			if entryBlock.Cid != entsCid {
+				return errors.New("advertisement entries cid does not match first entry chunk cid in car file")
+			}
+			first = false
+		}
+		chunk, err := entryBlock.EntryChunk()
+		if err != nil {
+			return fmt.Errorf("failed to decode entry chunk from car file data: %w", err)
+		}
+		if err = indexMultihashes(r.idxr, r.minKeyLen, value, chunk.Entries, log); err != nil {
+			return err
+		}
+		stats.Multihashes += len(chunk.Entries)
+	}
+	if first {
+		stats.NoEntries++
+	}
+	return nil
+}
+
+// markReindexed marks the advertisement as processed, the same as the ingester
+// does after processing an advertisement, and records the advertisement as
+// the latest sync and the last one reindexed for the publisher.
+func (r *Reindexer) markReindexed(ctx context.Context, publisher peer.ID, adCid cid.Cid) error {
+	err := r.dstore.Put(ctx, datastore.NewKey(adProcessedPrefix+adCid.String()), []byte{1})
+	if err != nil {
+		return fmt.Errorf("cannot mark advertisement processed: %w", err)
+	}
+	err = r.dstore.Put(ctx, datastore.NewKey(syncPrefix+publisher.String()), adCid.Bytes())
+	if err != nil {
+		return fmt.Errorf("cannot save latest sync: %w", err)
+	}
+	err = r.dstore.Put(ctx, datastore.NewKey(reindexPrefix+publisher.String()), adCid.Bytes())
+	if err != nil {
+		return fmt.Errorf("cannot save reindex progress: %w", err)
+	}
+	return nil
+}
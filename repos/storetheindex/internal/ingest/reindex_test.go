@@ -0,0 +1,204 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-test/random"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/storetheindex/carstore"
+	"github.com/ipni/storetheindex/filestore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReindexFromMirror(t *testing.T) {
+	ctx := context.Background()
+	providerID, privKey, _ := random.Identity()
+
+	adStore := datastore.NewMapDatastore()
+	lsys := mkProvLinkSystem(adStore)
+
+	var prevLink ipld.Link
+	storeAd := func(contextID string, isRm bool, entsSize int) (cid.Cid, []multihash.Multihash) {
+		ad := &schema.Advertisement{
+			PreviousID: prevLink,
+			Provider:   providerID.String(),
+			Addresses:  []string{"/ip4/127.0.0.1/tcp/9999"},
+			Entries:    schema.NoEntries,
+			ContextID:  []byte(contextID),
+			IsRm:       isRm,
+		}
+		var mhs []multihash.Multihash
+		if !isRm {
+			ad.Metadata = []byte("reindex-metadata")
+		}
+		if entsSize != 0 {
+			ad.Entries, mhs = newRandomLinkedList(t, lsys, entsSize)
+		}
+		require.NoError(t, ad.Sign(privKey))
+		node, err := ad.ToNode()
+		require.NoError(t, err)
+		prevLink, err = lsys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+		require.NoError(t, err)
+		return prevLink.(cidlink.Link).Cid, mhs
+	}
+
+	fileStore, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	carw, err := carstore.NewWriter(adStore, fileStore)
+	require.NoError(t, err)
+	carReader, err := carstore.NewReader(fileStore)
+	require.NoError(t, err)
+	writeMirror := func(headCid cid.Cid) {
+		_, err := carw.WriteChain(ctx, headCid, true)
+		require.NoError(t, err)
+		_, err = carw.WriteHead(ctx, headCid, providerID)
+		require.NoError(t, err)
+	}
+
+	// Context a is removed later in the chain, so its ad is skipped.
+	_, mhsA := storeAd("a", false, 2)
+	_, mhsB := storeAd("b", false, 2)
+	_, _ = storeAd("a", true, 0)
+	headCid, _ := storeAd("b", false, 0)
+	writeMirror(headCid)
+
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	ix := mkIndexer(t, true)
+	defer ix.Close()
+	reindexer := NewReindexer(carReader, ix, dstore, 0)
+
+	stats, err := reindexer.Reindex(ctx, providerID)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Ads)
+	require.Equal(t, 1, stats.Skipped)
+	require.Equal(t, 1, stats.Removals)
+	require.Equal(t, len(mhsB), stats.Multihashes)
+	require.False(t, stats.Incomplete)
+
+	require.NoError(t, checkAllIndexed(ix, providerID, mhsB))
+	requireNotIndexed(t, ix, providerID, mhsA)
+
+	// Check that ads are marked as processed.
+	processed, err := dstore.Get(ctx, datastore.NewKey(adProcessedPrefix+headCid.String()))
+	require.NoError(t, err)
+	require.Equal(t, []byte{1}, processed)
+	latest, err := dstore.Get(ctx, datastore.NewKey(syncPrefix+providerID.String()))
+	require.NoError(t, err)
+	require.Equal(t, headCid.Bytes(), latest)
+
+	// Reindexing again does nothing since chain is already reindexed.
+	stats, err = reindexer.Reindex(ctx, providerID)
+	require.NoError(t, err)
+	require.Zero(t, stats.Ads)
+
+	// Extend the chain. Only the new ads are reindexed, and removal of a
+	// context reindexed earlier is applied.
+	_, _ = storeAd("b", true, 0)
+	headCid, mhsC := storeAd("c", false, 1)
+	writeMirror(headCid)
+
+	stats, err = reindexer.Reindex(ctx, providerID)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Ads)
+	require.Equal(t, 1, stats.Removals)
+	require.Equal(t, len(mhsC), stats.Multihashes)
+	require.NoError(t, checkAllIndexed(ix, providerID, mhsC))
+	requireNotIndexed(t, ix, providerID, mhsB)
+
+	// After reset, the whole chain is reindexed into a new value store.
+	require.NoError(t, reindexer.Reset(ctx))
+	ix2 := mkIndexer(t, true)
+	defer ix2.Close()
+	stats, err = NewReindexer(carReader, ix2, dstore, 0).Reindex(ctx, providerID)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Ads)
+	require.Equal(t, 3, stats.Skipped)
+	require.Equal(t, 2, stats.Removals)
+	require.NoError(t, checkAllIndexed(ix2, providerID, mhsC))
+	requireNotIndexed(t, ix2, providerID, mhsA)
+	requireNotIndexed(t, ix2, providerID, mhsB)
+}
+
+func TestReindexPublisherChain(t *testing.T) {
+	ctx := context.Background()
+	pubID, _, _ := random.Identity()
+	provA, privKeyA, _ := random.Identity()
+	provB, privKeyB, _ := random.Identity()
+
+	adStore := datastore.NewMapDatastore()
+	lsys := mkProvLinkSystem(adStore)
+
+	var prevLink ipld.Link
+	storeAd := func(providerID peer.ID, privKey crypto.PrivKey, contextID string, isRm bool, entsSize int) (cid.Cid, []multihash.Multihash) {
+		ad := &schema.Advertisement{
+			PreviousID: prevLink,
+			Provider:   providerID.String(),
+			Addresses:  []string{"/ip4/127.0.0.1/tcp/9999"},
+			Entries:    schema.NoEntries,
+			ContextID:  []byte(contextID),
+			IsRm:       isRm,
+		}
+		var mhs []multihash.Multihash
+		if !isRm {
+			ad.Metadata = []byte("reindex-metadata")
+		}
+		if entsSize != 0 {
+			ad.Entries, mhs = newRandomLinkedList(t, lsys, entsSize)
+		}
+		require.NoError(t, ad.Sign(privKey))
+		node, err := ad.ToNode()
+		require.NoError(t, err)
+		prevLink, err = lsys.Store(ipld.LinkContext{}, schema.Linkproto, node)
+		require.NoError(t, err)
+		return prevLink.(cidlink.Link).Cid, mhs
+	}
+
+	// Both providers publish the same context ID through one publisher, and
+	// only provider A removes it.
+	adCidA, mhsA := storeAd(provA, privKeyA, "shared", false, 2)
+	adCidB, mhsB := storeAd(provB, privKeyB, "shared", false, 2)
+	headCid, _ := storeAd(provA, privKeyA, "shared", true, 0)
+
+	fileStore, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	carw, err := carstore.NewWriter(adStore, fileStore)
+	require.NoError(t, err)
+	for _, adCid := range []cid.Cid{adCidA, adCidB, headCid} {
+		_, err = carw.Write(ctx, adCid, false, true)
+		require.NoError(t, err)
+	}
+	_, err = carw.WriteHead(ctx, headCid, pubID)
+	require.NoError(t, err)
+	carReader, err := carstore.NewReader(fileStore)
+	require.NoError(t, err)
+
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	ix := mkIndexer(t, true)
+	defer ix.Close()
+
+	stats, err := NewReindexer(carReader, ix, dstore, 0).Reindex(ctx, pubID)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Ads)
+	require.Equal(t, 1, stats.Skipped)
+	require.Equal(t, 1, stats.Removals)
+	require.Equal(t, len(mhsB), stats.Multihashes)
+
+	require.NoError(t, checkAllIndexed(ix, provB, mhsB))
+	requireNotIndexed(t, ix, provA, mhsA)
+
+	// The latest sync is recorded for the publisher.
+	latest, err := dstore.Get(ctx, datastore.NewKey(syncPrefix+pubID.String()))
+	require.NoError(t, err)
+	require.Equal(t, headCid.Bytes(), latest)
+	_, err = dstore.Get(ctx, datastore.NewKey(syncPrefix+provA.String()))
+	require.ErrorIs(t, err, datastore.ErrNotFound)
+}
@@ -0,0 +1,294 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// ErrSyncCanceled is the error used to stop a sync that was canceled by
+// CancelSync.
+var ErrSyncCanceled = errors.New("sync canceled")
+
+// SyncProgress describes a sync with a publisher that is in progress. A sync
+// fetches a chain of advertisements from the publisher, and then processes
+// the advertisements, syncing their entries.
+type SyncProgress struct {
+	// Publisher is the ID of the publisher being synced.
+	Publisher peer.ID
+	// Target is the CID of the head advertisement of the chain being synced.
+	Target cid.Cid
+	// Started is when the sync started.
+	Started time.Time
+	// Fetching is true while advertisements are being fetched.
+	Fetching bool
+	// AdsFetched is the number of advertisements fetched so far.
+	AdsFetched int
+	// Segment is the number of the sync segment currently being fetched. Zero
+	// if no advertisements have been fetched.
+	Segment int
+	// Processing is true while fetched advertisements are being processed.
+	Processing bool
+	// AdsToProcess is the number of fetched advertisements to process.
+	AdsToProcess int
+	// AdsProcessed is the number of advertisements processed, including the
+	// current one.
+	AdsProcessed int
+	// CurrentAd is the CID of the advertisement currently being processed.
+	CurrentAd cid.Cid
+	// EntriesChunks is the number of entries chunks synced while processing
+	// advertisements.
+	EntriesChunks int
+	// Canceled is true if the sync was canceled and is stopping.
+	Canceled bool
+}
+
+// syncTracker tracks the progress of syncs that are in progress, and allows
+// them to be canceled.
+type syncTracker struct {
+	mutex sync.Mutex
+	syncs map[peer.ID]*syncState
+	// fetchCancels holds the cancel functions of contexts used for explicit
+	// syncs and auto-syncs. Syncs triggered by announce messages are stopped
+	// by the block hook when canceled.
+	fetchCancels map[peer.ID]context.CancelFunc
+	segDepth     int
+}
+
+type syncState struct {
+	SyncProgress
+	processCancel context.CancelFunc
+}
+
+func newSyncTracker(segDepth int) *syncTracker {
+	return &syncTracker{
+		syncs:        make(map[peer.ID]*syncState),
+		fetchCancels: make(map[peer.ID]context.CancelFunc),
+		segDepth:     segDepth,
+	}
+}
+
+// list returns the progress of all syncs in progress, oldest first.
+func (t *syncTracker) list() []SyncProgress {
+	t.mutex.Lock()
+	syncs := make([]SyncProgress, 0, len(t.syncs))
+	for _, s := range t.syncs {
+		syncs = append(syncs, s.SyncProgress)
+	}
+	t.mutex.Unlock()
+
+	sort.Slice(syncs, func(i, j int) bool {
+		return syncs[i].Started.Before(syncs[j].Started)
+	})
+	return syncs
+}
+
+// cancel cancels the sync with the publisher. Returns false if there is no
+// sync in progress with the publisher.
+func (t *syncTracker) cancel(pubID peer.ID) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	fetchCancel, fetching := t.fetchCancels[pubID]
+	if fetching {
+		fetchCancel()
+	}
+	s, ok := t.syncs[pubID]
+	if !ok {
+		return fetching
+	}
+	s.Canceled = true
+	if s.processCancel != nil {
+		s.processCancel()
+	}
+	return true
+}
+
+// setFetchCancel sets the function that cancels an explicit sync or
+// auto-sync with the publisher. Returns a function to call to remove the
+// cancel function when the sync returns.
+func (t *syncTracker) setFetchCancel(pubID peer.ID, cancel context.CancelFunc) func() {
+	t.mutex.Lock()
+	t.fetchCancels[pubID] = cancel
+	t.mutex.Unlock()
+
+	return func() {
+		t.mutex.Lock()
+		delete(t.fetchCancels, pubID)
+		t.mutex.Unlock()
+	}
+}
+
+// adFetched records that an advertisement was fetched from the publisher.
+// Returns true if the sync was canceled.
+func (t *syncTracker) adFetched(pubID peer.ID, adCid cid.Cid) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.syncs[pubID]
+	if !ok {
+		s = &syncState{
+			SyncProgress: SyncProgress{
+				Publisher: pubID,
+				Started:   time.Now(),
+			},
+		}
+		t.syncs[pubID] = s
+	}
+	if !s.Fetching {
+		// The first advertisement fetched is the head of the chain.
+		s.Fetching = true
+		s.Target = adCid
+		s.AdsFetched = 0
+	}
+	s.AdsFetched++
+	if t.segDepth > 0 {
+		s.Segment = (s.AdsFetched-1)/t.segDepth + 1
+	} else {
+		s.Segment = 1
+	}
+	return s.Canceled
+}
+
+// fetchDone records that fetching advertisements from the publisher has
+// ended. If the fetch succeeded, the sync remains tracked until the fetched
+// advertisements are processed. Returns true if the sync was canceled.
+func (t *syncTracker) fetchDone(pubID peer.ID, succeeded bool) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.syncs[pubID]
+	if !ok {
+		return false
+	}
+	s.Fetching = false
+	canceled := s.Canceled
+	if !s.Processing && (!succeeded || canceled) {
+		delete(t.syncs, pubID)
+	}
+	return canceled
+}
+
+// beginProcess records that processing the advertisement chain headed by
+// adCid has started. Returns a context that is canceled when the sync is
+// canceled, or false if the sync was already canceled.
+func (t *syncTracker) beginProcess(ctx context.Context, pubID peer.ID, adCid cid.Cid, adCount int) (context.Context, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.syncs[pubID]
+	if !ok {
+		s = &syncState{
+			SyncProgress: SyncProgress{
+				Publisher: pubID,
+				Started:   time.Now(),
+			},
+		}
+		t.syncs[pubID] = s
+	}
+	if s.Canceled {
+		return ctx, false
+	}
+	if !s.Fetching {
+		s.Target = adCid
+	}
+	s.Processing = true
+	s.AdsToProcess = adCount
+	s.AdsProcessed = 0
+	s.CurrentAd = cid.Undef
+	s.EntriesChunks = 0
+
+	ctx, s.processCancel = context.WithCancel(ctx)
+	return ctx, true
+}
+
+// setAdsToProcess sets the number of advertisements to process.
+func (t *syncTracker) setAdsToProcess(pubID peer.ID, adCount int) {
+	t.mutex.Lock()
+	if s, ok := t.syncs[pubID]; ok {
+		s.AdsToProcess = adCount
+	}
+	t.mutex.Unlock()
+}
+
+// processDone records that processing has ended. Returns true if the sync was
+// canceled.
+func (t *syncTracker) processDone(pubID peer.ID) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s, ok := t.syncs[pubID]
+	if !ok {
+		return false
+	}
+	if s.processCancel != nil {
+		s.processCancel()
+		s.processCancel = nil
+	}
+	s.Processing = false
+	canceled := s.Canceled
+	if !s.Fetching {
+		delete(t.syncs, pubID)
+	}
+	return canceled
+}
+
+// adProcessing records that an advertisement is being processed.
+func (t *syncTracker) adProcessing(pubID peer.ID, adCid cid.Cid) {
+	t.mutex.Lock()
+	if s, ok := t.syncs[pubID]; ok {
+		s.CurrentAd = adCid
+		s.AdsProcessed++
+	}
+	t.mutex.Unlock()
+}
+
+// entriesChunkSynced records that an entries chunk was synced.
+func (t *syncTracker) entriesChunkSynced(pubID peer.ID) {
+	t.mutex.Lock()
+	if s, ok := t.syncs[pubID]; ok {
+		s.EntriesChunks++
+	}
+	t.mutex.Unlock()
+}
+
+// Syncs returns the progress of all syncs in progress, oldest first.
+func (ing *Ingester) Syncs() []SyncProgress {
+	return ing.syncs.list()
+}
+
+// CancelSync cancels the sync with the publisher that is in progress. Fetching
+// advertisements stops at the end of the current sync segment, and processing
+// stops before the next advertisement. Advertisements that are not processed
+// are synced again by the next sync with the publisher. Returns false if there
+// is no sync in progress with the publisher.
+func (ing *Ingester) CancelSync(pubID peer.ID) bool {
+	if !ing.syncs.cancel(pubID) {
+		return false
+	}
+	log.Infow("Canceled sync with publisher", "publisher", pubID)
+	return true
+}
+
+// rewindLatestSync sets the latest sync for the publisher back to the last
+// processed advertisement, so that the advertisements from a canceled sync are
+// synced again by the next sync with the publisher.
+func (ing *Ingester) rewindLatestSync(pubID peer.ID) {
+	latest, err := ing.GetLatestSync(pubID)
+	if err != nil {
+		log.Errorw("Cannot get latest sync to rewind canceled sync", "err", err, "publisher", pubID)
+		return
+	}
+	if latest == cid.Undef {
+		log.Warnw("No advertisement processed to rewind canceled sync to, resync needed to get skipped advertisements", "publisher", pubID)
+		return
+	}
+	if err = ing.sub.SetLatestSync(pubID, latest); err != nil {
+		log.Errorw("Cannot rewind latest sync for canceled sync", "err", err, "publisher", pubID)
+	}
+}
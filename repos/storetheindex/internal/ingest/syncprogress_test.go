@@ -0,0 +1,190 @@
+package ingest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/storetheindex/test/typehelpers"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncTracker(t *testing.T) {
+	pubID := peer.ID("publisher")
+	c1 := cid.MustParse("bafkreigh2akiscaildcqabsyg3dfr6chu3fgpregiymsck7e7aqa4s52zy")
+	c2 := cid.MustParse("bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+
+	tracker := newSyncTracker(2)
+	require.Empty(t, tracker.list())
+	require.False(t, tracker.cancel(pubID))
+
+	require.False(t, tracker.adFetched(pubID, c1))
+	require.False(t, tracker.adFetched(pubID, c2))
+	require.False(t, tracker.adFetched(pubID, c2))
+	syncs := tracker.list()
+	require.Len(t, syncs, 1)
+	require.True(t, syncs[0].Fetching)
+	require.Equal(t, c1, syncs[0].Target)
+	require.Equal(t, 3, syncs[0].AdsFetched)
+	require.Equal(t, 2, syncs[0].Segment)
+
+	// Successful fetch remains tracked until processed.
+	require.False(t, tracker.fetchDone(pubID, true))
+	require.Len(t, tracker.list(), 1)
+
+	ctx, ok := tracker.beginProcess(context.Background(), pubID, c1, 3)
+	require.True(t, ok)
+	tracker.setAdsToProcess(pubID, 2)
+	tracker.adProcessing(pubID, c2)
+	tracker.entriesChunkSynced(pubID)
+	syncs = tracker.list()
+	require.Len(t, syncs, 1)
+	require.False(t, syncs[0].Fetching)
+	require.True(t, syncs[0].Processing)
+	require.Equal(t, 2, syncs[0].AdsToProcess)
+	require.Equal(t, 1, syncs[0].AdsProcessed)
+	require.Equal(t, c2, syncs[0].CurrentAd)
+	require.Equal(t, 1, syncs[0].EntriesChunks)
+
+	require.True(t, tracker.cancel(pubID))
+	require.ErrorIs(t, ctx.Err(), context.Canceled)
+	require.True(t, tracker.list()[0].Canceled)
+	require.True(t, tracker.processDone(pubID))
+	require.Empty(t, tracker.list())
+
+	// Canceled fetch stops at next fetched ad.
+	require.False(t, tracker.adFetched(pubID, c1))
+	require.True(t, tracker.cancel(pubID))
+	require.True(t, tracker.adFetched(pubID, c2))
+	require.True(t, tracker.fetchDone(pubID, false))
+	require.Empty(t, tracker.list())
+
+	// Explicit sync canceled before any ads fetched.
+	fetchCtx, fetchCancel := context.WithCancel(context.Background())
+	defer fetchCancel()
+	clearCancel := tracker.setFetchCancel(pubID, fetchCancel)
+	require.True(t, tracker.cancel(pubID))
+	require.ErrorIs(t, fetchCtx.Err(), context.Canceled)
+	clearCancel()
+	require.False(t, tracker.cancel(pubID))
+}
+
+func TestCancelSyncWhileFetching(t *testing.T) {
+	t.Parallel()
+	blockableLsysOpt, blockedReads, hitBlockedRead := blockableLinkSys(nil)
+	te := setupTestEnv(t, true, blockableLsysOpt)
+
+	headLink := typehelpers.RandomAdBuilder{
+		EntryBuilders: []typehelpers.EntryBuilder{
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 1},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 2},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 1, EntriesPerChunk: 1, Seed: 3},
+		}}.Build(t, te.publisherLinkSys, te.publisherPriv)
+	headCid := headLink.(cidlink.Link).Cid
+	ads := typehelpers.AllAdLinks(t, headLink, te.publisherLinkSys)
+	mhs := typehelpers.AllMultihashesFromAdLink(t, headLink, te.publisherLinkSys)
+	pubAddrInfo := te.pubHost.Peerstore().PeerInfo(te.pubHost.ID())
+	te.publisher.SetRoot(headCid)
+
+	// Block fetching the second advertisement.
+	blockedReads.add(ads[1].(cidlink.Link).Cid)
+
+	err := te.ingester.Announce(context.Background(), headCid, pubAddrInfo)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		syncs := te.ingester.Syncs()
+		return len(syncs) == 1 && syncs[0].AdsFetched == 1
+	}, testRetryTimeout, testRetryInterval)
+	syncs := te.ingester.Syncs()
+	require.Equal(t, te.pubHost.ID(), syncs[0].Publisher)
+	require.Equal(t, headCid, syncs[0].Target)
+	require.True(t, syncs[0].Fetching)
+	require.False(t, syncs[0].Processing)
+	require.Equal(t, 1, syncs[0].Segment)
+
+	require.True(t, te.ingester.CancelSync(te.pubHost.ID()))
+	<-hitBlockedRead
+
+	require.Eventually(t, func() bool {
+		return len(te.ingester.Syncs()) == 0
+	}, testRetryTimeout, testRetryInterval)
+	requireNotIndexed(t, te.ingester.indexer, te.pubHost.ID(), mhs)
+	require.False(t, te.ingester.CancelSync(te.pubHost.ID()))
+}
+
+func TestCancelSyncWhileProcessing(t *testing.T) {
+	t.Parallel()
+	blockableLsysOpt, blockedReads, hitBlockedRead := blockableLinkSys(nil)
+	te := setupTestEnv(t, true, blockableLsysOpt)
+
+	headLink := typehelpers.RandomAdBuilder{
+		EntryBuilders: []typehelpers.EntryBuilder{
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 2, EntriesPerChunk: 1, Seed: 1},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 2, EntriesPerChunk: 1, Seed: 2},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 2, EntriesPerChunk: 1, Seed: 3},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 2, EntriesPerChunk: 1, Seed: 4},
+		}}.Build(t, te.publisherLinkSys, te.publisherPriv)
+	headCid := headLink.(cidlink.Link).Cid
+	ads := typehelpers.AllAdLinks(t, headLink, te.publisherLinkSys)
+	mhs := typehelpers.AllMultihashesFromAdLink(t, headLink, te.publisherLinkSys)
+	tailMhs := typehelpers.AllMultihashesFromAdLink(t, ads[0], te.publisherLinkSys)
+	ad1Mhs := typehelpers.AllMultihashesFromAdLink(t, ads[1], te.publisherLinkSys)
+	te.publisher.SetRoot(headCid)
+
+	// Block syncing entries of the second advertisement.
+	ad1Cid := ads[1].(cidlink.Link).Cid
+	ad1 := typehelpers.AdFromLink(t, ads[1], te.publisherLinkSys)
+	blockedReads.add(ad1.Entries.(cidlink.Link).Cid)
+
+	peerInfo := peer.AddrInfo{
+		ID:    te.publisher.ID(),
+		Addrs: te.publisher.Addrs(),
+	}
+	syncErr := make(chan error, 1)
+	go func() {
+		_, err := te.ingester.Sync(context.Background(), peerInfo, 0, false)
+		syncErr <- err
+	}()
+
+	// Wait for first advertisement to be indexed.
+	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), tailMhs)
+
+	require.Eventually(t, func() bool {
+		syncs := te.ingester.Syncs()
+		return len(syncs) == 1 && syncs[0].CurrentAd == ad1Cid
+	}, testRetryTimeout, testRetryInterval)
+	syncs := te.ingester.Syncs()
+	require.Equal(t, headCid, syncs[0].Target)
+	require.False(t, syncs[0].Fetching)
+	require.True(t, syncs[0].Processing)
+	require.Equal(t, len(ads), syncs[0].AdsToProcess)
+	require.Equal(t, 2, syncs[0].AdsProcessed)
+	require.Equal(t, 2, syncs[0].EntriesChunks)
+
+	require.True(t, te.ingester.CancelSync(te.pubHost.ID()))
+	require.True(t, te.ingester.Syncs()[0].Canceled)
+	<-hitBlockedRead
+
+	require.Error(t, <-syncErr)
+	require.Eventually(t, func() bool {
+		return len(te.ingester.Syncs()) == 0
+	}, testRetryTimeout, testRetryInterval)
+
+	// Advertisements after the one being processed are not processed.
+	requireNotIndexed(t, te.ingester.indexer, te.pubHost.ID(), mhs[len(ad1Mhs):])
+
+	// Latest sync is rewound to last processed advertisement.
+	latest, err := te.ingester.GetLatestSync(te.pubHost.ID())
+	require.NoError(t, err)
+	require.NotEqual(t, headCid, latest)
+	require.Equal(t, cidlink.Link{Cid: latest}, te.ingester.sub.GetLatestSync(te.pubHost.ID()))
+
+	// Next sync processes the remaining advertisements.
+	blockedReads.rm(ad1.Entries.(cidlink.Link).Cid)
+	_, err = te.ingester.Sync(context.Background(), peerInfo, 0, false)
+	require.NoError(t, err)
+	requireIndexedEventually(t, te.ingester.indexer, te.pubHost.ID(), mhs)
+}
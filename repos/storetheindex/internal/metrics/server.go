@@ -16,12 +16,14 @@ import (
 var (
 	ErrKind, _ = tag.NewKey("errKind")
 	Found, _   = tag.NewKey("found")
+	Hit, _     = tag.NewKey("hit")
 	Method, _  = tag.NewKey("method")
 )
 
 // Measures
 var (
 	FindLatency          = stats.Float64("find/latency", "Time to respond to a find request", stats.UnitMilliseconds)
+	FindCacheLookup      = stats.Int64("find/cachelookup", "Number of find result cache lookups", stats.UnitDimensionless)
 	IngestChange         = stats.Int64("ingest/change", "Number of syncAdEntries started", stats.UnitDimensionless)
 	AdIngestLatency      = stats.Float64("ingest/adsynclatency", "latency of syncAdEntries completed successfully", stats.UnitDimensionless)
 	AdIngestErrorCount   = stats.Int64("ingest/adingestError", "Number of errors encountered while processing an ad", stats.UnitDimensionless)
@@ -43,6 +45,11 @@ var (
 		Aggregation: view.Distribution(0, 1, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 200, 300, 400, 500, 1000, 2000, 5000),
 		TagKeys:     []tag.Key{Method, Found},
 	}
+	findCacheLookupView = &view.View{
+		Measure:     FindCacheLookup,
+		Aggregation: view.Count(),
+		TagKeys:     []tag.Key{Hit},
+	}
 	adIngestLatencyView = &view.View{
 		Measure:     AdIngestLatency,
 		Aggregation: view.Distribution(0, 1, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100, 200, 300, 400, 500, 1000, 2000, 5000),
@@ -102,6 +109,7 @@ func Start(views []*view.View) http.Handler {
 	// Register default views
 	err := view.Register(
 		findLatencyView,
+		findCacheLookupView,
 		ingestChangeView,
 		providerView,
 		entriesSyncLatencyView,
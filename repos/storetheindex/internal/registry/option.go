@@ -9,6 +9,7 @@ import (
 type regConfig struct {
 	freezeAtPercent float64
 	freezeDirs      []string
+	probeLibp2p     bool
 }
 
 // Option is a function that sets a value in a regConfig.
@@ -35,3 +36,13 @@ func WithFreezer(freezeDirs []string, freezeAtPercent float64) Option {
 		return nil
 	}
 }
+
+// WithLibp2pProbe enables checking the reachability of provider addresses
+// that are not HTTP addresses, by dialing them with libp2p. If not enabled,
+// then only HTTP addresses are probed.
+func WithLibp2pProbe(enable bool) Option {
+	return func(c *regConfig) error {
+		c.probeLibp2p = enable
+		return nil
+	}
+}
@@ -0,0 +1,335 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ipni/go-libipni/maurl"
+	"github.com/ipni/go-libipni/mautil"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// Values for config.Discovery.UnreachableAddrs.
+const (
+	unreachableLast = "last"
+	unreachableDrop = "drop"
+)
+
+// maxProbeWorkers is the maximum number of addresses probed concurrently.
+const maxProbeWorkers = 32
+
+// AddrReachability records the results of probing a provider address.
+type AddrReachability struct {
+	// Reachable is true if the most recent probe of the address succeeded.
+	Reachable bool
+	// LastProbe is the time that the address was most recently probed.
+	LastProbe time.Time
+	// LastSuccess is the time that the address was most recently reachable.
+	LastSuccess time.Time
+	// UnreachableSince is the time of the first failed probe since the
+	// address was last reachable. It is zero when the address is reachable.
+	UnreachableSince time.Time
+	// LastError describes why the most recent probe failed.
+	LastError string `json:",omitempty"`
+}
+
+// unreachableFor returns true if the address has been unreachable for at
+// least the given amount of time.
+func (a AddrReachability) unreachableFor(d time.Duration, now time.Time) bool {
+	if a.Reachable || a.UnreachableSince.IsZero() {
+		return false
+	}
+	return now.Sub(a.UnreachableSince) >= d
+}
+
+type probeJob struct {
+	providerID peer.ID
+	addr       multiaddr.Multiaddr
+}
+
+func (r *Registry) runProbe(interval time.Duration) {
+	defer close(r.probeDone)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-r.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.probeProviders(ctx)
+		case <-r.closing:
+			return
+		}
+	}
+}
+
+// probeProviders checks the reachability of every address of every active
+// provider and records the results in the provider's info.
+func (r *Registry) probeProviders(ctx context.Context) {
+	var jobs []probeJob
+
+	r.provMutex.Lock()
+	for providerID, info := range r.providers {
+		if info.inactive || !r.policy.Allowed(providerID) {
+			continue
+		}
+		for _, addr := range info.AddrInfo.Addrs {
+			if r.probeHost == nil && !isHTTPAddr(addr) {
+				continue
+			}
+			jobs = append(jobs, probeJob{
+				providerID: providerID,
+				addr:       addr,
+			})
+		}
+	}
+	r.provMutex.Unlock()
+
+	if len(jobs) == 0 {
+		return
+	}
+
+	results := make([]error, len(jobs))
+	jobIndexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < min(maxProbeWorkers, len(jobs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobIndexes {
+				results[j] = r.probeAddr(ctx, jobs[j].providerID, jobs[j].addr)
+			}
+		}()
+	}
+sendJobs:
+	for i := range jobs {
+		select {
+		case jobIndexes <- i:
+		case <-ctx.Done():
+			break sendJobs
+		}
+	}
+	close(jobIndexes)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	now := time.Now()
+	updates := make(map[peer.ID]map[string]AddrReachability)
+
+	r.provMutex.Lock()
+	defer r.provMutex.Unlock()
+
+	for i, job := range jobs {
+		info, ok := r.providers[job.providerID]
+		if !ok {
+			continue
+		}
+		reach, ok := updates[job.providerID]
+		if !ok {
+			// Start with previous results for addresses that are still
+			// current, so that addresses not probed this time are kept.
+			reach = make(map[string]AddrReachability, len(info.AddrInfo.Addrs))
+			for _, addr := range info.AddrInfo.Addrs {
+				key := addr.String()
+				if prev, ok := info.Reachability[key]; ok {
+					reach[key] = prev
+				}
+			}
+			updates[job.providerID] = reach
+		}
+		key := job.addr.String()
+		prev, ok := reach[key]
+		if !ok && !multiaddrIn(job.addr, info.AddrInfo.Addrs) {
+			// Provider addresses changed during probe.
+			continue
+		}
+
+		ar := AddrReachability{
+			LastProbe:        now,
+			LastSuccess:      prev.LastSuccess,
+			UnreachableSince: prev.UnreachableSince,
+		}
+		if results[i] == nil {
+			ar.Reachable = true
+			ar.LastSuccess = now
+			ar.UnreachableSince = time.Time{}
+		} else {
+			ar.LastError = results[i].Error()
+			if prev.Reachable || prev.UnreachableSince.IsZero() {
+				ar.UnreachableSince = now
+			}
+			log.Debugw("Provider address unreachable", "provider", job.providerID, "addr", key, "err", results[i])
+		}
+		reach[key] = ar
+	}
+
+	for providerID, reach := range updates {
+		info := r.providers[providerID]
+		infoCpy := *info
+		infoCpy.Reachability = reach
+		r.providers[providerID] = &infoCpy
+	}
+}
+
+// newProbeHost creates the libp2p host used to probe provider addresses. The
+// host does not listen, so it only has the connections it dials for probes.
+func newProbeHost() (host.Host, error) {
+	h, err := libp2p.New(
+		libp2p.NoListenAddrs,
+		libp2p.DisableRelay(),
+		libp2p.ResourceManager(&network.NullResourceManager{}))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create probe host: %w", err)
+	}
+	return h, nil
+}
+
+// probeAddr checks whether a single provider address is reachable. HTTP
+// addresses are checked with a HEAD request, and any response from the server
+// means the address is reachable. Other addresses are checked by connecting to
+// the provider with libp2p, from the probe host. The probe host only knows the
+// address being probed and has no other connection to the provider, so that
+// address alone is checked.
+func (r *Registry) probeAddr(ctx context.Context, providerID peer.ID, addr multiaddr.Multiaddr) error {
+	if isHTTPAddr(addr) {
+		ctx, cancel := context.WithTimeout(ctx, r.probeTimeout)
+		defer cancel()
+
+		u, err := maurl.ToURL(addr)
+		if err != nil {
+			return fmt.Errorf("cannot convert address to url: %w", err)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		rsp, err := r.probeClient.Do(req)
+		if err != nil {
+			return err
+		}
+		rsp.Body.Close()
+		return nil
+	}
+
+	if r.probeHost == nil {
+		return errors.New("libp2p probing not enabled")
+	}
+	// Probe one address of a provider at a time, since a connection to the
+	// provider would otherwise be reused to probe its other addresses.
+	if err := r.lockProbeDial(ctx, providerID); err != nil {
+		return err
+	}
+	defer r.unlockProbeDial(providerID)
+
+	ctx, cancel := context.WithTimeout(ctx, r.probeTimeout)
+	defer cancel()
+
+	h := r.probeHost
+	h.Peerstore().AddAddr(providerID, addr, peerstore.TempAddrTTL)
+	_, err := h.Network().DialPeer(ctx, providerID)
+
+	// Forget the connection and everything learned about the provider, so
+	// that the next probe dials only its own address.
+	h.Network().ClosePeer(providerID)
+	h.Peerstore().ClearAddrs(providerID)
+	h.Peerstore().RemovePeer(providerID)
+	if sw, ok := h.Network().(*swarm.Swarm); ok {
+		sw.Backoff().Clear(providerID)
+	}
+	return err
+}
+
+// lockProbeDial waits until no other address of the provider is being probed
+// with libp2p, and then marks the provider as being probed.
+func (r *Registry) lockProbeDial(ctx context.Context, providerID peer.ID) error {
+	for {
+		r.probeDialMutex.Lock()
+		dialing, ok := r.probeDialing[providerID]
+		if !ok {
+			r.probeDialing[providerID] = make(chan struct{})
+			r.probeDialMutex.Unlock()
+			return nil
+		}
+		r.probeDialMutex.Unlock()
+
+		select {
+		case <-dialing:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *Registry) unlockProbeDial(providerID peer.ID) {
+	r.probeDialMutex.Lock()
+	close(r.probeDialing[providerID])
+	delete(r.probeDialing, providerID)
+	r.probeDialMutex.Unlock()
+}
+
+// FindAddrs returns the provider's addresses to return in find responses.
+// When reachability probing is enabled, addresses that have been unreachable
+// for longer than Discovery.UnreachableAfter are moved to the end of the list
+// or removed, as configured by Discovery.UnreachableAddrs.
+func (r *Registry) FindAddrs(info *ProviderInfo) []multiaddr.Multiaddr {
+	addrs := info.AddrInfo.Addrs
+	if len(info.Reachability) == 0 {
+		return addrs
+	}
+	if r.unreachableAddrs != unreachableLast && r.unreachableAddrs != unreachableDrop {
+		return addrs
+	}
+
+	now := time.Now()
+	var unreachable []multiaddr.Multiaddr
+	filtered := make([]multiaddr.Multiaddr, 0, len(addrs))
+	for _, addr := range addrs {
+		if info.Reachability[addr.String()].unreachableFor(r.unreachableAfter, now) {
+			unreachable = append(unreachable, addr)
+			continue
+		}
+		filtered = append(filtered, addr)
+	}
+	if len(unreachable) == 0 {
+		return addrs
+	}
+	if r.unreachableAddrs == unreachableLast {
+		filtered = append(filtered, unreachable...)
+	}
+	return filtered
+}
+
+func isHTTPAddr(addr multiaddr.Multiaddr) bool {
+	return len(mautil.FindHTTPAddrs([]multiaddr.Multiaddr{addr})) != 0
+}
+
+func multiaddrIn(addr multiaddr.Multiaddr, addrs []multiaddr.Multiaddr) bool {
+	for _, a := range addrs {
+		if a.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
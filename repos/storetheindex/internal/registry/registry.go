@@ -25,6 +25,7 @@ import (
 	"github.com/ipni/storetheindex/internal/freeze"
 	"github.com/ipni/storetheindex/internal/metrics"
 	"github.com/ipni/storetheindex/internal/registry/policy"
+	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"go.opencensus.io/stats"
@@ -56,6 +57,15 @@ type Registry struct {
 	providers map[peer.ID]*ProviderInfo
 	sequences *sequences
 
+	probeClient      *http.Client
+	probeDone        chan struct{}
+	probeHost        host.Host
+	probeDialing     map[peer.ID]chan struct{}
+	probeDialMutex   sync.Mutex
+	probeTimeout     time.Duration
+	unreachableAddrs string
+	unreachableAfter time.Duration
+
 	policy *policy.Policy
 
 	// assigned tracks peers assigned by assigner service.
@@ -94,6 +104,10 @@ type ProviderInfo struct {
 	PublisherAddr multiaddr.Multiaddr `json:",omitempty"`
 	// ExtendedProviders registered for that provider
 	ExtendedProviders *ExtendedProviders `json:",omitempty"`
+	// Reachability records the results of probing each of the provider's
+	// addresses, keyed by address. This is not persisted, so that
+	// reachability is determined again when the indexer is started.
+	Reachability map[string]AddrReachability `json:"-"`
 
 	// FrozenAt identifies the last advertisement that was received before the
 	// indexer became frozen.
@@ -297,6 +311,11 @@ func New(ctx context.Context, cfg config.Discovery, dstore datastore.Datastore,
 		tmpBlockPeers:     make(map[peer.ID]time.Time),
 		tmpBlockCheckDone: make(chan struct{}),
 		tmpBlockPeriod:    time.Duration(cfg.IgnoreBadAdsTime),
+
+		probeClient:      &http.Client{},
+		probeTimeout:     time.Duration(cfg.ProbeTimeout),
+		unreachableAddrs: cfg.UnreachableAddrs,
+		unreachableAfter: time.Duration(cfg.UnreachableAfter),
 	}
 
 	r.providers, err = loadPersistedProviders(ctx, dstore, cfg.FilterIPs)
@@ -353,6 +372,22 @@ func New(ctx context.Context, cfg config.Discovery, dstore datastore.Datastore,
 		go r.runPollCheck(poll, pollOverrides)
 	}
 
+	if opts.probeLibp2p {
+		r.probeHost, err = newProbeHost()
+		if err != nil {
+			if r.freezer != nil {
+				r.freezer.Close()
+			}
+			return nil, err
+		}
+		r.probeDialing = make(map[peer.ID]chan struct{})
+	}
+
+	if cfg.ProbeInterval != 0 {
+		r.probeDone = make(chan struct{})
+		go r.runProbe(time.Duration(cfg.ProbeInterval))
+	}
+
 	go r.runTmpBlockCheck()
 
 	return r, nil
@@ -419,6 +454,12 @@ func (r *Registry) Close() {
 		if r.pollDone != nil {
 			<-r.pollDone
 		}
+		if r.probeDone != nil {
+			<-r.probeDone
+		}
+		if r.probeHost != nil {
+			r.probeHost.Close()
+		}
 	})
 }
 
@@ -730,6 +771,7 @@ func (r *Registry) Update(ctx context.Context, provider, publisher peer.AddrInfo
 			Publisher:             info.Publisher,
 			PublisherAddr:         info.PublisherAddr,
 			ExtendedProviders:     info.ExtendedProviders,
+			Reachability:          info.Reachability,
 
 			FrozenAt:     info.FrozenAt,
 			FrozenAtTime: info.FrozenAtTime,
@@ -1174,6 +1216,15 @@ func (r *Registry) Frozen() bool {
 	return r.freezer.Frozen()
 }
 
+// FreezeAtPercent returns the disk usage percent at which the indexer
+// freezes, or 0 if the indexer does not freeze.
+func (r *Registry) FreezeAtPercent() float64 {
+	if r.freezer == nil {
+		return 0
+	}
+	return r.freezer.FreezeAt()
+}
+
 func (r *Registry) ValueStoreUsage() (*disk.UsageStats, error) {
 	if r.freezer == nil {
 		return nil, ErrNoFreeze
@@ -15,6 +15,7 @@ import (
 	"github.com/ipfs/go-test/random"
 	"github.com/ipni/go-libipni/find/model"
 	"github.com/ipni/storetheindex/config"
+	"github.com/libp2p/go-libp2p"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
@@ -971,6 +972,129 @@ func TestIgnoreBadAds(t *testing.T) {
 	require.True(t, r.Allowed(pubID), "publisher should be not allowed")
 }
 
+func TestProbeProviders(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	tsURL, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	goodAddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/" + tsURL.Port() + "/http")
+	require.NoError(t, err)
+	// Nothing listens on port 1.
+	badAddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/1/http")
+	require.NoError(t, err)
+
+	cfg := config.Discovery{
+		Policy: config.Policy{
+			Allow:   true,
+			Publish: true,
+		},
+		ProbeTimeout:     config.Duration(time.Second),
+		UnreachableAfter: config.Duration(time.Millisecond),
+		UnreachableAddrs: "last",
+	}
+
+	ctx := context.Background()
+	r, err := New(ctx, cfg, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+
+	provID, err := peer.Decode(limitedID)
+	require.NoError(t, err)
+	provider := peer.AddrInfo{
+		ID:    provID,
+		Addrs: []multiaddr.Multiaddr{badAddr, goodAddr},
+	}
+	err = r.Update(ctx, provider, peer.AddrInfo{}, cid.Undef, nil, 0)
+	require.NoError(t, err)
+
+	// Addresses returned in advertised order before probing.
+	info, _ := r.ProviderInfo(provID)
+	require.Equal(t, provider.Addrs, r.FindAddrs(info))
+
+	r.probeProviders(ctx)
+
+	info, _ = r.ProviderInfo(provID)
+	require.Len(t, info.Reachability, 2)
+	good := info.Reachability[goodAddr.String()]
+	require.True(t, good.Reachable)
+	require.False(t, good.LastSuccess.IsZero())
+	require.True(t, good.UnreachableSince.IsZero())
+	bad := info.Reachability[badAddr.String()]
+	require.False(t, bad.Reachable)
+	require.True(t, bad.LastSuccess.IsZero())
+	require.False(t, bad.UnreachableSince.IsZero())
+	require.NotEmpty(t, bad.LastError)
+
+	time.Sleep(2 * time.Millisecond)
+	require.Equal(t, []multiaddr.Multiaddr{goodAddr, badAddr}, r.FindAddrs(info))
+
+	r.unreachableAddrs = "drop"
+	require.Equal(t, []multiaddr.Multiaddr{goodAddr}, r.FindAddrs(info))
+
+	// Unreachable since time is kept on subsequent failed probes.
+	r.probeProviders(ctx)
+	info, _ = r.ProviderInfo(provID)
+	require.Equal(t, bad.UnreachableSince, info.Reachability[badAddr.String()].UnreachableSince)
+
+	// Reachability is kept when provider is updated, and results for
+	// addresses that are no longer used are discarded at next probe.
+	provider.Addrs = []multiaddr.Multiaddr{goodAddr}
+	err = r.Update(ctx, provider, peer.AddrInfo{}, cid.Undef, nil, 0)
+	require.NoError(t, err)
+	info, _ = r.ProviderInfo(provID)
+	require.Len(t, info.Reachability, 2)
+	r.probeProviders(ctx)
+	info, _ = r.ProviderInfo(provID)
+	require.Len(t, info.Reachability, 1)
+	require.True(t, info.Reachability[goodAddr.String()].Reachable)
+}
+
+func TestProbeLibp2pAddr(t *testing.T) {
+	provHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	require.NoError(t, err)
+	defer provHost.Close()
+	goodAddr := provHost.Addrs()[0]
+	// Nothing listens on port 1.
+	badAddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/1")
+	require.NoError(t, err)
+
+	cfg := config.Discovery{
+		Policy: config.Policy{
+			Allow:   true,
+			Publish: true,
+		},
+		ProbeTimeout: config.Duration(time.Second),
+	}
+	ctx := context.Background()
+	r, err := New(ctx, cfg, nil, WithLibp2pProbe(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+
+	require.NoError(t, r.probeAddr(ctx, provHost.ID(), goodAddr))
+	// Probing the bad address fails even though the good address was just
+	// dialed, since each probe dials only the address being probed.
+	require.Error(t, r.probeAddr(ctx, provHost.ID(), badAddr))
+	// The probe host is reused, and does not stay connected to the provider.
+	require.NoError(t, r.probeAddr(ctx, provHost.ID(), goodAddr))
+	require.Empty(t, r.probeHost.Network().ConnsToPeer(provHost.ID()))
+
+	// Concurrent probes of the same provider each check only their own
+	// address.
+	errs := make(chan error, 2)
+	for i := 0; i < 10; i++ {
+		go func() { errs <- r.probeAddr(ctx, provHost.ID(), goodAddr) }()
+		go func() { errs <- r.probeAddr(ctx, provHost.ID(), badAddr) }()
+		var failed int
+		for j := 0; j < 2; j++ {
+			if <-errs != nil {
+				failed++
+			}
+		}
+		require.Equal(t, 1, failed)
+	}
+}
+
 func writeJsonResponse(w http.ResponseWriter, status int, body []byte) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"context"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/pcache"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// registrySource is a pcache.ProviderSource that gets provider information
+// directly from the registry.
+type registrySource struct {
+	r *Registry
+}
+
+// ProviderSource returns a pcache.ProviderSource that gets provider
+// information from the registry. Providers that are inactive or not allowed
+// are still returned, since they are still known to the registry.
+func (r *Registry) ProviderSource() pcache.ProviderSource {
+	return registrySource{r: r}
+}
+
+func (s registrySource) Fetch(_ context.Context, providerID peer.ID) (*model.ProviderInfo, error) {
+	info, _ := s.r.ProviderInfo(providerID)
+	return RegToApiProviderInfo(info), nil
+}
+
+func (s registrySource) FetchAll(_ context.Context) ([]*model.ProviderInfo, error) {
+	infos := s.r.AllProviderInfo()
+	apiInfos := make([]*model.ProviderInfo, len(infos))
+	for i, info := range infos {
+		apiInfos[i] = RegToApiProviderInfo(info)
+	}
+	return apiInfos, nil
+}
+
+func (s registrySource) String() string {
+	return "registry"
+}
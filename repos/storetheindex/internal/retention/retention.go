@@ -0,0 +1,382 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	logging "github.com/ipfs/go-log/v2"
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/ipni/storetheindex/config"
+	"github.com/ipni/storetheindex/fsutil/disk"
+	"github.com/ipni/storetheindex/internal/registry"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+var log = logging.Logger("indexer/retention")
+
+const (
+	// StrategyProvider evicts all content of the providers that have gone the
+	// longest without publishing an advertisement.
+	StrategyProvider = "provider"
+	// StrategyContext evicts the context IDs that were first indexed longest
+	// ago.
+	StrategyContext = "context"
+
+	defaultProviderBatch = 1
+	defaultContextBatch  = 1000
+
+	// maxRecent is the number of most recent evictions kept for status.
+	maxRecent = 32
+)
+
+// Eviction describes index content that was evicted.
+type Eviction struct {
+	// Time is when the content was evicted.
+	Time time.Time
+	// Provider is the provider whose content was evicted.
+	Provider peer.ID
+	// ContextID is the evicted context ID. It is empty if all the provider's
+	// content was evicted, or if the evicted context ID is empty.
+	ContextID []byte `json:",omitempty"`
+	// Usage is the percent of storage used when the content was evicted.
+	Usage float64
+}
+
+// Status is the state of retention-based eviction.
+type Status struct {
+	// Evicting is true when usage reached the high-water mark and has not yet
+	// dropped below the low-water mark.
+	Evicting bool
+	// Usage is the percent of storage used at the last check.
+	Usage float64
+	// HighWaterPercent is the usage at which eviction starts.
+	HighWaterPercent float64
+	// LowWaterPercent is the usage below which eviction stops.
+	LowWaterPercent float64
+	// Strategy is the eviction strategy.
+	Strategy string
+	// ProvidersEvicted is the number of providers evicted since startup.
+	ProvidersEvicted int
+	// ContextsEvicted is the number of context IDs evicted since startup.
+	ContextsEvicted int
+	// Recent lists the most recent evictions, oldest first.
+	Recent []Eviction
+}
+
+// providerRegistry is the part of the registry used for eviction.
+type providerRegistry interface {
+	AllProviderInfo() []*registry.ProviderInfo
+	RemoveProvider(context.Context, peer.ID) error
+}
+
+// Compacter is a value store that reclaims the storage of removed content when
+// it is compacted.
+type Compacter interface {
+	// Compact compacts the value store, and returns when compaction is
+	// finished.
+	Compact() error
+}
+
+// Retention monitors storage usage and evicts index content when usage reaches
+// a high-water mark, until usage drops below a low-water mark.
+type Retention struct {
+	batchSize int
+	cancel    context.CancelFunc
+	compacter Compacter
+	done      chan struct{}
+	dstore    datastore.Datastore
+	idxr      indexer.Interface
+	interval  time.Duration
+	protect   map[peer.ID]struct{}
+	reg       providerRegistry
+	usage     func() (float64, error)
+
+	mutex  sync.Mutex
+	status Status
+}
+
+// New creates a Retention that checks the usage of the file systems that the
+// directories in dirPaths are on, and starts monitoring usage. Content is
+// evicted from idxr. When using the context strategy, idxr must be a Tracker
+// that records context IDs in dstore.
+//
+// Removing content from a value store such as pebble does not free storage
+// until the value store is compacted. If compacter is not nil, it is compacted
+// after each batch of content is evicted, before usage is checked again.
+func New(cfg config.Retention, dirPaths []string, idxr indexer.Interface, reg *registry.Registry, dstore datastore.Datastore, compacter Compacter) (*Retention, error) {
+	if len(dirPaths) == 0 {
+		return nil, errors.New("no directories to check usage of")
+	}
+	usage := func() (float64, error) {
+		var maxPercent float64
+		for _, dirPath := range dirPaths {
+			du, err := disk.Usage(dirPath)
+			if err != nil {
+				return 0, err
+			}
+			maxPercent = max(maxPercent, du.Percent)
+		}
+		return maxPercent, nil
+	}
+	return newRetention(cfg, usage, idxr, reg, dstore, compacter)
+}
+
+func newRetention(cfg config.Retention, usage func() (float64, error), idxr indexer.Interface, reg providerRegistry, dstore datastore.Datastore, compacter Compacter) (*Retention, error) {
+	if cfg.LowWaterPercent <= 0 || cfg.HighWaterPercent > 100 || cfg.LowWaterPercent >= cfg.HighWaterPercent {
+		return nil, fmt.Errorf("invalid retention water marks: low %v, high %v", cfg.LowWaterPercent, cfg.HighWaterPercent)
+	}
+
+	batchSize := cfg.BatchSize
+	switch cfg.Strategy {
+	case StrategyProvider:
+		if batchSize <= 0 {
+			batchSize = defaultProviderBatch
+		}
+	case StrategyContext:
+		if dstore == nil {
+			return nil, errors.New("context retention strategy requires a datastore")
+		}
+		if batchSize <= 0 {
+			batchSize = defaultContextBatch
+		}
+	default:
+		return nil, fmt.Errorf("unknown retention strategy: %q", cfg.Strategy)
+	}
+
+	protect := make(map[peer.ID]struct{}, len(cfg.Protect))
+	for _, s := range cfg.Protect {
+		pid, err := peer.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad protected provider id %q: %w", s, err)
+		}
+		protect[pid] = struct{}{}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Retention{
+		batchSize: batchSize,
+		cancel:    cancel,
+		compacter: compacter,
+		done:      make(chan struct{}),
+		dstore:    dstore,
+		idxr:      idxr,
+		interval:  time.Duration(cfg.CheckInterval),
+		protect:   protect,
+		reg:       reg,
+		usage:     usage,
+		status: Status{
+			HighWaterPercent: cfg.HighWaterPercent,
+			LowWaterPercent:  cfg.LowWaterPercent,
+			Strategy:         cfg.Strategy,
+		},
+	}
+	go r.run(ctx)
+	return r, nil
+}
+
+// Close stops monitoring usage and waits for any eviction in progress to stop.
+func (r *Retention) Close() {
+	r.cancel()
+	<-r.done
+}
+
+// Status returns the current state of retention-based eviction.
+func (r *Retention) Status() Status {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	s := r.status
+	s.Recent = make([]Eviction, len(r.status.Recent))
+	copy(s.Recent, r.status.Recent)
+	return s
+}
+
+func (r *Retention) run(ctx context.Context) {
+	defer close(r.done)
+
+	for {
+		r.check(ctx)
+		// Wait a full interval after each check, since evicting and
+		// compacting can take longer than the interval.
+		timer := time.NewTimer(r.interval)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// check gets the current usage, and evicts one batch of content if usage is
+// above the low-water mark after having reached the high-water mark.
+func (r *Retention) check(ctx context.Context) {
+	usage, err := r.usage()
+	if err != nil {
+		log.Errorw("Cannot get storage usage", "err", err)
+		return
+	}
+
+	r.mutex.Lock()
+	r.status.Usage = usage
+	evicting := r.status.Evicting
+	if !evicting && usage >= r.status.HighWaterPercent {
+		log.Warnw("Storage usage reached high-water mark, starting eviction", "usage", usage, "highWater", r.status.HighWaterPercent)
+		evicting = true
+	} else if evicting && usage < r.status.LowWaterPercent {
+		log.Infow("Storage usage below low-water mark, stopping eviction", "usage", usage, "lowWater", r.status.LowWaterPercent)
+		evicting = false
+	}
+	r.status.Evicting = evicting
+	r.mutex.Unlock()
+
+	if !evicting {
+		return
+	}
+
+	var count int
+	if r.status.Strategy == StrategyContext {
+		count, err = r.evictContexts(ctx, usage)
+	} else {
+		count, err = r.evictProviders(ctx, usage)
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return
+		}
+		log.Errorw("Cannot evict index content", "err", err)
+	}
+	if count == 0 && err == nil {
+		log.Warnw("Storage usage above low-water mark, but no content can be evicted", "usage", usage)
+	}
+	if count != 0 && r.compacter != nil {
+		// The storage of evicted content is only freed when the value store
+		// is compacted, so usage is not checked again until then.
+		start := time.Now()
+		if err = r.compacter.Compact(); err != nil {
+			log.Errorw("Cannot compact value store after eviction", "err", err)
+			return
+		}
+		log.Infow("Compacted value store after eviction", "elapsed", time.Since(start).String())
+	}
+}
+
+// evictProviders removes the providers that have gone the longest without
+// publishing an advertisement.
+func (r *Retention) evictProviders(ctx context.Context, usage float64) (int, error) {
+	infos := r.reg.AllProviderInfo()
+	candidates := make([]*registry.ProviderInfo, 0, len(infos))
+	for _, info := range infos {
+		if _, ok := r.protect[info.AddrInfo.ID]; !ok {
+			candidates = append(candidates, info)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastAdvertisementTime.Before(candidates[j].LastAdvertisementTime)
+	})
+	if len(candidates) > r.batchSize {
+		candidates = candidates[:r.batchSize]
+	}
+
+	var count int
+	for _, info := range candidates {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		pid := info.AddrInfo.ID
+		// Remove content before the registry entry, so that the provider is
+		// evicted again if removing its content fails.
+		if err := r.idxr.RemoveProvider(ctx, pid); err != nil {
+			return count, fmt.Errorf("cannot remove content for provider %s: %w", pid, err)
+		}
+		if err := r.reg.RemoveProvider(ctx, pid); err != nil {
+			return count, fmt.Errorf("cannot remove provider %s from registry: %w", pid, err)
+		}
+		log.Infow("Evicted provider", "provider", pid, "lastAdvertisement", info.LastAdvertisementTime, "usage", usage)
+		r.recordEviction(Eviction{
+			Time:     time.Now(),
+			Provider: pid,
+			Usage:    usage,
+		}, true)
+		count++
+	}
+	return count, nil
+}
+
+// evictContexts removes the context IDs that were first indexed longest ago.
+// Context IDs are listed from the time index, oldest first, so only as many
+// entries are read as are needed to fill a batch.
+func (r *Retention) evictContexts(ctx context.Context, usage float64) (int, error) {
+	results, err := r.dstore.Query(ctx, query.Query{
+		Prefix:   timeKeyPrefix,
+		Orders:   []query.Order{query.OrderByKey{}},
+		KeysOnly: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	candidates := make([]trackedContext, 0, r.batchSize)
+	for result := range results.Next() {
+		if result.Error != nil {
+			results.Close()
+			return 0, fmt.Errorf("cannot read tracked context: %w", result.Error)
+		}
+		tc, err := decodeTimeKey(result.Entry.Key)
+		if err != nil {
+			log.Errorw("Bad tracked context entry", "err", err, "key", result.Entry.Key)
+			continue
+		}
+		if _, ok := r.protect[tc.provider]; ok {
+			continue
+		}
+		candidates = append(candidates, tc)
+		if len(candidates) == r.batchSize {
+			break
+		}
+	}
+	results.Close()
+
+	var count int
+	for _, tc := range candidates {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		if err = r.idxr.RemoveProviderContext(tc.provider, tc.contextID); err != nil {
+			return count, fmt.Errorf("cannot remove context for provider %s: %w", tc.provider, err)
+		}
+		// Remove the index entry even if the tracked context was already
+		// gone, so that it is not listed again.
+		if err = r.dstore.Delete(ctx, timeKey(tc.indexed, tc.provider, tc.contextID)); err != nil {
+			return count, fmt.Errorf("cannot remove tracked context: %w", err)
+		}
+		log.Infow("Evicted context", "provider", tc.provider, "contextID", tc.contextID, "indexed", tc.indexed, "usage", usage)
+		r.recordEviction(Eviction{
+			Time:      time.Now(),
+			Provider:  tc.provider,
+			ContextID: tc.contextID,
+			Usage:     usage,
+		}, false)
+		count++
+	}
+	return count, nil
+}
+
+func (r *Retention) recordEviction(ev Eviction, provider bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if provider {
+		r.status.ProvidersEvicted++
+	} else {
+		r.status.ContextsEvicted++
+	}
+	if len(r.status.Recent) == maxRecent {
+		copy(r.status.Recent, r.status.Recent[1:])
+		r.status.Recent = r.status.Recent[:maxRecent-1]
+	}
+	r.status.Recent = append(r.status.Recent, ev)
+}
@@ -0,0 +1,249 @@
+package retention
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipfs/go-test/random"
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-indexer-core/engine"
+	"github.com/ipni/go-indexer-core/store/memory"
+	"github.com/ipni/storetheindex/config"
+	"github.com/ipni/storetheindex/internal/registry"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRegistry struct {
+	mutex sync.Mutex
+	infos map[peer.ID]*registry.ProviderInfo
+}
+
+func (m *mockRegistry) AllProviderInfo() []*registry.ProviderInfo {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	infos := make([]*registry.ProviderInfo, 0, len(m.infos))
+	for _, info := range m.infos {
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (m *mockRegistry) RemoveProvider(_ context.Context, pid peer.ID) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.infos, pid)
+	return nil
+}
+
+func (m *mockRegistry) count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.infos)
+}
+
+func testConfig(strategy string) config.Retention {
+	cfg := config.NewRetention()
+	cfg.CheckInterval = config.Duration(10 * time.Millisecond)
+	cfg.BatchSize = 1
+	cfg.Strategy = strategy
+	return cfg
+}
+
+func TestEvictProviders(t *testing.T) {
+	pids := random.Peers(3)
+	now := time.Now()
+	reg := &mockRegistry{
+		infos: make(map[peer.ID]*registry.ProviderInfo),
+	}
+	ind := engine.New(memory.New())
+	defer ind.Close()
+
+	mhs := random.Multihashes(3)
+	for i, pid := range pids {
+		info := &registry.ProviderInfo{
+			LastAdvertisementTime: now.Add(time.Duration(i) * time.Minute),
+		}
+		info.AddrInfo.ID = pid
+		reg.infos[pid] = info
+		err := ind.Put(indexer.Value{ProviderID: pid, ContextID: []byte("ctx"), MetadataBytes: []byte("meta")}, mhs[i])
+		require.NoError(t, err)
+	}
+
+	// Usage drops below the low-water mark after 2 providers are evicted.
+	usage := func() (float64, error) {
+		return 40.0 + 15.0*float64(reg.count()), nil
+	}
+
+	cfg := testConfig(StrategyProvider)
+	cfg.Protect = []string{pids[1].String()}
+	r, err := newRetention(cfg, usage, ind, reg, nil, nil)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Eventually(t, func() bool {
+		s := r.Status()
+		return s.ProvidersEvicted == 2 && !s.Evicting
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Oldest provider evicted first, and protected provider skipped.
+	status := r.Status()
+	require.Len(t, status.Recent, 2)
+	require.Equal(t, pids[0], status.Recent[0].Provider)
+	require.Equal(t, pids[2], status.Recent[1].Provider)
+	require.Zero(t, status.ContextsEvicted)
+	require.Equal(t, 1, reg.count())
+
+	_, found, err := ind.Get(mhs[0])
+	require.NoError(t, err)
+	require.False(t, found)
+	_, found, err = ind.Get(mhs[1])
+	require.NoError(t, err)
+	require.True(t, found)
+}
+
+func TestEvictContexts(t *testing.T) {
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	ind := engine.New(memory.New())
+	defer ind.Close()
+	tracker := NewTracker(ind, dstore)
+
+	pid := random.Peers(1)[0]
+	mhs := random.Multihashes(3)
+	ctxIDs := [][]byte{[]byte("ctx-0"), []byte("ctx-1"), []byte("ctx-2")}
+	for i, ctxID := range ctxIDs {
+		value := indexer.Value{ProviderID: pid, ContextID: ctxID, MetadataBytes: []byte("meta")}
+		require.NoError(t, tracker.Put(value, mhs[i]))
+		// Putting again must not change the time the context was first indexed.
+		require.NoError(t, tracker.Put(value, mhs[i]))
+		time.Sleep(time.Millisecond)
+	}
+
+	countKeys := func(prefix string) int {
+		results, err := dstore.Query(context.Background(), query.Query{Prefix: prefix, KeysOnly: true})
+		require.NoError(t, err)
+		all, err := results.Rest()
+		require.NoError(t, err)
+		return len(all)
+	}
+	trackedCount := func() int {
+		return countKeys(contextKeyPrefix)
+	}
+	require.Equal(t, 3, trackedCount())
+	require.Equal(t, 3, countKeys(timeKeyPrefix))
+
+	// Usage drops below the low-water mark after 2 contexts are evicted.
+	usage := func() (float64, error) {
+		return 40.0 + 15.0*float64(trackedCount()), nil
+	}
+
+	r, err := newRetention(testConfig(StrategyContext), usage, tracker, &mockRegistry{}, dstore, nil)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Eventually(t, func() bool {
+		s := r.Status()
+		return s.ContextsEvicted == 2 && !s.Evicting
+	}, 5*time.Second, 10*time.Millisecond)
+
+	status := r.Status()
+	require.Len(t, status.Recent, 2)
+	require.Equal(t, ctxIDs[0], status.Recent[0].ContextID)
+	require.Equal(t, ctxIDs[1], status.Recent[1].ContextID)
+	require.Zero(t, status.ProvidersEvicted)
+
+	_, found, err := ind.Get(mhs[0])
+	require.NoError(t, err)
+	require.False(t, found)
+	_, found, err = ind.Get(mhs[2])
+	require.NoError(t, err)
+	require.True(t, found)
+
+	// Removing the provider removes its remaining tracked contexts.
+	require.NoError(t, tracker.RemoveProvider(context.Background(), pid))
+	require.Zero(t, trackedCount())
+	require.Zero(t, countKeys(timeKeyPrefix))
+}
+
+// mockCompacter frees the storage of evicted providers only when compacted.
+type mockCompacter struct {
+	mutex     sync.Mutex
+	reg       *mockRegistry
+	compacted int
+	compacts  int
+}
+
+func (m *mockCompacter) Compact() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.compacted = m.reg.count()
+	m.compacts++
+	return nil
+}
+
+func (m *mockCompacter) usage() (float64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return 40.0 + 15.0*float64(m.compacted), nil
+}
+
+func TestEvictCompact(t *testing.T) {
+	pids := random.Peers(4)
+	reg := &mockRegistry{
+		infos: make(map[peer.ID]*registry.ProviderInfo),
+	}
+	for i, pid := range pids {
+		info := &registry.ProviderInfo{
+			LastAdvertisementTime: time.Now().Add(time.Duration(i) * time.Minute),
+		}
+		info.AddrInfo.ID = pid
+		reg.infos[pid] = info
+	}
+	ind := engine.New(memory.New())
+	defer ind.Close()
+	compacter := &mockCompacter{
+		reg:       reg,
+		compacted: len(pids),
+	}
+
+	// Usage only drops below the low-water mark once 3 evictions have been
+	// compacted, so exactly 3 providers are evicted.
+	r, err := newRetention(testConfig(StrategyProvider), compacter.usage, ind, reg, nil, compacter)
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.Eventually(t, func() bool {
+		s := r.Status()
+		return s.ProvidersEvicted == 3 && !s.Evicting
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	require.Equal(t, 3, r.Status().ProvidersEvicted)
+	require.Equal(t, 1, reg.count())
+	compacter.mutex.Lock()
+	require.Equal(t, 3, compacter.compacts)
+	compacter.mutex.Unlock()
+}
+
+func TestInvalidConfig(t *testing.T) {
+	cfg := testConfig(StrategyProvider)
+	cfg.LowWaterPercent = cfg.HighWaterPercent
+	_, err := newRetention(cfg, nil, nil, nil, nil, nil)
+	require.ErrorContains(t, err, "water marks")
+
+	_, err = newRetention(testConfig("random"), nil, nil, nil, nil, nil)
+	require.ErrorContains(t, err, "unknown retention strategy")
+
+	_, err = newRetention(testConfig(StrategyContext), nil, nil, nil, nil, nil)
+	require.ErrorContains(t, err, "requires a datastore")
+
+	cfg = testConfig(StrategyProvider)
+	cfg.Protect = []string{"bad-id"}
+	_, err = newRetention(cfg, nil, nil, nil, nil, nil)
+	require.ErrorContains(t, err, "bad protected provider id")
+}
@@ -0,0 +1,232 @@
+package retention
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+const (
+	// contextKeyPrefix is the prefix of the keys that record the time that
+	// each context ID was first indexed.
+	contextKeyPrefix = "/retention/ctx/"
+	// timeKeyPrefix is the prefix of the keys that index context IDs by the
+	// time they were first indexed, so that they are listed oldest first.
+	timeKeyPrefix = "/retention/time/"
+
+	// seenCacheSize is the number of recently put context IDs that are
+	// remembered, to avoid checking the datastore on every put.
+	seenCacheSize = 1 << 16
+)
+
+// Tracker is an indexer.Interface that records the time that each provider
+// context ID is first indexed, so that the context IDs indexed longest ago can
+// be evicted.
+type Tracker struct {
+	indexer.Interface
+
+	dstore datastore.Datastore
+	mutex  sync.Mutex
+	seen   *lru.Cache[string, struct{}]
+}
+
+type trackedContext struct {
+	provider  peer.ID
+	contextID []byte
+	indexed   time.Time
+}
+
+// NewTracker creates a Tracker that records context IDs, of the values put
+// into idxr, in dstore.
+func NewTracker(idxr indexer.Interface, dstore datastore.Datastore) *Tracker {
+	// Error only returned for non-positive size.
+	seen, _ := lru.New[string, struct{}](seenCacheSize)
+	return &Tracker{
+		Interface: idxr,
+		dstore:    dstore,
+		seen:      seen,
+	}
+}
+
+func (t *Tracker) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	if err := t.Interface.Put(value, mhs...); err != nil {
+		return err
+	}
+	if len(mhs) == 0 {
+		// Only updating metadata.
+		return nil
+	}
+	key := contextKey(value.ProviderID, value.ContextID)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.seen.Contains(key.String()) {
+		return nil
+	}
+	ctx := context.Background()
+	has, err := t.dstore.Has(ctx, key)
+	if err != nil {
+		return fmt.Errorf("cannot check tracked context: %w", err)
+	}
+	if !has {
+		indexed := time.Now()
+		// Write the time index first, so that a context is never tracked
+		// without being listed for eviction.
+		if err = t.dstore.Put(ctx, timeKey(indexed, value.ProviderID, value.ContextID), nil); err != nil {
+			return fmt.Errorf("cannot track context: %w", err)
+		}
+		if err = t.dstore.Put(ctx, key, encodeTime(indexed)); err != nil {
+			return fmt.Errorf("cannot track context: %w", err)
+		}
+	}
+	t.seen.Add(key.String(), struct{}{})
+	return nil
+}
+
+func (t *Tracker) RemoveProviderContext(providerID peer.ID, contextID []byte) error {
+	if err := t.Interface.RemoveProviderContext(providerID, contextID); err != nil {
+		return err
+	}
+	key := contextKey(providerID, contextID)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.seen.Remove(key.String())
+	return t.untrack(context.Background(), providerID, contextID)
+}
+
+// untrack deletes the tracked context and its time index entry.
+func (t *Tracker) untrack(ctx context.Context, providerID peer.ID, contextID []byte) error {
+	key := contextKey(providerID, contextID)
+	val, err := t.dstore.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, datastore.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("cannot read tracked context: %w", err)
+	}
+	indexed, err := decodeTime(val)
+	if err != nil {
+		return err
+	}
+	if err = t.dstore.Delete(ctx, timeKey(indexed, providerID, contextID)); err != nil {
+		return err
+	}
+	return t.dstore.Delete(ctx, key)
+}
+
+func (t *Tracker) RemoveProvider(ctx context.Context, providerID peer.ID) error {
+	if err := t.Interface.RemoveProvider(ctx, providerID); err != nil {
+		return err
+	}
+	prefix := contextKeyPrefix + providerID.String() + "/"
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	results, err := t.dstore.Query(ctx, query.Query{
+		Prefix:   prefix,
+		KeysOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	var keys []string
+	for result := range results.Next() {
+		if result.Error != nil {
+			results.Close()
+			return fmt.Errorf("cannot read tracked context: %w", result.Error)
+		}
+		keys = append(keys, result.Entry.Key)
+	}
+	results.Close()
+
+	// A key for an empty context ID does not match the prefix.
+	t.seen.Remove(contextKey(providerID, nil).String())
+	if err = t.untrack(ctx, providerID, nil); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		t.seen.Remove(key)
+		ctxStr := strings.TrimPrefix(key, prefix)
+		contextID, err := base64.RawURLEncoding.DecodeString(ctxStr)
+		if err != nil {
+			log.Errorw("Bad tracked context key", "err", err, "key", key)
+			if err = t.dstore.Delete(ctx, datastore.NewKey(key)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = t.untrack(ctx, providerID, contextID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func contextKey(providerID peer.ID, contextID []byte) datastore.Key {
+	return datastore.NewKey(contextKeyPrefix + providerID.String() + "/" + base64.RawURLEncoding.EncodeToString(contextID))
+}
+
+// timeKey returns the key that lists a context ID by the time it was first
+// indexed. The time is fixed-width hex so that keys sort in time order.
+func timeKey(indexed time.Time, providerID peer.ID, contextID []byte) datastore.Key {
+	return datastore.NewKey(fmt.Sprintf("%s%016x/%s/%s", timeKeyPrefix, uint64(indexed.UnixNano()),
+		providerID, base64.RawURLEncoding.EncodeToString(contextID)))
+}
+
+func encodeTime(t time.Time) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(t.UnixNano()))
+	return buf[:]
+}
+
+func decodeTime(b []byte) (time.Time, error) {
+	if len(b) != 8 {
+		return time.Time{}, errors.New("bad time value")
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(b))), nil
+}
+
+func decodeTimeKey(key string) (trackedContext, error) {
+	rest, ok := strings.CutPrefix(key, timeKeyPrefix)
+	if !ok {
+		return trackedContext{}, errors.New("missing key prefix")
+	}
+	timeStr, rest, _ := strings.Cut(rest, "/")
+	nanos, err := strconv.ParseUint(timeStr, 16, 64)
+	if err != nil {
+		return trackedContext{}, err
+	}
+	// An empty context ID has no path element after the provider ID.
+	pidStr, ctxStr, _ := strings.Cut(rest, "/")
+	pid, err := peer.Decode(pidStr)
+	if err != nil {
+		return trackedContext{}, err
+	}
+	contextID, err := base64.RawURLEncoding.DecodeString(ctxStr)
+	if err != nil {
+		return trackedContext{}, err
+	}
+	return trackedContext{
+		provider:  pid,
+		contextID: contextID,
+		indexed:   time.Unix(0, int64(nanos)),
+	}, nil
+}
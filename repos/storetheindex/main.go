@@ -38,11 +38,14 @@ func main() {
 		Commands: []*cli.Command{
 			command.AdminCmd,
 			command.AssignerCmd,
+			command.BackupCmd,
 			command.DaemonCmd,
 			command.GCCmd,
 			command.InitCmd,
 			command.LoadtestCmd,
 			command.LogCmd,
+			command.ReindexCmd,
+			command.RestoreCmd,
 			command.UpdateMirrorCmd,
 		},
 	}
@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ipfs/go-datastore"
+	indexer "github.com/ipni/go-indexer-core"
+	sticfg "github.com/ipni/storetheindex/config"
+	"github.com/ipni/storetheindex/internal/backup"
+	"github.com/ipni/storetheindex/internal/httpserver"
+)
+
+// backupSource is the indexer state that is written to a backup archive.
+type backupSource struct {
+	configPath     string
+	dstore         datastore.Datastore
+	dsTmp          datastore.Datastore
+	valueStore     indexer.Interface
+	valueStoreDir  string
+	valueStoreType string
+}
+
+// backup streams a backup archive of the indexer state. The query parameters
+// config, identity and tmp select whether to include the config file, the
+// private key in the config, and the temporary datastore.
+func (h *adminHandler) backup(w http.ResponseWriter, r *http.Request) {
+	if !httpserver.MethodOK(w, r, http.MethodGet) {
+		return
+	}
+	if h.backupSrc == nil {
+		http.Error(w, "backup not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+	params := map[string]bool{
+		"config":   false,
+		"identity": false,
+		"tmp":      false,
+	}
+	for name := range params {
+		valStr := query.Get(name)
+		if valStr == "" {
+			continue
+		}
+		val, err := strconv.ParseBool(valStr)
+		if err != nil {
+			log.Errorw("Cannot unmarshal backup flag as bool", "flag", name, "value", valStr, "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params[name] = val
+	}
+
+	src := backup.Source{
+		Datastore:      h.backupSrc.dstore,
+		PeerID:         h.id,
+		ValueStoreType: h.backupSrc.valueStoreType,
+	}
+	// A dhstore value store is not local to the indexer, so it is not backed
+	// up.
+	if h.backupSrc.valueStoreType != "dhstore" {
+		src.Indexer = h.backupSrc.valueStore
+	}
+	// Write the checkpoint of a pebble value store on the same file system,
+	// so that its files are hard-linked instead of copied.
+	if h.backupSrc.valueStoreDir != "" {
+		src.TempDir = filepath.Dir(h.backupSrc.valueStoreDir)
+	}
+	if params["tmp"] {
+		src.TmpDatastore = h.backupSrc.dsTmp
+	}
+	if params["config"] {
+		cfg, err := sticfg.Load(h.backupSrc.configPath)
+		if err != nil {
+			log.Errorw("Cannot load config for backup", "err", err)
+			http.Error(w, "cannot load config", http.StatusInternalServerError)
+			return
+		}
+		if params["identity"] {
+			src.Identity = cfg.Identity.PrivKey != ""
+		} else {
+			cfg.Identity = sticfg.Identity{}
+		}
+		src.Config, err = json.MarshalIndent(cfg, "", "  ")
+		if err != nil {
+			log.Errorw("Cannot encode config for backup", "err", err)
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Writing the backup takes longer than the server write timeout.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Warnw("Cannot clear write deadline for backup", "err", err)
+	}
+
+	log.Info("Writing backup")
+	start := time.Now()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	stats, err := backup.Write(r.Context(), w, src)
+	if err != nil {
+		// The archive is incomplete without its end record, so the client
+		// detects the failure.
+		log.Errorw("Failed to write backup", "err", err)
+		return
+	}
+	log.Infow("Finished writing backup", "elapsed", time.Since(start).String(),
+		"datastoreKeys", stats.DatastoreKeys, "tmpDatastoreKeys", stats.TmpDatastoreKeys,
+		"multihashes", stats.Multihashes, "checkpointFiles", stats.CheckpointFiles)
+}
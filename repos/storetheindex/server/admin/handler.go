@@ -12,25 +12,31 @@ import (
 	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipni/go-indexer-core"
 	"github.com/ipni/storetheindex/admin/model"
+	"github.com/ipni/storetheindex/gc/reaper"
 	"github.com/ipni/storetheindex/internal/httpserver"
 	"github.com/ipni/storetheindex/internal/ingest"
 	"github.com/ipni/storetheindex/internal/registry"
+	"github.com/ipni/storetheindex/internal/retention"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
 )
 
 type adminHandler struct {
+	backupSrc         *backupSource
 	ctx               context.Context
+	gcSchedule        *reaper.Schedule
 	id                peer.ID
 	indexer           indexer.Interface
 	ingester          *ingest.Ingester
 	reg               *registry.Registry
 	reloadErrChan     chan<- chan error
+	retention         *retention.Retention
 	pendingSyncs      sync.WaitGroup
 	pendingSyncsPeers map[peer.ID]struct{}
 	pendingSyncsLock  sync.Mutex
@@ -406,6 +412,70 @@ func (h *adminHandler) sync(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GET /ingest/syncs
+func (h *adminHandler) listSyncs(w http.ResponseWriter, r *http.Request) {
+	if !httpserver.MethodOK(w, r, http.MethodGet) {
+		return
+	}
+
+	if h.ingester == nil {
+		http.Error(w, "ingester disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	now := time.Now()
+	progress := h.ingester.Syncs()
+	syncs := make([]model.SyncProgress, len(progress))
+	for i, p := range progress {
+		syncs[i] = model.SyncProgress{
+			Publisher:     p.Publisher,
+			Target:        p.Target,
+			Started:       p.Started,
+			Elapsed:       now.Sub(p.Started),
+			Fetching:      p.Fetching,
+			AdsFetched:    p.AdsFetched,
+			Segment:       p.Segment,
+			Processing:    p.Processing,
+			AdsToProcess:  p.AdsToProcess,
+			AdsProcessed:  p.AdsProcessed,
+			CurrentAd:     p.CurrentAd,
+			EntriesChunks: p.EntriesChunks,
+			Canceled:      p.Canceled,
+		}
+	}
+
+	data, err := json.Marshal(syncs)
+	if err != nil {
+		log.Errorw("Error marshaling sync progress", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	httpserver.WriteJsonResponse(w, http.StatusOK, data)
+}
+
+// DELETE /ingest/syncs/<publisher-id>
+func (h *adminHandler) cancelSync(w http.ResponseWriter, r *http.Request) {
+	if !httpserver.MethodOK(w, r, http.MethodDelete) {
+		return
+	}
+
+	if h.ingester == nil {
+		http.Error(w, "ingester disabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	peerID, ok := decodePeerID(path.Base(r.URL.Path), w)
+	if !ok {
+		return
+	}
+
+	if !h.ingester.CancelSync(peerID) {
+		http.Error(w, "no sync in progress with publisher", http.StatusNotFound)
+		return
+	}
+}
+
 func (h *adminHandler) importProviders(w http.ResponseWriter, r *http.Request) {
 	if !httpserver.MethodOK(w, r, http.MethodPost) {
 		return
@@ -494,9 +564,30 @@ func (h *adminHandler) status(w http.ResponseWriter, r *http.Request) {
 	}
 
 	status := model.Status{
-		Frozen: h.reg.Frozen(),
-		ID:     h.id,
-		Usage:  usage,
+		Frozen:          h.reg.Frozen(),
+		ID:              h.id,
+		Usage:           usage,
+		FreezeAtPercent: h.reg.FreezeAtPercent(),
+	}
+	if h.ingester != nil {
+		status.Ingested = h.ingester.MultihashesIngested()
+	}
+	if h.retention != nil {
+		rs := h.retention.Status()
+		recent := make([]model.Eviction, len(rs.Recent))
+		for i, ev := range rs.Recent {
+			recent[i] = model.Eviction(ev)
+		}
+		status.Retention = &model.RetentionStatus{
+			Evicting:         rs.Evicting,
+			Usage:            rs.Usage,
+			HighWaterPercent: rs.HighWaterPercent,
+			LowWaterPercent:  rs.LowWaterPercent,
+			Strategy:         rs.Strategy,
+			ProvidersEvicted: rs.ProvidersEvicted,
+			ContextsEvicted:  rs.ContextsEvicted,
+			Recent:           recent,
+		}
 	}
 
 	data, err := json.Marshal(status)
@@ -509,6 +600,38 @@ func (h *adminHandler) status(w http.ResponseWriter, r *http.Request) {
 	httpserver.WriteJsonResponse(w, http.StatusOK, data)
 }
 
+func (h *adminHandler) gcStatus(w http.ResponseWriter, r *http.Request) {
+	if !httpserver.MethodOK(w, r, http.MethodGet) {
+		return
+	}
+	if h.gcSchedule == nil {
+		http.Error(w, "garbage collection not enabled", http.StatusNotFound)
+		return
+	}
+
+	p := h.gcSchedule.Progress()
+	status := model.GCStatus{
+		Running:         p.Running,
+		Provider:        p.Provider,
+		ProvidersDone:   p.ProvidersDone,
+		ProvidersFailed: p.ProvidersFailed,
+		ProvidersTotal:  p.ProvidersTotal,
+		RunStarted:      p.RunStarted,
+		RunEnded:        p.RunEnded,
+		NextRun:         p.NextRun,
+		Stats:           model.GCStats(p.Stats),
+	}
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		log.Errorw("Error marshaling gc status", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	httpserver.WriteJsonResponse(w, http.StatusOK, data)
+}
+
 func (h *adminHandler) healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	if !httpserver.MethodOK(w, r, http.MethodGet) {
 		return
@@ -3,6 +3,11 @@ package admin
 import (
 	"fmt"
 	"time"
+
+	"github.com/ipfs/go-datastore"
+	indexer "github.com/ipni/go-indexer-core"
+	"github.com/ipni/storetheindex/gc/reaper"
+	"github.com/ipni/storetheindex/internal/retention"
 )
 
 const (
@@ -12,7 +17,10 @@ const (
 
 // config contains all options for the server.
 type config struct {
+	backupSrc    *backupSource
+	gcSchedule   *reaper.Schedule
 	readTimeout  time.Duration
+	retention    *retention.Retention
 	writeTimeout time.Duration
 }
 
@@ -34,6 +42,42 @@ func getOpts(opts []Option) (config, error) {
 	return cfg, nil
 }
 
+// WithBackup enables streaming a backup archive of the datastore, temporary
+// datastore and value store. The config file at configPath is included in the
+// archive when requested. A value store of type dhstore is not included. A
+// value store checkpoint is written next to valueStoreDir while it is
+// archived.
+func WithBackup(dstore, dsTmp datastore.Datastore, valueStore indexer.Interface, valueStoreDir, configPath, valueStoreType string) Option {
+	return func(c *config) error {
+		c.backupSrc = &backupSource{
+			configPath:     configPath,
+			dstore:         dstore,
+			dsTmp:          dsTmp,
+			valueStore:     valueStore,
+			valueStoreDir:  valueStoreDir,
+			valueStoreType: valueStoreType,
+		}
+		return nil
+	}
+}
+
+// WithGCSchedule sets the schedule of garbage collection run by the daemon, to
+// report the progress of.
+func WithGCSchedule(s *reaper.Schedule) Option {
+	return func(c *config) error {
+		c.gcSchedule = s
+		return nil
+	}
+}
+
+// WithRetention sets the retention-based eviction to report the status of.
+func WithRetention(r *retention.Retention) Option {
+	return func(c *config) error {
+		c.retention = r
+		return nil
+	}
+}
+
 // WithReadTimeout configures server read timeout.
 func WithReadTimeout(t time.Duration) Option {
 	return func(c *config) error {
@@ -49,6 +49,9 @@ func New(listen string, id peer.ID, indexer indexer.Interface, ingester *ingest.
 
 	ctx, cancel := context.WithCancel(context.Background())
 	h := newHandler(ctx, id, indexer, ingester, reg, reloadErrChan)
+	h.backupSrc = opts.backupSrc
+	h.gcSchedule = opts.gcSchedule
+	h.retention = opts.retention
 
 	s := &Server{
 		cancel:   cancel,
@@ -58,7 +61,9 @@ func New(listen string, id peer.ID, indexer indexer.Interface, ingester *ingest.
 	}
 
 	// Admin routes
+	mux.HandleFunc("/backup", h.backup)
 	mux.HandleFunc("/freeze", h.freeze)
+	mux.HandleFunc("/gc", h.gcStatus)
 	mux.HandleFunc("/status", h.status)
 	mux.HandleFunc("/healthcheck", h.healthCheckHandler)
 	mux.HandleFunc("/importproviders", h.importProviders)
@@ -69,6 +74,8 @@ func New(listen string, id peer.ID, indexer indexer.Interface, ingester *ingest.
 	mux.HandleFunc("/ingest/allow/", h.allowPeer)
 	mux.HandleFunc("/ingest/block/", h.blockPeer)
 	mux.HandleFunc("/ingest/sync/", h.sync)
+	mux.HandleFunc("/ingest/syncs", h.listSyncs)
+	mux.HandleFunc("/ingest/syncs/", h.cancelSync)
 
 	// Assignment routes
 	mux.HandleFunc("/ingest/assign/", h.assignPeer)
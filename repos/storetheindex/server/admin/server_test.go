@@ -14,6 +14,7 @@ import (
 	indexer "github.com/ipni/go-indexer-core"
 	"github.com/ipni/go-indexer-core/engine"
 	"github.com/ipni/go-indexer-core/store/memory"
+	"github.com/ipni/go-libipni/apierror"
 	"github.com/ipni/go-libipni/find/model"
 	"github.com/ipni/storetheindex/admin/client"
 	"github.com/ipni/storetheindex/config"
@@ -233,6 +234,19 @@ func TestMarkAdProcessed(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestSyncs(t *testing.T) {
+	te := makeTestenv(t)
+
+	syncs, err := te.client.ListSyncs(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, syncs)
+
+	err = te.client.CancelSync(context.Background(), peerID)
+	var apierr *apierror.Error
+	require.ErrorAs(t, err, &apierr)
+	require.Equal(t, http.StatusNotFound, apierr.Status())
+}
+
 func writeJsonResponse(w http.ResponseWriter, status int, body []byte) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
@@ -0,0 +1,202 @@
+package find
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	coremetrics "github.com/ipni/go-indexer-core/metrics"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/storetheindex/internal/httpserver"
+	"github.com/ipni/storetheindex/internal/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// peerSchema is the delegated routing schema of provider records.
+const peerSchema = "peer"
+
+// drResponse is the JSON body of a delegated routing find response.
+type drResponse struct {
+	Providers []drProvider
+}
+
+// drProvider is a delegated routing provider record with the peer schema.
+// Metadata holds the protocol-specific metadata for each protocol, which is
+// written as a field named after the protocol.
+type drProvider struct {
+	Protocols []string
+	Schema    string
+	ID        peer.ID
+	Addrs     []multiaddr.Multiaddr
+	Metadata  map[string][]byte
+}
+
+func (dp drProvider) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(dp.Metadata)+4)
+	for key, val := range dp.Metadata {
+		m[key] = val
+	}
+	m["Schema"] = dp.Schema
+	m["ID"] = dp.ID
+	if dp.Addrs != nil {
+		m["Addrs"] = dp.Addrs
+	}
+	if dp.Protocols != nil {
+		m["Protocols"] = dp.Protocols
+	}
+	return json.Marshal(m)
+}
+
+// findDelegated serves the HTTP delegated routing API providers request,
+// /routing/v1/providers/{cid}, from the same results as a find request.
+func (s *Server) findDelegated(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !httpserver.MethodOK(w, r, http.MethodGet) {
+		return
+	}
+
+	match, ok := acceptsAnyOf(w, r, false, mediaTypeNDJson, mediaTypeJson, mediaTypeAny)
+	if !ok {
+		return
+	}
+	stream := match == mediaTypeNDJson
+
+	cidVar := path.Base(r.URL.Path)
+	c, err := cid.Decode(cidVar)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid cid %q: %s", cidVar, err), http.StatusBadRequest)
+		return
+	}
+
+	startTime := time.Now()
+	var found bool
+	defer func() {
+		_ = stats.RecordWithOptions(context.Background(),
+			stats.WithTags(tag.Insert(metrics.Method, "delegated"), tag.Insert(metrics.Found, fmt.Sprintf("%v", found))),
+			stats.WithMeasurements(metrics.FindLatency.M(coremetrics.MsecSince(startTime))))
+	}()
+
+	// Delegated routing requests are never filtered, so results are read
+	// from the result cache when it is enabled.
+	var provResults []model.ProviderResult
+	var resultsTag string
+	if s.cache != nil {
+		provResults, resultsTag, err = s.findCached(c.Hash())
+	} else {
+		var response *model.FindResponse
+		response, err = s.find([]multihash.Multihash{c.Hash()}, nil)
+		if err == nil && len(response.MultihashResults) != 0 {
+			provResults = response.MultihashResults[0].ProviderResults
+		}
+	}
+	if err != nil {
+		httpserver.HandleError(w, err, "get")
+		return
+	}
+	if len(provResults) == 0 {
+		http.Error(w, "no results for query", http.StatusNotFound)
+		return
+	}
+	found = true
+
+	if resultsTag != "" {
+		// Delegated routing records are a different representation of the
+		// same results as a find response.
+		etag := responseETag(resultsTag+"-delegated", stream, findQuery{})
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", s.cacheControl)
+		w.Header().Add("Vary", "Accept")
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	providers := delegatedProviders(provResults)
+
+	if stream {
+		setNDJsonHeaders(w)
+		flusher, flushable := w.(http.Flusher)
+		encoder := json.NewEncoder(w)
+		for _, provider := range providers {
+			if err = encoder.Encode(provider); err != nil {
+				log.Errorw("Failed to encode streaming response", "err", err)
+				return
+			}
+			if flushable {
+				flusher.Flush()
+			}
+		}
+		return
+	}
+
+	data, err := json.Marshal(drResponse{
+		Providers: providers,
+	})
+	if err != nil {
+		log.Errorw("Failed to marshal delegated routing response", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	httpserver.WriteJsonResponse(w, http.StatusOK, data)
+}
+
+// delegatedProviders converts provider results to delegated routing provider
+// records, with the protocols and their metadata decoded from the result
+// metadata. Delegated routing records have no context ID, so results that only
+// differ by context ID are returned as a single record.
+func delegatedProviders(results []model.ProviderResult) []drProvider {
+	providers := make([]drProvider, 0, len(results))
+	unique := make(map[uint32]struct{}, len(results))
+
+	for _, result := range results {
+		provider := drProvider{
+			Schema: peerSchema,
+			ID:     result.Provider.ID,
+			Addrs:  result.Provider.Addrs,
+		}
+		md := metadata.Default.New()
+		if err := md.UnmarshalBinary(result.Metadata); err == nil {
+			provider.Metadata = make(map[string][]byte)
+			for _, proto := range md.Protocols() {
+				data, _ := md.Get(proto).MarshalBinary()
+				provider.Protocols = append(provider.Protocols, proto.String())
+				provider.Metadata[proto.String()] = data
+			}
+		}
+
+		key := provider.checksum()
+		if _, ok := unique[key]; ok {
+			continue
+		}
+		unique[key] = struct{}{}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+func (dp *drProvider) checksum() uint32 {
+	buf := []byte(dp.ID)
+	for _, proto := range dp.Protocols {
+		buf = append(buf, proto...)
+	}
+	buf = append(buf, dp.Schema...)
+	for _, proto := range dp.Protocols {
+		buf = append(buf, dp.Metadata[proto]...)
+	}
+	return crc32.ChecksumIEEE(buf)
+}
@@ -0,0 +1,205 @@
+package find
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multicodec"
+)
+
+// Query parameters that filter and paginate find results.
+const (
+	queryCursor   = "cursor"
+	queryExtended = "extended"
+	queryLimit    = "limit"
+	queryProtocol = "protocol"
+	queryProvider = "provider"
+)
+
+// nextCursorHeader is the response header that holds the cursor for the next
+// page of results, when a limit is given and there are more results.
+const nextCursorHeader = "X-Next-Cursor"
+
+// protocolNames maps short protocol names, accepted in addition to the full
+// multicodec names, to the transport multicodec.
+var protocolNames = map[string]multicodec.Code{
+	"bitswap":   multicodec.TransportBitswap,
+	"graphsync": multicodec.TransportGraphsyncFilecoinv1,
+	"http":      multicodec.TransportIpfsGatewayHttp,
+}
+
+// resultFilter selects which provider results are returned for a multihash.
+// A nil resultFilter selects all results.
+type resultFilter struct {
+	// extended, if not nil, selects only results from extended providers when
+	// true, or only results from the main provider when false.
+	extended  *bool
+	protocols map[multicodec.Code]struct{}
+	providers map[peer.ID]struct{}
+}
+
+// findQuery holds the filter and pagination parameters of a find request.
+type findQuery struct {
+	filter *resultFilter
+	// limit is the maximum number of provider results to return. Zero means
+	// no limit.
+	limit  int
+	offset int
+}
+
+// parseFindQuery reads the filter and pagination parameters from the request
+// URL query.
+func parseFindQuery(r *http.Request) (findQuery, error) {
+	var fq findQuery
+	query := r.URL.Query()
+
+	filter, err := parseResultFilter(query)
+	if err != nil {
+		return findQuery{}, err
+	}
+	fq.filter = filter
+
+	if limitStr := query.Get(queryLimit); limitStr != "" {
+		fq.limit, err = strconv.Atoi(limitStr)
+		if err != nil || fq.limit < 1 {
+			return findQuery{}, fmt.Errorf("invalid %s: %q", queryLimit, limitStr)
+		}
+	}
+	if cursor := query.Get(queryCursor); cursor != "" {
+		fq.offset, err = decodeCursor(cursor)
+		if err != nil {
+			return findQuery{}, err
+		}
+	}
+	return fq, nil
+}
+
+func (fq findQuery) paginated() bool {
+	return fq.limit != 0 || fq.offset != 0
+}
+
+// paginate returns the page of results selected by the query, and a cursor for
+// the next page if there are more results.
+func (fq findQuery) paginate(results []model.ProviderResult) ([]model.ProviderResult, string) {
+	if fq.offset >= len(results) {
+		return nil, ""
+	}
+	results = results[fq.offset:]
+	if fq.limit == 0 || len(results) <= fq.limit {
+		return results, ""
+	}
+	return results[:fq.limit], encodeCursor(fq.offset + fq.limit)
+}
+
+func parseResultFilter(query url.Values) (*resultFilter, error) {
+	var filter resultFilter
+	var filtered bool
+
+	for _, name := range splitQueryValues(query[queryProtocol]) {
+		code, ok := protocolNames[strings.ToLower(name)]
+		if !ok {
+			if err := code.Set(name); err != nil {
+				return nil, fmt.Errorf("unknown %s: %q", queryProtocol, name)
+			}
+		}
+		if filter.protocols == nil {
+			filter.protocols = make(map[multicodec.Code]struct{})
+		}
+		filter.protocols[code] = struct{}{}
+		filtered = true
+	}
+
+	for _, idStr := range splitQueryValues(query[queryProvider]) {
+		providerID, err := peer.Decode(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", queryProvider, idStr)
+		}
+		if filter.providers == nil {
+			filter.providers = make(map[peer.ID]struct{})
+		}
+		filter.providers[providerID] = struct{}{}
+		filtered = true
+	}
+
+	if extStr := query.Get(queryExtended); extStr != "" {
+		extended, err := strconv.ParseBool(extStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", queryExtended, extStr)
+		}
+		filter.extended = &extended
+		filtered = true
+	}
+
+	if !filtered {
+		return nil, nil
+	}
+	return &filter, nil
+}
+
+// match returns true if the result is selected by the filter. The extended
+// argument tells whether the result is from an extended provider.
+func (f *resultFilter) match(result *model.ProviderResult, extended bool) bool {
+	if f == nil {
+		return true
+	}
+	if f.extended != nil && *f.extended != extended {
+		return false
+	}
+	if f.providers != nil {
+		if _, ok := f.providers[result.Provider.ID]; !ok {
+			return false
+		}
+	}
+	if f.protocols != nil {
+		md := metadata.Default.New()
+		if err := md.UnmarshalBinary(result.Metadata); err != nil {
+			return false
+		}
+		for _, proto := range md.Protocols() {
+			if _, ok := f.protocols[proto]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// splitQueryValues returns the values of a query parameter that may be given
+// multiple times, or as a comma-separated list, or both.
+func splitQueryValues(values []string) []string {
+	var split []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				split = append(split, s)
+			}
+		}
+	}
+	return split
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	errBadCursor := errors.New("invalid cursor")
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, errBadCursor
+	}
+	offset, err := strconv.Atoi(string(data))
+	if err != nil || offset < 0 {
+		return 0, errBadCursor
+	}
+	return offset, nil
+}
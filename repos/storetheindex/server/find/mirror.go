@@ -0,0 +1,345 @@
+package find
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/ipni/go-libipni/dagsync/ipnisync/head"
+	"github.com/ipni/go-libipni/ingest/schema"
+	"github.com/ipni/storetheindex/carstore"
+	"github.com/ipni/storetheindex/internal/httpserver"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	// mirrorPath is the path under which each provider's mirrored
+	// advertisement chain is published, as /mirror/<provider-id>/ipni/v1/ad/.
+	mirrorPath = "/mirror/"
+
+	// mirrorEntriesCacheSize is the number of entries block CIDs for which to
+	// remember the advertisement CAR file that contains the block.
+	mirrorEntriesCacheSize = 1 << 16
+	// mirrorEntriesKeyPrefix is the datastore key prefix of the entries block
+	// CIDs mapped to the advertisement CAR file that contains the block.
+	mirrorEntriesKeyPrefix = "/mirror/entries/"
+
+	// mirrorCursors is the maximum number of CAR files kept open for reading
+	// the next entries block.
+	mirrorCursors = 64
+	// mirrorCursorIdle is how long a CAR file is kept open without the next
+	// entries block being requested.
+	mirrorCursorIdle = time.Minute
+)
+
+// mirrorPublisher serves the advertisement chains stored in the CAR mirror
+// using the ipnisync HTTP protocol, with the head of each chain read from the
+// provider's head file.
+type mirrorPublisher struct {
+	carReader *carstore.CarReader
+	privKey   crypto.PrivKey
+	topic     string
+
+	// dstore, if not nil, persists the mapping of entries blocks to
+	// advertisements, so that entries are found after a restart.
+	dstore datastore.Datastore
+	// entriesAd maps entries block CIDs to the CID of the advertisement
+	// whose CAR file contains the entries block.
+	entriesAd *lru.Cache[cid.Cid, cid.Cid]
+
+	mutex sync.Mutex
+	// cursors holds open CAR files by the CID of the next entries block to
+	// read from them.
+	cursors *lru.Cache[cid.Cid, *carCursor]
+}
+
+// carCursor is a CAR file that is read up to an entries block. Entries blocks
+// are requested in the order they are stored in the CAR file, so reading the
+// next block from the cursor reads each CAR file only once.
+type carCursor struct {
+	adCid   cid.Cid
+	next    carstore.EntryBlock
+	entries <-chan carstore.EntryBlock
+	cancel  context.CancelFunc
+	used    time.Time
+	// taken is set while the cursor is removed from the cache to be read, so
+	// that removing it does not close it. Guarded by mirrorPublisher.mutex.
+	taken bool
+}
+
+// close stops reading the CAR file.
+func (c *carCursor) close() {
+	c.cancel()
+	// Drain the entries so that the reader closes the file.
+	go func() {
+		for range c.entries {
+		}
+	}()
+}
+
+func newMirrorPublisher(carReader *carstore.CarReader, dstore datastore.Datastore, privKey crypto.PrivKey, topic string) (*mirrorPublisher, error) {
+	entriesAd, err := lru.New[cid.Cid, cid.Cid](mirrorEntriesCacheSize)
+	if err != nil {
+		return nil, err
+	}
+	cursors, err := lru.NewWithEvict[cid.Cid, *carCursor](mirrorCursors, func(_ cid.Cid, cur *carCursor) {
+		if !cur.taken {
+			cur.close()
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorPublisher{
+		carReader: carReader,
+		dstore:    dstore,
+		privKey:   privKey,
+		topic:     topic,
+		entriesAd: entriesAd,
+		cursors:   cursors,
+	}, nil
+}
+
+// close closes all open CAR files.
+func (m *mirrorPublisher) close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.cursors.Purge()
+}
+
+func (s *Server) serveMirror(w http.ResponseWriter, r *http.Request) {
+	if !httpserver.MethodOK(w, r, http.MethodGet) {
+		return
+	}
+
+	// Path is /mirror/<provider-id>/ipni/v1/ad/<head or cid>
+	provStr, rest, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, mirrorPath), "/")
+	if !ok {
+		http.Error(w, "missing provider id", http.StatusNotFound)
+		return
+	}
+	ask, ok := strings.CutPrefix("/"+rest, ipnisync.IPNIPath+"/")
+	if !ok || ask == "" || strings.Contains(ask, "/") {
+		http.Error(w, "invalid request path: "+r.URL.Path, http.StatusBadRequest)
+		return
+	}
+	providerID, err := peer.Decode(provStr)
+	if err != nil {
+		log.Infow("Request for mirror with invalid provider id", "err", err)
+		http.Error(w, "invalid provider id", http.StatusBadRequest)
+		return
+	}
+
+	if ask == "head" {
+		s.mirror.serveHead(r.Context(), w, providerID)
+		return
+	}
+
+	c, err := cid.Parse(ask)
+	if err != nil {
+		http.Error(w, "invalid request: not a cid", http.StatusBadRequest)
+		return
+	}
+	s.mirror.serveBlock(r.Context(), w, c)
+}
+
+func (m *mirrorPublisher) serveHead(ctx context.Context, w http.ResponseWriter, providerID peer.ID) {
+	headCid, err := m.carReader.ReadHead(ctx, providerID)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "", http.StatusNoContent)
+			return
+		}
+		log.Errorw("Cannot read mirror head", "err", err, "provider", providerID)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+
+	signedHead, err := head.NewSignedHead(headCid, m.topic, m.privKey)
+	if err != nil {
+		log.Errorw("Cannot sign mirror head", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	data, err := signedHead.Encode()
+	if err != nil {
+		log.Errorw("Cannot encode mirror head", "err", err)
+		http.Error(w, "", http.StatusInternalServerError)
+		return
+	}
+	httpserver.WriteJsonResponse(w, http.StatusOK, data)
+}
+
+// serveBlock writes the raw data of the advertisement or entries block. An
+// advertisement is read from its CAR file. An entries block is read from the
+// CAR file of an advertisement that was previously served and links to the
+// entries.
+func (m *mirrorPublisher) serveBlock(ctx context.Context, w http.ResponseWriter, c cid.Cid) {
+	data, err := m.readBlock(ctx, c)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, "cid not found", http.StatusNotFound)
+			return
+		}
+		log.Errorw("Cannot read block from mirror", "err", err, "cid", c)
+		http.Error(w, "unable to load data for cid", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(data)
+}
+
+func (m *mirrorPublisher) readBlock(ctx context.Context, c cid.Cid) ([]byte, error) {
+	if cur := m.takeCursor(c); cur != nil {
+		return m.advance(ctx, cur), nil
+	}
+
+	adCid, ok := m.entriesAdCid(ctx, c)
+	if !ok {
+		// Not a known entries block, so read as an advertisement.
+		adBlock, err := m.carReader.Read(ctx, c, true)
+		if err != nil {
+			return nil, err
+		}
+		ad, err := adBlock.Advertisement()
+		if err != nil {
+			return nil, err
+		}
+		if ad.Entries != nil && ad.Entries != schema.NoEntries {
+			m.addEntriesAd(ctx, ad.Entries.(cidlink.Link).Cid, c)
+		}
+		return adBlock.Data, nil
+	}
+
+	// Read the CAR file up to the entries block. The CAR file stays open, to
+	// read the following entries block, after the request is done.
+	readCtx, cancel := context.WithCancel(context.Background())
+	adBlock, err := m.carReader.Read(readCtx, adCid, false)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if adBlock.Entries == nil {
+		cancel()
+		return nil, fs.ErrNotExist
+	}
+	cur := &carCursor{
+		adCid:   adCid,
+		entries: adBlock.Entries,
+		cancel:  cancel,
+	}
+	for entBlock := range adBlock.Entries {
+		if entBlock.Err != nil {
+			cur.close()
+			return nil, entBlock.Err
+		}
+		m.addEntriesAd(ctx, entBlock.Cid, adCid)
+		if entBlock.Cid == c {
+			cur.next = entBlock
+			return m.advance(ctx, cur), nil
+		}
+	}
+	cancel()
+	return nil, fs.ErrNotExist
+}
+
+// takeCursor removes and returns the cursor whose next entries block is c, or
+// returns nil if there is none.
+func (m *mirrorPublisher) takeCursor(c cid.Cid) *carCursor {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cur, ok := m.cursors.Peek(c)
+	if !ok {
+		return nil
+	}
+	cur.taken = true
+	m.cursors.Remove(c)
+	return cur
+}
+
+// advance returns the data of the cursor's next entries block, and reads the
+// block after it. The cursor is kept for reading that block, unless it is the
+// end of the CAR file.
+func (m *mirrorPublisher) advance(ctx context.Context, cur *carCursor) []byte {
+	data := cur.next.Data
+	entBlock, ok := <-cur.entries
+	if !ok || entBlock.Err != nil {
+		cur.close()
+		return data
+	}
+	m.addEntriesAd(ctx, entBlock.Cid, cur.adCid)
+	cur.next = entBlock
+	cur.used = time.Now()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	// Close cursors that have not been used recently, oldest first.
+	for {
+		_, oldest, ok := m.cursors.GetOldest()
+		if !ok || time.Since(oldest.used) < mirrorCursorIdle {
+			break
+		}
+		m.cursors.RemoveOldest()
+	}
+	if m.cursors.Contains(entBlock.Cid) {
+		// Another sync of the same chain is already reading this CAR file.
+		cur.close()
+		return data
+	}
+	cur.taken = false
+	m.cursors.Add(entBlock.Cid, cur)
+	return data
+}
+
+// entriesAdCid returns the CID of the advertisement whose CAR file contains
+// the entries block.
+func (m *mirrorPublisher) entriesAdCid(ctx context.Context, c cid.Cid) (cid.Cid, bool) {
+	if adCid, ok := m.entriesAd.Get(c); ok {
+		return adCid, true
+	}
+	if m.dstore == nil {
+		return cid.Undef, false
+	}
+	val, err := m.dstore.Get(ctx, datastore.NewKey(mirrorEntriesKeyPrefix+c.String()))
+	if err != nil {
+		if !errors.Is(err, datastore.ErrNotFound) {
+			log.Errorw("Cannot read mirror entries mapping", "err", err, "cid", c)
+		}
+		return cid.Undef, false
+	}
+	_, adCid, err := cid.CidFromBytes(val)
+	if err != nil {
+		log.Errorw("Bad mirror entries mapping", "err", err, "cid", c)
+		return cid.Undef, false
+	}
+	m.entriesAd.Add(c, adCid)
+	return adCid, true
+}
+
+// addEntriesAd records the CID of the advertisement whose CAR file contains
+// the entries block.
+func (m *mirrorPublisher) addEntriesAd(ctx context.Context, c, adCid cid.Cid) {
+	if prev, ok := m.entriesAd.Get(c); ok && prev == adCid {
+		return
+	}
+	m.entriesAd.Add(c, adCid)
+	if m.dstore == nil {
+		return
+	}
+	err := m.dstore.Put(ctx, datastore.NewKey(mirrorEntriesKeyPrefix+c.String()), adCid.Bytes())
+	if err != nil {
+		log.Errorw("Cannot store mirror entries mapping", "err", err, "cid", c)
+	}
+}
@@ -1,12 +1,19 @@
 package find
 
 import (
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipni/storetheindex/carstore"
+	"github.com/libp2p/go-libp2p/core/crypto"
 )
 
 const (
+	defaultCacheMaxAge  = time.Minute
 	defaultHomepage     = "https://web-ipni.cid.contact/"
+	defaultMaxBatchSize = 1000
 	defaultMaxConns     = 8_000
 	defaultReadTimeout  = 30 * time.Second
 	defaultWriteTimeout = 30 * time.Second
@@ -14,8 +21,15 @@ const (
 
 // config contains all options for the server.
 type config struct {
+	cacheMaxAge  time.Duration
+	cacheSize    int
 	homepageURL  string
+	maxBatchSize int
 	maxConns     int
+	mirrorDstore datastore.Datastore
+	mirrorKey    crypto.PrivKey
+	mirrorReader *carstore.CarReader
+	mirrorTopic  string
 	readTimeout  time.Duration
 	writeTimeout time.Duration
 	version      string
@@ -27,7 +41,9 @@ type Option func(*config) error
 // getOpts creates a config and applies Options to it.
 func getOpts(opts []Option) (config, error) {
 	cfg := config{
+		cacheMaxAge:  defaultCacheMaxAge,
 		homepageURL:  defaultHomepage,
+		maxBatchSize: defaultMaxBatchSize,
 		maxConns:     defaultMaxConns,
 		readTimeout:  defaultReadTimeout,
 		writeTimeout: defaultWriteTimeout,
@@ -41,6 +57,27 @@ func getOpts(opts []Option) (config, error) {
 	return cfg, nil
 }
 
+// WithCacheSize sets the number of multihashes whose find results are kept in
+// the server's result cache. A value of zero or less disables the cache.
+func WithCacheSize(size int) Option {
+	return func(c *config) error {
+		c.cacheSize = size
+		return nil
+	}
+}
+
+// WithCacheMaxAge sets the maximum time that results are kept in the result
+// cache, and the max-age that responses from the cache tell clients to cache
+// them for. A value of zero or less sets the default.
+func WithCacheMaxAge(maxAge time.Duration) Option {
+	return func(c *config) error {
+		if maxAge > 0 {
+			c.cacheMaxAge = maxAge
+		}
+		return nil
+	}
+}
+
 // WithHomepage config for API.
 func WithHomepage(URL string) Option {
 	return func(c *config) error {
@@ -49,6 +86,17 @@ func WithHomepage(URL string) Option {
 	}
 }
 
+// WithMaxBatchSize sets the maximum number of CIDs and multihashes allowed in
+// a batch find request. A value of zero or less sets the default.
+func WithMaxBatchSize(size int) Option {
+	return func(c *config) error {
+		if size > 0 {
+			c.maxBatchSize = size
+		}
+		return nil
+	}
+}
+
 // MaxConnections config allowed by server.
 func WithMaxConnections(maxConnections int) Option {
 	return func(c *config) error {
@@ -80,3 +128,33 @@ func WithVersion(ver string) Option {
 		return nil
 	}
 }
+
+// WithMirror publishes the advertisement chains in the CAR mirror read by
+// carReader using the ipnisync HTTP protocol. Each provider's chain is served
+// at /mirror/<provider-id>/, with the chain head read from the provider's head
+// file. Head messages are signed with privKey and use the given topic.
+func WithMirror(carReader *carstore.CarReader, privKey crypto.PrivKey, topic string) Option {
+	return func(c *config) error {
+		if carReader == nil {
+			return nil
+		}
+		if privKey == nil {
+			return errors.New("mirror publishing requires private key")
+		}
+		c.mirrorReader = carReader
+		c.mirrorKey = privKey
+		c.mirrorTopic = topic
+		return nil
+	}
+}
+
+// WithMirrorDatastore stores, in dstore, which advertisement CAR file in the
+// mirror contains each entries block that has been published. This lets
+// entries be published after a restart without first publishing their
+// advertisement again.
+func WithMirrorDatastore(dstore datastore.Datastore) Option {
+	return func(c *config) error {
+		c.mirrorDstore = dstore
+		return nil
+	}
+}
@@ -0,0 +1,297 @@
+package find
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/simplelru"
+	"github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/storetheindex/internal/metrics"
+	"github.com/ipni/storetheindex/internal/registry"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+	"go.opencensus.io/stats"
+	"go.opencensus.io/tag"
+)
+
+// resultCache is an LRU cache of the unfiltered provider results for single
+// multihash find requests.
+//
+// Cached results are removed when values for their multihash are put or
+// removed through the indexer returned by Server.CacheInvalidator, and when
+// values are removed for any provider in the results. Changes to provider
+// info are detected when the cached results are read, by checking that the
+// registry still holds the same provider info that the results were
+// assembled from.
+type resultCache struct {
+	lru    *simplelru.LRU[string, *cacheEntry]
+	maxAge time.Duration
+	mutex  sync.Mutex
+	// pending holds a token for each key whose results are being looked up.
+	// Invalidating the key deletes its token, so that results looked up
+	// before the invalidation are not cached.
+	pending   map[string]uint64
+	nextToken uint64
+	// provKeys indexes the cached keys by the providers of their values.
+	provKeys map[peer.ID]map[string]struct{}
+}
+
+type cacheEntry struct {
+	created time.Time
+	// etag identifies the content of the cached results.
+	etag string
+	// provInfos holds the provider info that the results were assembled
+	// from. A provider with nil info was omitted from the results.
+	provInfos map[peer.ID]*registry.ProviderInfo
+	results   []model.ProviderResult
+}
+
+func newResultCache(size int, maxAge time.Duration) (*resultCache, error) {
+	c := &resultCache{
+		maxAge:   maxAge,
+		pending:  make(map[string]uint64),
+		provKeys: make(map[peer.ID]map[string]struct{}),
+	}
+	var err error
+	c.lru, err = simplelru.NewLRU[string, *cacheEntry](size, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// get returns the cached entry for the key, or nil if there is no entry or
+// the entry is older than the maximum age.
+func (c *resultCache) get(key string) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.lru.Get(key)
+	if !ok {
+		return nil
+	}
+	if c.maxAge > 0 && time.Since(entry.created) >= c.maxAge {
+		c.lru.Remove(key)
+		return nil
+	}
+	return entry
+}
+
+// begin records that results for the key are being looked up, and returns a
+// token to pass to finish.
+func (c *resultCache) begin(key string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.nextToken++
+	c.pending[key] = c.nextToken
+	return c.nextToken
+}
+
+// finish caches the entry for the key, unless the key was invalidated since
+// begin was called. A nil entry ends the lookup without caching anything.
+func (c *resultCache) finish(key string, token uint64, entry *cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.pending[key] != token {
+		return
+	}
+	delete(c.pending, key)
+	if entry == nil {
+		return
+	}
+	c.lru.Add(key, entry)
+	for provID := range entry.provInfos {
+		keys, ok := c.provKeys[provID]
+		if !ok {
+			keys = make(map[string]struct{})
+			c.provKeys[provID] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// remove removes the cached results for the keys.
+func (c *resultCache) remove(keys ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(c.pending, key)
+		c.lru.Remove(key)
+	}
+}
+
+// removeProvider removes all cached results that have values from the
+// provider.
+func (c *resultCache) removeProvider(providerID peer.ID) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.provKeys[providerID] {
+		c.lru.Remove(key)
+	}
+	// The providers of pending lookups are not known yet.
+	clear(c.pending)
+}
+
+// onEvict is called, with the mutex held, when an entry is removed from the
+// LRU.
+func (c *resultCache) onEvict(key string, entry *cacheEntry) {
+	for provID := range entry.provInfos {
+		keys := c.provKeys[provID]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.provKeys, provID)
+		}
+	}
+}
+
+// findCached returns the unfiltered provider results for a multihash, and a
+// tag that identifies their content. Results are read from the result cache,
+// or looked up and cached if they are not in the cache or are no longer
+// valid.
+func (s *Server) findCached(mh multihash.Multihash) ([]model.ProviderResult, string, error) {
+	key := string(mh)
+	entry := s.cache.get(key)
+	if entry != nil && s.cacheEntryValid(entry) {
+		recordCacheLookup(true)
+		return entry.results, entry.etag, nil
+	}
+	recordCacheLookup(false)
+
+	token := s.cache.begin(key)
+	provInfos := map[peer.ID]*registry.ProviderInfo{}
+	provResults, err := s.findProviderResults(mh, provInfos, nil)
+	if err != nil || len(provResults) == 0 {
+		s.cache.finish(key, token, nil)
+		return nil, "", err
+	}
+
+	data, err := json.Marshal(provResults)
+	if err != nil {
+		s.cache.finish(key, token, nil)
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	entry = &cacheEntry{
+		created:   time.Now(),
+		etag:      hex.EncodeToString(sum[:8]),
+		provInfos: provInfos,
+		results:   provResults,
+	}
+	s.cache.finish(key, token, entry)
+	return entry.results, entry.etag, nil
+}
+
+// cacheEntryValid returns true if the registry has the same provider info for
+// each provider that the cached results were assembled from.
+func (s *Server) cacheEntryValid(entry *cacheEntry) bool {
+	for provID, cached := range entry.provInfos {
+		pinfo, allowed := s.registry.ProviderInfo(provID)
+		if pinfo == nil || !allowed || pinfo.Inactive() {
+			pinfo = nil
+		}
+		if pinfo != cached {
+			return false
+		}
+	}
+	return true
+}
+
+func recordCacheLookup(hit bool) {
+	_ = stats.RecordWithOptions(context.Background(),
+		stats.WithTags(tag.Insert(metrics.Hit, strconv.FormatBool(hit))),
+		stats.WithMeasurements(metrics.FindCacheLookup.M(1)))
+}
+
+// responseETag returns the ETag for a response made from cached results with
+// the given tag. The ETag also identifies the representation and page of the
+// results.
+func responseETag(resultsTag string, stream bool, fq findQuery) string {
+	etag := resultsTag
+	if stream {
+		etag += "-ndjson"
+	}
+	if fq.paginated() {
+		etag += fmt.Sprintf("-o%d-l%d", fq.offset, fq.limit)
+	}
+	return strconv.Quote(etag)
+}
+
+// etagMatch returns true if the If-None-Match header value matches the ETag.
+func etagMatch(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheInvalidator returns an indexer.Interface that passes all calls to ind,
+// and removes the cached find results that are affected by values put into or
+// removed from ind. Values must be put and removed through the returned
+// interface for the results served by the find server to stay current. If the
+// result cache is disabled, then ind is returned.
+func (s *Server) CacheInvalidator(ind indexer.Interface) indexer.Interface {
+	if s.cache == nil {
+		return ind
+	}
+	return &invalidatingIndexer{
+		Interface: ind,
+		cache:     s.cache,
+	}
+}
+
+type invalidatingIndexer struct {
+	indexer.Interface
+	cache *resultCache
+}
+
+func (x *invalidatingIndexer) Put(value indexer.Value, mhs ...multihash.Multihash) error {
+	err := x.Interface.Put(value, mhs...)
+	if len(mhs) == 0 {
+		// Metadata update for existing values of the provider.
+		x.cache.removeProvider(value.ProviderID)
+	} else {
+		x.cache.remove(mhKeys(mhs)...)
+	}
+	return err
+}
+
+func (x *invalidatingIndexer) Remove(value indexer.Value, mhs ...multihash.Multihash) error {
+	err := x.Interface.Remove(value, mhs...)
+	x.cache.remove(mhKeys(mhs)...)
+	return err
+}
+
+func (x *invalidatingIndexer) RemoveProvider(ctx context.Context, providerID peer.ID) error {
+	err := x.Interface.RemoveProvider(ctx, providerID)
+	x.cache.removeProvider(providerID)
+	return err
+}
+
+func (x *invalidatingIndexer) RemoveProviderContext(providerID peer.ID, contextID []byte) error {
+	err := x.Interface.RemoveProviderContext(providerID, contextID)
+	x.cache.removeProvider(providerID)
+	return err
+}
+
+func mhKeys(mhs []multihash.Multihash) []string {
+	keys := make([]string, len(mhs))
+	for i, mh := range mhs {
+		keys[i] = string(mh)
+	}
+	return keys
+}
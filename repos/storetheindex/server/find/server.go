@@ -5,6 +5,7 @@ import (
 	"context"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -31,14 +32,29 @@ import (
 var log = logging.Logger("indexer/find")
 
 type Server struct {
-	server    *http.Server
-	listener  net.Listener
-	healthMsg string
-	indexer   indexer.Interface
-	registry  *registry.Registry
-	stats     *cachedStats
+	server       *http.Server
+	listener     net.Listener
+	cache        *resultCache
+	cacheControl string
+	healthMsg    string
+	indexer      indexer.Interface
+	maxBatchSize int
+	mirror       *mirrorPublisher
+	registry     *registry.Registry
+	stats        *cachedStats
 }
 
+// batchFindRequest is the JSON body of a batch find request. Each CID is a
+// string-encoded CID, and each multihash is a base58 or hex encoded multihash.
+type batchFindRequest struct {
+	Cids        []string `json:",omitempty"`
+	Multihashes []string `json:",omitempty"`
+}
+
+// maxBytesPerBatchKey is the request body size allowed for each key in a batch
+// find request.
+const maxBytesPerBatchKey = 256
+
 func (s *Server) URL() string {
 	return fmt.Sprint("http://", s.listener.Addr().String())
 }
@@ -84,11 +100,28 @@ func New(listen string, indexer indexer.Interface, registry *registry.Registry,
 		ReadTimeout:  opts.readTimeout,
 	}
 	s := &Server{
-		server:   server,
-		listener: l,
-		indexer:  indexer,
-		registry: registry,
-		stats:    newCachedStats(indexer, time.Hour),
+		server:       server,
+		listener:     l,
+		indexer:      indexer,
+		maxBatchSize: opts.maxBatchSize,
+		registry:     registry,
+		stats:        newCachedStats(indexer, time.Hour),
+	}
+
+	if opts.cacheSize > 0 {
+		s.cache, err = newResultCache(opts.cacheSize, opts.cacheMaxAge)
+		if err != nil {
+			return nil, err
+		}
+		s.cacheControl = fmt.Sprintf("public, max-age=%d", int(opts.cacheMaxAge.Seconds()))
+	}
+
+	if opts.mirrorReader != nil {
+		s.mirror, err = newMirrorPublisher(opts.mirrorReader, opts.mirrorDstore, opts.mirrorKey, opts.mirrorTopic)
+		if err != nil {
+			return nil, err
+		}
+		mux.HandleFunc(mirrorPath, s.serveMirror)
 	}
 
 	s.healthMsg = "ready"
@@ -112,10 +145,12 @@ func New(listen string, indexer indexer.Interface, registry *registry.Registry,
 		}
 	})
 	mux.HandleFunc("/cid/", s.findCid)
+	mux.HandleFunc("/multihash", s.findBatch)
 	mux.HandleFunc("/multihash/", s.findMultihash)
 	mux.HandleFunc("/health", s.health)
 	mux.HandleFunc("/providers", s.listProviders)
 	mux.HandleFunc("/providers/", s.getProvider)
+	mux.HandleFunc("/routing/v1/providers/", s.findDelegated)
 	mux.HandleFunc("/stats", s.getStats)
 
 	return s, nil
@@ -133,7 +168,11 @@ func (s *Server) RefreshStats() {
 func (s *Server) Close() error {
 	log.Info("find http server shutdown")
 	s.stats.close()
-	return s.server.Shutdown(context.Background())
+	err := s.server.Shutdown(context.Background())
+	if s.mirror != nil {
+		s.mirror.close()
+	}
+	return err
 }
 
 func enableCors(w http.ResponseWriter) {
@@ -161,7 +200,12 @@ func (s *Server) findCid(w http.ResponseWriter, r *http.Request) {
 		httpserver.HandleError(w, err, "find")
 		return
 	}
-	s.getIndexes(w, []multihash.Multihash{c.Hash()}, stream)
+	fq, err := parseFindQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.getIndexes(w, r, []multihash.Multihash{c.Hash()}, stream, fq)
 }
 
 func (s *Server) findMultihash(w http.ResponseWriter, r *http.Request) {
@@ -178,19 +222,96 @@ func (s *Server) findMultihash(w http.ResponseWriter, r *http.Request) {
 	// Explicitly accepts NDJson.
 	stream := match == mediaTypeNDJson
 
-	mhVar := path.Base(r.URL.Path)
-	m, err := multihash.FromB58String(mhVar)
+	m, err := decodeMultihash(path.Base(r.URL.Path))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fq, err := parseFindQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.getIndexes(w, r, []multihash.Multihash{m}, stream, fq)
+}
+
+func (s *Server) findBatch(w http.ResponseWriter, r *http.Request) {
+	enableCors(w)
+
+	if !httpserver.MethodOK(w, r, http.MethodPost) {
+		return
+	}
+
+	match, ok := acceptsAnyOf(w, r, false, mediaTypeNDJson, mediaTypeJson, mediaTypeAny)
+	if !ok {
+		return
+	}
+	// Explicitly accepts NDJson.
+	stream := match == mediaTypeNDJson
+
+	fq, err := parseFindQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if fq.paginated() {
+		http.Error(w, "find: pagination not supported for batch find", http.StatusBadRequest)
+		return
+	}
+
+	body := http.MaxBytesReader(w, r.Body, int64(s.maxBatchSize*maxBytesPerBatchKey))
+	var req batchFindRequest
+	if err = json.NewDecoder(body).Decode(&req); err != nil {
+		log.Errorw("Cannot decode batch find request", "err", err)
+		http.Error(w, "find: cannot decode batch request", http.StatusBadRequest)
+		return
+	}
+
+	count := len(req.Cids) + len(req.Multihashes)
+	if count == 0 {
+		http.Error(w, "find: no cids or multihashes in batch request", http.StatusBadRequest)
+		return
+	}
+	if count > s.maxBatchSize {
+		msg := fmt.Sprintf("find: batch size %d exceeds maximum of %d", count, s.maxBatchSize)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	mhs := make([]multihash.Multihash, 0, count)
+	for _, cidStr := range req.Cids {
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			log.Errorw("error decoding cid", "cid", cidStr, "err", err)
+			http.Error(w, fmt.Sprintf("find: invalid cid %q: %s", cidStr, err), http.StatusBadRequest)
+			return
+		}
+		mhs = append(mhs, c.Hash())
+	}
+	for _, mhStr := range req.Multihashes {
+		m, err := decodeMultihash(mhStr)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mhs = append(mhs, m)
+	}
+	s.getIndexes(w, r, mhs, stream, fq)
+}
+
+// decodeMultihash decodes a base58 or hex encoded multihash.
+func decodeMultihash(mhStr string) (multihash.Multihash, error) {
+	m, err := multihash.FromB58String(mhStr)
 	if err != nil {
 		var hexErr error
-		m, hexErr = multihash.FromHexString(mhVar)
+		m, hexErr = multihash.FromHexString(mhStr)
 		if hexErr != nil {
 			msg := "find: input is not a valid base58 or hex encoded multihash"
-			log.Errorw(msg, "multihash", mhVar, "err", err, "hexErr", hexErr)
-			http.Error(w, msg, http.StatusBadRequest)
-			return
+			log.Errorw(msg, "multihash", mhStr, "err", err, "hexErr", hexErr)
+			return nil, errors.New(msg)
 		}
 	}
-	s.getIndexes(w, []multihash.Multihash{m}, stream)
+	return m, nil
 }
 
 func (s *Server) listProviders(w http.ResponseWriter, r *http.Request) {
@@ -241,8 +362,14 @@ func (s *Server) getProvider(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "provider not found", http.StatusNotFound)
 		return
 	}
-	rsp := registry.RegToApiProviderInfo(info)
-	data, err := json.Marshal(rsp)
+	rsp := struct {
+		*model.ProviderInfo
+		Reachability map[string]registry.AddrReachability `json:",omitempty"`
+	}{
+		ProviderInfo: registry.RegToApiProviderInfo(info),
+		Reachability: info.Reachability,
+	}
+	data, err := json.Marshal(&rsp)
 	if err != nil {
 		log.Error("cannot get provider", "err", err)
 		http.Error(w, "", http.StatusInternalServerError)
@@ -294,12 +421,7 @@ func (s *Server) health(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, s.healthMsg, http.StatusOK)
 }
 
-func (s *Server) getIndexes(w http.ResponseWriter, mhs []multihash.Multihash, stream bool) {
-	if len(mhs) != 1 && stream {
-		log.Errorw("Streaming response is not supported for batch find")
-		http.Error(w, "", http.StatusInternalServerError)
-		return
-	}
+func (s *Server) getIndexes(w http.ResponseWriter, r *http.Request, mhs []multihash.Multihash, stream bool, fq findQuery) {
 	startTime := time.Now()
 	var found bool
 	defer func() {
@@ -309,7 +431,27 @@ func (s *Server) getIndexes(w http.ResponseWriter, mhs []multihash.Multihash, st
 			stats.WithMeasurements(metrics.FindLatency.M(msecPerMh)))
 	}()
 
-	response, err := s.find(mhs)
+	if stream && len(mhs) != 1 {
+		found = s.streamBatch(w, mhs, fq.filter)
+		return
+	}
+
+	var response *model.FindResponse
+	var resultsTag string
+	var err error
+	if s.cache != nil && len(mhs) == 1 && fq.filter == nil {
+		var provResults []model.ProviderResult
+		provResults, resultsTag, err = s.findCached(mhs[0])
+		response = &model.FindResponse{}
+		if len(provResults) != 0 {
+			response.MultihashResults = []model.MultihashResult{{
+				Multihash:       mhs[0],
+				ProviderResults: provResults,
+			}}
+		}
+	} else {
+		response, err = s.find(mhs, fq.filter)
+	}
 	if err != nil {
 		httpserver.HandleError(w, err, "get")
 		return
@@ -321,6 +463,31 @@ func (s *Server) getIndexes(w http.ResponseWriter, mhs []multihash.Multihash, st
 		return
 	}
 
+	if fq.paginated() && len(mhs) == 1 {
+		mhr := &response.MultihashResults[0]
+		var next string
+		mhr.ProviderResults, next = fq.paginate(mhr.ProviderResults)
+		if len(mhr.ProviderResults) == 0 {
+			http.Error(w, "no results for query", http.StatusNotFound)
+			return
+		}
+		if next != "" {
+			w.Header().Set(nextCursorHeader, next)
+		}
+	}
+
+	if resultsTag != "" {
+		etag := responseETag(resultsTag, stream, fq)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", s.cacheControl)
+		w.Header().Add("Vary", "Accept")
+		if etagMatch(r.Header.Get("If-None-Match"), etag) {
+			found = true
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
 	if stream {
 		log := log.With("mh", mhs[0].B58String())
 		pr := response.MultihashResults[0].ProviderResults
@@ -328,9 +495,7 @@ func (s *Server) getIndexes(w http.ResponseWriter, mhs []multihash.Multihash, st
 			http.Error(w, "no results for query", http.StatusNotFound)
 			return
 		}
-		w.Header().Set("Content-Type", mediaTypeNDJson)
-		w.Header().Set("Connection", "Keep-Alive")
-		w.Header().Set("X-Content-Type-Options", "nosniff")
+		setNDJsonHeaders(w)
 		flusher, flushable := w.(http.Flusher)
 		encoder := json.NewEncoder(w)
 		var count int
@@ -366,6 +531,59 @@ func (s *Server) getIndexes(w http.ResponseWriter, mhs []multihash.Multihash, st
 	httpserver.WriteJsonResponse(w, http.StatusOK, rb)
 }
 
+// streamBatch writes the results for each multihash in a batch as a line of
+// NDJSON, as soon as the results for that multihash are found. Multihashes
+// that have no results are omitted. Returns true if any results were written.
+func (s *Server) streamBatch(w http.ResponseWriter, mhs []multihash.Multihash, filter *resultFilter) bool {
+	provInfos := map[peer.ID]*registry.ProviderInfo{}
+	flusher, flushable := w.(http.Flusher)
+	var encoder *json.Encoder
+
+	for _, mh := range mhs {
+		provResults, err := s.findProviderResults(mh, provInfos, filter)
+		if err != nil {
+			if encoder == nil {
+				httpserver.HandleError(w, err, "get")
+				return false
+			}
+			// Response already started, so the error cannot be reported to
+			// the client.
+			log.Errorw("Failed to find results for batch", "err", err)
+			break
+		}
+		if len(provResults) == 0 {
+			continue
+		}
+		if encoder == nil {
+			setNDJsonHeaders(w)
+			encoder = json.NewEncoder(w)
+		}
+		err = encoder.Encode(model.MultihashResult{
+			Multihash:       mh,
+			ProviderResults: provResults,
+		})
+		if err != nil {
+			log.Errorw("Failed to encode streaming response", "err", err)
+			break
+		}
+		if flushable {
+			flusher.Flush()
+		}
+	}
+
+	if encoder == nil {
+		http.Error(w, "no results for query", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func setNDJsonHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", mediaTypeNDJson)
+	w.Header().Set("Connection", "Keep-Alive")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
 func getProviderID(r *http.Request) (peer.ID, error) {
 	providerID, err := peer.Decode(path.Base(r.URL.Path))
 	if err != nil {
@@ -375,111 +593,132 @@ func getProviderID(r *http.Request) (peer.ID, error) {
 }
 
 // find reads from indexer core to populate a response from a list of
-// multihashes.
-func (s *Server) find(mhashes []multihash.Multihash) (*model.FindResponse, error) {
+// multihashes. Only provider results selected by the filter are included.
+func (s *Server) find(mhashes []multihash.Multihash, filter *resultFilter) (*model.FindResponse, error) {
 	results := make([]model.MultihashResult, 0, len(mhashes))
 	provInfos := map[peer.ID]*registry.ProviderInfo{}
 
 	for i := range mhashes {
-		values, found, err := s.indexer.Get(mhashes[i])
+		provResults, err := s.findProviderResults(mhashes[i], provInfos, filter)
 		if err != nil {
-			err = fmt.Errorf("failed to query multihash %s: %s", mhashes[i].B58String(), err)
-			return nil, apierror.New(err, http.StatusInternalServerError)
+			return nil, err
 		}
-		if !found {
+
+		// If there are no providers for this multihash, then do not return a
+		// result for it.
+		if len(provResults) == 0 {
 			continue
 		}
 
-		provResults := make([]model.ProviderResult, 0, len(values))
-		for j := range values {
-			iVal := values[j]
-			provID := iVal.ProviderID
-			pinfo := s.fetchProviderInfo(provID, iVal.ContextID, provInfos, true)
-			if pinfo == nil {
-				continue
-			}
+		// Add the result to the list of index results.
+		results = append(results, model.MultihashResult{
+			Multihash:       mhashes[i],
+			ProviderResults: provResults,
+		})
+	}
 
-			// Adding the main provider
-			provResult := model.ProviderResult{
-				ContextID: iVal.ContextID,
-				Metadata:  iVal.MetadataBytes,
-				Provider: &peer.AddrInfo{
-					ID:    provID,
-					Addrs: pinfo.AddrInfo.Addrs,
-				},
-			}
-			provResults = append(provResults, provResult)
+	return &model.FindResponse{
+		MultihashResults: results,
+	}, nil
+}
 
-			if pinfo.ExtendedProviders == nil {
-				continue
-			}
+// findProviderResults reads the values for a single multihash from indexer
+// core and returns the provider results for the multihash, including extended
+// providers. Provider info is looked up in provInfos before going to the
+// registry, and is added to provInfos when found. Only results selected by
+// the filter are returned.
+func (s *Server) findProviderResults(mh multihash.Multihash, provInfos map[peer.ID]*registry.ProviderInfo, filter *resultFilter) ([]model.ProviderResult, error) {
+	values, found, err := s.indexer.Get(mh)
+	if err != nil {
+		err = fmt.Errorf("failed to query multihash %s: %s", mh.B58String(), err)
+		return nil, apierror.New(err, http.StatusInternalServerError)
+	}
+	if !found {
+		return nil, nil
+	}
 
-			epRecord := pinfo.ExtendedProviders
-
-			// If override is set to true at the context level then the chain
-			// level EPs should be ignored for this context ID
-			override := false
-
-			// Adding context-level EPs if they exist
-			if contextualEpRecord, ok := epRecord.ContextualProviders[string(iVal.ContextID)]; ok {
-				override = contextualEpRecord.Override
-				for _, epInfo := range contextualEpRecord.Providers {
-					// Skippng the main provider's record if its metadata is
-					// nil or is the same as the one retrieved from the
-					// indexer, because such EP record does not advertise any
-					// new protocol.
-					if epInfo.PeerID == provID &&
-						(len(epInfo.Metadata) == 0 || bytes.Equal(epInfo.Metadata, iVal.MetadataBytes)) {
-						continue
-					}
-					provResult := createExtendedProviderResult(epInfo, iVal)
-					provResults = append(provResults, *provResult)
+	provResults := make([]model.ProviderResult, 0, len(values))
+	for j := range values {
+		iVal := values[j]
+		provID := iVal.ProviderID
+		pinfo := s.fetchProviderInfo(provID, iVal.ContextID, provInfos, true)
+		if pinfo == nil {
+			continue
+		}
 
-				}
-			}
+		// Adding the main provider
+		provResult := model.ProviderResult{
+			ContextID: iVal.ContextID,
+			Metadata:  iVal.MetadataBytes,
+			Provider: &peer.AddrInfo{
+				ID:    provID,
+				Addrs: s.registry.FindAddrs(pinfo),
+			},
+		}
+		if filter.match(&provResult, false) {
+			provResults = append(provResults, provResult)
+		}
 
-			if override {
-				continue
-			}
+		if pinfo.ExtendedProviders == nil {
+			continue
+		}
+
+		epRecord := pinfo.ExtendedProviders
 
-			// Adding chain-level EPs if such exist
-			for _, epInfo := range epRecord.Providers {
-				// Skippng the main provider's record if its metadata is nil or
-				// is the same as the one retrieved from the indexer, because
-				// such EP record does not advertise any new protocol.
+		// If override is set to true at the context level then the chain
+		// level EPs should be ignored for this context ID
+		override := false
+
+		// Adding context-level EPs if they exist
+		if contextualEpRecord, ok := epRecord.ContextualProviders[string(iVal.ContextID)]; ok {
+			override = contextualEpRecord.Override
+			for _, epInfo := range contextualEpRecord.Providers {
+				// Skippng the main provider's record if its metadata is
+				// nil or is the same as the one retrieved from the
+				// indexer, because such EP record does not advertise any
+				// new protocol.
 				if epInfo.PeerID == provID &&
 					(len(epInfo.Metadata) == 0 || bytes.Equal(epInfo.Metadata, iVal.MetadataBytes)) {
 					continue
 				}
 				provResult := createExtendedProviderResult(epInfo, iVal)
-				provResults = append(provResults, *provResult)
+				if filter.match(provResult, true) {
+					provResults = append(provResults, *provResult)
+				}
 			}
-
 		}
 
-		// If there are no providers for this multihash, then do not return a
-		// result for it.
-		if len(provResults) == 0 {
+		if override {
 			continue
 		}
 
-		// Add the result to the list of index results.
-		results = append(results, model.MultihashResult{
-			Multihash:       mhashes[i],
-			ProviderResults: provResults,
-		})
+		// Adding chain-level EPs if such exist
+		for _, epInfo := range epRecord.Providers {
+			// Skippng the main provider's record if its metadata is nil or
+			// is the same as the one retrieved from the indexer, because
+			// such EP record does not advertise any new protocol.
+			if epInfo.PeerID == provID &&
+				(len(epInfo.Metadata) == 0 || bytes.Equal(epInfo.Metadata, iVal.MetadataBytes)) {
+				continue
+			}
+			provResult := createExtendedProviderResult(epInfo, iVal)
+			if filter.match(provResult, true) {
+				provResults = append(provResults, *provResult)
+			}
+		}
+
 	}
 
-	return &model.FindResponse{
-		MultihashResults: results,
-	}, nil
+	return provResults, nil
 }
 
 func (s *Server) fetchProviderInfo(provID peer.ID, contextID []byte, provAddrs map[peer.ID]*registry.ProviderInfo, removeProviderContext bool) *registry.ProviderInfo {
 	// Lookup provider info for each unique provider, look in local map
-	// before going to registry.
-	pinfo, ok := provAddrs[provID]
-	if ok {
+	// before going to registry. Omitted providers are recorded with nil info,
+	// but are looked up again so that the contexts of deleted providers are
+	// removed.
+	pinfo := provAddrs[provID]
+	if pinfo != nil {
 		return pinfo
 	}
 	pinfo, allowed := s.registry.ProviderInfo(provID)
@@ -496,10 +735,12 @@ func (s *Server) fetchProviderInfo(provID peer.ID, contextID []byte, provAddrs m
 			}
 		}(provID, contextID)
 		// If provider not in registry, do not return in result.
+		provAddrs[provID] = nil
 		return nil
 	}
 	// Omit provider info if not allowed or marked as inactive.
 	if !allowed || pinfo.Inactive() {
+		provAddrs[provID] = nil
 		return nil
 	}
 	provAddrs[provID] = pinfo
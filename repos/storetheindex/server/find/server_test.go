@@ -1,25 +1,46 @@
 package find_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
 	"github.com/ipfs/go-test/random"
+	"github.com/ipld/go-ipld-prime"
+	cidlink "github.com/ipld/go-ipld-prime/linking/cid"
+	selectorparse "github.com/ipld/go-ipld-prime/traversal/selector/parse"
 	"github.com/ipni/go-indexer-core"
+	"github.com/ipni/go-libipni/dagsync/ipnisync"
+	"github.com/ipni/go-libipni/find/model"
+	"github.com/ipni/go-libipni/metadata"
+	"github.com/ipni/storetheindex/carstore"
+	"github.com/ipni/storetheindex/filestore"
+	"github.com/ipni/storetheindex/internal/registry"
 	"github.com/ipni/storetheindex/server/find"
+	"github.com/ipni/storetheindex/test/typehelpers"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/multiformats/go-multihash"
 	"github.com/stretchr/testify/require"
 )
 
+const (
+	mediaTypeJson   = "application/json"
+	mediaTypeNDJson = "application/x-ndjson"
+)
+
 const landingRendered = `<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -288,6 +309,399 @@ func TestServer_StreamingResponse(t *testing.T) {
 	}
 }
 
+func TestServer_BatchFind(t *testing.T) {
+	mhs := random.Multihashes(10)
+	p, err := peer.Decode("12D3KooWKRyzVWW6ChFjQjK4miCty85Niy48tpPV95XdKu1BcvMA")
+	require.NoError(t, err)
+
+	s := setupTestServer(t, indexer.Value{
+		ProviderID:    p,
+		ContextID:     []byte("fish"),
+		MetadataBytes: []byte("lobster"),
+	}, mhs[:5])
+
+	unknown := random.Multihashes(1)[0]
+	reqBody := `{"Cids":["` + cid.NewCidV1(cid.Raw, mhs[0]).String() + `"],"Multihashes":["` +
+		mhs[1].B58String() + `","` + unknown.B58String() + `","` + mhs[2].HexString() + `"]}`
+
+	doPost := func(accept, body string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodPost, s.URL()+"/multihash", strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		return res, data
+	}
+
+	res, data := doPost(mediaTypeJson, reqBody)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	var findRsp model.FindResponse
+	require.NoError(t, json.Unmarshal(data, &findRsp))
+	require.Len(t, findRsp.MultihashResults, 3)
+	for i, mhr := range findRsp.MultihashResults {
+		require.Equal(t, mhs[i], mhr.Multihash)
+		require.Len(t, mhr.ProviderResults, 1)
+		require.Equal(t, p, mhr.ProviderResults[0].Provider.ID)
+	}
+
+	res, data = doPost(mediaTypeNDJson, reqBody)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, mediaTypeNDJson, res.Header.Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	for i, line := range lines {
+		var mhr model.MultihashResult
+		require.NoError(t, json.Unmarshal([]byte(line), &mhr))
+		require.Equal(t, mhs[i], mhr.Multihash)
+		require.Len(t, mhr.ProviderResults, 1)
+	}
+
+	// No results for any multihash in batch.
+	res, _ = doPost(mediaTypeNDJson, `{"Multihashes":["`+unknown.B58String()+`"]}`)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = doPost(mediaTypeJson, `{"Multihashes":["`+mhs[7].B58String()+`","`+unknown.B58String()+`"]}`)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// Bad requests.
+	res, _ = doPost(mediaTypeJson, `{}`)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res, _ = doPost(mediaTypeJson, `{"Cids":["notacid"]}`)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	res, _ = doPost(mediaTypeJson, `{"Multihashes":["deadbeef"]}`)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestServer_BatchFindMaxSize(t *testing.T) {
+	reg := initRegistry(t)
+	ind := initIndex(t, false)
+	s, err := find.New("127.0.0.1:0", ind, reg, find.WithMaxBatchSize(2))
+	require.NoError(t, err)
+	go func() {
+		err := s.Start()
+		require.ErrorIs(t, err, http.ErrServerClosed)
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+
+	mhs := random.Multihashes(3)
+	reqBody := `{"Multihashes":["` + mhs[0].B58String() + `","` + mhs[1].B58String() + `","` + mhs[2].B58String() + `"]}`
+	res, err := http.Post(s.URL()+"/multihash", mediaTypeJson, strings.NewReader(reqBody))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	require.Contains(t, string(body), "exceeds maximum of 2")
+}
+
+func TestServer_FilterAndPaginate(t *testing.T) {
+	ctx := context.Background()
+	reg := initRegistryWithRestrictivePolicy(t, false)
+	ind := initIndex(t, false)
+	s := setupServer(t, ind, reg)
+	go func() {
+		err := s.Start()
+		require.ErrorIs(t, err, http.ErrServerClosed)
+	}()
+
+	md := metadata.Default.New(metadata.Bitswap{})
+	bitswapMeta, err := md.MarshalBinary()
+	require.NoError(t, err)
+	md = metadata.Default.New(metadata.IpfsGatewayHttp{})
+	httpMeta, err := md.MarshalBinary()
+	require.NoError(t, err)
+
+	maddrs := random.Multiaddrs(2)
+	providerID, _, _ := random.Identity()
+	epID, _, _ := random.Identity()
+	_, mhs := createProviderAndPopulateIndexer(t, ctx, ind, reg, []byte("ctx-1"), bitswapMeta, providerID, maddrs[:1], &registry.ExtendedProviders{
+		Providers: []registry.ExtendedProviderInfo{
+			{
+				PeerID:   epID,
+				Addrs:    maddrs[1:],
+				Metadata: httpMeta,
+			},
+		},
+	})
+	mhStr := mhs[0].B58String()
+
+	getResults := func(query string) ([]model.ProviderResult, *http.Response) {
+		res, err := http.Get(s.URL() + "/multihash/" + mhStr + query)
+		require.NoError(t, err)
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		if res.StatusCode != http.StatusOK {
+			return nil, res
+		}
+		var findRsp model.FindResponse
+		require.NoError(t, json.Unmarshal(data, &findRsp))
+		require.Len(t, findRsp.MultihashResults, 1)
+		return findRsp.MultihashResults[0].ProviderResults, res
+	}
+
+	results, _ := getResults("")
+	require.Len(t, results, 2)
+
+	results, _ = getResults("?protocol=bitswap")
+	require.Len(t, results, 1)
+	require.Equal(t, providerID, results[0].Provider.ID)
+
+	results, _ = getResults("?protocol=transport-ipfs-gateway-http")
+	require.Len(t, results, 1)
+	require.Equal(t, epID, results[0].Provider.ID)
+
+	results, _ = getResults("?protocol=graphsync,http")
+	require.Len(t, results, 1)
+	require.Equal(t, epID, results[0].Provider.ID)
+
+	results, _ = getResults("?extended=false")
+	require.Len(t, results, 1)
+	require.Equal(t, providerID, results[0].Provider.ID)
+
+	results, _ = getResults("?extended=true&provider=" + epID.String())
+	require.Len(t, results, 1)
+	require.Equal(t, epID, results[0].Provider.ID)
+
+	_, res := getResults("?protocol=graphsync")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	_, res = getResults("?protocol=carrier-pigeon")
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Page through results one at a time.
+	results, res = getResults("?limit=1")
+	require.Len(t, results, 1)
+	require.Equal(t, providerID, results[0].Provider.ID)
+	cursor := res.Header.Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor)
+
+	results, res = getResults("?limit=1&cursor=" + cursor)
+	require.Len(t, results, 1)
+	require.Equal(t, epID, results[0].Provider.ID)
+	require.Empty(t, res.Header.Get("X-Next-Cursor"))
+
+	_, res = getResults("?limit=0")
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	_, res = getResults("?cursor=bogus")
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// Filters also apply to streamed results.
+	req, err := http.NewRequest(http.MethodGet, s.URL()+"/multihash/"+mhStr+"?extended=true", nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", mediaTypeNDJson)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	data, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var result model.ProviderResult
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &result))
+	require.Equal(t, epID, result.Provider.ID)
+}
+
+func TestServer_ResultCache(t *testing.T) {
+	ctx := context.Background()
+	reg := initRegistryWithRestrictivePolicy(t, false)
+	core := initIndex(t, false)
+	s, err := find.New("127.0.0.1:0", core, reg, find.WithCacheSize(10))
+	require.NoError(t, err)
+	go func() {
+		err := s.Start()
+		require.ErrorIs(t, err, http.ErrServerClosed)
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, s.Close())
+	})
+	ind := s.CacheInvalidator(core)
+
+	maddrs := random.Multiaddrs(2)
+	providerID, _, _ := random.Identity()
+	_, mhs := createProviderAndPopulateIndexer(t, ctx, ind, reg, []byte("ctx-1"), []byte("meta-1"), providerID, maddrs[:1], nil)
+
+	doGet := func(etag string) ([]model.ProviderResult, *http.Response) {
+		req, err := http.NewRequest(http.MethodGet, s.URL()+"/multihash/"+mhs[0].B58String(), nil)
+		require.NoError(t, err)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		if res.StatusCode != http.StatusOK {
+			return nil, res
+		}
+		var findRsp model.FindResponse
+		require.NoError(t, json.Unmarshal(data, &findRsp))
+		require.Len(t, findRsp.MultihashResults, 1)
+		return findRsp.MultihashResults[0].ProviderResults, res
+	}
+
+	results, res := doGet("")
+	require.Len(t, results, 1)
+	etag := res.Header.Get("ETag")
+	require.NotEmpty(t, etag)
+	require.Equal(t, "public, max-age=60", res.Header.Get("Cache-Control"))
+
+	_, res = doGet(etag)
+	require.Equal(t, http.StatusNotModified, res.StatusCode)
+
+	// Delegated routing responses are made from the same cached results, with
+	// their own ETag.
+	doGetDelegated := func(etag string) *http.Response {
+		c := cid.NewCidV1(cid.Raw, mhs[0])
+		req, err := http.NewRequest(http.MethodGet, s.URL()+"/routing/v1/providers/"+c.String(), nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", mediaTypeNDJson)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_, err = io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		return res
+	}
+	res = doGetDelegated("")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	drETag := res.Header.Get("ETag")
+	require.NotEmpty(t, drETag)
+	require.NotEqual(t, etag, drETag)
+	res = doGetDelegated(drETag)
+	require.Equal(t, http.StatusNotModified, res.StatusCode)
+	res = doGetDelegated(etag)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Putting a value for the multihash invalidates the cached results.
+	otherID, _, _ := random.Identity()
+	createProviderAndPopulateIndexer(t, ctx, ind, reg, []byte("ctx-2"), []byte("meta-2"), otherID, maddrs[:1], nil)
+	require.NoError(t, ind.Put(indexer.Value{
+		ProviderID:    otherID,
+		ContextID:     []byte("ctx-2"),
+		MetadataBytes: []byte("meta-2"),
+	}, mhs[0]))
+	results, res = doGet(etag)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Len(t, results, 2)
+	require.NotEqual(t, etag, res.Header.Get("ETag"))
+
+	// Changing provider info invalidates the cached results.
+	err = reg.Update(ctx, peer.AddrInfo{ID: providerID, Addrs: maddrs[1:]}, peer.AddrInfo{}, cid.Undef, nil, 0)
+	require.NoError(t, err)
+	results, _ = doGet("")
+	require.Len(t, results, 2)
+	for _, result := range results {
+		if result.Provider.ID == providerID {
+			require.Equal(t, maddrs[1:], result.Provider.Addrs)
+		}
+	}
+
+	// Removing the provider's values invalidates the cached results.
+	require.NoError(t, ind.RemoveProviderContext(otherID, []byte("ctx-2")))
+	results, _ = doGet("")
+	require.Len(t, results, 1)
+	require.Equal(t, providerID, results[0].Provider.ID)
+}
+
+func TestServer_DelegatedRouting(t *testing.T) {
+	ctx := context.Background()
+	reg := initRegistryWithRestrictivePolicy(t, false)
+	ind := initIndex(t, false)
+	s := setupServer(t, ind, reg)
+	go func() {
+		err := s.Start()
+		require.ErrorIs(t, err, http.ErrServerClosed)
+	}()
+
+	md := metadata.Default.New(metadata.Bitswap{})
+	bitswapMeta, err := md.MarshalBinary()
+	require.NoError(t, err)
+	md = metadata.Default.New(metadata.IpfsGatewayHttp{})
+	httpMeta, err := md.MarshalBinary()
+	require.NoError(t, err)
+
+	maddrs := random.Multiaddrs(2)
+	providerID, _, _ := random.Identity()
+	epID, _, _ := random.Identity()
+	_, mhs := createProviderAndPopulateIndexer(t, ctx, ind, reg, []byte("ctx-1"), bitswapMeta, providerID, maddrs[:1], &registry.ExtendedProviders{
+		Providers: []registry.ExtendedProviderInfo{
+			{
+				PeerID:   epID,
+				Addrs:    maddrs[1:],
+				Metadata: httpMeta,
+			},
+		},
+	})
+	// Same provider and metadata with a different context ID is deduplicated.
+	err = ind.Put(indexer.Value{
+		ProviderID:    providerID,
+		ContextID:     []byte("ctx-2"),
+		MetadataBytes: bitswapMeta,
+	}, mhs[0])
+	require.NoError(t, err)
+	c := cid.NewCidV1(cid.Raw, mhs[0])
+
+	doGet := func(accept string) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, s.URL()+"/routing/v1/providers/"+c.String(), nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		data, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		require.NoError(t, err)
+		return res, data
+	}
+
+	type drProvider struct {
+		Schema    string
+		ID        peer.ID
+		Addrs     []string
+		Protocols []string
+	}
+
+	res, data := doGet(mediaTypeJson)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Contains(t, res.Header.Get("Content-Type"), mediaTypeJson)
+	var drRsp struct {
+		Providers []drProvider
+	}
+	require.NoError(t, json.Unmarshal(data, &drRsp))
+	require.Len(t, drRsp.Providers, 2)
+	require.Equal(t, "peer", drRsp.Providers[0].Schema)
+	require.Equal(t, providerID, drRsp.Providers[0].ID)
+	require.Equal(t, []string{maddrs[0].String()}, drRsp.Providers[0].Addrs)
+	require.Equal(t, []string{"transport-bitswap"}, drRsp.Providers[0].Protocols)
+	require.Equal(t, epID, drRsp.Providers[1].ID)
+	require.Equal(t, []string{"transport-ipfs-gateway-http"}, drRsp.Providers[1].Protocols)
+
+	res, data = doGet(mediaTypeNDJson)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, mediaTypeNDJson, res.Header.Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	var provider drProvider
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &provider))
+	require.Equal(t, epID, provider.ID)
+
+	req, err := http.NewRequest(http.MethodGet, s.URL()+"/routing/v1/providers/"+random.Cids(1)[0].String(), nil)
+	require.NoError(t, err)
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
 func TestServer_Landing(t *testing.T) {
 	reg := initRegistry(t)
 	ind := initIndex(t, false)
@@ -337,3 +751,150 @@ func setupTestServer(t *testing.T, iv indexer.Value, mhs []multihash.Multihash)
 
 	return s
 }
+
+func TestServer_MirrorPublisher(t *testing.T) {
+	ctx := context.Background()
+	reg := initRegistryWithRestrictivePolicy(t, false)
+	ind := initIndex(t, false)
+
+	// Create an advertisement chain and write it to the CAR mirror.
+	dstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	provID, provPriv, _ := random.Identity()
+	headLink := typehelpers.RandomAdBuilder{
+		EntryBuilders: []typehelpers.EntryBuilder{
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 70, EntriesPerChunk: 5, Seed: 1},
+			typehelpers.RandomEntryChunkBuilder{ChunkCount: 2, EntriesPerChunk: 5, Seed: 2},
+		},
+	}.Build(t, dsLinkSystem(dstore), provPriv)
+	headCid := headLink.(cidlink.Link).Cid
+
+	results, err := dstore.Query(ctx, query.Query{})
+	require.NoError(t, err)
+	blocks, err := results.Rest()
+	require.NoError(t, err)
+	require.Len(t, blocks, 74)
+
+	fileStore, err := filestore.NewLocal(t.TempDir())
+	require.NoError(t, err)
+	carw, err := carstore.NewWriter(dstore, fileStore, carstore.WithCompress(carstore.Zstd))
+	require.NoError(t, err)
+	count, err := carw.WriteChain(ctx, headCid, false)
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	_, err = carw.WriteHead(ctx, headCid, provID)
+	require.NoError(t, err)
+	countStore := &countingStore{Interface: fileStore}
+	carr, err := carstore.NewReader(countStore, carstore.WithCompress(carstore.Zstd))
+	require.NoError(t, err)
+
+	idxID, idxPriv, _ := random.Identity()
+	mirrorDstore := dssync.MutexWrap(datastore.NewMapDatastore())
+	s, err := find.New("127.0.0.1:0", ind, reg,
+		find.WithMirror(carr, idxPriv, "/indexer/ingest/test"),
+		find.WithMirrorDatastore(mirrorDstore))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, s.Close()) })
+	go func() {
+		err := s.Start()
+		require.ErrorIs(t, err, http.ErrServerClosed)
+	}()
+
+	// Unknown provider has no head.
+	resp, err := http.Get(s.URL() + "/mirror/" + random.Peers(1)[0].String() + "/ipni/v1/ad/head")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Sync the provider's chain from the mirror publisher.
+	destStore := dssync.MutexWrap(datastore.NewMapDatastore())
+	sync := ipnisync.NewSync(dsLinkSystem(destStore), nil)
+	defer sync.Close()
+	pubURL, err := url.Parse(s.URL())
+	require.NoError(t, err)
+	pubAddr, err := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/" + pubURL.Port() + "/http/http-path/" + url.PathEscape("mirror/"+provID.String()))
+	require.NoError(t, err)
+	syncer, err := sync.NewSyncer(peer.AddrInfo{ID: idxID, Addrs: []multiaddr.Multiaddr{pubAddr}})
+	require.NoError(t, err)
+
+	gotHead, err := syncer.GetHead(ctx)
+	require.NoError(t, err)
+	require.Equal(t, headCid, gotHead)
+
+	err = syncer.Sync(ctx, gotHead, selectorparse.CommonSelector_ExploreAllRecursively)
+	require.NoError(t, err)
+
+	for _, block := range blocks {
+		data, err := destStore.Get(ctx, datastore.NewKey(block.Key))
+		require.NoError(t, err)
+		require.Equal(t, block.Value, data)
+	}
+	// Each CAR file is read once for its advertisement, and once for all its
+	// entries.
+	require.Equal(t, int64(4), countStore.gets.Load())
+
+	// After a restart, entries are served without first serving their
+	// advertisement.
+	adBlock, err := carr.Read(ctx, headCid, true)
+	require.NoError(t, err)
+	ad, err := adBlock.Advertisement()
+	require.NoError(t, err)
+	adCids := map[string]struct{}{
+		datastore.NewKey(headCid.String()).String():                          {},
+		datastore.NewKey(ad.PreviousID.(cidlink.Link).Cid.String()).String(): {},
+	}
+	s2, err := find.New("127.0.0.1:0", ind, reg,
+		find.WithMirror(carr, idxPriv, "/indexer/ingest/test"),
+		find.WithMirrorDatastore(mirrorDstore))
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, s2.Close()) })
+	go func() {
+		err := s2.Start()
+		require.ErrorIs(t, err, http.ErrServerClosed)
+	}()
+	var entriesCount int
+	for _, block := range blocks {
+		if _, ok := adCids[block.Key]; ok {
+			continue
+		}
+		entriesCount++
+		resp, err := http.Get(s2.URL() + "/mirror/" + provID.String() + "/ipni/v1/ad" + block.Key)
+		require.NoError(t, err)
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.Equal(t, block.Value, data)
+	}
+	require.Equal(t, 72, entriesCount)
+}
+
+// countingStore counts the CAR files read from a file store.
+type countingStore struct {
+	filestore.Interface
+	gets atomic.Int64
+}
+
+func (c *countingStore) Get(ctx context.Context, relPath string) (*filestore.File, io.ReadCloser, error) {
+	if _, ok := carstore.FileCompression(relPath); ok {
+		c.gets.Add(1)
+	}
+	return c.Interface.Get(ctx, relPath)
+}
+
+func dsLinkSystem(ds datastore.Datastore) ipld.LinkSystem {
+	lsys := cidlink.DefaultLinkSystem()
+	lsys.StorageReadOpener = func(lctx ipld.LinkContext, lnk ipld.Link) (io.Reader, error) {
+		val, err := ds.Get(lctx.Ctx, datastore.NewKey(lnk.(cidlink.Link).Cid.String()))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewBuffer(val), nil
+	}
+	lsys.StorageWriteOpener = func(lctx ipld.LinkContext) (io.Writer, ipld.BlockWriteCommitter, error) {
+		buf := bytes.NewBuffer(nil)
+		return buf, func(lnk ipld.Link) error {
+			return ds.Put(lctx.Ctx, datastore.NewKey(lnk.(cidlink.Link).Cid.String()), buf.Bytes())
+		}, nil
+	}
+	return lsys
+}